@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/usmanzaheer1995/bed-and-breakfast/internal/models"
+	"github.com/usmanzaheer1995/bed-and-breakfast/internal/repository"
+)
+
+// blockAdd blocks a room from -from up to, but not including, -to
+func (c *cli) blockAdd(ctx context.Context, args []string) error {
+	fs := c.newFlagSet("block add")
+	roomID := fs.Int("room", 0, "Id of the room")
+	from := fs.String("from", "", "First day blocked (yyyy-mm-dd)")
+	to := fs.String("to", "", "Day after the last day blocked (yyyy-mm-dd)")
+	err := fs.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	start, end, err := parseDates(*from, *to)
+	if err != nil {
+		return err
+	}
+
+	room, err := c.repo.DB.GetRoomByID(ctx, *roomID)
+	if err != nil {
+		return fmt.Errorf("no room has id %d", *roomID)
+	}
+
+	err = c.repo.DB.InsertBlocksForRoom(ctx, *roomID, start, end)
+	if errors.Is(err, repository.ErrNotAvailable) {
+		return fmt.Errorf("%s is already booked or blocked on some of those days", room.RoomName)
+	} else if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(c.out, "blocked %s from %s to %s\n", room.RoomName, *from, *to)
+	return nil
+}
+
+// blockRemove removes the owner blocks of a room from -from up to, but not including, -to.
+// Reservations and holds are left alone
+func (c *cli) blockRemove(ctx context.Context, args []string) error {
+	fs := c.newFlagSet("block remove")
+	roomID := fs.Int("room", 0, "Id of the room")
+	from := fs.String("from", "", "First day to unblock (yyyy-mm-dd)")
+	to := fs.String("to", "", "Day after the last day to unblock (yyyy-mm-dd)")
+	err := fs.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	start, end, err := parseDates(*from, *to)
+	if err != nil {
+		return err
+	}
+
+	restrictions, err := c.repo.DB.GetRestrictionsByDateRange(ctx, start, end)
+	if err != nil {
+		return err
+	}
+
+	removed := 0
+	for _, r := range restrictions {
+		if r.RoomID != *roomID || r.RestrictionID != models.RestrictionOwnerBlock || r.ReservationID != 0 {
+			continue
+		}
+		if r.StartDate.Before(start) || !r.StartDate.Before(end) {
+			continue
+		}
+
+		err = c.repo.DB.DeleteBlockForRoom(ctx, r.RoomID, r.ID, r.UpdatedAt)
+		if err != nil {
+			return fmt.Errorf("removed %d blocks, then failed on the block of %s: %w", removed,
+				r.StartDate.Format(dateLayout), err)
+		}
+		removed++
+	}
+
+	fmt.Fprintf(c.out, "removed %d blocks\n", removed)
+	return nil
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/usmanzaheer1995/bed-and-breakfast/internal/integrity"
+)
+
+// check reports reservations and room restrictions that disagree, repairing the issues that can be
+// repaired safely when -repair is given. It returns an error if any issue is left
+func (c *cli) check(ctx context.Context, args []string) error {
+	fs := c.newFlagSet("check")
+	repair := fs.Bool("repair", false, "Repair the issues that can be repaired safely")
+	err := fs.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	issues, err := integrity.Check(ctx, c.repo.DB)
+	if err != nil {
+		return err
+	}
+
+	if *repair && len(issues) > 0 {
+		repaired, errs := integrity.RepairAll(ctx, c.repo.DB, issues)
+		fmt.Fprintf(c.out, "repaired %d issues\n", repaired)
+		for _, err := range errs {
+			fmt.Fprintln(c.out, "can't repair:", err)
+		}
+
+		issues, err = integrity.Check(ctx, c.repo.DB)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, issue := range issues {
+		note := "needs sorting out by hand"
+		if issue.Repairable() {
+			note = "repair: " + issue.Repair
+		}
+		fmt.Fprintf(c.out, "%s (%s)\n", issue.Description, note)
+	}
+
+	if len(issues) > 0 {
+		return fmt.Errorf("found %d issues", len(issues))
+	}
+	fmt.Fprintln(c.out, "no problems found")
+	return nil
+}
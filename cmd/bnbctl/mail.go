@@ -0,0 +1,24 @@
+package main
+
+import (
+	"context"
+	"fmt"
+)
+
+// mailResend queues the confirmation of a reservation again. The server's mail worker sends it
+func (c *cli) mailResend(ctx context.Context, args []string) error {
+	fs := c.newFlagSet("mail resend")
+	id := fs.Int("reservation", 0, "Id of the reservation")
+	err := fs.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	err = c.repo.ResendConfirmation(ctx, *id)
+	if err != nil {
+		return fmt.Errorf("can't resend the confirmation of reservation %d: %w", *id, err)
+	}
+
+	fmt.Fprintf(c.out, "queued the confirmation of reservation %d\n", *id)
+	return nil
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/usmanzaheer1995/bed-and-breakfast/internal/config"
+	"github.com/usmanzaheer1995/bed-and-breakfast/internal/driver"
+	"github.com/usmanzaheer1995/bed-and-breakfast/internal/handlers"
+	"github.com/usmanzaheer1995/bed-and-breakfast/internal/ical"
+	"github.com/usmanzaheer1995/bed-and-breakfast/internal/logging"
+	"github.com/usmanzaheer1995/bed-and-breakfast/internal/mailer"
+	"io"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+const usage = `usage: bnbctl [settings flags] <command> [flags]
+
+commands:
+  user create       add a user
+  user reset        set a user's password, signing them out everywhere
+  user deactivate   stop a user signing in, signing them out everywhere
+  user activate     let a deactivated user sign in again
+  reservations list list reservations
+  reservations export
+                    write reservations as CSV or JSON
+  block add         block a room for a range of dates
+  block remove      remove the owner blocks of a room in a range of dates
+  mail resend       queue a reservation's confirmation email again
+  check             look for inconsistent reservations and room restrictions
+
+The database and email settings are read from the same config file, BNB_* variables and flags as
+the server, e.g. bnbctl -config=bnb.yml user create -email=jane@example.com. Run a command with
+-h for its flags.`
+
+// dateLayout is how dates are given and shown
+const dateLayout = "2006-01-02"
+
+var app config.AppConfig
+
+// cli runs the commands, through the same repository as the site so they behave the same way
+type cli struct {
+	repo *handlers.Repository
+	in   io.Reader
+	out  io.Writer
+}
+
+// command is a bnbctl command, named by one or two words
+type command struct {
+	name string
+	run  func(c *cli, ctx context.Context, args []string) error
+}
+
+var commands = []command{
+	{"user create", (*cli).userCreate},
+	{"user reset", (*cli).userReset},
+	{"user deactivate", (*cli).userDeactivate},
+	{"user activate", (*cli).userActivate},
+	{"reservations list", (*cli).reservationsList},
+	{"reservations export", (*cli).reservationsExport},
+	{"block add", (*cli).blockAdd},
+	{"block remove", (*cli).blockRemove},
+	{"mail resend", (*cli).mailResend},
+	{"check", (*cli).check},
+}
+
+func main() {
+	err := run(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(0)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "bnbctl:", err)
+		os.Exit(1)
+	}
+}
+
+// run reads the settings, connects to the database and runs the command in args
+func run(args []string) error {
+	settings, rest, err := config.LoadCommandSettings(args, os.Getenv)
+	if err != nil {
+		return err
+	}
+
+	cmd, cmdArgs, err := findCommand(rest)
+	if err != nil {
+		return err
+	}
+
+	db, err := setup(settings)
+	if err != nil {
+		return err
+	}
+	defer db.SQL.Close()
+
+	c := &cli{
+		repo: handlers.NewRepo(&app, db),
+		in:   os.Stdin,
+		out:  os.Stdout,
+	}
+	return cmd.run(c, context.Background(), cmdArgs)
+}
+
+// findCommand returns the command args start with, and the arguments that follow its name
+func findCommand(args []string) (command, []string, error) {
+	for _, cmd := range commands {
+		words := strings.Fields(cmd.name)
+		if len(args) >= len(words) && strings.Join(args[:len(words)], " ") == cmd.name {
+			return cmd, args[len(words):], nil
+		}
+	}
+	return command{}, nil, errors.New(usage)
+}
+
+// setup configures the app the commands share from settings and connects to the database
+func setup(settings config.Settings) (*driver.DB, error) {
+	level, _ := logging.ParseLevel(settings.Log.Level)
+	app.Logger = logging.New(os.Stderr, settings.Log.Format, level)
+	app.QueryTimeout = time.Duration(settings.Database.QueryTimeout)
+	app.BaseURL = settings.BaseURL
+	app.MailFrom = settings.Mail.From
+	app.StaffMailFrom = settings.Mail.StaffFrom
+
+	// validated with the rest of the settings
+	location, _ := time.LoadLocation(settings.Property.TimeZone)
+	u, _ := url.Parse(settings.BaseURL)
+	app.Property = ical.Property{
+		Name:     settings.Property.Name,
+		Address:  settings.Property.Address,
+		Email:    settings.Mail.From,
+		Domain:   u.Hostname(),
+		CheckIn:  settings.Property.CheckIn,
+		CheckOut: settings.Property.CheckOut,
+		Location: location,
+	}
+
+	var err error
+	app.EmailTemplates, err = mailer.LoadTemplates(mailer.TemplateDir)
+	if err != nil {
+		return nil, err
+	}
+
+	db, err := driver.ConnectSQL(settings.Database.ConnectionString())
+	if err != nil {
+		return nil, fmt.Errorf("cannot connect to database: %w", err)
+	}
+	return db, nil
+}
+
+// newFlagSet creates the flag set of a command, which reports errors rather than exiting
+func (c *cli) newFlagSet(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet("bnbctl "+name, flag.ContinueOnError)
+	fs.SetOutput(c.out)
+	return fs
+}
+
+// parseDates parses the -from and -to dates of a command, which must be in order
+func parseDates(from, to string) (time.Time, time.Time, error) {
+	start, err := time.Parse(dateLayout, from)
+	if err != nil {
+		return start, start, fmt.Errorf("-from %q is not a date like 2021-06-01", from)
+	}
+
+	end, err := time.Parse(dateLayout, to)
+	if err != nil {
+		return start, end, fmt.Errorf("-to %q is not a date like 2021-06-01", to)
+	}
+
+	if !end.After(start) {
+		return start, end, errors.New("-to must be after -from")
+	}
+	return start, end, nil
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+var commandTests = []struct {
+	name           string
+	args           []string
+	stdin          string
+	expectedOutput string
+	expectedError  string
+}{
+	{"create user", []string{"user", "create", "-email=jane@doe.com", "-first=Jane", "-password=secret"}, "", "created user 2", ""},
+	{"create user, password on stdin", []string{"user", "create", "-email=jane@doe.com"}, "secret\n", "created user 2", ""},
+	{"create user without a password", []string{"user", "create", "-email=jane@doe.com"}, "", "", "give a password"},
+	{"create user with a bad email", []string{"user", "create", "-email=jane"}, "", "", "not a valid email address"},
+	{"create user with a taken email", []string{"user", "create", "-email=admin@admin.com", "-password=x"}, "", "", "already exists"},
+	{"reset password", []string{"user", "reset", "-email=admin@admin.com"}, "new-secret\n", "reset the password of user 1 and signed them out everywhere", ""},
+	{"reset password of nobody", []string{"user", "reset", "-email=nobody@here.com", "-password=x"}, "", "", "no user has the email address"},
+	{"deactivate user", []string{"user", "deactivate", "-email=admin@admin.com"}, "", "deactivated user 1 and signed them out everywhere", ""},
+	{"activate user", []string{"user", "activate", "-email=admin@admin.com"}, "", "activated user 1", ""},
+	{"deactivate nobody", []string{"user", "deactivate", "-email=nobody@here.com"}, "", "", "no user has the email address"},
+	{"list reservations", []string{"reservations", "list", "-new"}, "", "ID  ARRIVAL", ""},
+	{"export reservations as csv", []string{"reservations", "export"}, "", "id,first_name,last_name,email", ""},
+	{"export reservations as json", []string{"reservations", "export", "-format=json"}, "", "[]", ""},
+	{"export reservations as xml", []string{"reservations", "export", "-format=xml"}, "", "", "must be csv or json"},
+	{"add block", []string{"block", "add", "-room=1", "-from=2050-01-01", "-to=2050-01-03"}, "", "blocked", ""},
+	{"add block to unknown room", []string{"block", "add", "-room=7", "-from=2050-01-01", "-to=2050-01-03"}, "", "", "no room has id 7"},
+	{"add block backwards", []string{"block", "add", "-room=1", "-from=2050-01-03", "-to=2050-01-01"}, "", "", "-to must be after -from"},
+	{"remove blocks", []string{"block", "remove", "-room=1", "-from=2050-01-01", "-to=2050-01-03"}, "", "removed 0 blocks", ""},
+	{"resend confirmation", []string{"mail", "resend", "-reservation=4"}, "", "queued the confirmation of reservation 4", ""},
+	{"check", []string{"check"}, "", "no problems found", ""},
+	{"check and repair", []string{"check", "-repair"}, "", "no problems found", ""},
+}
+
+func TestCommands(t *testing.T) {
+	for _, e := range commandTests {
+		cmd, args, err := findCommand(e.args)
+		if err != nil {
+			t.Errorf("%s: %v", e.name, err)
+			continue
+		}
+
+		c, out := newTestCLI(e.stdin)
+		err = cmd.run(c, context.Background(), args)
+
+		if e.expectedError == "" && err != nil {
+			t.Errorf("%s: unexpected error %v", e.name, err)
+		}
+		if e.expectedError != "" && (err == nil || !strings.Contains(err.Error(), e.expectedError)) {
+			t.Errorf("%s: expected an error containing %q, got %v", e.name, e.expectedError, err)
+		}
+		if !strings.Contains(out.String(), e.expectedOutput) {
+			t.Errorf("%s: expected output containing %q, got %q", e.name, e.expectedOutput, out.String())
+		}
+	}
+}
+
+func TestFindCommand(t *testing.T) {
+	cmd, args, err := findCommand([]string{"block", "add", "-room=1"})
+	if err != nil || cmd.name != "block add" || len(args) != 1 || args[0] != "-room=1" {
+		t.Errorf("expected block add with its flag, got %q %v %v", cmd.name, args, err)
+	}
+
+	for _, args := range [][]string{nil, {"user"}, {"user", "delete"}} {
+		_, _, err := findCommand(args)
+		if err == nil || !strings.HasPrefix(err.Error(), "usage:") {
+			t.Errorf("expected the usage for %v, got %v", args, err)
+		}
+	}
+}
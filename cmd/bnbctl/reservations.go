@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"github.com/usmanzaheer1995/bed-and-breakfast/internal/models"
+	"strconv"
+	"text/tabwriter"
+)
+
+// exportedReservation is a reservation as it is exported
+type exportedReservation struct {
+	ID        int    `json:"id"`
+	FirstName string `json:"first_name"`
+	LastName  string `json:"last_name"`
+	Email     string `json:"email"`
+	Phone     string `json:"phone"`
+	StartDate string `json:"start_date"`
+	EndDate   string `json:"end_date"`
+	RoomID    int    `json:"room_id"`
+	RoomName  string `json:"room_name"`
+	Processed bool   `json:"processed"`
+}
+
+// loadReservations returns every reservation, or only the unprocessed ones when newOnly is set
+func (c *cli) loadReservations(ctx context.Context, newOnly bool) ([]models.Reservation, error) {
+	if newOnly {
+		return c.repo.DB.AllNewReservations(ctx)
+	}
+	return c.repo.DB.AllReservations(ctx)
+}
+
+// reservationsList prints reservations as a table
+func (c *cli) reservationsList(ctx context.Context, args []string) error {
+	fs := c.newFlagSet("reservations list")
+	newOnly := fs.Bool("new", false, "Only list reservations that haven't been processed")
+	err := fs.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	reservations, err := c.loadReservations(ctx, *newOnly)
+	if err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(c.out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tARRIVAL\tDEPARTURE\tROOM\tGUEST\tEMAIL\tPROCESSED")
+	for _, res := range reservations {
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%s %s\t%s\t%t\n", res.ID, res.StartDate.Format(dateLayout),
+			res.EndDate.Format(dateLayout), res.Room.RoomName, res.FirstName, res.LastName, res.Email, res.Processed == 1)
+	}
+	return tw.Flush()
+}
+
+// reservationsExport writes reservations to stdout as CSV or JSON
+func (c *cli) reservationsExport(ctx context.Context, args []string) error {
+	fs := c.newFlagSet("reservations export")
+	format := fs.String("format", "csv", "Export format (csv, json)")
+	newOnly := fs.Bool("new", false, "Only export reservations that haven't been processed")
+	err := fs.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	if *format != "csv" && *format != "json" {
+		return fmt.Errorf("-format %q must be csv or json", *format)
+	}
+
+	reservations, err := c.loadReservations(ctx, *newOnly)
+	if err != nil {
+		return err
+	}
+
+	exported := []exportedReservation{}
+	for _, res := range reservations {
+		exported = append(exported, exportedReservation{
+			ID:        res.ID,
+			FirstName: res.FirstName,
+			LastName:  res.LastName,
+			Email:     res.Email,
+			Phone:     res.Phone,
+			StartDate: res.StartDate.Format(dateLayout),
+			EndDate:   res.EndDate.Format(dateLayout),
+			RoomID:    res.RoomID,
+			RoomName:  res.Room.RoomName,
+			Processed: res.Processed == 1,
+		})
+	}
+
+	if *format == "json" {
+		enc := json.NewEncoder(c.out)
+		enc.SetIndent("", "    ")
+		return enc.Encode(exported)
+	}
+
+	w := csv.NewWriter(c.out)
+	w.Write([]string{"id", "first_name", "last_name", "email", "phone", "start_date", "end_date", "room_id",
+		"room_name", "processed"})
+	for _, res := range exported {
+		w.Write([]string{strconv.Itoa(res.ID), res.FirstName, res.LastName, res.Email, res.Phone, res.StartDate,
+			res.EndDate, strconv.Itoa(res.RoomID), res.RoomName, strconv.FormatBool(res.Processed)})
+	}
+	w.Flush()
+	return w.Error()
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"github.com/usmanzaheer1995/bed-and-breakfast/internal/handlers"
+	"github.com/usmanzaheer1995/bed-and-breakfast/internal/ical"
+	"github.com/usmanzaheer1995/bed-and-breakfast/internal/logging"
+	"github.com/usmanzaheer1995/bed-and-breakfast/internal/mailer"
+	"io/ioutil"
+	"log"
+	"log/slog"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMain(m *testing.M) {
+	app.Logger = logging.New(ioutil.Discard, logging.FormatText, slog.LevelInfo)
+	app.MailFrom = "me@here.com"
+	app.Property = ical.Property{
+		Name:     "Test B&B",
+		Domain:   "localhost",
+		CheckIn:  "15:00",
+		CheckOut: "11:00",
+		Location: time.UTC,
+	}
+
+	var err error
+	app.EmailTemplates, err = mailer.LoadTemplates("./../../email-templates")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	os.Exit(m.Run())
+}
+
+// newTestCLI creates a cli on the test repository, reading in as stdin
+func newTestCLI(in string) (*cli, *strings.Builder) {
+	out := &strings.Builder{}
+	return &cli{
+		repo: handlers.NewTestRepo(&app),
+		in:   strings.NewReader(in),
+		out:  out,
+	}, out
+}
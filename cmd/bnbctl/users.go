@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/usmanzaheer1995/bed-and-breakfast/internal/models"
+	"net/mail"
+	"strings"
+)
+
+// adminAccessLevel is the access level of staff who can use the admin pages
+const adminAccessLevel = 3
+
+// userCreate adds a user, reading the password from stdin unless -password is given
+func (c *cli) userCreate(ctx context.Context, args []string) error {
+	fs := c.newFlagSet("user create")
+	email := fs.String("email", "", "Email address the user logs in with")
+	firstName := fs.String("first", "", "First name")
+	lastName := fs.String("last", "", "Last name")
+	level := fs.Int("level", adminAccessLevel, "Access level (3 is an admin)")
+	password := fs.String("password", "", "Password; read from stdin if not given, to keep it out of the shell history")
+	err := fs.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	if _, err := mail.ParseAddress(*email); err != nil {
+		return fmt.Errorf("-email %q is not a valid email address", *email)
+	}
+
+	if *password == "" {
+		*password, err = c.readPassword()
+		if err != nil {
+			return err
+		}
+	}
+
+	u := models.User{
+		FirstName:   *firstName,
+		LastName:    *lastName,
+		Email:       *email,
+		AccessLevel: *level,
+	}
+
+	id, err := c.repo.DB.InsertUser(ctx, u, *password)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(c.out, "created user %d\n", id)
+	return nil
+}
+
+// userReset sets the password of the user with an email address
+func (c *cli) userReset(ctx context.Context, args []string) error {
+	fs := c.newFlagSet("user reset")
+	email := fs.String("email", "", "Email address of the user")
+	password := fs.String("password", "", "New password; read from stdin if not given")
+	err := fs.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	u, err := c.repo.DB.GetUserByEmail(ctx, *email)
+	if errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("no user has the email address %q", *email)
+	} else if err != nil {
+		return err
+	}
+
+	if *password == "" {
+		*password, err = c.readPassword()
+		if err != nil {
+			return err
+		}
+	}
+
+	err = c.repo.DB.UpdatePassword(ctx, u.ID, *password)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(c.out, "reset the password of user %d and signed them out everywhere\n", u.ID)
+	return nil
+}
+
+// userDeactivate stops a user signing in and signs them out everywhere
+func (c *cli) userDeactivate(ctx context.Context, args []string) error {
+	return c.setUserActive(ctx, "user deactivate", args, false)
+}
+
+// userActivate lets a deactivated user sign in again
+func (c *cli) userActivate(ctx context.Context, args []string) error {
+	return c.setUserActive(ctx, "user activate", args, true)
+}
+
+// setUserActive activates or deactivates the user with the email address given in args
+func (c *cli) setUserActive(ctx context.Context, name string, args []string, active bool) error {
+	fs := c.newFlagSet(name)
+	email := fs.String("email", "", "Email address of the user")
+	err := fs.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	u, err := c.repo.DB.GetUserByEmail(ctx, *email)
+	if errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("no user has the email address %q", *email)
+	} else if err != nil {
+		return err
+	}
+
+	u.Active = active
+	err = c.repo.DB.UpdateUser(ctx, u)
+	if err != nil {
+		return err
+	}
+
+	if active {
+		fmt.Fprintf(c.out, "activated user %d\n", u.ID)
+	} else {
+		fmt.Fprintf(c.out, "deactivated user %d and signed them out everywhere\n", u.ID)
+	}
+	return nil
+}
+
+// readPassword reads a password from the first line of stdin
+func (c *cli) readPassword() (string, error) {
+	scanner := bufio.NewScanner(c.in)
+	scanner.Scan()
+	if err := scanner.Err(); err != nil {
+		return "", err
+	}
+
+	password := strings.TrimRight(scanner.Text(), "\r")
+	if password == "" {
+		return "", errors.New("give a password with -password or on stdin")
+	}
+	return password, nil
+}
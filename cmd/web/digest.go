@@ -0,0 +1,32 @@
+package main
+
+import (
+	"context"
+	"github.com/usmanzaheer1995/bed-and-breakfast/internal/handlers"
+	"time"
+)
+
+// digestCheckInterval is how often the digest sender checks whether the day's digest is due
+const digestCheckInterval = 5 * time.Minute
+
+// listenForDigests sends the daily notification digest once a day, from the configured hour on.
+// It checks straight away, so a restart after the digest hour doesn't skip the day's digest
+func listenForDigests(bg *background) {
+	bg.Go(func(ctx context.Context) {
+		ticker := time.NewTicker(digestCheckInterval)
+		defer ticker.Stop()
+
+		for {
+			err := handlers.Repo.SendDailyDigest(ctx, time.Now())
+			if err != nil {
+				app.Logger.Error("can't send digests", "error", err)
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	})
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"context"
+	"github.com/usmanzaheer1995/bed-and-breakfast/internal/handlers"
+	"time"
+)
+
+// holdSweepInterval is how often expired holds are released
+const holdSweepInterval = time.Minute
+
+func listenForExpiredHolds(bg *background) {
+	bg.Go(func(ctx context.Context) {
+		ticker := time.NewTicker(holdSweepInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			err := handlers.Repo.ReleaseExpiredHolds(ctx)
+			if err != nil {
+				app.Logger.Error("can't release expired holds", "error", err)
+			}
+		}
+	})
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+)
+
+// background tracks the long running workers, so shutdown can stop them and wait for them to finish
+type background struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// newBackground creates an empty set of workers
+func newBackground() *background {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &background{ctx: ctx, cancel: cancel}
+}
+
+// Go runs fn in its own goroutine. fn must return soon after ctx is done
+func (b *background) Go(fn func(ctx context.Context)) {
+	b.wg.Add(1)
+	go func() {
+		defer b.wg.Done()
+		fn(b.ctx)
+	}()
+}
+
+// Stop tells every worker to finish and waits for them, giving up when ctx is done
+func (b *background) Stop(ctx context.Context) error {
+	b.cancel()
+
+	done := make(chan struct{})
+	go func() {
+		b.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// serve handles requests on ln until ctx is done or the server fails. It then stops accepting
+// connections, lets in-flight requests finish, and stops the background workers, all within app.ShutdownTimeout
+func serve(ctx context.Context, srv *http.Server, ln net.Listener, bg *background) error {
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- srv.Serve(ln)
+	}()
+
+	var err error
+	select {
+	case err = <-serveErr:
+	case <-ctx.Done():
+		app.Logger.Info("shutting down")
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), app.ShutdownTimeout)
+	defer cancel()
+
+	// requests go first, since they may queue mail for the workers to send
+	if err == nil {
+		err = srv.Shutdown(shutdownCtx)
+		if err != nil {
+			srv.Close()
+		}
+		<-serveErr
+	}
+
+	bgErr := bg.Stop(shutdownCtx)
+	if bgErr != nil {
+		bgErr = fmt.Errorf("background jobs did not finish in time: %w", bgErr)
+	}
+
+	if err == nil {
+		err = bgErr
+	}
+	return err
+}
+
+// flushTraces sends the spans still buffered to the collector, if tracing is on
+func flushTraces() {
+	if tracerProvider == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), app.ShutdownTimeout)
+	defer cancel()
+
+	err := tracerProvider.Shutdown(ctx)
+	if err != nil {
+		app.Logger.Error("can't flush traces", "error", err)
+	}
+}
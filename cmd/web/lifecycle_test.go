@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// startServe runs serve on a random local port, returning its address and the result of serve
+func startServe(t *testing.T, ctx context.Context, handler http.Handler, bg *background) (string, chan error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result := make(chan error, 1)
+	go func() {
+		result <- serve(ctx, &http.Server{Handler: handler}, ln, bg)
+	}()
+
+	return "http://" + ln.Addr().String(), result
+}
+
+func TestServe_DrainsRequestsAndWorkers(t *testing.T) {
+	app.ShutdownTimeout = 5 * time.Second
+
+	started := make(chan struct{})
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		time.Sleep(200 * time.Millisecond)
+		w.Write([]byte("done"))
+	})
+
+	// the worker takes a moment to flush after it is told to stop
+	var flushed int32
+	bg := newBackground()
+	bg.Go(func(ctx context.Context) {
+		<-ctx.Done()
+		time.Sleep(50 * time.Millisecond)
+		atomic.StoreInt32(&flushed, 1)
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	addr, result := startServe(t, ctx, handler, bg)
+
+	body := make(chan string, 1)
+	go func() {
+		resp, err := http.Get(addr + "/slow")
+		if err != nil {
+			body <- err.Error()
+			return
+		}
+		defer resp.Body.Close()
+		b, _ := ioutil.ReadAll(resp.Body)
+		body <- string(b)
+	}()
+
+	<-started
+	cancel()
+
+	select {
+	case err := <-result:
+		if err != nil {
+			t.Fatalf("expected a clean shutdown, got %s", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("serve did not return after shutdown")
+	}
+
+	if got := <-body; got != "done" {
+		t.Errorf("expected the in-flight request to finish, got %q", got)
+	}
+
+	if atomic.LoadInt32(&flushed) != 1 {
+		t.Error("expected serve to wait for the background workers")
+	}
+
+	_, err := http.Get(addr + "/")
+	if err == nil {
+		t.Error("expected new connections to be refused after shutdown")
+	}
+}
+
+func TestServe_ShutdownDeadline(t *testing.T) {
+	app.ShutdownTimeout = 100 * time.Millisecond
+
+	// a worker that ignores being told to stop
+	release := make(chan struct{})
+	defer close(release)
+	bg := newBackground()
+	bg.Go(func(ctx context.Context) {
+		<-release
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	_, result := startServe(t, ctx, http.NotFoundHandler(), bg)
+	cancel()
+
+	select {
+	case err := <-result:
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("expected the shutdown deadline to be exceeded, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("serve did not give up at the shutdown deadline")
+	}
+}
+
+func TestServe_ListenerFails(t *testing.T) {
+	app.ShutdownTimeout = time.Second
+
+	var stopped int32
+	bg := newBackground()
+	bg.Go(func(ctx context.Context) {
+		<-ctx.Done()
+		atomic.StoreInt32(&stopped, 1)
+	})
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln.Close()
+
+	err = serve(context.Background(), &http.Server{Handler: http.NotFoundHandler()}, ln, bg)
+	if err == nil {
+		t.Error("expected an error from a closed listener")
+	}
+
+	if atomic.LoadInt32(&stopped) != 1 {
+		t.Error("expected the background workers to be stopped when the server fails")
+	}
+}
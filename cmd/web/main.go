@@ -1,91 +1,219 @@
 package main
 
 import (
+	"context"
 	"encoding/gob"
+	"errors"
 	"flag"
 	"fmt"
 	"github.com/alexedwards/scs/v2"
+	"github.com/usmanzaheer1995/bed-and-breakfast/internal/captcha"
 	"github.com/usmanzaheer1995/bed-and-breakfast/internal/config"
 	"github.com/usmanzaheer1995/bed-and-breakfast/internal/driver"
 	"github.com/usmanzaheer1995/bed-and-breakfast/internal/handlers"
+	"github.com/usmanzaheer1995/bed-and-breakfast/internal/health"
 	"github.com/usmanzaheer1995/bed-and-breakfast/internal/helpers"
+	"github.com/usmanzaheer1995/bed-and-breakfast/internal/ical"
+	"github.com/usmanzaheer1995/bed-and-breakfast/internal/logging"
+	"github.com/usmanzaheer1995/bed-and-breakfast/internal/mailer"
+	"github.com/usmanzaheer1995/bed-and-breakfast/internal/metrics"
 	"github.com/usmanzaheer1995/bed-and-breakfast/internal/models"
+	"github.com/usmanzaheer1995/bed-and-breakfast/internal/ratelimit"
 	"github.com/usmanzaheer1995/bed-and-breakfast/internal/render"
+	"github.com/usmanzaheer1995/bed-and-breakfast/internal/sessionstore"
+	"github.com/usmanzaheer1995/bed-and-breakfast/internal/tracing"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
 	"log"
+	"log/slog"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
+	"os/signal"
+	"syscall"
 	"time"
 )
 
-const PORT = ":8080"
-
 var app config.AppConfig
 var session *scs.SessionManager
-var infoLog *log.Logger
-var errorLog *log.Logger
+var tracerProvider *sdktrace.TracerProvider
+
+// captchaTimeout is how long the CAPTCHA provider gets to verify a response
+const captchaTimeout = 5 * time.Second
 
 func main() {
-	db, err := run()
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		err := runMigrate(os.Args[2:], os.Stdout)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	db, err := run(os.Args[1:])
 	if err != nil {
 		log.Fatal(err)
 	}
-	defer db.SQL.Close()
 
-	defer close(app.MailChan)
+	bg := newBackground()
+
+	app.Logger.Info("starting mail listener")
+	listenForMail(bg)
+
+	if app.Features.Waitlist {
+		app.Logger.Info("starting waitlist matcher")
+		listenForWaitlist(bg)
+	}
+
+	app.Logger.Info("starting hold sweeper")
+	listenForExpiredHolds(bg)
+
+	app.Logger.Info("starting unconfirmed reservation sweeper")
+	listenForUnconfirmedReservations(bg)
+
+	if store, ok := session.Store.(*sessionstore.PostgresStore); ok {
+		app.Logger.Info("starting session sweeper")
+		listenForExpiredSessions(bg, store)
+	}
+
+	if store, ok := rateLimits.(*ratelimit.PostgresStore); ok && app.RateLimit.Enabled {
+		app.Logger.Info("starting rate limit sweeper")
+		listenForIdleRateLimits(bg, store)
+	}
+
+	if app.Features.StaffDigest {
+		app.Logger.Info("starting digest sender")
+		listenForDigests(bg)
+	}
+
+	if app.Features.ScheduledEmails {
+		app.Logger.Info("starting scheduled email sender")
+		listenForScheduledEmails(bg)
+	}
+
+	ln, err := net.Listen("tcp", app.ListenAddr)
+	if err != nil {
+		app.Logger.Error("can't listen", "addr", app.ListenAddr, "error", err)
+		os.Exit(1)
+	}
 
-	fmt.Println("starting mail listener...")
-	listenForMail()
+	if app.MetricsAddr != "" {
+		metricsLn, err := net.Listen("tcp", app.MetricsAddr)
+		if err != nil {
+			app.Logger.Error("can't listen for metrics", "addr", app.MetricsAddr, "error", err)
+			os.Exit(1)
+		}
 
-	fmt.Println(fmt.Sprintf("Starting application on port %s", PORT))
+		app.Logger.Info("serving metrics", "addr", app.MetricsAddr)
+		metricsSrv := &http.Server{Handler: metricsRoutes()}
+		go metricsSrv.Serve(metricsLn)
+		defer metricsSrv.Close()
+	}
+
+	app.Logger.Info("starting application", "addr", app.ListenAddr)
 
 	srv := &http.Server{
-		Addr:    PORT,
 		Handler: routes(&app),
 	}
 
-	err = srv.ListenAndServe()
-	log.Fatal(err)
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	err = serve(ctx, srv, ln, bg)
+	db.SQL.Close()
+	flushTraces()
+	if err != nil {
+		app.Logger.Error("shutdown failed", "error", err)
+		os.Exit(1)
+	}
+	app.Logger.Info("stopped")
 }
 
-func run() (*driver.DB, error) {
+// run sets up the application from the settings in args, the environment and the config file
+func run(args []string) (*driver.DB, error) {
 	// what am I going to put in the session
 	gob.Register(models.Reservation{})
 	gob.Register(models.User{})
 	gob.Register(models.Room{})
 	gob.Register(models.Restriction{})
-	gob.Register(map[string]int{})
-
-	// read flags
-	inProduction := flag.Bool("production", true, "Application is in production")
-	useCache := flag.Bool("cache", true, "Use template cache")
-	dbHost :=flag.String("dbhost", "localhost", "Database host")
-	dbName :=flag.String("dbname", "", "Database name")
-	dbUser :=flag.String("dbuser", "", "Database user")
-	dbPass :=flag.String("dbpass", "", "Database password")
-	dbPort :=flag.String("dbport", "5432", "Database port")
-	dbSSL :=flag.String("dbssl", "disable", "Database ssl settings(disable, prefer, require)")
-
-	flag.Parse()
-
-	if *dbName == "" || *dbUser == "" {
-		fmt.Println("Missing required flags")
-		os.Exit(1)
+
+	// read settings from the config file, the environment and flags
+	settings, err := config.LoadSettings(args, os.Getenv)
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(0)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// validated with the rest of the settings
+	level, _ := logging.ParseLevel(settings.Log.Level)
+	app.Logger = logging.New(os.Stdout, settings.Log.Format, level)
+	// anything still using the standard logger ends up in the same place
+	slog.SetDefault(app.Logger)
+
+	if settings.Tracing.Exporter == "otlp" {
+		exporter, err := tracing.NewOTLPExporter(context.Background(), settings.Tracing.Endpoint, settings.Tracing.Insecure)
+		if err != nil {
+			return nil, err
+		}
+		tracerProvider = tracing.Install(exporter, settings.Tracing.SampleRatio)
 	}
 
-	mailChan := make(chan models.MailData)
-	app.MailChan = mailChan
+	if settings.Mail.Dir != "" {
+		app.Mailer, err = mailer.NewFileMailer(settings.Mail.Dir)
+	} else {
+		app.Mailer, err = mailer.NewSMTPMailer(settings.SMTP.Host, settings.SMTP.Port, settings.SMTP.Username,
+			settings.SMTP.Password, settings.SMTP.Encryption)
+	}
+	if err != nil {
+		return nil, err
+	}
 
-	app.InProduction = *inProduction
-	app.UseCache = *useCache
+	// buffered so a pending run absorbs further triggers
+	app.MailQueueChan = make(chan struct{}, 1)
+	app.MailWorker = health.NewHeartbeat(mailWorkerMaxSilence)
+	app.WaitlistChan = make(chan struct{}, 1)
+	app.ListenAddr = settings.Listen
+	app.MetricsAddr = settings.MetricsListen
+	app.ShutdownTimeout = time.Duration(settings.ShutdownTimeout)
+	app.QueryTimeout = time.Duration(settings.Database.QueryTimeout)
+	app.BaseURL = settings.BaseURL
+	app.MailFrom = settings.Mail.From
+	app.StaffMailFrom = settings.Mail.StaffFrom
+	app.DigestHour = settings.Mail.DigestHour
+	app.Features = settings.Features
+	app.RateLimit = settings.RateLimit
+	// validated with the rest of the settings
+	app.TrustedProxies, _ = settings.TrustedProxyPrefixes()
+	app.Spam = settings.Spam
 
-	infoLog = log.New(os.Stdout, "INFO\t", log.Ldate|log.Ltime)
-	app.InfoLog = infoLog
+	if c := settings.Spam.Captcha; c.Provider != "none" {
+		app.Captcha, err = captcha.New(c.Provider, c.SiteKey, c.Secret, captchaTimeout)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// validated with the rest of the settings
+	location, _ := time.LoadLocation(settings.Property.TimeZone)
+	u, _ := url.Parse(settings.BaseURL)
+	app.Property = ical.Property{
+		Name:     settings.Property.Name,
+		Address:  settings.Property.Address,
+		Email:    settings.Mail.From,
+		Domain:   u.Hostname(),
+		CheckIn:  settings.Property.CheckIn,
+		CheckOut: settings.Property.CheckOut,
+		Location: location,
+	}
 
-	errorLog = log.New(os.Stdout, "ERROR\t", log.Ldate|log.Ltime|log.Lshortfile)
-	app.ErrorLog = errorLog
+	app.InProduction = settings.Production
+	app.UseCache = settings.UseCache
 
 	session = scs.New()
-	session.Lifetime = 24 * time.Hour
+	session.Lifetime = time.Duration(settings.Session.Lifetime)
 	session.Cookie.Persist = true
 	session.Cookie.SameSite = http.SameSiteLaxMode
 	session.Cookie.Secure = app.InProduction
@@ -93,25 +221,50 @@ func run() (*driver.DB, error) {
 	app.Session = session
 
 	// connect to database
-	log.Println("connecting to database")
-	connectionString := fmt.Sprintf("host=%s port=%s dbname=%s user=%s password=%s sslmode=%s", *dbHost, *dbPort, *dbName, *dbUser, *dbPass, *dbSSL)
-	//db, err := driver.ConnectSQL("host=localhost port=5432 dbname=bedandbreakfast user=postgres password=usman123")
-	db, err := driver.ConnectSQL(connectionString)
+	app.Logger.Info("connecting to database")
+	db, err := driver.ConnectSQL(settings.Database.ConnectionString())
 	if err != nil {
-		log.Fatal("cannot connect to database. Shutting down...")
+		return nil, fmt.Errorf("cannot connect to database: %w", err)
 	}
-	log.Println("connected to database")
+	app.Logger.Info("connected to database")
 
-	tc, err := render.CreateTemplateCache()
+	if settings.Database.AutoMigrate {
+		err = autoMigrate(db, app.Logger)
+		if err != nil {
+			return nil, err
+		}
+	}
+	metrics.RegisterDB(db.SQL, "bedandbreakfast")
+
+	store, err := newSessionStore(settings, db)
 	if err != nil {
-		log.Fatal("cannot create template cache")
 		return nil, err
 	}
+	if store != nil {
+		session.Store = store
+	}
+	app.Logger.Info("keeping sessions", "store", settings.Session.Store)
+
+	rateLimits = newRateLimitStore(settings, db)
+
+	tc, err := render.CreateTemplateCache()
+	if err != nil {
+		return nil, fmt.Errorf("cannot create template cache: %w", err)
+	}
 	app.TemplateCache = tc
 
+	app.EmailTemplates, err = mailer.LoadTemplates(mailer.TemplateDir)
+	if err != nil {
+		return nil, err
+	}
+
 	repo := handlers.NewRepo(&app, db)
 	handlers.NewHandlers(repo)
 
+	metrics.RegisterMailQueue(func() (int, error) {
+		return repo.DB.CountOutboxMessages(context.Background(), models.OutboxQueued)
+	})
+
 	render.NewRenderer(&app)
 	helpers.NewHelpers(&app)
 
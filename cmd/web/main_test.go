@@ -1,10 +1,28 @@
 package main
 
-import "testing"
+import (
+	"github.com/usmanzaheer1995/bed-and-breakfast/internal/config"
+	"os"
+	"testing"
+)
 
 func TestRun(t *testing.T) {
-	_, err := run()
+	// settings come from the environment alone here, never from the test binary's own flags
+	if os.Getenv(config.EnvPrefix+"DSN") == "" && os.Getenv(config.EnvPrefix+"DBNAME") == "" &&
+		os.Getenv(config.EnvPrefix+"CONFIG") == "" {
+		t.Skip("no database configured, set BNB_DSN to run")
+	}
+
+	db, err := run(nil)
 	if err != nil {
-		t.Error("failed run()")
+		t.Fatal("failed run():", err)
+	}
+	db.SQL.Close()
+}
+
+func TestRun_BadSettings(t *testing.T) {
+	_, err := run([]string{"-nosuchflag"})
+	if err == nil {
+		t.Error("expected an error for an unknown flag")
 	}
 }
@@ -2,10 +2,86 @@ package main
 
 import (
 	"github.com/justinas/nosurf"
+	"github.com/usmanzaheer1995/bed-and-breakfast/internal/csp"
+	"github.com/usmanzaheer1995/bed-and-breakfast/internal/handlers"
 	"github.com/usmanzaheer1995/bed-and-breakfast/internal/helpers"
+	"github.com/usmanzaheer1995/bed-and-breakfast/internal/logging"
+	"github.com/usmanzaheer1995/bed-and-breakfast/internal/ratelimit"
+	"math"
 	"net/http"
+	"strconv"
+	"time"
 )
 
+// RequestID tags every request with an id that is logged with it and returned to the client
+func RequestID(next http.Handler) http.Handler {
+	return logging.RequestID(next)
+}
+
+// AccessLog logs every request with its status and duration
+func AccessLog(next http.Handler) http.Handler {
+	return logging.AccessLog(app.Logger)(next)
+}
+
+// SecureHeaders sets the security headers on every response, with a Content-Security-Policy whose nonce,
+// new for each response, lets the templates' inline scripts run. HSTS is only sent in production, so
+// a development server over plain http doesn't make the browser insist on https for localhost
+func SecureHeaders(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		nonce, err := csp.NewNonce()
+		if err != nil {
+			helpers.ServerError(w, r, err)
+			return
+		}
+
+		h := w.Header()
+		if app.InProduction {
+			h.Set("Strict-Transport-Security", "max-age=31536000; includeSubDomains")
+		}
+		h.Set("X-Content-Type-Options", "nosniff")
+		h.Set("X-Frame-Options", "DENY")
+		h.Set("Referrer-Policy", "strict-origin-when-cross-origin")
+		h.Set("Permissions-Policy", "camera=(), microphone=(), geolocation=(), payment=(), usb=()")
+		h.Set("Content-Security-Policy", csp.Policy(nonce, widgetHosts()...))
+
+		next.ServeHTTP(w, r.WithContext(csp.WithNonce(r.Context(), nonce)))
+	})
+}
+
+// widgetHosts returns the hosts of the CAPTCHA widget, if there is one, for the CSP to allow
+func widgetHosts() []string {
+	if app.Captcha == nil {
+		return nil
+	}
+	return app.Captcha.Widget().Hosts
+}
+
+// RateLimit limits how often each client may use a route, answering 429 with a Retry-After once it has
+// used them all. If the store can't be reached the request goes through, since turning every guest away
+// is worse than letting a few extra requests in
+func RateLimit(name string, limit ratelimit.Limit) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !app.RateLimit.Enabled || limit.Off() {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			key := name + ":" + helpers.ClientIP(r)
+			wait, err := rateLimits.Take(r.Context(), key, limit, time.Now())
+			if err != nil {
+				app.Logger.ErrorContext(r.Context(), "can't check rate limit", "limit", name, "error", err)
+			} else if wait > 0 {
+				w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+				helpers.ClientError(w, r, http.StatusTooManyRequests)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
 // NoSurf adds CSRF protection to all POST requests
 func NoSurf(next http.Handler) http.Handler {
 	csrfHandler := nosurf.New(next)
@@ -23,6 +99,11 @@ func SessionLoad(next http.Handler) http.Handler {
 	return session.LoadAndSave(next)
 }
 
+// TrackSession signs out sessions that were signed out from elsewhere and records activity on the rest
+func TrackSession(next http.Handler) http.Handler {
+	return handlers.Repo.TrackSession(next)
+}
+
 // Auth checks if user is authenticated for private routes
 func Auth(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
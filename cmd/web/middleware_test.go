@@ -2,8 +2,14 @@ package main
 
 import (
 	"fmt"
+	"github.com/usmanzaheer1995/bed-and-breakfast/internal/csp"
+	"github.com/usmanzaheer1995/bed-and-breakfast/internal/helpers"
+	"github.com/usmanzaheer1995/bed-and-breakfast/internal/ratelimit"
 	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestNoSurf(t *testing.T) {
@@ -27,3 +33,76 @@ func TestSessionLoad(t *testing.T) {
 		t.Error(fmt.Sprintf("type is not http.Handler, but is %T", v))
 	}
 }
+
+func TestSecureHeaders(t *testing.T) {
+	for _, production := range []bool{false, true} {
+		app.InProduction = production
+
+		var nonce string
+		h := SecureHeaders(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			nonce = csp.NonceFrom(r.Context())
+		}))
+
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, httptest.NewRequest("GET", "/", nil))
+
+		if nonce == "" {
+			t.Fatal("expected a nonce in the request context")
+		}
+		if policy := rr.Header().Get("Content-Security-Policy"); !strings.Contains(policy, "'nonce-"+nonce+"'") {
+			t.Errorf("expected the policy to allow the nonce, got %q", policy)
+		}
+		if rr.Header().Get("X-Content-Type-Options") != "nosniff" {
+			t.Error("expected X-Content-Type-Options to be nosniff")
+		}
+		if hsts := rr.Header().Get("Strict-Transport-Security"); (hsts != "") != production {
+			t.Errorf("production %t: got Strict-Transport-Security %q", production, hsts)
+		}
+	}
+	app.InProduction = false
+}
+
+func TestRateLimit(t *testing.T) {
+	helpers.NewHelpers(&app)
+	app.RateLimit.Enabled = true
+	rateLimits = ratelimit.NewMemoryStore()
+	defer func() {
+		app.RateLimit.Enabled = false
+	}()
+
+	h := RateLimit("test", ratelimit.Limit{Requests: 2, Per: time.Hour})(&myHandler{})
+	request := func(remoteAddr string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("POST", "/", nil)
+		r.RemoteAddr = remoteAddr
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, r)
+		return rr
+	}
+
+	for i := 0; i < 2; i++ {
+		if rr := request("203.0.113.7:5000"); rr.Code != http.StatusOK {
+			t.Fatalf("request %d: expected %d, got %d", i+1, http.StatusOK, rr.Code)
+		}
+	}
+
+	rr := request("203.0.113.7:5001")
+	if rr.Code != http.StatusTooManyRequests {
+		t.Errorf("expected %d once the limit is used up, got %d", http.StatusTooManyRequests, rr.Code)
+	}
+	if retry := rr.Header().Get("Retry-After"); retry != "1800" {
+		t.Errorf("expected Retry-After of 1800, got %q", retry)
+	}
+
+	if rr := request("198.51.100.1:5000"); rr.Code != http.StatusOK {
+		t.Errorf("expected another client to be allowed, got %d", rr.Code)
+	}
+
+	off := RateLimit("test", ratelimit.Limit{})(&myHandler{})
+	r := httptest.NewRequest("POST", "/", nil)
+	r.RemoteAddr = "203.0.113.7:5000"
+	rr = httptest.NewRecorder()
+	off.ServeHTTP(rr, r)
+	if rr.Code != http.StatusOK {
+		t.Errorf("expected no limit when it is off, got %d", rr.Code)
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/usmanzaheer1995/bed-and-breakfast/internal/config"
+	"github.com/usmanzaheer1995/bed-and-breakfast/internal/driver"
+	"github.com/usmanzaheer1995/bed-and-breakfast/internal/logging"
+	"github.com/usmanzaheer1995/bed-and-breakfast/internal/migrate"
+	"github.com/usmanzaheer1995/bed-and-breakfast/migrations"
+	"io"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// migrationsDir is where migrate create writes new migrations, relative to the project root
+const migrationsDir = "migrations"
+
+const migrateUsage = `usage: bed-and-breakfast migrate <command> [settings flags]
+
+commands:
+  up             apply every migration not yet applied
+  down [n]       roll back the last n migrations (1 by default)
+  status         list the migrations and whether each is applied
+  create <name>  write empty up and down files for a new migration to ./migrations
+
+The database is chosen with the same config file, BNB_* variables and flags as the server.`
+
+// runMigrate runs the migrate subcommand with args, the arguments after "migrate"
+func runMigrate(args []string, out io.Writer) error {
+	// the command and its arguments come before the settings flags
+	var command []string
+	for len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		command = append(command, args[0])
+		args = args[1:]
+	}
+
+	if len(command) == 0 {
+		return errors.New(migrateUsage)
+	}
+
+	switch command[0] {
+	case "create":
+		if len(command) != 2 {
+			return errors.New(migrateUsage)
+		}
+		paths, err := migrate.Create(migrationsDir, command[1], time.Now())
+		if err != nil {
+			return err
+		}
+		for _, path := range paths {
+			fmt.Fprintln(out, "created", path)
+		}
+		return nil
+	case "up", "status":
+		if len(command) != 1 {
+			return errors.New(migrateUsage)
+		}
+	case "down":
+		if len(command) > 2 {
+			return errors.New(migrateUsage)
+		}
+	default:
+		return errors.New(migrateUsage)
+	}
+
+	steps := 1
+	if command[0] == "down" && len(command) == 2 {
+		n, err := strconv.Atoi(command[1])
+		if err != nil || n < 1 {
+			return fmt.Errorf("can't roll back %q migrations", command[1])
+		}
+		steps = n
+	}
+
+	settings, err := config.LoadSettings(args, os.Getenv)
+	if err != nil {
+		return err
+	}
+
+	level, _ := logging.ParseLevel(settings.Log.Level)
+	logger := logging.New(out, settings.Log.Format, level)
+
+	db, err := driver.ConnectSQL(settings.Database.ConnectionString())
+	if err != nil {
+		return fmt.Errorf("cannot connect to database: %w", err)
+	}
+	defer db.SQL.Close()
+
+	migrator, err := migrate.New(db.SQL, migrations.FS, logger)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	switch command[0] {
+	case "up":
+		n, err := migrator.Up(ctx)
+		if err != nil {
+			return err
+		}
+		logger.Info("database is up to date", "applied", n)
+	case "down":
+		n, err := migrator.Down(ctx, steps)
+		if err != nil {
+			return err
+		}
+		logger.Info("rolled back", "migrations", n)
+	case "status":
+		states, err := migrator.Status(ctx)
+		if err != nil {
+			return err
+		}
+		for _, s := range states {
+			status := "pending"
+			if s.Applied {
+				status = "applied"
+			}
+			fmt.Fprintf(out, "%-8s %s_%s\n", status, s.Version, s.Name)
+		}
+	}
+
+	return nil
+}
+
+// autoMigrate brings the database up to date as the server starts
+func autoMigrate(db *driver.DB, logger *slog.Logger) error {
+	migrator, err := migrate.New(db.SQL, migrations.FS, logger)
+	if err != nil {
+		return err
+	}
+
+	n, err := migrator.Up(context.Background())
+	if err != nil {
+		return fmt.Errorf("cannot migrate database: %w", err)
+	}
+	logger.Info("database is up to date", "applied", n)
+	return nil
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+var runMigrateTests = []struct {
+	name          string
+	args          []string
+	expectedError string
+}{
+	{"no command", []string{"-config=bnb.yml"}, "usage:"},
+	{"unknown command", []string{"sideways"}, "usage:"},
+	{"create without a name", []string{"create"}, "usage:"},
+	{"status with an argument", []string{"status", "now"}, "usage:"},
+	{"down by a bad count", []string{"down", "all"}, "can't roll back \"all\" migrations"},
+}
+
+func TestRunMigrate(t *testing.T) {
+	for _, e := range runMigrateTests {
+		err := runMigrate(e.args, ioutil.Discard)
+		if err == nil || !strings.Contains(err.Error(), e.expectedError) {
+			t.Errorf("%s: expected an error containing %q, got %v", e.name, e.expectedError, err)
+		}
+	}
+}
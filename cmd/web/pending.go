@@ -0,0 +1,30 @@
+package main
+
+import (
+	"context"
+	"github.com/usmanzaheer1995/bed-and-breakfast/internal/handlers"
+	"time"
+)
+
+// pendingSweepInterval is how often reservations guests didn't confirm in time are deleted
+const pendingSweepInterval = 15 * time.Minute
+
+func listenForUnconfirmedReservations(bg *background) {
+	bg.Go(func(ctx context.Context) {
+		ticker := time.NewTicker(pendingSweepInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			err := handlers.Repo.DeleteExpiredPendingReservations(ctx)
+			if err != nil {
+				app.Logger.Error("can't delete unconfirmed reservations", "error", err)
+			}
+		}
+	})
+}
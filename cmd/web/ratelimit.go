@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"github.com/usmanzaheer1995/bed-and-breakfast/internal/config"
+	"github.com/usmanzaheer1995/bed-and-breakfast/internal/driver"
+	"github.com/usmanzaheer1995/bed-and-breakfast/internal/ratelimit"
+	"time"
+)
+
+// rateLimitSweepInterval is how often idle rate limit buckets are deleted from the database
+const rateLimitSweepInterval = 10 * time.Minute
+
+// rateLimits keeps every client's request counts for the RateLimit middleware
+var rateLimits ratelimit.Store = ratelimit.NewMemoryStore()
+
+// newRateLimitStore returns the store configured for rate limits
+func newRateLimitStore(settings config.Settings, db *driver.DB) ratelimit.Store {
+	if settings.RateLimit.Store == "postgres" {
+		return ratelimit.NewPostgresStore(db.SQL, time.Duration(settings.Database.QueryTimeout))
+	}
+	return ratelimit.NewMemoryStore()
+}
+
+// listenForIdleRateLimits deletes the buckets that have been idle for longer than the longest limit's
+// period from the database, since they are full again and no different from new ones
+func listenForIdleRateLimits(bg *background, store *ratelimit.PostgresStore) {
+	limits := app.RateLimit
+	var idle time.Duration
+	for _, limit := range []ratelimit.Limit{limits.Availability, limits.Reservation, limits.Login} {
+		if limit.Per > idle {
+			idle = limit.Per
+		}
+	}
+
+	bg.Go(func(ctx context.Context) {
+		ticker := time.NewTicker(rateLimitSweepInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			deleted, err := store.DeleteIdle(ctx, time.Now().Add(-idle))
+			if err != nil {
+				app.Logger.Error("can't delete idle rate limits", "error", err)
+			} else if deleted > 0 {
+				app.Logger.Debug("deleted idle rate limits", "count", deleted)
+			}
+		}
+	})
+}
@@ -5,15 +5,26 @@ import (
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/usmanzaheer1995/bed-and-breakfast/internal/config"
 	"github.com/usmanzaheer1995/bed-and-breakfast/internal/handlers"
+	"github.com/usmanzaheer1995/bed-and-breakfast/internal/metrics"
+	"github.com/usmanzaheer1995/bed-and-breakfast/internal/tracing"
 	"net/http"
 )
 
 func routes(app *config.AppConfig) http.Handler {
 	mux := chi.NewRouter()
 
+	mux.Use(RequestID)
+	mux.Use(SecureHeaders)
+	mux.Use(tracing.Middleware)
+	mux.Use(AccessLog)
+	mux.Use(metrics.Instrument)
 	mux.Use(middleware.Recoverer)
 	mux.Use(NoSurf)
 	mux.Use(SessionLoad)
+	mux.Use(TrackSession)
+
+	mux.Get("/healthz", handlers.Repo.Healthz)
+	mux.Get("/readyz", handlers.Repo.Readyz)
 
 	mux.Get("/", handlers.Repo.Home)
 	mux.Get("/about", handlers.Repo.About)
@@ -21,19 +32,32 @@ func routes(app *config.AppConfig) http.Handler {
 	mux.Get("/majors-suite", handlers.Repo.Majors)
 
 	mux.Get("/make-reservation", handlers.Repo.Reservation)
-	mux.Post("/make-reservation", handlers.Repo.PostReservation)
+	mux.With(RateLimit("reservation", app.RateLimit.Reservation)).Post("/make-reservation", handlers.Repo.PostReservation)
 	mux.Get("/reservation-summary", handlers.Repo.ReservationSummary)
+	mux.Get("/confirm-reservation/{token}", handlers.Repo.ConfirmReservation)
+	mux.Post("/confirm-reservation/{token}", handlers.Repo.PostConfirmReservation)
 
 	mux.Get("/search-availability", handlers.Repo.Availability)
-	mux.Post("/search-availability", handlers.Repo.PostAvailability)
-	mux.Post("/search-availability-json", handlers.Repo.AvailabilityJSON)
+	availabilityLimit := RateLimit("availability", app.RateLimit.Availability)
+	mux.With(availabilityLimit).Post("/search-availability", handlers.Repo.PostAvailability)
+	mux.With(availabilityLimit).Post("/search-availability-json", handlers.Repo.AvailabilityJSON)
+	if app.Features.FlexibleSearch {
+		mux.With(availabilityLimit).Post("/search-availability-flexible", handlers.Repo.PostFlexibleAvailability)
+	}
 	mux.Get("/choose-room/{id}", handlers.Repo.ChooseRoom)
 	mux.Get("/book-room", handlers.Repo.BookRoom)
 
+	if app.Features.Waitlist {
+		mux.Get("/waitlist", handlers.Repo.Waitlist)
+		mux.Post("/waitlist", handlers.Repo.PostWaitlist)
+		mux.Get("/waitlist/{token}", handlers.Repo.ClaimWaitlist)
+		mux.Post("/waitlist/{token}", handlers.Repo.PostClaimWaitlist)
+	}
+
 	mux.Get("/contact", handlers.Repo.Contact)
 
 	mux.Get("/user/login", handlers.Repo.ShowLogin)
-	mux.Post("/user/login", handlers.Repo.PostShowLogin)
+	mux.With(RateLimit("login", app.RateLimit.Login)).Post("/user/login", handlers.Repo.PostShowLogin)
 	mux.Get("/user/logout", handlers.Repo.Logout)
 
 	fileServer := http.FileServer(http.Dir("./static/"))
@@ -47,12 +71,44 @@ func routes(app *config.AppConfig) http.Handler {
 		mux.Get("/reservations-all", handlers.Repo.AdminAllReservations)
 		mux.Get("/reservations-calendar", handlers.Repo.AdminReservationsCalendar)
 		mux.Post("/reservations-calendar", handlers.Repo.AdminPostReservationsCalendar)
+		mux.Get("/reservations-planner", handlers.Repo.AdminReservationsPlanner)
+		mux.Get("/calendar-json", handlers.Repo.AdminCalendarJSON)
+		mux.Post("/calendar-json", handlers.Repo.AdminPostCalendarJSON)
 		mux.Get("/process-reservation/{src}/{id}/do", handlers.Repo.AdminProcessReservation)
 		mux.Get("/delete-reservation/{src}/{id}/do", handlers.Repo.AdminDeleteReservation)
 
 		mux.Get("/reservations/{src}/{id}/show", handlers.Repo.AdminShowReservation)
 		mux.Post("/reservations/{src}/{id}", handlers.Repo.AdminPostShowReservation)
+
+		mux.Get("/outbox", handlers.Repo.AdminOutbox)
+		mux.Post("/outbox/{id}/resend", handlers.Repo.AdminResendOutboxMessage)
+
+		mux.Get("/email-templates", handlers.Repo.AdminEmailTemplates)
+		mux.Get("/email-templates/{name}", handlers.Repo.AdminEmailTemplates)
+
+		mux.Get("/notifications", handlers.Repo.AdminNotifications)
+		mux.Post("/notifications/rules", handlers.Repo.AdminPostNotificationRule)
+		mux.Post("/notifications/rules/{id}/delete", handlers.Repo.AdminDeleteNotificationRule)
+		mux.Post("/notifications/preferences", handlers.Repo.AdminPostNotificationPreferences)
+
+		mux.Get("/scheduled-emails", handlers.Repo.AdminScheduledEmails)
+		mux.Get("/scheduled-emails/{id}", handlers.Repo.AdminShowScheduledEmail)
+		mux.Post("/scheduled-emails/{id}", handlers.Repo.AdminPostScheduledEmail)
+
+		mux.Get("/integrity", handlers.Repo.AdminIntegrity)
+		mux.Post("/integrity/repair", handlers.Repo.AdminPostIntegrityRepair)
+
+		mux.Get("/account", handlers.Repo.AdminAccount)
+		mux.Post("/account/sessions/{id}/sign-out", handlers.Repo.AdminPostSignOutSession)
+		mux.Post("/account/sessions/sign-out-all", handlers.Repo.AdminPostSignOutEverywhere)
 	})
 
 	return mux
 }
+
+// metricsRoutes serves the Prometheus metrics on the internal listener, away from guests
+func metricsRoutes() http.Handler {
+	mux := chi.NewRouter()
+	mux.Handle("/metrics", metrics.Handler())
+	return mux
+}
@@ -4,6 +4,8 @@ import (
 	"fmt"
 	"github.com/go-chi/chi/v5"
 	"github.com/usmanzaheer1995/bed-and-breakfast/internal/config"
+	"net/http"
+	"net/http/httptest"
 	"testing"
 )
 
@@ -18,3 +20,17 @@ func TestRoutes(t *testing.T) {
 		t.Error(fmt.Sprintf("type is not *chi.Mux, type is %T", v))
 	}
 }
+
+func TestMetricsRoutes(t *testing.T) {
+	var app config.AppConfig
+
+	if routes(&app).(*chi.Mux).Match(chi.NewRouteContext(), "GET", "/metrics") {
+		t.Error("expected metrics not to be served to guests")
+	}
+
+	rr := httptest.NewRecorder()
+	metricsRoutes().ServeHTTP(rr, httptest.NewRequest("GET", "/metrics", nil))
+	if rr.Code != http.StatusOK {
+		t.Errorf("expected metrics on the internal listener, got %d", rr.Code)
+	}
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"context"
+	"github.com/usmanzaheer1995/bed-and-breakfast/internal/handlers"
+	"time"
+)
+
+// scheduledEmailInterval is how often reservations are checked for scheduled emails that fell due
+const scheduledEmailInterval = time.Hour
+
+// listenForScheduledEmails queues pre-arrival and post-stay emails as they fall due
+func listenForScheduledEmails(bg *background) {
+	bg.Go(func(ctx context.Context) {
+		ticker := time.NewTicker(scheduledEmailInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			err := handlers.Repo.SendScheduledEmails(ctx)
+			if err != nil {
+				app.Logger.Error("can't queue scheduled emails", "error", err)
+			}
+		}
+	})
+}
@@ -1,63 +1,84 @@
 package main
 
 import (
-	"fmt"
+	"context"
+	"github.com/usmanzaheer1995/bed-and-breakfast/internal/handlers"
 	"github.com/usmanzaheer1995/bed-and-breakfast/internal/models"
-	mail "github.com/xhit/go-simple-mail/v2"
-	"io/ioutil"
-	"log"
-	"strings"
+	"sync"
 	"time"
 )
 
-func listenForMail() {
-	go func() {
-		for {
-			msg := <-app.MailChan
-			sendMail(msg)
-			//select {
-			//case <-app.MailChan:
-			//	sendMail(<-app.MailChan)
-			//}
+// mailWorkers is how many emails are delivered at once
+const mailWorkers = 3
+
+// mailBatchSize is how many due emails are claimed from the outbox at a time
+const mailBatchSize = 30
+
+// mailPollInterval is how often the outbox is checked for emails due for a retry
+const mailPollInterval = 30 * time.Second
+
+// mailWorkerMaxSilence is how long the mail worker can go without checking the outbox before it
+// is reported as not running
+const mailWorkerMaxSilence = 3 * mailPollInterval
+
+// listenForMail starts a pool of workers delivering emails from the outbox. The outbox is checked
+// whenever a handler queues an email, and regularly for retries. On shutdown everything already due
+// is sent before the workers stop
+func listenForMail(bg *background) {
+	bg.Go(func(ctx context.Context) {
+		// the final flush runs after ctx is done, so the outbox is worked on with a context that isn't
+		work := context.WithoutCancel(ctx)
+		jobs := make(chan models.OutboxMessage)
+
+		var workers sync.WaitGroup
+		for i := 0; i < mailWorkers; i++ {
+			workers.Add(1)
+			go func() {
+				defer workers.Done()
+				for msg := range jobs {
+					err := handlers.Repo.DeliverMail(work, msg)
+					if err != nil {
+						app.Logger.Error("can't deliver email", "outbox_id", msg.ID, "error", err)
+					}
+				}
+			}()
 		}
 
-	}()
-}
+		defer func() {
+			close(jobs)
+			workers.Wait()
+		}()
+
+		ticker := time.NewTicker(mailPollInterval)
+		defer ticker.Stop()
+
+		for {
+			app.MailWorker.Beat()
+
+			messages, err := handlers.Repo.DueMail(work, mailBatchSize)
+			if err != nil {
+				app.Logger.Error("can't fetch due email", "error", err)
+			}
+
+			for _, msg := range messages {
+				jobs <- msg
+			}
+
+			// a full batch means more may be waiting
+			if len(messages) == mailBatchSize {
+				continue
+			}
+
+			// the outbox has been flushed one last time
+			if ctx.Err() != nil {
+				return
+			}
 
-func sendMail(m models.MailData) {
-	server := mail.NewSMTPClient()
-	server.Host = "localhost"
-	server.Port = 1025
-	server.KeepAlive = false
-	server.ConnectTimeout = 10 * time.Second
-	server.SendTimeout = 10 * time.Second
-
-	client, err := server.Connect()
-	if err != nil {
-		errorLog.Println(err)
-	}
-
-	email := mail.NewMSG()
-	email.
-		SetFrom(m.From).
-		AddTo(m.To).
-		SetSubject(m.Subject)
-
-	if m.Template == "" {
-		email.SetBody(mail.TextHTML, m.Content)
-	} else {
-		data, err := ioutil.ReadFile(fmt.Sprintf("./email-templates/%s", m.Template))
-		if err != nil {
-			app.ErrorLog.Println(err)
+			select {
+			case <-ctx.Done():
+			case <-ticker.C:
+			case <-app.MailQueueChan:
+			}
 		}
-		mailTemplate := string(data)
-		msgToSend := strings.Replace(mailTemplate, "[%body%]", m.Content, 1)
-		email.SetBody(mail.TextHTML, msgToSend)
-	}
-
-	err = email.Send(client)
-	if err != nil {
-		errorLog.Println(err)
-	}
-	log.Println("Email sent")
+	})
 }
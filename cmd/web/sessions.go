@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/alexedwards/scs/v2"
+	"github.com/usmanzaheer1995/bed-and-breakfast/internal/config"
+	"github.com/usmanzaheer1995/bed-and-breakfast/internal/driver"
+	"github.com/usmanzaheer1995/bed-and-breakfast/internal/sessionstore"
+	"time"
+)
+
+// sessionSweepInterval is how often expired sessions are deleted from the database
+const sessionSweepInterval = 5 * time.Minute
+
+// newSessionStore returns the store configured for sessions, or nil to keep them in memory
+func newSessionStore(settings config.Settings, db *driver.DB) (scs.Store, error) {
+	timeout := time.Duration(settings.Database.QueryTimeout)
+
+	switch settings.Session.Store {
+	case "postgres":
+		return sessionstore.NewPostgresStore(db.SQL, timeout), nil
+	case "redis":
+		redis := settings.Session.Redis
+		store := sessionstore.NewRedisStore(redis.Addr, redis.Password, redis.DB, timeout)
+		err := store.Ping()
+		if err != nil {
+			return nil, fmt.Errorf("cannot connect to redis at %s: %w", redis.Addr, err)
+		}
+		return store, nil
+	default:
+		return nil, nil
+	}
+}
+
+// listenForExpiredSessions deletes expired sessions from the database, which unlike memory and
+// redis doesn't drop them by itself
+func listenForExpiredSessions(bg *background, store *sessionstore.PostgresStore) {
+	bg.Go(func(ctx context.Context) {
+		ticker := time.NewTicker(sessionSweepInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			deleted, err := store.DeleteExpired(ctx)
+			if err != nil {
+				app.Logger.Error("can't delete expired sessions", "error", err)
+			} else if deleted > 0 {
+				app.Logger.Debug("deleted expired sessions", "count", deleted)
+			}
+		}
+	})
+}
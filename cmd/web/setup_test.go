@@ -1,12 +1,15 @@
 package main
 
 import (
+	"github.com/usmanzaheer1995/bed-and-breakfast/internal/logging"
+	"log/slog"
 	"net/http"
 	"os"
 	"testing"
 )
 
 func TestMain(m *testing.M) {
+	app.Logger = logging.New(os.Stdout, logging.FormatText, slog.LevelInfo)
 
 	os.Exit(m.Run())
 }
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"context"
+	"github.com/usmanzaheer1995/bed-and-breakfast/internal/handlers"
+	"time"
+)
+
+// waitlistInterval is how often the waitlist is matched even when nothing has triggered it,
+// so lapsed holds are passed on to the next guest
+const waitlistInterval = 15 * time.Minute
+
+func listenForWaitlist(bg *background) {
+	bg.Go(func(ctx context.Context) {
+		ticker := time.NewTicker(waitlistInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-app.WaitlistChan:
+			case <-ticker.C:
+			}
+
+			err := handlers.Repo.MatchWaitlist(ctx)
+			if err != nil {
+				app.Logger.Error("can't match waitlist", "error", err)
+			}
+		}
+	})
+}
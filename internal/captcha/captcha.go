@@ -0,0 +1,185 @@
+package captcha
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ErrFailed is returned when the provider says the person didn't pass the challenge
+var ErrFailed = errors.New("captcha was not passed")
+
+// Verifier checks the response a CAPTCHA widget put into a form
+type Verifier interface {
+	// Verify checks response, given by the client at remoteIP. It returns ErrFailed if the challenge
+	// wasn't passed, or another error if the provider couldn't be asked
+	Verify(ctx context.Context, response, remoteIP string) error
+	// Widget describes how to show the challenge in a form
+	Widget() *Widget
+}
+
+// Widget is what a template needs to show a CAPTCHA: a div of Class with a data-sitekey of SiteKey, and
+// the script at Script, which puts the response into a form field named Field
+type Widget struct {
+	Script  string
+	Class   string
+	SiteKey string
+	Field   string
+	// Hosts are the origins the widget loads scripts and frames from, which the CSP has to allow
+	Hosts []string
+}
+
+// provider is a CAPTCHA service with a siteverify endpoint
+type provider struct {
+	verifyURL string
+	widget    Widget
+}
+
+// providers are the supported services. They share the same siteverify API
+var providers = map[string]provider{
+	"hcaptcha": {
+		verifyURL: "https://api.hcaptcha.com/siteverify",
+		widget: Widget{
+			Script: "https://js.hcaptcha.com/1/api.js",
+			Class:  "h-captcha",
+			Field:  "h-captcha-response",
+			Hosts:  []string{"https://hcaptcha.com", "https://*.hcaptcha.com"},
+		},
+	},
+	"turnstile": {
+		verifyURL: "https://challenges.cloudflare.com/turnstile/v0/siteverify",
+		widget: Widget{
+			Script: "https://challenges.cloudflare.com/turnstile/v0/api.js",
+			Class:  "cf-turnstile",
+			Field:  "cf-turnstile-response",
+			Hosts:  []string{"https://challenges.cloudflare.com"},
+		},
+	},
+	"recaptcha": {
+		verifyURL: "https://www.google.com/recaptcha/api/siteverify",
+		widget: Widget{
+			Script: "https://www.google.com/recaptcha/api.js",
+			Class:  "g-recaptcha",
+			Field:  "g-recaptcha-response",
+			Hosts:  []string{"https://www.google.com", "https://www.gstatic.com"},
+		},
+	},
+}
+
+// Providers returns the names of the supported services
+func Providers() []string {
+	var names []string
+	for name := range providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// SiteVerify verifies responses with a provider's siteverify endpoint
+type SiteVerify struct {
+	verifyURL string
+	secret    string
+	widget    Widget
+	client    *http.Client
+}
+
+// New creates a verifier for the named provider, whose requests give up after timeout
+func New(name, siteKey, secret string, timeout time.Duration) (*SiteVerify, error) {
+	p, ok := providers[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown captcha provider %q, must be one of %s", name, strings.Join(Providers(), ", "))
+	}
+
+	widget := p.widget
+	widget.SiteKey = siteKey
+
+	return &SiteVerify{
+		verifyURL: p.verifyURL,
+		secret:    secret,
+		widget:    widget,
+		client:    &http.Client{Timeout: timeout},
+	}, nil
+}
+
+// Widget describes the provider's widget
+func (s *SiteVerify) Widget() *Widget {
+	w := s.widget
+	return &w
+}
+
+// Verify asks the provider whether response passed the challenge
+func (s *SiteVerify) Verify(ctx context.Context, response, remoteIP string) error {
+	if response == "" {
+		return ErrFailed
+	}
+
+	form := url.Values{}
+	form.Set("secret", s.secret)
+	form.Set("response", response)
+	if remoteIP != "" {
+		form.Set("remoteip", remoteIP)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", s.verifyURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("captcha provider answered %s", resp.Status)
+	}
+
+	var result struct {
+		Success    bool     `json:"success"`
+		ErrorCodes []string `json:"error-codes"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&result)
+	if err != nil {
+		return err
+	}
+
+	if !result.Success {
+		return fmt.Errorf("%w: %s", ErrFailed, strings.Join(result.ErrorCodes, ", "))
+	}
+	return nil
+}
+
+// FakePass is the only response the fake verifier accepts
+const FakePass = "pass"
+
+// Fake is a verifier for tests and development, which passes the response FakePass and fails any other
+type Fake struct{}
+
+// Verify passes FakePass
+func (Fake) Verify(ctx context.Context, response, remoteIP string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if response != FakePass {
+		return ErrFailed
+	}
+	return nil
+}
+
+// Widget describes a widget with no script, for a test to fill in the field itself
+func (Fake) Widget() *Widget {
+	return &Widget{
+		Class:   "fake-captcha",
+		SiteKey: "fake",
+		Field:   "fake-captcha-response",
+	}
+}
@@ -0,0 +1,79 @@
+package captcha
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestNew(t *testing.T) {
+	for _, name := range Providers() {
+		v, err := New(name, "site-key", "secret", time.Second)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if w := v.Widget(); w.SiteKey != "site-key" || w.Script == "" || w.Field == "" || len(w.Hosts) == 0 {
+			t.Errorf("%s: incomplete widget %+v", name, w)
+		}
+	}
+
+	_, err := New("nocaptcha", "site-key", "secret", time.Second)
+	if err == nil {
+		t.Error("expected an error for an unknown provider")
+	}
+}
+
+var siteVerifyTests = []struct {
+	name     string
+	response string
+	status   int
+	body     string
+	expected error
+}{
+	{"passed", "good", http.StatusOK, `{"success": true}`, nil},
+	{"failed", "bad", http.StatusOK, `{"success": false, "error-codes": ["invalid-input-response"]}`, ErrFailed},
+	{"no response", "", http.StatusOK, `{"success": true}`, ErrFailed},
+	{"provider down", "good", http.StatusServiceUnavailable, ``, errors.New("any")},
+	{"garbage", "good", http.StatusOK, `<html>`, errors.New("any")},
+}
+
+func TestSiteVerify_Verify(t *testing.T) {
+	for _, e := range siteVerifyTests {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.FormValue("secret") != "secret" || r.FormValue("remoteip") != "203.0.113.7" {
+				t.Errorf("%s: unexpected request %v", e.name, r.Form)
+			}
+			w.WriteHeader(e.status)
+			w.Write([]byte(e.body))
+		}))
+
+		v, _ := New("hcaptcha", "site-key", "secret", time.Second)
+		v.verifyURL = srv.URL
+
+		err := v.Verify(context.Background(), e.response, "203.0.113.7")
+		switch {
+		case e.expected == nil && err != nil:
+			t.Errorf("%s: expected to pass, got %v", e.name, err)
+		case errors.Is(e.expected, ErrFailed) && !errors.Is(err, ErrFailed):
+			t.Errorf("%s: expected ErrFailed, got %v", e.name, err)
+		case e.expected != nil && !errors.Is(e.expected, ErrFailed) && (err == nil || errors.Is(err, ErrFailed)):
+			t.Errorf("%s: expected an error asking the provider, got %v", e.name, err)
+		}
+
+		srv.Close()
+	}
+}
+
+func TestFake(t *testing.T) {
+	var v Verifier = Fake{}
+
+	if err := v.Verify(context.Background(), FakePass, ""); err != nil {
+		t.Errorf("expected %q to pass, got %v", FakePass, err)
+	}
+	if err := v.Verify(context.Background(), "fail", ""); !errors.Is(err, ErrFailed) {
+		t.Errorf("expected anything else to fail, got %v", err)
+	}
+}
@@ -2,18 +2,40 @@ package config
 
 import (
 	"github.com/alexedwards/scs/v2"
-	"github.com/usmanzaheer1995/bed-and-breakfast/internal/models"
+	"github.com/usmanzaheer1995/bed-and-breakfast/internal/captcha"
+	"github.com/usmanzaheer1995/bed-and-breakfast/internal/health"
+	"github.com/usmanzaheer1995/bed-and-breakfast/internal/ical"
+	"github.com/usmanzaheer1995/bed-and-breakfast/internal/mailer"
 	"html/template"
-	"log"
+	"log/slog"
+	"net/netip"
+	"time"
 )
 
 // AppConfig holds the application config
 type AppConfig struct {
-	UseCache      bool
-	TemplateCache map[string]*template.Template
-	InfoLog       *log.Logger
-	ErrorLog      *log.Logger
-	InProduction  bool
-	Session       *scs.SessionManager
-	MailChan      chan models.MailData
+	UseCache        bool
+	TemplateCache   map[string]*template.Template
+	Logger          *slog.Logger
+	InProduction    bool
+	Session         *scs.SessionManager
+	Mailer          mailer.Mailer
+	EmailTemplates  *mailer.Templates
+	MailQueueChan   chan struct{}
+	MailWorker      *health.Heartbeat
+	WaitlistChan    chan struct{}
+	ListenAddr      string
+	MetricsAddr     string
+	ShutdownTimeout time.Duration
+	QueryTimeout    time.Duration
+	BaseURL         string
+	MailFrom        string
+	StaffMailFrom   string
+	DigestHour      int
+	Property        ical.Property
+	Features        Features
+	RateLimit       RateLimitSettings
+	TrustedProxies  []netip.Prefix
+	Spam            SpamSettings
+	Captcha         captcha.Verifier
 }
@@ -0,0 +1,621 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/BurntSushi/toml"
+	"github.com/usmanzaheer1995/bed-and-breakfast/internal/captcha"
+	"github.com/usmanzaheer1995/bed-and-breakfast/internal/ratelimit"
+	"gopkg.in/yaml.v3"
+	"io"
+	"io/ioutil"
+	"log/slog"
+	"net/mail"
+	"net/netip"
+	"net/url"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// EnvPrefix starts the name of every environment variable the server reads its settings from
+const EnvPrefix = "BNB_"
+
+// Settings is the server configuration, read from a YAML or TOML file, BNB_* environment variables and
+// command line flags. Each source overrides the one before it
+type Settings struct {
+	ConfigFile string `yaml:"-" toml:"-"`
+
+	Listen          string   `yaml:"listen" toml:"listen"`
+	MetricsListen   string   `yaml:"metrics_listen" toml:"metrics_listen"`
+	BaseURL         string   `yaml:"base_url" toml:"base_url"`
+	Production      bool     `yaml:"production" toml:"production"`
+	UseCache        bool     `yaml:"cache" toml:"cache"`
+	ShutdownTimeout Duration `yaml:"shutdown_timeout" toml:"shutdown_timeout"`
+	// TrustedProxies are the addresses, or CIDR ranges, of proxies whose X-Forwarded-For header is believed
+	TrustedProxies List `yaml:"trusted_proxies" toml:"trusted_proxies"`
+
+	Database  DatabaseSettings  `yaml:"database" toml:"database"`
+	Session   SessionSettings   `yaml:"session" toml:"session"`
+	SMTP      SMTPSettings      `yaml:"smtp" toml:"smtp"`
+	Mail      MailSettings      `yaml:"mail" toml:"mail"`
+	Property  PropertySettings  `yaml:"property" toml:"property"`
+	Features  Features          `yaml:"features" toml:"features"`
+	RateLimit RateLimitSettings `yaml:"rate_limit" toml:"rate_limit"`
+	Spam      SpamSettings      `yaml:"spam" toml:"spam"`
+	Log       LogSettings       `yaml:"log" toml:"log"`
+	Tracing   TracingSettings   `yaml:"tracing" toml:"tracing"`
+}
+
+// DatabaseSettings says how to connect to Postgres. A DSN, if set, is used as is
+type DatabaseSettings struct {
+	DSN      string `yaml:"dsn" toml:"dsn"`
+	Host     string `yaml:"host" toml:"host"`
+	Port     string `yaml:"port" toml:"port"`
+	Name     string `yaml:"name" toml:"name"`
+	User     string `yaml:"user" toml:"user"`
+	Password string `yaml:"password" toml:"password"`
+	SSLMode  string `yaml:"ssl_mode" toml:"ssl_mode"`
+	// QueryTimeout bounds every query the app runs
+	QueryTimeout Duration `yaml:"query_timeout" toml:"query_timeout"`
+	// AutoMigrate applies pending migrations when the server starts
+	AutoMigrate bool `yaml:"auto_migrate" toml:"auto_migrate"`
+}
+
+// SessionSettings configures user sessions
+type SessionSettings struct {
+	Lifetime Duration `yaml:"lifetime" toml:"lifetime"`
+	// Store is where sessions are kept: memory, postgres or redis. Only postgres and redis share
+	// sessions between instances and keep them across restarts
+	Store string        `yaml:"store" toml:"store"`
+	Redis RedisSettings `yaml:"redis" toml:"redis"`
+}
+
+// RedisSettings configures the Redis server sessions are kept in
+type RedisSettings struct {
+	Addr     string `yaml:"addr" toml:"addr"`
+	Password string `yaml:"password" toml:"password"`
+	DB       int    `yaml:"db" toml:"db"`
+}
+
+// SMTPSettings configures the SMTP server email is sent through
+type SMTPSettings struct {
+	Host       string `yaml:"host" toml:"host"`
+	Port       int    `yaml:"port" toml:"port"`
+	Username   string `yaml:"username" toml:"username"`
+	Password   string `yaml:"password" toml:"password"`
+	Encryption string `yaml:"encryption" toml:"encryption"`
+}
+
+// MailSettings configures the email the site sends
+type MailSettings struct {
+	From       string `yaml:"from" toml:"from"`
+	StaffFrom  string `yaml:"staff_from" toml:"staff_from"`
+	Dir        string `yaml:"dir" toml:"dir"`
+	DigestHour int    `yaml:"digest_hour" toml:"digest_hour"`
+}
+
+// PropertySettings describes the bed and breakfast
+type PropertySettings struct {
+	Name     string `yaml:"name" toml:"name"`
+	Address  string `yaml:"address" toml:"address"`
+	CheckIn  string `yaml:"check_in" toml:"check_in"`
+	CheckOut string `yaml:"check_out" toml:"check_out"`
+	TimeZone string `yaml:"time_zone" toml:"time_zone"`
+}
+
+// Features turns optional parts of the site on and off
+type Features struct {
+	Waitlist        bool `yaml:"waitlist" toml:"waitlist"`
+	FlexibleSearch  bool `yaml:"flexible_search" toml:"flexible_search"`
+	ScheduledEmails bool `yaml:"scheduled_emails" toml:"scheduled_emails"`
+	StaffDigest     bool `yaml:"staff_digest" toml:"staff_digest"`
+}
+
+// RateLimitSettings configures how often one client may use the public endpoints that invite abuse.
+// Each limit is written like "10/1m", or "off"
+type RateLimitSettings struct {
+	Enabled bool `yaml:"enabled" toml:"enabled"`
+	// Store is where clients' request counts are kept: memory, or postgres to share them between instances
+	Store string `yaml:"store" toml:"store"`
+	// Availability limits the availability searches, Reservation booking and Login signing in
+	Availability ratelimit.Limit `yaml:"availability" toml:"availability"`
+	Reservation  ratelimit.Limit `yaml:"reservation" toml:"reservation"`
+	Login        ratelimit.Limit `yaml:"login" toml:"login"`
+}
+
+// SpamSettings configures the checks that keep bots from booking rooms
+type SpamSettings struct {
+	// MinFillTime is the least time a person takes to fill in the reservation form; quicker posts are
+	// taken to be bots. Zero turns the check off
+	MinFillTime Duration `yaml:"min_fill_time" toml:"min_fill_time"`
+	// VerifyEmail holds new reservations, without blocking the room, until the guest follows a link
+	// emailed to them within VerifyWindow
+	VerifyEmail  bool            `yaml:"verify_email" toml:"verify_email"`
+	VerifyWindow Duration        `yaml:"verify_window" toml:"verify_window"`
+	Captcha      CaptchaSettings `yaml:"captcha" toml:"captcha"`
+}
+
+// CaptchaSettings configures the CAPTCHA on the reservation form. A provider of none leaves it out
+type CaptchaSettings struct {
+	Provider string `yaml:"provider" toml:"provider"`
+	SiteKey  string `yaml:"site_key" toml:"site_key"`
+	Secret   string `yaml:"secret" toml:"secret"`
+}
+
+// LogSettings configures the server log
+type LogSettings struct {
+	Format string `yaml:"format" toml:"format"`
+	Level  string `yaml:"level" toml:"level"`
+}
+
+// TracingSettings configures where OpenTelemetry traces are sent
+type TracingSettings struct {
+	Exporter    string  `yaml:"exporter" toml:"exporter"`
+	Endpoint    string  `yaml:"endpoint" toml:"endpoint"`
+	Insecure    bool    `yaml:"insecure" toml:"insecure"`
+	SampleRatio float64 `yaml:"sample_ratio" toml:"sample_ratio"`
+}
+
+// Duration is a time.Duration written like "24h" or "90m" in config files, environment variables and flags
+type Duration time.Duration
+
+// UnmarshalText parses a duration like "24h"
+func (d *Duration) UnmarshalText(text []byte) error {
+	v, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// String formats the duration like "24h0m0s"
+func (d Duration) String() string {
+	return time.Duration(d).String()
+}
+
+// Set parses a duration from a flag or environment variable
+func (d *Duration) Set(value string) error {
+	return d.UnmarshalText([]byte(value))
+}
+
+// List is a list of strings, written comma separated in environment variables and flags
+type List []string
+
+// String formats the list comma separated
+func (l List) String() string {
+	return strings.Join(l, ",")
+}
+
+// Set replaces the list with the comma separated values from a flag or environment variable
+func (l *List) Set(value string) error {
+	*l = nil
+	for _, v := range strings.Split(value, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			*l = append(*l, v)
+		}
+	}
+	return nil
+}
+
+// TrustedProxyPrefixes parses the trusted proxies, taking a single address to be a range of one
+func (s Settings) TrustedProxyPrefixes() ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, proxy := range s.TrustedProxies {
+		if addr, err := netip.ParseAddr(proxy); err == nil {
+			prefixes = append(prefixes, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+			continue
+		}
+
+		prefix, err := netip.ParsePrefix(proxy)
+		if err != nil {
+			return nil, fmt.Errorf("trusted proxy %q must be an IP address or a CIDR range", proxy)
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
+
+// DefaultSettings returns the settings used when nothing overrides them
+func DefaultSettings() Settings {
+	return Settings{
+		Listen:          ":8080",
+		MetricsListen:   "127.0.0.1:9090",
+		BaseURL:         "http://localhost:8080",
+		Production:      true,
+		UseCache:        true,
+		ShutdownTimeout: Duration(30 * time.Second),
+		TrustedProxies:  List{"127.0.0.1", "::1"},
+		Database: DatabaseSettings{
+			Host:         "localhost",
+			Port:         "5432",
+			SSLMode:      "disable",
+			QueryTimeout: Duration(3 * time.Second),
+		},
+		Session: SessionSettings{
+			Lifetime: Duration(24 * time.Hour),
+			Store:    "memory",
+			Redis: RedisSettings{
+				Addr: "localhost:6379",
+			},
+		},
+		SMTP: SMTPSettings{
+			Host:       "localhost",
+			Port:       1025,
+			Encryption: "none",
+		},
+		Mail: MailSettings{
+			From:       "developer@bednbreakfast.com",
+			StaffFrom:  "developer@bednbreakfast.com",
+			DigestHour: 7,
+		},
+		Property: PropertySettings{
+			Name:     "Usman's Bed and Breakfast",
+			CheckIn:  "15:00",
+			CheckOut: "11:00",
+			TimeZone: "Local",
+		},
+		Features: Features{
+			Waitlist:        true,
+			FlexibleSearch:  true,
+			ScheduledEmails: true,
+			StaffDigest:     true,
+		},
+		RateLimit: RateLimitSettings{
+			Enabled:      true,
+			Store:        "memory",
+			Availability: ratelimit.Limit{Requests: 30, Per: time.Minute},
+			Reservation:  ratelimit.Limit{Requests: 10, Per: time.Hour},
+			Login:        ratelimit.Limit{Requests: 10, Per: 15 * time.Minute},
+		},
+		Spam: SpamSettings{
+			MinFillTime:  Duration(3 * time.Second),
+			VerifyEmail:  true,
+			VerifyWindow: Duration(time.Hour),
+			Captcha: CaptchaSettings{
+				Provider: "none",
+			},
+		},
+		Log: LogSettings{
+			Format: "text",
+			Level:  "info",
+		},
+		Tracing: TracingSettings{
+			Exporter:    "none",
+			Endpoint:    "localhost:4318",
+			SampleRatio: 1,
+		},
+	}
+}
+
+// flagSet binds a flag to every setting. The BNB_* environment variable of a flag is its name in upper
+// case, so -dbpass can also be set with BNB_DBPASS
+func flagSet(s *Settings) *flag.FlagSet {
+	fs := flag.NewFlagSet("bed-and-breakfast", flag.ContinueOnError)
+
+	fs.StringVar(&s.ConfigFile, "config", s.ConfigFile, "Read settings from this YAML (.yml, .yaml) or TOML (.toml) file")
+
+	fs.StringVar(&s.Listen, "listen", s.Listen, "Address to listen on")
+	fs.StringVar(&s.MetricsListen, "metricslisten", s.MetricsListen, "Internal address serving /metrics, or empty for none")
+	fs.StringVar(&s.BaseURL, "baseurl", s.BaseURL, "Public URL of the site, used in email links")
+	fs.BoolVar(&s.Production, "production", s.Production, "Application is in production")
+	fs.BoolVar(&s.UseCache, "cache", s.UseCache, "Use template cache")
+	fs.Var(&s.ShutdownTimeout, "shutdowntimeout", "How long requests and background jobs get to finish on shutdown, e.g. 30s")
+	fs.Var(&s.TrustedProxies, "trustedproxies", "Comma separated addresses or CIDR ranges of proxies whose X-Forwarded-For is believed")
+
+	fs.StringVar(&s.Database.DSN, "dsn", s.Database.DSN, "Database connection string, used instead of the other database settings")
+	fs.StringVar(&s.Database.Host, "dbhost", s.Database.Host, "Database host")
+	fs.StringVar(&s.Database.Port, "dbport", s.Database.Port, "Database port")
+	fs.StringVar(&s.Database.Name, "dbname", s.Database.Name, "Database name")
+	fs.StringVar(&s.Database.User, "dbuser", s.Database.User, "Database user")
+	fs.StringVar(&s.Database.Password, "dbpass", s.Database.Password, "Database password")
+	fs.StringVar(&s.Database.SSLMode, "dbssl", s.Database.SSLMode, "Database ssl settings(disable, prefer, require)")
+	fs.Var(&s.Database.QueryTimeout, "dbtimeout", "Longest a database query may run, e.g. 3s")
+	fs.BoolVar(&s.Database.AutoMigrate, "automigrate", s.Database.AutoMigrate, "Apply pending database migrations on startup")
+
+	fs.Var(&s.Session.Lifetime, "sessionlifetime", "How long a session lasts, e.g. 24h")
+	fs.StringVar(&s.Session.Store, "sessionstore", s.Session.Store, "Where sessions are kept (memory, postgres, redis)")
+	fs.StringVar(&s.Session.Redis.Addr, "redisaddr", s.Session.Redis.Addr, "Redis server address (host:port) for sessions")
+	fs.StringVar(&s.Session.Redis.Password, "redispass", s.Session.Redis.Password, "Redis password")
+	fs.IntVar(&s.Session.Redis.DB, "redisdb", s.Session.Redis.DB, "Redis database number")
+
+	fs.StringVar(&s.SMTP.Host, "smtphost", s.SMTP.Host, "SMTP server host")
+	fs.IntVar(&s.SMTP.Port, "smtpport", s.SMTP.Port, "SMTP server port")
+	fs.StringVar(&s.SMTP.Username, "smtpuser", s.SMTP.Username, "SMTP username, if the server requires authentication")
+	fs.StringVar(&s.SMTP.Password, "smtppass", s.SMTP.Password, "SMTP password")
+	fs.StringVar(&s.SMTP.Encryption, "smtpencryption", s.SMTP.Encryption, "SMTP encryption (none, starttls, tls)")
+
+	fs.StringVar(&s.Mail.From, "mailfrom", s.Mail.From, "Sender address of email to guests")
+	fs.StringVar(&s.Mail.StaffFrom, "stafffrom", s.Mail.StaffFrom, "Sender address of email to staff")
+	fs.StringVar(&s.Mail.Dir, "maildir", s.Mail.Dir, "Write email to this maildir instead of sending it, for development")
+	fs.IntVar(&s.Mail.DigestHour, "digesthour", s.Mail.DigestHour, "Hour of the day (0-23), in the property's time zone, from which the daily notification digest is sent")
+
+	fs.StringVar(&s.Property.Name, "propertyname", s.Property.Name, "Name of the property, shown in calendar invites")
+	fs.StringVar(&s.Property.Address, "propertyaddress", s.Property.Address, "Address of the property, shown in calendar invites")
+	fs.StringVar(&s.Property.CheckIn, "checkin", s.Property.CheckIn, "Check-in time (hh:mm)")
+	fs.StringVar(&s.Property.CheckOut, "checkout", s.Property.CheckOut, "Check-out time (hh:mm)")
+	fs.StringVar(&s.Property.TimeZone, "timezone", s.Property.TimeZone, "Time zone of the property, e.g. Europe/London")
+
+	fs.BoolVar(&s.Features.Waitlist, "waitlist", s.Features.Waitlist, "Let guests join a waitlist for dates that are taken")
+	fs.BoolVar(&s.Features.FlexibleSearch, "flexiblesearch", s.Features.FlexibleSearch, "Offer the flexible dates search")
+	fs.BoolVar(&s.Features.ScheduledEmails, "scheduledemails", s.Features.ScheduledEmails, "Send scheduled pre-arrival and post-stay emails")
+	fs.BoolVar(&s.Features.StaffDigest, "staffdigest", s.Features.StaffDigest, "Send the daily staff notification digest")
+
+	fs.BoolVar(&s.RateLimit.Enabled, "ratelimit", s.RateLimit.Enabled, "Limit how often one client may search, book and sign in")
+	fs.StringVar(&s.RateLimit.Store, "ratelimitstore", s.RateLimit.Store, "Where request counts are kept (memory, postgres)")
+	fs.Var(&s.RateLimit.Availability, "availabilitylimit", "Availability searches allowed per client, e.g. 30/1m, or off")
+	fs.Var(&s.RateLimit.Reservation, "reservationlimit", "Reservations allowed per client, e.g. 10/1h, or off")
+	fs.Var(&s.RateLimit.Login, "loginlimit", "Sign in attempts allowed per client, e.g. 10/15m, or off")
+
+	fs.Var(&s.Spam.MinFillTime, "minfilltime", "Reservation forms posted quicker than this are taken for bots, e.g. 3s, or 0s for no check")
+	fs.BoolVar(&s.Spam.VerifyEmail, "verifyemail", s.Spam.VerifyEmail, "Hold reservations until the guest confirms their email address")
+	fs.Var(&s.Spam.VerifyWindow, "verifywindow", "How long a guest has to confirm their email address, e.g. 1h")
+	fs.StringVar(&s.Spam.Captcha.Provider, "captcha", s.Spam.Captcha.Provider, "CAPTCHA on the reservation form (none, "+strings.Join(captcha.Providers(), ", ")+")")
+	fs.StringVar(&s.Spam.Captcha.SiteKey, "captchasitekey", s.Spam.Captcha.SiteKey, "CAPTCHA site key")
+	fs.StringVar(&s.Spam.Captcha.Secret, "captchasecret", s.Spam.Captcha.Secret, "CAPTCHA secret key")
+
+	fs.StringVar(&s.Log.Format, "logformat", s.Log.Format, "Log format (text, json)")
+	fs.StringVar(&s.Log.Level, "loglevel", s.Log.Level, "Lowest level logged (debug, info, warn, error)")
+
+	fs.StringVar(&s.Tracing.Exporter, "tracing", s.Tracing.Exporter, "Where to send traces (none, otlp)")
+	fs.StringVar(&s.Tracing.Endpoint, "otlpendpoint", s.Tracing.Endpoint, "OTLP/HTTP collector address, host:port")
+	fs.BoolVar(&s.Tracing.Insecure, "otlpinsecure", s.Tracing.Insecure, "Send traces to the collector over plain HTTP")
+	fs.Float64Var(&s.Tracing.SampleRatio, "tracesample", s.Tracing.SampleRatio, "Share of requests traced, from 0 to 1")
+
+	return fs
+}
+
+// LoadSettings reads the settings from, in increasing order of precedence, the defaults, the config file
+// named by -config or BNB_CONFIG, BNB_* environment variables and the command line flags in args.
+// The settings are validated before they are returned
+func LoadSettings(args []string, getenv func(string) string) (Settings, error) {
+	s, _, err := LoadCommandSettings(args, getenv)
+	return s, err
+}
+
+// LoadCommandSettings is LoadSettings for tools that take settings flags before a command of their
+// own. Flags are parsed up to the first argument that isn't one, and the arguments from there are
+// returned
+func LoadCommandSettings(args []string, getenv func(string) string) (Settings, []string, error) {
+	// the flags are parsed once to find the config file, and again at the end to override everything else
+	s := DefaultSettings()
+	err := flagSet(&s).Parse(args)
+	if err != nil {
+		return s, nil, err
+	}
+
+	configFile := s.ConfigFile
+	if configFile == "" {
+		configFile = getenv(EnvPrefix + "CONFIG")
+	}
+
+	s = DefaultSettings()
+	if configFile != "" {
+		err = s.readFile(configFile)
+		if err != nil {
+			return s, nil, err
+		}
+	}
+
+	err = s.readEnv(getenv)
+	if err != nil {
+		return s, nil, err
+	}
+
+	fs := flagSet(&s)
+	fs.SetOutput(ioutil.Discard)
+	err = fs.Parse(args)
+	if err != nil {
+		return s, nil, err
+	}
+	s.ConfigFile = configFile
+
+	return s, fs.Args(), s.Validate()
+}
+
+// readFile reads settings from a YAML or TOML file, chosen by its extension. Unknown keys are reported,
+// so a misspelt setting is not silently ignored
+func (s *Settings) readFile(path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("can't read config file: %w", err)
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yml", ".yaml":
+		dec := yaml.NewDecoder(bytes.NewReader(data))
+		dec.KnownFields(true)
+		err = dec.Decode(s)
+		if errors.Is(err, io.EOF) {
+			// an empty file changes nothing
+			err = nil
+		}
+	case ".toml":
+		var md toml.MetaData
+		md, err = toml.Decode(string(data), s)
+		if err == nil && len(md.Undecoded()) > 0 {
+			var keys []string
+			for _, k := range md.Undecoded() {
+				keys = append(keys, k.String())
+			}
+			err = fmt.Errorf("unknown settings %s", strings.Join(keys, ", "))
+		}
+	default:
+		return fmt.Errorf("config file %s must end in .yml, .yaml or .toml", path)
+	}
+
+	if err != nil {
+		return fmt.Errorf("invalid config file %s: %w", path, err)
+	}
+	return nil
+}
+
+// readEnv overrides settings with the BNB_* environment variables that are set
+func (s *Settings) readEnv(getenv func(string) string) error {
+	var err error
+	flagSet(s).VisitAll(func(f *flag.Flag) {
+		if err != nil || f.Name == "config" {
+			return
+		}
+
+		name := EnvPrefix + strings.ToUpper(f.Name)
+		value := getenv(name)
+		if value == "" {
+			return
+		}
+
+		if setErr := f.Value.Set(value); setErr != nil {
+			err = fmt.Errorf("invalid value %q for %s: %w", value, name, setErr)
+		}
+	})
+	return err
+}
+
+// Validate checks the settings make sense, reporting every problem found
+func (s Settings) Validate() error {
+	var problems []string
+	add := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	if s.Listen == "" {
+		add("listen address is required")
+	}
+
+	if s.MetricsListen != "" && s.MetricsListen == s.Listen {
+		add("metrics listen address must differ from the listen address")
+	}
+
+	u, err := url.Parse(s.BaseURL)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		add("base url %q must be an absolute URL like https://example.com", s.BaseURL)
+	}
+
+	if s.Database.DSN == "" && (s.Database.Name == "" || s.Database.User == "") {
+		add("database: set a dsn, or a database name and user")
+	}
+
+	if s.Database.QueryTimeout <= 0 {
+		add("database query timeout must be positive")
+	}
+
+	if s.ShutdownTimeout <= 0 {
+		add("shutdown timeout must be positive")
+	}
+
+	if s.Session.Lifetime <= 0 {
+		add("session lifetime must be positive")
+	}
+
+	switch s.Session.Store {
+	case "memory", "postgres":
+	case "redis":
+		if s.Session.Redis.Addr == "" {
+			add("redis address is required when sessions are kept in redis")
+		}
+		if s.Session.Redis.DB < 0 {
+			add("redis database %d must not be negative", s.Session.Redis.DB)
+		}
+	default:
+		add("session store %q must be memory, postgres or redis", s.Session.Store)
+	}
+
+	if s.Mail.Dir == "" {
+		if s.SMTP.Host == "" {
+			add("smtp host is required unless mail is written to a maildir")
+		}
+		if s.SMTP.Port < 1 || s.SMTP.Port > 65535 {
+			add("smtp port %d is not a valid port", s.SMTP.Port)
+		}
+		switch s.SMTP.Encryption {
+		case "none", "starttls", "tls":
+		default:
+			add("smtp encryption %q must be none, starttls or tls", s.SMTP.Encryption)
+		}
+	}
+
+	for name, address := range map[string]string{"mail from": s.Mail.From, "staff from": s.Mail.StaffFrom} {
+		if _, err := mail.ParseAddress(address); err != nil {
+			add("%s address %q is not a valid email address", name, address)
+		}
+	}
+
+	if s.Mail.DigestHour < 0 || s.Mail.DigestHour > 23 {
+		add("digest hour %d must be between 0 and 23", s.Mail.DigestHour)
+	}
+
+	for name, clock := range map[string]string{"check-in": s.Property.CheckIn, "check-out": s.Property.CheckOut} {
+		if _, err := time.Parse("15:04", clock); err != nil {
+			add("%s time %q must be written like 15:00", name, clock)
+		}
+	}
+
+	if _, err := time.LoadLocation(s.Property.TimeZone); err != nil {
+		add("unknown time zone %q", s.Property.TimeZone)
+	}
+
+	if s.Log.Format != "text" && s.Log.Format != "json" {
+		add("log format %q must be text or json", s.Log.Format)
+	}
+
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(s.Log.Level)); err != nil {
+		add("log level %q must be debug, info, warn or error", s.Log.Level)
+	}
+
+	if _, err := s.TrustedProxyPrefixes(); err != nil {
+		add("%s", err)
+	}
+
+	if s.RateLimit.Store != "memory" && s.RateLimit.Store != "postgres" {
+		add("rate limit store %q must be memory or postgres", s.RateLimit.Store)
+	}
+
+	if s.Spam.MinFillTime < 0 {
+		add("minimum fill time must not be negative")
+	}
+
+	if s.Spam.VerifyEmail && s.Spam.VerifyWindow <= 0 {
+		add("email verification window must be positive")
+	}
+
+	if c := s.Spam.Captcha; c.Provider != "none" {
+		if _, err := captcha.New(c.Provider, c.SiteKey, c.Secret, 0); err != nil {
+			add("%s", err)
+		} else if c.SiteKey == "" || c.Secret == "" {
+			add("captcha: set the site key and secret")
+		}
+	}
+
+	if s.Tracing.Exporter != "none" && s.Tracing.Exporter != "otlp" {
+		add("tracing exporter %q must be none or otlp", s.Tracing.Exporter)
+	}
+
+	if s.Tracing.Exporter == "otlp" && s.Tracing.Endpoint == "" {
+		add("tracing: set the otlp endpoint")
+	}
+
+	if s.Tracing.SampleRatio < 0 || s.Tracing.SampleRatio > 1 {
+		add("trace sample ratio %g must be between 0 and 1", s.Tracing.SampleRatio)
+	}
+
+	if len(problems) > 0 {
+		// maps are walked in random order, so sort for a stable message
+		sort.Strings(problems)
+		return fmt.Errorf("invalid configuration:\n  - %s", strings.Join(problems, "\n  - "))
+	}
+	return nil
+}
+
+// ConnectionString returns the connection string for the database
+func (d DatabaseSettings) ConnectionString() string {
+	if d.DSN != "" {
+		return d.DSN
+	}
+	return fmt.Sprintf("host=%s port=%s dbname=%s user=%s password=%s sslmode=%s",
+		d.Host, d.Port, d.Name, d.User, d.Password, d.SSLMode)
+}
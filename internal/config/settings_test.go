@@ -0,0 +1,331 @@
+package config
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// env returns a getenv function reading from a map
+func env(vars map[string]string) func(string) string {
+	return func(name string) string {
+		return vars[name]
+	}
+}
+
+// writeFile writes a config file into a temporary directory and returns its path
+func writeFile(t *testing.T, name, content string) string {
+	path := filepath.Join(t.TempDir(), name)
+	err := ioutil.WriteFile(path, []byte(content), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadSettings_Defaults(t *testing.T) {
+	s, err := LoadSettings([]string{"-dbname=bedandbreakfast", "-dbuser=postgres"}, env(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if s.Listen != ":8080" {
+		t.Errorf("expected default listen address :8080, got %s", s.Listen)
+	}
+	if s.MetricsListen != "127.0.0.1:9090" {
+		t.Errorf("expected metrics on loopback by default, got %s", s.MetricsListen)
+	}
+	if time.Duration(s.Session.Lifetime) != 24*time.Hour {
+		t.Errorf("expected default session lifetime of 24h, got %s", s.Session.Lifetime)
+	}
+	if !s.Features.Waitlist || !s.Features.FlexibleSearch {
+		t.Error("expected features to be on by default")
+	}
+	if s.Database.ConnectionString() != "host=localhost port=5432 dbname=bedandbreakfast user=postgres password= sslmode=disable" {
+		t.Errorf("unexpected connection string %q", s.Database.ConnectionString())
+	}
+}
+
+func TestLoadSettings_YAML(t *testing.T) {
+	path := writeFile(t, "bnb.yml", `
+listen: ":9000"
+trusted_proxies: [10.0.0.1, 192.168.0.0/16]
+database:
+  dsn: postgres://postgres@localhost/bedandbreakfast
+session:
+  lifetime: 90m
+mail:
+  from: bookings@here.com
+features:
+  waitlist: false
+rate_limit:
+  login: 5/1m
+  availability: "off"
+spam:
+  verify_window: 30m
+  captcha:
+    provider: turnstile
+    site_key: site
+    secret: shh
+`)
+
+	s, err := LoadSettings([]string{"-config", path}, env(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if s.Listen != ":9000" {
+		t.Errorf("expected listen address from file, got %s", s.Listen)
+	}
+	if s.Database.ConnectionString() != "postgres://postgres@localhost/bedandbreakfast" {
+		t.Errorf("expected the dsn to be used as is, got %q", s.Database.ConnectionString())
+	}
+	if time.Duration(s.Session.Lifetime) != 90*time.Minute {
+		t.Errorf("expected session lifetime from file, got %s", s.Session.Lifetime)
+	}
+	if s.Mail.From != "bookings@here.com" {
+		t.Errorf("expected mail from address from file, got %s", s.Mail.From)
+	}
+	if s.Features.Waitlist || !s.Features.FlexibleSearch {
+		t.Error("expected only the waitlist to be turned off")
+	}
+	if s.RateLimit.Login.Requests != 5 || s.RateLimit.Login.Per != time.Minute || !s.RateLimit.Availability.Off() {
+		t.Errorf("expected rate limits from file, got %+v", s.RateLimit)
+	}
+	if s.RateLimit.Reservation.Requests != 10 {
+		t.Errorf("expected the default reservation limit, got %s", s.RateLimit.Reservation)
+	}
+	if !s.Spam.VerifyEmail || time.Duration(s.Spam.VerifyWindow) != 30*time.Minute || s.Spam.Captcha.Provider != "turnstile" {
+		t.Errorf("expected spam settings from file, got %+v", s.Spam)
+	}
+
+	prefixes, err := s.TrustedProxyPrefixes()
+	if err != nil || len(prefixes) != 2 || prefixes[0].String() != "10.0.0.1/32" || prefixes[1].String() != "192.168.0.0/16" {
+		t.Errorf("expected trusted proxies from file, got %v (%v)", prefixes, err)
+	}
+}
+
+func TestLoadSettings_TOML(t *testing.T) {
+	path := writeFile(t, "bnb.toml", `
+listen = ":9001"
+
+[database]
+name = "bedandbreakfast"
+user = "postgres"
+
+[smtp]
+host = "mail.here.com"
+port = 587
+encryption = "starttls"
+`)
+
+	s, err := LoadSettings(nil, env(map[string]string{"BNB_CONFIG": path}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if s.Listen != ":9001" || s.SMTP.Host != "mail.here.com" || s.SMTP.Port != 587 {
+		t.Errorf("expected settings from the file named by BNB_CONFIG, got %+v", s)
+	}
+}
+
+func TestLoadSettings_Precedence(t *testing.T) {
+	path := writeFile(t, "bnb.yml", `
+listen: ":9000"
+base_url: https://file.example.com
+database:
+  name: bedandbreakfast
+  user: postgres
+  password: from-file
+`)
+
+	s, err := LoadSettings([]string{"-config", path, "-listen", ":9002"}, env(map[string]string{
+		"BNB_LISTEN":  ":9001",
+		"BNB_BASEURL": "https://env.example.com",
+		"BNB_DBPASS":  "from-env",
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if s.Listen != ":9002" {
+		t.Errorf("expected the flag to override the environment, got %s", s.Listen)
+	}
+	if s.BaseURL != "https://env.example.com" {
+		t.Errorf("expected the environment to override the file, got %s", s.BaseURL)
+	}
+	if s.Database.Password != "from-env" {
+		t.Errorf("expected the password from the environment, got %s", s.Database.Password)
+	}
+	if s.Database.Name != "bedandbreakfast" {
+		t.Errorf("expected the database name from the file, got %s", s.Database.Name)
+	}
+}
+
+var loadSettingsErrorTests = []struct {
+	name          string
+	file          string
+	content       string
+	args          []string
+	env           map[string]string
+	expectedError string
+}{
+	{
+		name:          "no database",
+		expectedError: "database: set a dsn, or a database name and user",
+	},
+	{
+		name:          "unknown yaml key",
+		file:          "bnb.yml",
+		content:       "databse:\n  name: x\n",
+		expectedError: "databse",
+	},
+	{
+		name:          "unknown toml key",
+		file:          "bnb.toml",
+		content:       "[databse]\nname = \"x\"\n",
+		expectedError: "unknown settings databse",
+	},
+	{
+		name:          "unsupported file type",
+		file:          "bnb.json",
+		content:       "{}",
+		expectedError: "must end in .yml, .yaml or .toml",
+	},
+	{
+		name:          "invalid environment value",
+		args:          []string{"-dbname=x", "-dbuser=y"},
+		env:           map[string]string{"BNB_SMTPPORT": "many"},
+		expectedError: "BNB_SMTPPORT",
+	},
+	{
+		name:          "invalid values",
+		args:          []string{"-dbname=x", "-dbuser=y", "-smtpencryption=ssl", "-mailfrom=nobody", "-checkin=3pm", "-digesthour=24"},
+		expectedError: "smtp encryption \"ssl\" must be none, starttls or tls",
+	},
+	{
+		name:          "metrics on the public listener",
+		args:          []string{"-dbname=x", "-dbuser=y", "-listen=:8080", "-metricslisten=:8080"},
+		expectedError: "metrics listen address must differ from the listen address",
+	},
+	{
+		name:          "negative shutdown timeout",
+		args:          []string{"-dbname=x", "-dbuser=y", "-shutdowntimeout=-5s"},
+		expectedError: "shutdown timeout must be positive",
+	},
+	{
+		name:          "zero query timeout",
+		args:          []string{"-dbname=x", "-dbuser=y", "-dbtimeout=0s"},
+		expectedError: "database query timeout must be positive",
+	},
+	{
+		name:          "unknown session store",
+		args:          []string{"-dbname=x", "-dbuser=y", "-sessionstore=cookie"},
+		expectedError: "session store \"cookie\" must be memory, postgres or redis",
+	},
+	{
+		name:          "redis without an address",
+		args:          []string{"-dbname=x", "-dbuser=y", "-sessionstore=redis", "-redisaddr="},
+		expectedError: "redis address is required when sessions are kept in redis",
+	},
+	{
+		name:          "bad trusted proxy",
+		args:          []string{"-dbname=x", "-dbuser=y", "-trustedproxies=10.0.0.0/8,caddy"},
+		expectedError: "trusted proxy \"caddy\" must be an IP address or a CIDR range",
+	},
+	{
+		name:          "bad rate limit",
+		args:          []string{"-dbname=x", "-dbuser=y", "-loginlimit=10"},
+		expectedError: "rate limit \"10\" must be like 10/1m, or off",
+	},
+	{
+		name:          "unknown rate limit store",
+		args:          []string{"-dbname=x", "-dbuser=y", "-ratelimitstore=redis"},
+		expectedError: "rate limit store \"redis\" must be memory or postgres",
+	},
+	{
+		name:          "no email verification window",
+		args:          []string{"-dbname=x", "-dbuser=y", "-verifywindow=0s"},
+		expectedError: "email verification window must be positive",
+	},
+	{
+		name:          "unknown captcha provider",
+		args:          []string{"-dbname=x", "-dbuser=y", "-captcha=nocaptcha"},
+		expectedError: "unknown captcha provider \"nocaptcha\"",
+	},
+	{
+		name:          "captcha without keys",
+		args:          []string{"-dbname=x", "-dbuser=y", "-captcha=turnstile", "-captchasitekey=abc"},
+		expectedError: "captcha: set the site key and secret",
+	},
+	{
+		name:          "unknown log level",
+		args:          []string{"-dbname=x", "-dbuser=y", "-loglevel=loud"},
+		expectedError: "log level \"loud\" must be debug, info, warn or error",
+	},
+	{
+		name:          "unknown tracing exporter",
+		args:          []string{"-dbname=x", "-dbuser=y", "-tracing=jaeger", "-tracesample=2"},
+		expectedError: "tracing exporter \"jaeger\" must be none or otlp",
+	},
+	{
+		name:          "unknown flag",
+		args:          []string{"-colour=blue"},
+		expectedError: "flag provided but not defined",
+	},
+}
+
+func TestLoadSettings_Errors(t *testing.T) {
+	for _, e := range loadSettingsErrorTests {
+		args := e.args
+		if e.file != "" {
+			args = append([]string{"-config", writeFile(t, e.file, e.content)}, args...)
+		}
+
+		_, err := LoadSettings(args, env(e.env))
+		if err == nil {
+			t.Errorf("%s: expected an error", e.name)
+			continue
+		}
+		if !strings.Contains(err.Error(), e.expectedError) {
+			t.Errorf("%s: expected error to contain %q, got %q", e.name, e.expectedError, err)
+		}
+	}
+}
+
+func TestLoadCommandSettings(t *testing.T) {
+	s, rest, err := LoadCommandSettings([]string{"-dbname=bedandbreakfast", "-dbuser=postgres", "user", "create",
+		"-email=jane@doe.com"}, env(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if s.Database.Name != "bedandbreakfast" {
+		t.Errorf("expected the settings flags to be read, got %+v", s.Database)
+	}
+	if strings.Join(rest, " ") != "user create -email=jane@doe.com" {
+		t.Errorf("expected the command and its flags back, got %v", rest)
+	}
+}
+
+func TestSettings_ValidateReportsEveryProblem(t *testing.T) {
+	s := DefaultSettings()
+	s.Database.Name = "bedandbreakfast"
+	s.Database.User = "postgres"
+	s.Mail.From = "nobody"
+	s.Property.CheckIn = "3pm"
+	s.Mail.DigestHour = 24
+
+	err := s.Validate()
+	if err == nil {
+		t.Fatal("expected invalid settings")
+	}
+
+	for _, expected := range []string{"mail from address", "check-in time", "digest hour 24"} {
+		if !strings.Contains(err.Error(), expected) {
+			t.Errorf("expected error to mention %q, got %q", expected, err)
+		}
+	}
+}
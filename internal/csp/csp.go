@@ -0,0 +1,67 @@
+package csp
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"strings"
+)
+
+// Hosts the templates load scripts, styles and fonts from
+var cdnHosts = []string{"https://cdn.jsdelivr.net", "https://unpkg.com"}
+
+// nonceKey is the context key for the nonce
+type nonceKey struct{}
+
+// NewNonce makes a random nonce for one response
+func NewNonce() (string, error) {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(b), nil
+}
+
+// WithNonce returns a copy of ctx carrying the nonce
+func WithNonce(ctx context.Context, nonce string) context.Context {
+	return context.WithValue(ctx, nonceKey{}, nonce)
+}
+
+// NonceFrom returns the nonce carried by ctx, or an empty string
+func NonceFrom(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	nonce, _ := ctx.Value(nonceKey{}).(string)
+	return nonce
+}
+
+// Policy returns the Content-Security-Policy for a response. Scripts run only from the site, the CDNs
+// and inline script tags carrying the nonce, so inline event handlers and injected scripts don't run.
+// Inline styles are allowed, as the templates and the alert libraries use them. Widgets are the hosts
+// of an embedded widget, such as a CAPTCHA, which loads scripts and frames and calls home
+func Policy(nonce string, widgets ...string) string {
+	cdns := strings.Join(cdnHosts, " ")
+	extra := ""
+	if len(widgets) > 0 {
+		extra = " " + strings.Join(widgets, " ")
+	}
+
+	directives := []string{
+		"default-src 'self'",
+		"script-src 'self' 'nonce-" + nonce + "' " + cdns + extra,
+		"style-src 'self' 'unsafe-inline' " + cdns + extra,
+		"font-src 'self' data: " + cdns,
+		// email previews show images from anywhere
+		"img-src 'self' data: https:",
+		"connect-src 'self'" + extra,
+		// the map on the contact page
+		"frame-src https://www.google.com" + extra,
+		"frame-ancestors 'none'",
+		"base-uri 'self'",
+		"form-action 'self'",
+		"object-src 'none'",
+	}
+	return strings.Join(directives, "; ")
+}
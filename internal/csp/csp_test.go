@@ -0,0 +1,68 @@
+package csp
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestNewNonce(t *testing.T) {
+	a, err := NewNonce()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := NewNonce()
+
+	if len(a) != 24 {
+		t.Errorf("expected a 24 character nonce, got %q", a)
+	}
+	if a == b {
+		t.Error("expected a different nonce each time")
+	}
+}
+
+func TestNonceFrom(t *testing.T) {
+	if NonceFrom(context.Background()) != "" {
+		t.Error("expected no nonce in a plain context")
+	}
+	if NonceFrom(WithNonce(context.Background(), "abc")) != "abc" {
+		t.Error("expected the nonce put in the context")
+	}
+}
+
+func TestPolicy(t *testing.T) {
+	policy := Policy("abc")
+
+	for _, want := range []string{
+		"script-src 'self' 'nonce-abc' https://cdn.jsdelivr.net https://unpkg.com",
+		"frame-ancestors 'none'",
+		"object-src 'none'",
+	} {
+		if !strings.Contains(policy, want) {
+			t.Errorf("expected the policy to contain %q, got %q", want, policy)
+		}
+	}
+	for _, directive := range strings.Split(policy, "; ") {
+		if strings.HasPrefix(directive, "script-src") && strings.Contains(directive, "unsafe-inline") {
+			t.Errorf("expected inline scripts to need the nonce, got %q", directive)
+		}
+	}
+}
+
+func TestPolicy_Widgets(t *testing.T) {
+	policy := Policy("abc", "https://challenges.cloudflare.com")
+
+	for _, directive := range strings.Split(policy, "; ") {
+		name, _, _ := strings.Cut(directive, " ")
+		switch name {
+		case "script-src", "frame-src", "connect-src":
+			if !strings.HasSuffix(directive, " https://challenges.cloudflare.com") {
+				t.Errorf("expected %s to allow the widget, got %q", name, directive)
+			}
+		case "frame-ancestors", "object-src":
+			if strings.Contains(directive, "cloudflare") {
+				t.Errorf("expected %s not to allow the widget, got %q", name, directive)
+			}
+		}
+	}
+}
@@ -5,8 +5,12 @@ import (
 	"github.com/asaskevich/govalidator"
 	"net/url"
 	"strings"
+	"time"
 )
 
+// WholeForm is the key of errors about the form as a whole rather than one of its fields
+const WholeForm = "form"
+
 // Form creates a custom form struct, and embeds a url.Values object
 type Form struct {
 	url.Values
@@ -61,3 +65,19 @@ func (f *Form) IsEmail(field string) {
 		f.Errors.Add(field, "Invalid email address")
 	}
 }
+
+// Honeypot checks that field, which the template hides from people, was left empty. Bots fill in
+// every field they find
+func (f *Form) Honeypot(field string) {
+	if f.Get(field) != "" {
+		f.Errors.Add(WholeForm, "Your request looked automated, please try again")
+	}
+}
+
+// MinFillTime checks that at least min passed between the form being shown at shown and posted at now,
+// since people take longer than that to fill it in. A zero shown means the form was never shown
+func (f *Form) MinFillTime(shown, now time.Time, min time.Duration) {
+	if shown.IsZero() || now.Sub(shown) < min {
+		f.Errors.Add(WholeForm, "Your request looked automated, please try again")
+	}
+}
@@ -3,6 +3,7 @@ package forms
 import (
 	"net/url"
 	"testing"
+	"time"
 )
 
 func TestForm_Valid(t *testing.T) {
@@ -117,3 +118,46 @@ func TestForm_IsEmail(t *testing.T) {
 		t.Error("got a valid email for an invalid email address")
 	}
 }
+
+func TestForm_Honeypot(t *testing.T) {
+	form := New(url.Values{})
+
+	form.Honeypot("website")
+	if !form.Valid() {
+		t.Error("got invalid when the honeypot was left empty")
+	}
+
+	postFormData := url.Values{}
+	postFormData.Add("website", "http://spam.example")
+	form = New(postFormData)
+
+	form.Honeypot("website")
+	if form.Valid() {
+		t.Error("got valid when the honeypot was filled in")
+	}
+	if form.Errors.Get(WholeForm) == "" {
+		t.Error("expected an error about the whole form")
+	}
+}
+
+func TestForm_MinFillTime(t *testing.T) {
+	now := time.Date(2021, 6, 5, 12, 0, 0, 0, time.UTC)
+
+	form := New(url.Values{})
+	form.MinFillTime(now.Add(-10*time.Second), now, 3*time.Second)
+	if !form.Valid() {
+		t.Error("got invalid for a form filled in slowly enough")
+	}
+
+	form = New(url.Values{})
+	form.MinFillTime(now.Add(-time.Second), now, 3*time.Second)
+	if form.Valid() {
+		t.Error("got valid for a form filled in too quickly")
+	}
+
+	form = New(url.Values{})
+	form.MinFillTime(time.Time{}, now, 3*time.Second)
+	if form.Valid() {
+		t.Error("got valid for a form that was never shown")
+	}
+}
@@ -0,0 +1,232 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"github.com/go-chi/chi/v5"
+	"github.com/usmanzaheer1995/bed-and-breakfast/internal/helpers"
+	"github.com/usmanzaheer1995/bed-and-breakfast/internal/models"
+	"github.com/usmanzaheer1995/bed-and-breakfast/internal/render"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// sessionTouchInterval is how stale a session's last activity may get before it is recorded again, so
+// that not every request writes to the database
+const sessionTouchInterval = time.Minute
+
+// accountSession is a signed in session as listed on the account page
+type accountSession struct {
+	models.UserSession
+	Device  string
+	Current bool
+}
+
+// startUserSession records a sign in and ties the session to the record, so it can be listed and
+// signed out from elsewhere
+func (m *Repository) startUserSession(r *http.Request, userID int) error {
+	key, err := newSessionKey()
+	if err != nil {
+		return err
+	}
+
+	_, err = m.DB.InsertUserSession(r.Context(), models.UserSession{
+		UserID:    userID,
+		Key:       key,
+		UserAgent: r.UserAgent(),
+		IPAddress: helpers.ClientIP(r),
+	})
+	if err != nil {
+		return err
+	}
+
+	m.App.Session.Put(r.Context(), "user_id", userID)
+	m.App.Session.Put(r.Context(), "session_key", key)
+	return nil
+}
+
+// endSession signs out the current session, dropping its record
+func (m *Repository) endSession(ctx context.Context) {
+	key := m.App.Session.GetString(ctx, "session_key")
+	if key != "" {
+		s, err := m.DB.GetUserSessionByKey(ctx, key)
+		if err == nil {
+			err = m.DB.DeleteUserSession(ctx, s.UserID, s.ID)
+		}
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			m.App.Logger.WarnContext(ctx, "can't delete user session", "error", err)
+		}
+	}
+
+	_ = m.App.Session.Destroy(ctx)
+	_ = m.App.Session.RenewToken(ctx)
+}
+
+// TrackSession signs out a session whose record has been deleted, because it was signed out from
+// elsewhere or its user's password changed or the user was deactivated, and records when and from
+// where the others were last used
+func (m *Repository) TrackSession(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		if !m.App.Session.Exists(ctx, "user_id") {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// sessions signed in before sessions were recorded have no key
+		key := m.App.Session.GetString(ctx, "session_key")
+		var s models.UserSession
+		err := sql.ErrNoRows
+		if key != "" {
+			s, err = m.DB.GetUserSessionByKey(ctx, key)
+		}
+		if errors.Is(err, sql.ErrNoRows) || (err == nil && s.UserID != m.App.Session.GetInt(ctx, "user_id")) {
+			m.App.Logger.InfoContext(ctx, "session was signed out", "user_id", m.App.Session.GetInt(ctx, "user_id"))
+			_ = m.App.Session.Destroy(ctx)
+			_ = m.App.Session.RenewToken(ctx)
+			next.ServeHTTP(w, r)
+			return
+		} else if err != nil {
+			helpers.ServerError(w, r, err)
+			return
+		}
+
+		ip := helpers.ClientIP(r)
+		if time.Since(s.LastSeenAt) > sessionTouchInterval || s.IPAddress != ip {
+			err = m.DB.TouchUserSession(ctx, s.ID, ip, time.Now())
+			if err != nil {
+				m.App.Logger.WarnContext(ctx, "can't record session activity", "error", err)
+			}
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// AdminAccount lists the places the signed in user is signed in
+func (m *Repository) AdminAccount(w http.ResponseWriter, r *http.Request) {
+	userSessions, err := m.DB.UserSessions(r.Context(), m.App.Session.GetInt(r.Context(), "user_id"))
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	current := m.App.Session.GetString(r.Context(), "session_key")
+	var sessions []accountSession
+	for _, s := range userSessions {
+		sessions = append(sessions, accountSession{
+			UserSession: s,
+			Device:      describeDevice(s.UserAgent),
+			Current:     s.Key == current,
+		})
+	}
+
+	data := make(map[string]interface{})
+	data["sessions"] = sessions
+
+	render.Template(w, r, "admin-account.page.tmpl", &models.TemplateData{
+		Data: data,
+	})
+}
+
+// AdminPostSignOutSession signs out one of the signed in user's sessions, which may be this one
+func (m *Repository) AdminPostSignOutSession(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ClientError(w, r, http.StatusBadRequest)
+		return
+	}
+
+	userID := m.App.Session.GetInt(r.Context(), "user_id")
+	current, err := m.DB.GetUserSessionByKey(r.Context(), m.App.Session.GetString(r.Context(), "session_key"))
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	err = m.DB.DeleteUserSession(r.Context(), userID, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		m.App.Session.Put(r.Context(), "flash", "That session has already been signed out")
+		http.Redirect(w, r, "/admin/account", http.StatusSeeOther)
+		return
+	} else if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	m.App.Logger.InfoContext(r.Context(), "signed out session", "user_id", userID, "session_id", id)
+
+	if current.ID == id {
+		m.endSession(r.Context())
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Session signed out")
+	http.Redirect(w, r, "/admin/account", http.StatusSeeOther)
+}
+
+// AdminPostSignOutEverywhere signs out every session of the signed in user, this one included
+func (m *Repository) AdminPostSignOutEverywhere(w http.ResponseWriter, r *http.Request) {
+	userID := m.App.Session.GetInt(r.Context(), "user_id")
+
+	err := m.DB.DeleteUserSessions(r.Context(), userID)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	m.App.Logger.InfoContext(r.Context(), "signed out everywhere", "user_id", userID)
+
+	_ = m.App.Session.Destroy(r.Context())
+	_ = m.App.Session.RenewToken(r.Context())
+	m.App.Session.Put(r.Context(), "flash", "Signed out everywhere")
+	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+}
+
+// newSessionKey returns a random key tying a session to its record
+func newSessionKey() (string, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// describeDevice names the browser and operating system in a user agent, e.g. "Firefox on Windows"
+func describeDevice(userAgent string) string {
+	browser := "Unknown browser"
+	for _, b := range []struct{ token, name string }{
+		// Edge and Chrome also claim to be Safari, and Edge to be Chrome, so they are checked first
+		{"Edg/", "Edge"},
+		{"Firefox/", "Firefox"},
+		{"Chrome/", "Chrome"},
+		{"Safari/", "Safari"},
+		{"curl/", "curl"},
+	} {
+		if strings.Contains(userAgent, b.token) {
+			browser = b.name
+			break
+		}
+	}
+
+	for _, o := range []struct{ token, name string }{
+		{"iPhone", "iPhone"},
+		{"iPad", "iPad"},
+		{"Android", "Android"},
+		{"Windows", "Windows"},
+		{"Mac OS X", "macOS"},
+		{"Linux", "Linux"},
+	} {
+		if strings.Contains(userAgent, o.token) {
+			return browser + " on " + o.name
+		}
+	}
+	return browser
+}
@@ -0,0 +1,52 @@
+package handlers
+
+import (
+	"github.com/go-chi/chi/v5"
+	"github.com/usmanzaheer1995/bed-and-breakfast/internal/helpers"
+	"github.com/usmanzaheer1995/bed-and-breakfast/internal/mailer"
+	"github.com/usmanzaheer1995/bed-and-breakfast/internal/models"
+	"github.com/usmanzaheer1995/bed-and-breakfast/internal/render"
+	"net/http"
+)
+
+// AdminEmailTemplates previews an email template with sample data. Without a template name, the first
+// template is shown
+func (m *Repository) AdminEmailTemplates(w http.ResponseWriter, r *http.Request) {
+	names := m.App.EmailTemplates.Names()
+
+	name := chi.URLParam(r, "name")
+	if name == "" && len(names) > 0 {
+		name = names[0]
+	}
+
+	var sample mailer.Email
+	for _, x := range mailer.Samples() {
+		if x.TemplateName() == name {
+			sample = x
+		}
+	}
+	if sample == nil {
+		helpers.ClientError(w, r, http.StatusNotFound)
+		return
+	}
+
+	subject, html, text, err := m.App.EmailTemplates.Render(sample)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["names"] = names
+
+	stringMap := make(map[string]string)
+	stringMap["name"] = name
+	stringMap["subject"] = subject
+	stringMap["html"] = html
+	stringMap["text"] = text
+
+	render.Template(w, r, "admin-email-templates.page.tmpl", &models.TemplateData{
+		Data:      data,
+		StringMap: stringMap,
+	})
+}
@@ -2,17 +2,22 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/go-chi/chi/v5"
 	"github.com/usmanzaheer1995/bed-and-breakfast/internal/config"
 	"github.com/usmanzaheer1995/bed-and-breakfast/internal/driver"
 	"github.com/usmanzaheer1995/bed-and-breakfast/internal/forms"
 	"github.com/usmanzaheer1995/bed-and-breakfast/internal/helpers"
+	"github.com/usmanzaheer1995/bed-and-breakfast/internal/ical"
+	"github.com/usmanzaheer1995/bed-and-breakfast/internal/mailer"
+	"github.com/usmanzaheer1995/bed-and-breakfast/internal/metrics"
 	"github.com/usmanzaheer1995/bed-and-breakfast/internal/models"
 	"github.com/usmanzaheer1995/bed-and-breakfast/internal/repository"
 	"github.com/usmanzaheer1995/bed-and-breakfast/internal/repository/dbrepo"
-	"log"
 	"net/http"
+	"net/url"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -67,7 +72,147 @@ func (m *Repository) Majors(w http.ResponseWriter, r *http.Request) {
 }
 
 func (m *Repository) Availability(w http.ResponseWriter, r *http.Request) {
-	render.Template(w, r, "search-availability.page.tmpl", &models.TemplateData{})
+	data := make(map[string]interface{})
+	data["flexible_search"] = m.App.Features.FlexibleSearch
+
+	render.Template(w, r, "search-availability.page.tmpl", &models.TemplateData{
+		Form: forms.New(nil),
+		Data: data,
+	})
+}
+
+// maxFlexibleWindowDays is the longest date window a flexible search may cover
+const maxFlexibleWindowDays = 62
+
+// maxFlexibleNights is the longest stay a flexible search may ask for
+const maxFlexibleNights = 30
+
+// flexibleCell is one arrival date for one room in the flexible search grid
+type flexibleCell struct {
+	StartDate string
+	EndDate   string
+	Available bool
+}
+
+// flexibleRow is one room in the flexible search grid
+type flexibleRow struct {
+	Room  models.Room
+	Cells []flexibleCell
+}
+
+// PostFlexibleAvailability finds every room and arrival date where a stay of the requested
+// number of nights fits inside a date window, and shows them as a grid
+func (m *Repository) PostFlexibleAvailability(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "can't parse form!")
+		http.Redirect(w, r, "/search-availability", http.StatusSeeOther)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("window_start", "window_end", "nights")
+
+	layout := "2006-01-02"
+	windowStart, err := time.Parse(layout, form.Get("window_start"))
+	if err != nil {
+		form.Errors.Add("window_start", "Invalid date")
+	}
+	windowEnd, err := time.Parse(layout, form.Get("window_end"))
+	if err != nil {
+		form.Errors.Add("window_end", "Invalid date")
+	}
+	nights, err := strconv.Atoi(form.Get("nights"))
+	if err != nil || nights < 1 || nights > maxFlexibleNights {
+		form.Errors.Add("nights", fmt.Sprintf("Stay must be between 1 and %d nights", maxFlexibleNights))
+	}
+
+	if form.Valid() {
+		if windowStart.AddDate(0, 0, nights).After(windowEnd) {
+			form.Errors.Add("window_end", "The window is shorter than the stay")
+		} else if windowStart.AddDate(0, 0, maxFlexibleWindowDays).Before(windowEnd) {
+			form.Errors.Add("window_end", fmt.Sprintf("Please search a window of at most %d days", maxFlexibleWindowDays))
+		}
+	}
+
+	stringMap := make(map[string]string)
+	stringMap["window_start"] = form.Get("window_start")
+	stringMap["window_end"] = form.Get("window_end")
+	stringMap["nights"] = form.Get("nights")
+
+	data := make(map[string]interface{})
+	data["flexible_search"] = m.App.Features.FlexibleSearch
+
+	if !form.Valid() {
+		render.Template(w, r, "search-availability.page.tmpl", &models.TemplateData{
+			Form:      form,
+			Data:      data,
+			StringMap: stringMap,
+		})
+		return
+	}
+
+	available, err := m.DB.SearchFlexibleAvailability(r.Context(), windowStart, windowEnd, nights)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	rooms, err := m.DB.AllRooms(r.Context())
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	// every arrival date in the window that leaves room for the whole stay
+	var dates []time.Time
+	for d := windowStart; !d.AddDate(0, 0, nights).After(windowEnd); d = d.AddDate(0, 0, 1) {
+		dates = append(dates, d)
+	}
+
+	free := make(map[int]map[string]bool)
+	for _, a := range available {
+		if free[a.Room.ID] == nil {
+			free[a.Room.ID] = make(map[string]bool)
+			if !containsRoom(rooms, a.Room.ID) {
+				rooms = append(rooms, a.Room)
+			}
+		}
+		free[a.Room.ID][a.StartDate.Format(layout)] = true
+	}
+
+	var rows []flexibleRow
+	for _, room := range rooms {
+		row := flexibleRow{Room: room}
+		for _, d := range dates {
+			row.Cells = append(row.Cells, flexibleCell{
+				StartDate: d.Format(layout),
+				EndDate:   d.AddDate(0, 0, nights).Format(layout),
+				Available: free[room.ID][d.Format(layout)],
+			})
+		}
+		rows = append(rows, row)
+	}
+
+	data["flexible_dates"] = dates
+	data["flexible_rows"] = rows
+	data["flexible_found"] = len(available) > 0
+
+	render.Template(w, r, "search-availability.page.tmpl", &models.TemplateData{
+		Form:      form,
+		Data:      data,
+		StringMap: stringMap,
+	})
+}
+
+// containsRoom reports whether a room is in a slice of rooms
+func containsRoom(rooms []models.Room, id int) bool {
+	for _, x := range rooms {
+		if x.ID == id {
+			return true
+		}
+	}
+	return false
 }
 
 type jsonResponse struct {
@@ -100,7 +245,7 @@ func (m *Repository) AvailabilityJSON(w http.ResponseWriter, r *http.Request) {
 	endDate, _ := time.Parse(layout, ed)
 	roomID, _ := strconv.Atoi(r.Form.Get("room_id"))
 
-	available, err := m.DB.SearchAvailabilityByDatesByRoomID(startDate, endDate, roomID)
+	available, err := m.DB.SearchAvailabilityByDatesByRoomID(r.Context(), startDate, endDate, roomID)
 	if err != nil {
 		resp := jsonResponse{
 			OK:      false,
@@ -133,25 +278,31 @@ func (m *Repository) PostAvailability(w http.ResponseWriter, r *http.Request) {
 	layout := "2006-01-02"
 	startDate, err := time.Parse(layout, start)
 	if err != nil {
-		helpers.ServerError(w, err)
+		helpers.ServerError(w, r, err)
 		return
 	}
 	endDate, err := time.Parse(layout, end)
 	if err != nil {
-		helpers.ServerError(w, err)
+		helpers.ServerError(w, r, err)
 		return
 	}
 
-	rooms, err := m.DB.SearchAvailabilityForAllRooms(startDate, endDate)
+	rooms, err := m.DB.SearchAvailabilityForAllRooms(r.Context(), startDate, endDate)
 	if err != nil {
-		helpers.ServerError(w, err)
+		helpers.ServerError(w, r, err)
 		return
 	}
 
 	if len(rooms) == 0 {
-		// no availability
 		m.App.Session.Put(r.Context(), "error", "No availability")
-		http.Redirect(w, r, "/search-availability", http.StatusSeeOther)
+		if !m.App.Features.Waitlist {
+			http.Redirect(w, r, "/search-availability", http.StatusSeeOther)
+			return
+		}
+
+		// offer the waitlist for these dates
+		query := url.Values{"start": {start}, "end": {end}}
+		http.Redirect(w, r, "/waitlist?"+query.Encode(), http.StatusSeeOther)
 		return
 	}
 
@@ -178,7 +329,7 @@ func (m *Repository) Reservation(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	room, err := m.DB.GetRoomByID(res.RoomID)
+	room, err := m.DB.GetRoomByID(r.Context(), res.RoomID)
 	if err != nil {
 		m.App.Session.Put(r.Context(), "error", "can't find room")
 		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
@@ -197,6 +348,10 @@ func (m *Repository) Reservation(w http.ResponseWriter, r *http.Request) {
 	stringMap["end_date"] = ed
 	data := make(map[string]interface{})
 	data["reservation"] = res
+	data["captcha"] = m.captchaWidget()
+
+	// remember when the form was shown, since one posted back quicker than a person can type is a bot
+	m.App.Session.Put(r.Context(), formShownKey, time.Now().UnixNano())
 
 	render.Template(w, r, "make-reservation.page.tmpl", &models.TemplateData{
 		Form:      forms.New(nil),
@@ -260,10 +415,12 @@ func (m *Repository) PostReservation(w http.ResponseWriter, r *http.Request) {
 	form.Required("first_name", "last_name", "email")
 	form.MinLength("first_name", 3)
 	form.IsEmail("email")
+	m.checkForBots(r, form)
 
 	if !form.Valid() {
 		data := make(map[string]interface{})
 		data["reservation"] = reservation
+		data["captcha"] = m.captchaWidget()
 
 		stringMap := make(map[string]string)
 		stringMap["start_date"] = sd
@@ -277,57 +434,41 @@ func (m *Repository) PostReservation(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	newReservationID, err := m.DB.InsertReservation(reservation)
-	if err != nil {
-		m.App.Session.Put(r.Context(), "error", "can't insert reservation into the database")
-		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
+	// until the guest confirms their email address the room stays free, so a bot can't block it
+	if m.App.Spam.VerifyEmail {
+		m.postPendingReservation(w, r, reservation)
 		return
 	}
 
-	restriction := models.RoomRestriction{
-		StartDate:     reservation.StartDate,
-		EndDate:       reservation.EndDate,
-		RoomID:        reservation.RoomID,
-		ReservationID: newReservationID,
-		RestrictionID: 1,
-	}
-
-	err = m.DB.InsertRoomRestriction(restriction)
+	messages, digest, err := m.reservationMessages(r.Context(), reservation)
 	if err != nil {
-		m.App.Session.Put(r.Context(), "error", "can't insert room restriction")
-		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
+		helpers.ServerError(w, r, err)
 		return
 	}
 
-	// send notifications - first to guest
-	htmlMsg := fmt.Sprintf(`
-		<strong>Reservation Confirmation</strong><br>
-		Dear %s, <br>
-		This is to confirm your reservation from %s to %s
-	`, reservation.FirstName, reservation.StartDate.Format("2006-01-02"), reservation.EndDate.Format("2006-01-02"))
-
-	msg := models.MailData{
-		To:       reservation.Email,
-		From:     "developer@bednbreakfast.com",
-		Subject:  "Reservation Confirmation",
-		Content:  htmlMsg,
-		Template: "basic.html",
+	// the notifications are queued in the same transaction, so they can't be lost if mail is down.
+	// The room is checked in it too, where the guest's hold gives way to their reservation
+	newReservationID, err := m.DB.InsertReservationWithOutbox(r.Context(), reservation,
+		m.App.Session.GetInt(r.Context(), "hold_id"), messages)
+	if errors.Is(err, repository.ErrNotAvailable) {
+		m.releaseHold(r.Context())
+		m.App.Session.Put(r.Context(), "error", "Sorry, the room is no longer available for those dates")
+		http.Redirect(w, r, "/search-availability", http.StatusSeeOther)
+		return
+	} else if err != nil {
+		m.App.Session.Put(r.Context(), "error", "can't insert reservation into the database")
+		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
+		return
 	}
+	reservation.ID = newReservationID
 
-	m.App.MailChan <- msg
+	// the hold was deleted with the reservation that now blocks the room
+	m.App.Session.Remove(r.Context(), "hold_id")
+	m.claimWaitlistEntry(r.Context())
+	m.triggerMailDelivery()
 
-	// send notifications - first to property owner
-	htmlMsg = fmt.Sprintf(`
-		<strong>Reservation Notification</strong><br>
-		A reservation has been made for %s from %s to %s
-	`, reservation.Room.RoomName, reservation.StartDate.Format("2006-01-02"), reservation.EndDate.Format("2006-01-02"))
-
-	msg = models.MailData{
-		To:      "me@here.com",
-		From:    "developer@bednbreakfast.com",
-		Subject: "Reservation Notification",
-		Content: htmlMsg,
-	}
+	m.addToDigest(r.Context(), digest, models.NotifyNewBooking, reservationSummary("New booking", reservation))
+	metrics.Booking(metrics.BookingCreated)
 
 	m.App.Session.Put(r.Context(), "reservation", reservation)
 
@@ -342,7 +483,7 @@ func (m *Repository) Contact(w http.ResponseWriter, r *http.Request) {
 func (m *Repository) ReservationSummary(w http.ResponseWriter, r *http.Request) {
 	reservation, ok := m.App.Session.Get(r.Context(), "reservation").(models.Reservation)
 	if !ok {
-		m.App.ErrorLog.Println("Can't get error from session")
+		m.App.Logger.ErrorContext(r.Context(), "can't get reservation from session")
 		m.App.Session.Put(r.Context(), "error", "Can't get reservation from session")
 		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
 		return
@@ -381,6 +522,17 @@ func (m *Repository) ChooseRoom(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	res.RoomID = roomId
+
+	err = m.placeHold(r.Context(), roomId, res.StartDate, res.EndDate)
+	if errors.Is(err, repository.ErrNotAvailable) {
+		m.App.Session.Put(r.Context(), "error", "Sorry, that room has just been taken. Please search again")
+		http.Redirect(w, r, "/search-availability", http.StatusSeeOther)
+		return
+	} else if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
 	m.App.Session.Put(r.Context(), "reservation", res)
 	http.Redirect(w, r, "/make-reservation", http.StatusSeeOther)
 }
@@ -402,11 +554,11 @@ func (m *Repository) BookRoom(w http.ResponseWriter, r *http.Request) {
 	}
 	endDate, err := time.Parse(layout, ed)
 	if err != nil {
-		helpers.ServerError(w, err)
+		helpers.ServerError(w, r, err)
 		return
 	}
 
-	room, err := m.DB.GetRoomByID(roomID)
+	room, err := m.DB.GetRoomByID(r.Context(), roomID)
 	if err != nil {
 		m.App.Session.Put(r.Context(), "error", "missing url parameter")
 		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
@@ -418,6 +570,16 @@ func (m *Repository) BookRoom(w http.ResponseWriter, r *http.Request) {
 	res.StartDate = startDate
 	res.EndDate = endDate
 
+	err = m.placeHold(r.Context(), roomID, startDate, endDate)
+	if errors.Is(err, repository.ErrNotAvailable) {
+		m.App.Session.Put(r.Context(), "error", "Sorry, that room has just been taken. Please search again")
+		http.Redirect(w, r, "/search-availability", http.StatusSeeOther)
+		return
+	} else if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
 	m.App.Session.Put(r.Context(), "reservation", res)
 
 	http.Redirect(w, r, "/make-reservation", http.StatusSeeOther)
@@ -435,7 +597,7 @@ func (m *Repository) PostShowLogin(w http.ResponseWriter, r *http.Request) {
 
 	err := r.ParseForm()
 	if err != nil {
-		log.Println(err)
+		m.App.Logger.ErrorContext(r.Context(), "can't parse login form", "error", err)
 	}
 
 	email := r.Form.Get("email")
@@ -452,23 +614,27 @@ func (m *Repository) PostShowLogin(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	id, _, err := m.DB.Authenticate(email, password)
+	id, _, err := m.DB.Authenticate(r.Context(), email, password)
 	if err != nil {
-		log.Println(err)
+		m.App.Logger.InfoContext(r.Context(), "login failed", "email", email, "error", err)
 		m.App.Session.Put(r.Context(), "error", "Invalid login credentials")
 		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
 		return
 	}
 
-	m.App.Session.Put(r.Context(), "user_id", id)
+	err = m.startUserSession(r, id)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
 	m.App.Session.Put(r.Context(), "flash", "Logged in successfully")
 	http.Redirect(w, r, "/", http.StatusSeeOther)
 }
 
 // Logout the user out
 func (m *Repository) Logout(w http.ResponseWriter, r *http.Request) {
-	_ = m.App.Session.Destroy(r.Context())
-	_ = m.App.Session.RenewToken(r.Context())
+	m.endSession(r.Context())
 	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
 }
 
@@ -478,9 +644,9 @@ func (m *Repository) AdminDashboard(w http.ResponseWriter, r *http.Request) {
 
 // AdminNewReservations shows all new reservations in admin tool
 func (m *Repository) AdminNewReservations(w http.ResponseWriter, r *http.Request) {
-	reservations, err := m.DB.AllNewReservations()
+	reservations, err := m.DB.AllNewReservations(r.Context())
 	if err != nil {
-		helpers.ServerError(w, err)
+		helpers.ServerError(w, r, err)
 		return
 	}
 
@@ -494,9 +660,9 @@ func (m *Repository) AdminNewReservations(w http.ResponseWriter, r *http.Request
 
 // AdminAllReservations shows all reservations in admin tool
 func (m *Repository) AdminAllReservations(w http.ResponseWriter, r *http.Request) {
-	reservations, err := m.DB.AllReservations()
+	reservations, err := m.DB.AllReservations(r.Context())
 	if err != nil {
-		helpers.ServerError(w, err)
+		helpers.ServerError(w, r, err)
 		return
 	}
 
@@ -514,7 +680,7 @@ func (m *Repository) AdminShowReservation(w http.ResponseWriter, r *http.Request
 
 	id, err := strconv.Atoi(exploded[4])
 	if err != nil {
-		helpers.ServerError(w, err)
+		helpers.ServerError(w, r, err)
 		return
 	}
 
@@ -530,9 +696,9 @@ func (m *Repository) AdminShowReservation(w http.ResponseWriter, r *http.Request
 	stringMap["year"] = year
 
 	// get reservation from the database
-	res, err := m.DB.GetReservationByID(id)
+	res, err := m.DB.GetReservationByID(r.Context(), id)
 	if err != nil {
-		helpers.ServerError(w, err)
+		helpers.ServerError(w, r, err)
 		return
 	}
 
@@ -549,7 +715,7 @@ func (m *Repository) AdminShowReservation(w http.ResponseWriter, r *http.Request
 func (m *Repository) AdminPostShowReservation(w http.ResponseWriter, r *http.Request) {
 	err := r.ParseForm()
 	if err != nil {
-		helpers.ServerError(w, err)
+		helpers.ServerError(w, r, err)
 		return
 	}
 
@@ -557,15 +723,15 @@ func (m *Repository) AdminPostShowReservation(w http.ResponseWriter, r *http.Req
 
 	id, err := strconv.Atoi(exploded[4])
 	if err != nil {
-		helpers.ServerError(w, err)
+		helpers.ServerError(w, r, err)
 		return
 	}
 
 	src := exploded[3]
 
-	res, err := m.DB.GetReservationByID(id)
+	res, err := m.DB.GetReservationByID(r.Context(), id)
 	if err != nil {
-		helpers.ServerError(w, err)
+		helpers.ServerError(w, r, err)
 		return
 	}
 
@@ -574,12 +740,15 @@ func (m *Repository) AdminPostShowReservation(w http.ResponseWriter, r *http.Req
 	res.Email = r.Form.Get("email")
 	res.Phone = r.Form.Get("phone")
 
-	err = m.DB.UpdateReservation(res)
+	err = m.DB.UpdateReservation(r.Context(), res)
 	if err != nil {
-		helpers.ServerError(w, err)
+		helpers.ServerError(w, r, err)
 		return
 	}
 
+	m.notifyStaff(r.Context(), models.NotifyModification, "Reservation changed", reservationSummary("Guest details changed", res))
+	metrics.Booking(metrics.BookingModified)
+
 	month := r.Form.Get("month")
 	year := r.Form.Get("year")
 
@@ -633,9 +802,9 @@ func (m *Repository) AdminReservationsCalendar(w http.ResponseWriter, r *http.Re
 	intMap := make(map[string]int)
 	intMap["days_in_month"] = lastOfMonth.Day()
 
-	rooms, err := m.DB.AllRooms()
+	rooms, err := m.DB.AllRooms(r.Context())
 	if err != nil {
-		log.Println(err)
+		m.App.Logger.ErrorContext(r.Context(), "can't fetch rooms", "error", err)
 		m.App.Session.Put(r.Context(), "error", "error while fetching rooms")
 		return
 	}
@@ -646,16 +815,19 @@ func (m *Repository) AdminReservationsCalendar(w http.ResponseWriter, r *http.Re
 		// create maps
 		reservationMap := make(map[string]int)
 		blockMap := make(map[string]int)
+		blockVersionMap := make(map[string]string)
+		holdMap := make(map[string]int)
 
 		for d := firstOfMonth; d.After(lastOfMonth) == false; d = d.AddDate(0, 0, 1) {
 			reservationMap[d.Format("2006-01-2")] = 0
 			blockMap[d.Format("2006-01-2")] = 0
+			holdMap[d.Format("2006-01-2")] = 0
 		}
 
 		// get all restrictions for the current room
-		restrictions, err := m.DB.GetRestrictionsForRoomByDay(x.ID, firstOfMonth, lastOfMonth)
+		restrictions, err := m.DB.GetRestrictionsForRoomByDay(r.Context(), x.ID, firstOfMonth, lastOfMonth)
 		if err != nil {
-			helpers.ServerError(w, err)
+			helpers.ServerError(w, r, err)
 			return
 		}
 
@@ -664,14 +836,21 @@ func (m *Repository) AdminReservationsCalendar(w http.ResponseWriter, r *http.Re
 				for d := y.StartDate; d.After(y.EndDate) == false; d = d.AddDate(0, 0, 1) {
 					reservationMap[d.Format("2006-01-2")] = y.ReservationID
 				}
+			} else if y.RestrictionID == models.RestrictionHold {
+				// a guest is part way through booking these nights
+				for d := y.StartDate; d.Before(y.EndDate); d = d.AddDate(0, 0, 1) {
+					holdMap[d.Format("2006-01-2")] = y.ID
+				}
 			} else {
 				blockMap[y.StartDate.Format("2006-01-2")] = y.ID
+				// the version is posted back so the save can detect edits made by another admin
+				blockVersionMap[y.StartDate.Format("2006-01-2")] = fmt.Sprintf("%d:%d", y.ID, y.UpdatedAt.UnixNano())
 			}
 		}
 		data[fmt.Sprintf("reservation_map_%d", x.ID)] = reservationMap
 		data[fmt.Sprintf("block_map_%d", x.ID)] = blockMap
-
-		m.App.Session.Put(r.Context(), fmt.Sprintf("block_map_%d", x.ID), blockMap)
+		data[fmt.Sprintf("block_version_map_%d", x.ID)] = blockVersionMap
+		data[fmt.Sprintf("hold_map_%d", x.ID)] = holdMap
 	}
 
 	render.Template(w, r, "admin-reservations-calendar.page.tmpl", &models.TemplateData{
@@ -686,7 +865,7 @@ func (m *Repository) AdminProcessReservation(w http.ResponseWriter, r *http.Requ
 
 	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
 	src := chi.URLParam(r, "src")
-	_ = m.DB.UpdateProcessedReservation(id, 1)
+	_ = m.DB.UpdateProcessedReservation(r.Context(), id, 1)
 
 	year := r.URL.Query().Get("y")
 	month := r.URL.Query().Get("m")
@@ -705,7 +884,34 @@ func (m *Repository) AdminProcessReservation(w http.ResponseWriter, r *http.Requ
 func (m *Repository) AdminDeleteReservation(w http.ResponseWriter, r *http.Request) {
 	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
 	src := chi.URLParam(r, "src")
-	_ = m.DB.DeleteReservation(id)
+
+	res, err := m.DB.GetReservationByID(r.Context(), id)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	err = m.DB.DeleteReservation(r.Context(), id)
+	if err == nil {
+		m.triggerWaitlistMatch()
+
+		// let the guest know, and take the stay out of their calendar
+		msg, err := m.App.EmailTemplates.Message(m.App.MailFrom, res.Email,
+			mailer.Cancellation{Reservation: res})
+		if err == nil {
+			var invite models.MailAttachment
+			invite, err = ical.Attachment(ical.MethodCancel, res, m.App.Property)
+			msg.Attachments = append(msg.Attachments, invite)
+		}
+		if err != nil {
+			m.App.Logger.ErrorContext(r.Context(), "can't build cancellation email", "reservation_id", res.ID, "error", err)
+		} else {
+			m.queueMail(r.Context(), msg)
+		}
+
+		m.notifyStaff(r.Context(), models.NotifyCancellation, "Reservation cancelled", reservationSummary("Cancelled booking", res))
+		metrics.Booking(metrics.BookingCancelled)
+	}
 
 	year := r.URL.Query().Get("y")
 	month := r.URL.Query().Get("m")
@@ -719,59 +925,363 @@ func (m *Repository) AdminDeleteReservation(w http.ResponseWriter, r *http.Reque
 	}
 }
 
-// AdminPostReservationsCalendar handles post of reservation calendar
+// AdminPostReservationsCalendar handles post of reservation calendar. Every existing block is posted
+// with its version, and a block is removed when its checkbox is no longer ticked. Changes that clash
+// with edits made by another admin since the calendar was loaded are skipped and reported
 func (m *Repository) AdminPostReservationsCalendar(w http.ResponseWriter, r *http.Request) {
 	err := r.ParseForm()
 	if err != nil {
-		helpers.ServerError(w, err)
+		helpers.ServerError(w, r, err)
 		return
 	}
 
 	year, _ := strconv.Atoi(r.Form.Get("y"))
 	month, _ := strconv.Atoi(r.Form.Get("m"))
 
-	// process blocks
-	rooms, err := m.DB.AllRooms()
+	rooms, err := m.DB.AllRooms(r.Context())
 	if err != nil {
-		helpers.ServerError(w, err)
+		helpers.ServerError(w, r, err)
 		return
 	}
 
-	form := forms.New(r.PostForm)
+	roomNames := make(map[int]string)
 	for _, x := range rooms {
-		// get the block map from session. Loop through the map, if an entry in map does not exist
-		// in our posted data, and if the restriction_id > 0, then it is a block we need to remove
-		curMap := m.App.Session.Get(r.Context(), fmt.Sprintf("block_map_%d", x.ID)).(map[string]int)
-		for name, value := range curMap {
-			// ok will be false if the value is not in the map
-			if val, ok := curMap[name]; ok {
-				// only pay attention to values > 0, and that are not in the form post
-				// the rest are just placeholders for days without blocks
-				if val > 0 && !form.Has(fmt.Sprintf("remove_block_%d_%s", x.ID, name)) {
-					// delete the restriction by ID
-					err := m.DB.DeleteBlockForRoom(value)
-					if err != nil {
-						log.Println(err)
-					}
-				}
-			}
-		}
+		roomNames[x.ID] = x.RoomName
 	}
 
-	// now handle new blocks
-	for name, _ := range r.PostForm {
-		if strings.HasPrefix(name, "add_block") {
-			exploded := strings.Split(name, "_")
-			roomID, _ := strconv.Atoi(exploded[2])
-			t, _ := time.Parse("2006-01-2", exploded[3])
-			// insert a new block
-			err := m.DB.InsertBlockForRoom(roomID, t)
+	form := forms.New(r.PostForm)
+	var conflicts, changes []string
+
+	for name := range r.PostForm {
+		switch {
+		case strings.HasPrefix(name, "existing_block_"):
+			roomID, day, err := parseCalendarField(strings.TrimPrefix(name, "existing_block_"))
+			if err != nil {
+				helpers.ClientError(w, r, http.StatusBadRequest)
+				return
+			}
+
+			// the block is still ticked, so there is nothing to do
+			if form.Has(fmt.Sprintf("block_%d_%s", roomID, day.Format("2006-01-2"))) {
+				continue
+			}
+
+			blockID, version, err := parseBlockVersion(form.Get(name))
+			if err != nil {
+				helpers.ClientError(w, r, http.StatusBadRequest)
+				return
+			}
+
+			err = m.DB.DeleteBlockForRoom(r.Context(), roomID, blockID, version)
+			if errors.Is(err, repository.ErrConflict) {
+				conflicts = append(conflicts, fmt.Sprintf("%s on %s", roomNames[roomID], day.Format("2006-01-02")))
+			} else if err != nil {
+				helpers.ServerError(w, r, err)
+				return
+			} else {
+				m.triggerWaitlistMatch()
+				changes = append(changes, fmt.Sprintf("Unblocked %s on %s", roomNames[roomID], day.Format("2006-01-02")))
+			}
+		case strings.HasPrefix(name, "add_block_"):
+			roomID, day, err := parseCalendarField(strings.TrimPrefix(name, "add_block_"))
 			if err != nil {
-				log.Println(err)
+				helpers.ClientError(w, r, http.StatusBadRequest)
+				return
+			}
+
+			err = m.DB.InsertBlockForRoom(r.Context(), roomID, day)
+			if errors.Is(err, repository.ErrConflict) {
+				conflicts = append(conflicts, fmt.Sprintf("%s on %s", roomNames[roomID], day.Format("2006-01-02")))
+			} else if err != nil {
+				helpers.ServerError(w, r, err)
+				return
+			} else {
+				changes = append(changes, fmt.Sprintf("Blocked %s on %s", roomNames[roomID], day.Format("2006-01-02")))
 			}
 		}
 	}
 
-	m.App.Session.Put(r.Context(), "flash", "Changes saved")
+	if len(changes) > 0 {
+		sort.Strings(changes)
+		m.notifyStaff(r.Context(), models.NotifyBlockChange, "Calendar blocks changed", strings.Join(changes, "; "))
+	}
+
+	if len(conflicts) > 0 {
+		sort.Strings(conflicts)
+		m.App.Session.Put(r.Context(), "error", fmt.Sprintf(
+			"Another admin changed the calendar while you were editing. These changes were not saved: %s",
+			strings.Join(conflicts, ", ")))
+	} else {
+		m.App.Session.Put(r.Context(), "flash", "Changes saved")
+	}
 	http.Redirect(w, r, fmt.Sprintf("/admin/reservations-calendar?y=%d&m=%d", year, month), http.StatusSeeOther)
-}
\ No newline at end of file
+}
+
+// parseCalendarField extracts the room id and day from a calendar field suffix like 1_2021-05-3
+func parseCalendarField(suffix string) (int, time.Time, error) {
+	exploded := strings.Split(suffix, "_")
+	if len(exploded) != 2 {
+		return 0, time.Time{}, fmt.Errorf("invalid calendar field %q", suffix)
+	}
+
+	roomID, err := strconv.Atoi(exploded[0])
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+
+	day, err := time.Parse("2006-01-2", exploded[1])
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+	return roomID, day, nil
+}
+
+// parseBlockVersion splits a posted block version of the form id:updated_at_unix_nano
+func parseBlockVersion(value string) (int, time.Time, error) {
+	exploded := strings.Split(value, ":")
+	if len(exploded) != 2 {
+		return 0, time.Time{}, fmt.Errorf("invalid block version %q", value)
+	}
+
+	id, err := strconv.Atoi(exploded[0])
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+
+	version, err := parseVersion(exploded[1])
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+	return id, version, nil
+}
+
+// formatVersion turns an updated_at timestamp into an opaque version string
+func formatVersion(t time.Time) string {
+	return strconv.FormatInt(t.UnixNano(), 10)
+}
+
+// parseVersion turns a version string created by formatVersion back into a timestamp
+func parseVersion(value string) (time.Time, error) {
+	nanos, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(0, nanos), nil
+}
+
+// AdminReservationsPlanner displays the drag and drop reservation calendar
+func (m *Repository) AdminReservationsPlanner(w http.ResponseWriter, r *http.Request) {
+	render.Template(w, r, "admin-reservations-planner.page.tmpl", &models.TemplateData{})
+}
+
+// calendarRoom is a room row on the interactive calendar
+type calendarRoom struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+// calendarEvent is a reservation or block shown on the interactive calendar
+type calendarEvent struct {
+	ID        int    `json:"id"`
+	RoomID    int    `json:"room_id"`
+	Title     string `json:"title"`
+	StartDate string `json:"start_date"`
+	EndDate   string `json:"end_date"`
+	Version   string `json:"version"`
+}
+
+type calendarResponse struct {
+	OK           bool            `json:"ok"`
+	Message      string          `json:"message"`
+	Rooms        []calendarRoom  `json:"rooms"`
+	Reservations []calendarEvent `json:"reservations"`
+	Blocks       []calendarEvent `json:"blocks"`
+	Holds        []calendarEvent `json:"holds"`
+}
+
+// calendarOperation is a change posted by the interactive calendar. Move and resize change the room
+// and/or dates of a reservation, create adds owner blocks for a date range
+type calendarOperation struct {
+	Action        string `json:"action"`
+	ReservationID int    `json:"reservation_id"`
+	RoomID        int    `json:"room_id"`
+	StartDate     string `json:"start_date"`
+	EndDate       string `json:"end_date"`
+	Version       string `json:"version"`
+}
+
+// maxCalendarDays is the longest date range the interactive calendar may request at once
+const maxCalendarDays = 366
+
+// AdminCalendarJSON returns all rooms, reservations and blocks for a date range as JSON
+func (m *Repository) AdminCalendarJSON(w http.ResponseWriter, r *http.Request) {
+	layout := "2006-01-02"
+	startDate, err := time.Parse(layout, r.URL.Query().Get("start"))
+	if err != nil {
+		writeCalendarJSON(w, http.StatusBadRequest, calendarResponse{Message: "invalid start date"})
+		return
+	}
+	endDate, err := time.Parse(layout, r.URL.Query().Get("end"))
+	if err != nil {
+		writeCalendarJSON(w, http.StatusBadRequest, calendarResponse{Message: "invalid end date"})
+		return
+	}
+	if !endDate.After(startDate) || endDate.Sub(startDate) > maxCalendarDays*24*time.Hour {
+		writeCalendarJSON(w, http.StatusBadRequest, calendarResponse{Message: "invalid date range"})
+		return
+	}
+
+	rooms, err := m.DB.AllRooms(r.Context())
+	if err != nil {
+		m.App.Logger.ErrorContext(r.Context(), "can't load calendar", "error", err)
+		writeCalendarJSON(w, http.StatusInternalServerError, calendarResponse{Message: "error connecting to database"})
+		return
+	}
+
+	restrictions, err := m.DB.GetRestrictionsByDateRange(r.Context(), startDate, endDate)
+	if err != nil {
+		m.App.Logger.ErrorContext(r.Context(), "can't load calendar", "error", err)
+		writeCalendarJSON(w, http.StatusInternalServerError, calendarResponse{Message: "error connecting to database"})
+		return
+	}
+
+	resp := calendarResponse{
+		OK:           true,
+		Rooms:        []calendarRoom{},
+		Reservations: []calendarEvent{},
+		Blocks:       []calendarEvent{},
+		Holds:        []calendarEvent{},
+	}
+
+	for _, x := range rooms {
+		resp.Rooms = append(resp.Rooms, calendarRoom{ID: x.ID, Name: x.RoomName})
+	}
+
+	for _, x := range restrictions {
+		if x.ReservationID > 0 {
+			resp.Reservations = append(resp.Reservations, calendarEvent{
+				ID:        x.ReservationID,
+				RoomID:    x.RoomID,
+				Title:     strings.TrimSpace(fmt.Sprintf("%s %s", x.Reservation.FirstName, x.Reservation.LastName)),
+				StartDate: x.StartDate.Format(layout),
+				EndDate:   x.EndDate.Format(layout),
+				Version:   formatVersion(x.Reservation.UpdatedAt),
+			})
+		} else if x.RestrictionID == models.RestrictionHold {
+			resp.Holds = append(resp.Holds, calendarEvent{
+				ID:        x.ID,
+				RoomID:    x.RoomID,
+				Title:     "Held for a guest",
+				StartDate: x.StartDate.Format(layout),
+				EndDate:   x.EndDate.Format(layout),
+				Version:   formatVersion(x.UpdatedAt),
+			})
+		} else {
+			resp.Blocks = append(resp.Blocks, calendarEvent{
+				ID:        x.ID,
+				RoomID:    x.RoomID,
+				Title:     "Owner block",
+				StartDate: x.StartDate.Format(layout),
+				EndDate:   x.EndDate.Format(layout),
+				Version:   formatVersion(x.UpdatedAt),
+			})
+		}
+	}
+
+	writeCalendarJSON(w, http.StatusOK, resp)
+}
+
+// AdminPostCalendarJSON applies a move, resize or create operation from the interactive calendar
+func (m *Repository) AdminPostCalendarJSON(w http.ResponseWriter, r *http.Request) {
+	var op calendarOperation
+	err := json.NewDecoder(r.Body).Decode(&op)
+	if err != nil {
+		writeCalendarJSON(w, http.StatusBadRequest, calendarResponse{Message: "invalid request body"})
+		return
+	}
+
+	layout := "2006-01-02"
+	startDate, err := time.Parse(layout, op.StartDate)
+	if err != nil {
+		writeCalendarJSON(w, http.StatusBadRequest, calendarResponse{Message: "invalid start date"})
+		return
+	}
+	endDate, err := time.Parse(layout, op.EndDate)
+	if err != nil {
+		writeCalendarJSON(w, http.StatusBadRequest, calendarResponse{Message: "invalid end date"})
+		return
+	}
+	if !endDate.After(startDate) {
+		writeCalendarJSON(w, http.StatusBadRequest, calendarResponse{Message: "departure must be after arrival"})
+		return
+	}
+	if op.RoomID < 1 {
+		writeCalendarJSON(w, http.StatusBadRequest, calendarResponse{Message: "invalid room"})
+		return
+	}
+
+	switch op.Action {
+	case "move", "resize":
+		version, err := parseVersion(op.Version)
+		if err != nil || op.ReservationID < 1 {
+			writeCalendarJSON(w, http.StatusBadRequest, calendarResponse{Message: "invalid reservation"})
+			return
+		}
+
+		err = m.DB.UpdateReservationDates(r.Context(), models.Reservation{
+			ID:        op.ReservationID,
+			RoomID:    op.RoomID,
+			StartDate: startDate,
+			EndDate:   endDate,
+		}, version)
+		if err != nil {
+			writeCalendarError(w, r, m.App, err)
+			return
+		}
+		// the old dates may suit someone on the waitlist
+		m.triggerWaitlistMatch()
+
+		m.notifyStaff(r.Context(), models.NotifyModification, "Reservation changed", fmt.Sprintf(
+			"Reservation %d moved to room %d from %s to %s", op.ReservationID, op.RoomID, op.StartDate, op.EndDate))
+		metrics.Booking(metrics.BookingModified)
+	case "create":
+		err = m.DB.InsertBlocksForRoom(r.Context(), op.RoomID, startDate, endDate)
+		if err != nil {
+			writeCalendarError(w, r, m.App, err)
+			return
+		}
+
+		m.notifyStaff(r.Context(), models.NotifyBlockChange, "Calendar blocks changed", fmt.Sprintf(
+			"Blocked room %d from %s to %s", op.RoomID, op.StartDate, op.EndDate))
+	default:
+		writeCalendarJSON(w, http.StatusBadRequest, calendarResponse{Message: "unknown action"})
+		return
+	}
+
+	writeCalendarJSON(w, http.StatusOK, calendarResponse{OK: true, Message: "Changes saved"})
+}
+
+// writeCalendarError maps repository errors to a calendar JSON response
+func writeCalendarError(w http.ResponseWriter, r *http.Request, app *config.AppConfig, err error) {
+	switch {
+	case errors.Is(err, repository.ErrConflict):
+		writeCalendarJSON(w, http.StatusConflict, calendarResponse{
+			Message: "This reservation was changed by another admin. The calendar has been reloaded, please try again.",
+		})
+	case errors.Is(err, repository.ErrNotAvailable):
+		writeCalendarJSON(w, http.StatusConflict, calendarResponse{
+			Message: "The room is not available for those dates",
+		})
+	default:
+		app.Logger.ErrorContext(r.Context(), "can't save calendar change", "error", err)
+		writeCalendarJSON(w, http.StatusInternalServerError, calendarResponse{Message: "error connecting to database"})
+	}
+}
+
+// writeCalendarJSON writes a calendar response with the given status code
+func writeCalendarJSON(w http.ResponseWriter, status int, resp calendarResponse) {
+	out, _ := json.MarshalIndent(resp, "", "    ")
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(out)
+}
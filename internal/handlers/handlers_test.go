@@ -3,14 +3,22 @@ package handlers
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/go-chi/chi/v5"
+	"github.com/usmanzaheer1995/bed-and-breakfast/internal/captcha"
+	"github.com/usmanzaheer1995/bed-and-breakfast/internal/config"
+	"github.com/usmanzaheer1995/bed-and-breakfast/internal/health"
 	"github.com/usmanzaheer1995/bed-and-breakfast/internal/models"
 	"io"
 	"log"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"reflect"
 	"strings"
 	"testing"
+	"time"
 )
 
 type postData struct {
@@ -30,6 +38,11 @@ var theTests = []struct {
 	{"ms", "/majors-suite", "GET", http.StatusOK},
 	{"sa", "/search-availability", "GET", http.StatusOK},
 	{"contact", "/contact", "GET", http.StatusOK},
+	{"healthz", "/healthz", "GET", http.StatusOK},
+	{"readyz", "/readyz", "GET", http.StatusOK},
+	{"integrity", "/admin/integrity", "GET", http.StatusOK},
+	{"account", "/admin/account", "GET", http.StatusOK},
+	{"confirm-reservation", "/confirm-reservation/valid", "GET", http.StatusOK},
 
 	//{"post-search-avail", "/search-availability", "POST", []postData{
 	//	{key: "start", value: "2020-01-01"},
@@ -231,6 +244,10 @@ func TestRepository_PostReservation(t *testing.T) {
 		ctx := getCtx(req)
 		req = req.WithContext(ctx)
 
+		// the guest holds the room from choosing it, offered from the waitlist
+		session.Put(ctx, "hold_id", 1)
+		session.Put(ctx, waitlistEntryKey, 1)
+
 		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 
 		rr := httptest.NewRecorder()
@@ -240,6 +257,116 @@ func TestRepository_PostReservation(t *testing.T) {
 		if rr.Code != e.expectedStatusCode {
 			t.Errorf("reservation handler return wrong response code for %s. Expected %d, but got %d", e.name, e.expectedStatusCode, rr.Code)
 		}
+		if e.expectedStatusCode == http.StatusSeeOther && e.name == "happy-path" && session.GetInt(ctx, "hold_id") != 0 {
+			t.Error("expected hold to be released after the reservation was made")
+		}
+		if e.expectedStatusCode == http.StatusSeeOther && e.name == "happy-path" && session.Exists(ctx, waitlistEntryKey) {
+			t.Error("expected the waitlist entry to be claimed after the reservation was made")
+		}
+		if e.expectedStatusCode != http.StatusSeeOther && !session.Exists(ctx, waitlistEntryKey) {
+			t.Errorf("for %s expected the waitlist entry to stay unclaimed", e.name)
+		}
+	}
+
+	// without a hold, a room taken in the meantime sends the guest back to search
+	postData := url.Values{}
+	postData.Add("start_date", "2050-01-01")
+	postData.Add("end_date", "2050-01-02")
+	postData.Add("first_name", "John")
+	postData.Add("last_name", "Smith")
+	postData.Add("email", "john@smith.com")
+	postData.Add("phone", "123123456")
+	postData.Add("room_id", "2")
+
+	req, _ := http.NewRequest("POST", "/make-reservation", strings.NewReader(postData.Encode()))
+	ctx := getCtx(req)
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(Repo.PostReservation).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusSeeOther {
+		t.Errorf("expected %d for a room without a hold, but got %d", http.StatusSeeOther, rr.Code)
+	}
+	if loc, _ := rr.Result().Location(); loc == nil || loc.Path != "/search-availability" {
+		t.Errorf("expected redirect to /search-availability, got %v", loc)
+	}
+}
+
+var postAvailabilityNoRoomsTests = []struct {
+	name             string
+	waitlist         bool
+	expectedLocation string
+}{
+	{"waitlist on", true, "/waitlist?end=2050-01-02&start=2050-01-01"},
+	{"waitlist off", false, "/search-availability"},
+}
+
+func TestRepository_PostAvailabilityNoRooms(t *testing.T) {
+	defer func() {
+		app.Features.Waitlist = true
+	}()
+
+	for _, e := range postAvailabilityNoRoomsTests {
+		app.Features.Waitlist = e.waitlist
+
+		postData := url.Values{}
+		postData.Add("start", "2050-01-01")
+		postData.Add("end", "2050-01-02")
+
+		req, _ := http.NewRequest("POST", "/search-availability", strings.NewReader(postData.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.ParseForm()
+
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(Repo.PostAvailability).ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusSeeOther {
+			t.Errorf("%s: expected %d, but got %d", e.name, http.StatusSeeOther, rr.Code)
+		}
+		if loc, _ := rr.Result().Location(); loc == nil || loc.String() != e.expectedLocation {
+			t.Errorf("%s: expected redirect to %s, got %v", e.name, e.expectedLocation, loc)
+		}
+	}
+}
+
+var chooseRoomTests = []struct {
+	name               string
+	roomID             string
+	expectedStatusCode int
+	expectedLocation   string
+}{
+	{"held", "1", http.StatusSeeOther, "/make-reservation"},
+	{"just-taken", "1000", http.StatusSeeOther, "/search-availability"},
+}
+
+func TestRepository_ChooseRoom(t *testing.T) {
+	for _, e := range chooseRoomTests {
+		req, _ := http.NewRequest("GET", "/choose-room/"+e.roomID, nil)
+		ctx := getCtx(req)
+
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("id", e.roomID)
+		ctx = context.WithValue(ctx, chi.RouteCtxKey, rctx)
+		req = req.WithContext(ctx)
+
+		session.Put(ctx, "reservation", models.Reservation{})
+
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(Repo.ChooseRoom).ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: expected %d, but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+		if loc, _ := rr.Result().Location(); loc == nil || loc.Path != e.expectedLocation {
+			t.Errorf("%s: expected redirect to %s, got %v", e.name, e.expectedLocation, loc)
+		}
+		if e.expectedLocation == "/make-reservation" && session.GetInt(ctx, "hold_id") == 0 {
+			t.Errorf("%s: expected a hold to be recorded in the session", e.name)
+		}
 	}
 }
 
@@ -286,3 +413,1500 @@ func getCtx(req *http.Request) context.Context {
 	}
 	return ctx
 }
+
+var adminPostReservationsCalendarTests = []struct {
+	name          string
+	params        []postData
+	expectedError bool
+}{
+	{"add-block", []postData{
+		{key: "y", value: "2050"},
+		{key: "m", value: "1"},
+		{key: "add_block_1_2050-01-2", value: "1"},
+	}, false},
+	{"keep-block", []postData{
+		{key: "y", value: "2050"},
+		{key: "m", value: "1"},
+		{key: "existing_block_1_2050-01-3", value: "5:1000"},
+		{key: "block_1_2050-01-3", value: "1"},
+	}, false},
+	{"remove-block", []postData{
+		{key: "y", value: "2050"},
+		{key: "m", value: "1"},
+		{key: "existing_block_1_2050-01-3", value: "5:1000"},
+	}, false},
+	{"add-block-conflict", []postData{
+		{key: "y", value: "2050"},
+		{key: "m", value: "1"},
+		{key: "add_block_1000_2050-01-2", value: "1"},
+	}, true},
+	{"remove-block-conflict", []postData{
+		{key: "y", value: "2050"},
+		{key: "m", value: "1"},
+		{key: "existing_block_1_2050-01-3", value: "1000:1000"},
+	}, true},
+}
+
+func TestRepository_AdminPostReservationsCalendar(t *testing.T) {
+	for _, e := range adminPostReservationsCalendarTests {
+		postData := url.Values{}
+		for _, v := range e.params {
+			postData.Add(v.key, v.value)
+		}
+
+		req, _ := http.NewRequest("POST", "/admin/reservations-calendar", strings.NewReader(postData.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		rr := httptest.NewRecorder()
+
+		// there is no block map in the session, which must not matter
+		handler := http.HandlerFunc(Repo.AdminPostReservationsCalendar)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusSeeOther {
+			t.Errorf("calendar handler returned wrong response code for %s. Expected %d, but got %d", e.name, http.StatusSeeOther, rr.Code)
+		}
+
+		hasError := session.GetString(ctx, "error") != ""
+		if hasError != e.expectedError {
+			t.Errorf("for %s expected conflict error to be %t, but got %t", e.name, e.expectedError, hasError)
+		}
+	}
+
+	// a malformed version is rejected
+	postData := url.Values{}
+	postData.Add("existing_block_1_2050-01-3", "invalid")
+
+	req, _ := http.NewRequest("POST", "/admin/reservations-calendar", strings.NewReader(postData.Encode()))
+	req = req.WithContext(getCtx(req))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(Repo.AdminPostReservationsCalendar).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("calendar handler returned wrong response code for malformed version. Expected %d, but got %d", http.StatusBadRequest, rr.Code)
+	}
+}
+
+var adminCalendarJSONTests = []struct {
+	name               string
+	query              string
+	expectedStatusCode int
+}{
+	{"valid-range", "?start=2050-01-01&end=2050-02-01", http.StatusOK},
+	{"invalid-start", "?start=invalid&end=2050-02-01", http.StatusBadRequest},
+	{"invalid-end", "?start=2050-01-01&end=invalid", http.StatusBadRequest},
+	{"end-before-start", "?start=2050-02-01&end=2050-01-01", http.StatusBadRequest},
+	{"range-too-long", "?start=2050-01-01&end=2052-01-01", http.StatusBadRequest},
+}
+
+func TestRepository_AdminCalendarJSON(t *testing.T) {
+	for _, e := range adminCalendarJSONTests {
+		req, _ := http.NewRequest("GET", "/admin/calendar-json"+e.query, nil)
+		req = req.WithContext(getCtx(req))
+
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(Repo.AdminCalendarJSON).ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("calendar json handler returned wrong response code for %s. Expected %d, but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+
+		var j calendarResponse
+		err := json.Unmarshal(rr.Body.Bytes(), &j)
+		if err != nil {
+			t.Errorf("failed to parse json for %s", e.name)
+		}
+		if j.OK != (e.expectedStatusCode == http.StatusOK) {
+			t.Errorf("for %s got ok %t with status %d", e.name, j.OK, rr.Code)
+		}
+	}
+}
+
+var adminPostCalendarJSONTests = []struct {
+	name               string
+	body               string
+	expectedStatusCode int
+}{
+	{"move", `{"action":"move","reservation_id":1,"room_id":2,"start_date":"2050-01-05","end_date":"2050-01-07","version":"1000"}`, http.StatusOK},
+	{"resize", `{"action":"resize","reservation_id":1,"room_id":1,"start_date":"2050-01-05","end_date":"2050-01-09","version":"1000"}`, http.StatusOK},
+	{"create", `{"action":"create","room_id":1,"start_date":"2050-01-05","end_date":"2050-01-07"}`, http.StatusOK},
+	{"invalid-body", `not json`, http.StatusBadRequest},
+	{"unknown-action", `{"action":"explode","room_id":1,"start_date":"2050-01-05","end_date":"2050-01-07"}`, http.StatusBadRequest},
+	{"invalid-dates", `{"action":"create","room_id":1,"start_date":"2050-01-07","end_date":"2050-01-05"}`, http.StatusBadRequest},
+	{"missing-version", `{"action":"move","reservation_id":1,"room_id":2,"start_date":"2050-01-05","end_date":"2050-01-07"}`, http.StatusBadRequest},
+	{"changed-by-another-admin", `{"action":"move","reservation_id":1000,"room_id":2,"start_date":"2050-01-05","end_date":"2050-01-07","version":"1000"}`, http.StatusConflict},
+	{"room-not-available", `{"action":"move","reservation_id":1,"room_id":1000,"start_date":"2050-01-05","end_date":"2050-01-07","version":"1000"}`, http.StatusConflict},
+	{"block-not-available", `{"action":"create","room_id":1000,"start_date":"2050-01-05","end_date":"2050-01-07"}`, http.StatusConflict},
+}
+
+func TestRepository_AdminPostCalendarJSON(t *testing.T) {
+	for _, e := range adminPostCalendarJSONTests {
+		req, _ := http.NewRequest("POST", "/admin/calendar-json", strings.NewReader(e.body))
+		req = req.WithContext(getCtx(req))
+		req.Header.Set("Content-Type", "application/json")
+
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(Repo.AdminPostCalendarJSON).ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("calendar json handler returned wrong response code for %s. Expected %d, but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+
+		var j calendarResponse
+		err := json.Unmarshal(rr.Body.Bytes(), &j)
+		if err != nil {
+			t.Errorf("failed to parse json for %s", e.name)
+		}
+	}
+}
+
+var postWaitlistTests = []struct {
+	name               string
+	params             []postData
+	expectedStatusCode int
+	expectedLocation   string
+}{
+	{"happy-path", []postData{
+		{key: "start", value: "2050-01-01"},
+		{key: "end", value: "2050-01-03"},
+		{key: "room_id", value: "1"},
+		{key: "email", value: "john@smith.com"},
+	}, http.StatusSeeOther, "/"},
+	{"invalid-email", []postData{
+		{key: "start", value: "2050-01-01"},
+		{key: "end", value: "2050-01-03"},
+		{key: "email", value: "john@"},
+	}, http.StatusOK, ""},
+	{"end-before-start", []postData{
+		{key: "start", value: "2050-01-03"},
+		{key: "end", value: "2050-01-01"},
+		{key: "email", value: "john@smith.com"},
+	}, http.StatusOK, ""},
+	{"failure-to-insert", []postData{
+		{key: "start", value: "2050-01-01"},
+		{key: "end", value: "2050-01-03"},
+		{key: "room_id", value: "1000"},
+		{key: "email", value: "john@smith.com"},
+	}, http.StatusSeeOther, "/"},
+}
+
+func TestRepository_PostWaitlist(t *testing.T) {
+	for _, e := range postWaitlistTests {
+		postData := url.Values{}
+		for _, v := range e.params {
+			postData.Add(v.key, v.value)
+		}
+
+		req, _ := http.NewRequest("POST", "/waitlist", strings.NewReader(postData.Encode()))
+		req = req.WithContext(getCtx(req))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(Repo.PostWaitlist).ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("waitlist handler returned wrong response code for %s. Expected %d, but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+
+		if e.expectedLocation != "" {
+			location, _ := rr.Result().Location()
+			if location.String() != e.expectedLocation {
+				t.Errorf("for %s expected location %s, but got %s", e.name, e.expectedLocation, location.String())
+			}
+		}
+	}
+}
+
+var claimWaitlistTests = []struct {
+	name             string
+	token            string
+	expectedLocation string
+}{
+	{"valid", "valid", "/make-reservation"},
+	{"expired", "expired", "/search-availability"},
+	{"room-taken", "unavailable", "/search-availability"},
+	{"unknown-token", "unknown", "/search-availability"},
+}
+
+func TestRepository_ClaimWaitlist(t *testing.T) {
+	for _, e := range claimWaitlistTests {
+		req, _ := http.NewRequest("GET", "/waitlist/"+e.token, nil)
+		ctx := getCtx(req)
+
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("token", e.token)
+		ctx = context.WithValue(ctx, chi.RouteCtxKey, rctx)
+		req = req.WithContext(ctx)
+
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(Repo.ClaimWaitlist).ServeHTTP(rr, req)
+
+		// following the link only shows the offer
+		if e.expectedLocation == "/make-reservation" {
+			if rr.Code != http.StatusOK {
+				t.Errorf("for %s expected the offer to be shown, but got %d", e.name, rr.Code)
+			}
+			if session.Exists(ctx, "hold_id") || session.Exists(ctx, "reservation") {
+				t.Errorf("for %s expected following the link to take nothing up", e.name)
+			}
+			continue
+		}
+
+		if rr.Code != http.StatusSeeOther {
+			t.Errorf("claim waitlist handler returned wrong response code for %s. Expected %d, but got %d", e.name, http.StatusSeeOther, rr.Code)
+		}
+		location, _ := rr.Result().Location()
+		if location.String() != e.expectedLocation {
+			t.Errorf("for %s expected location %s, but got %s", e.name, e.expectedLocation, location.String())
+		}
+	}
+}
+
+func TestRepository_PostClaimWaitlist(t *testing.T) {
+	for _, e := range claimWaitlistTests {
+		req, _ := http.NewRequest("POST", "/waitlist/"+e.token, nil)
+		ctx := getCtx(req)
+
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("token", e.token)
+		ctx = context.WithValue(ctx, chi.RouteCtxKey, rctx)
+		req = req.WithContext(ctx)
+
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(Repo.PostClaimWaitlist).ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusSeeOther {
+			t.Errorf("claim waitlist handler returned wrong response code for %s. Expected %d, but got %d", e.name, http.StatusSeeOther, rr.Code)
+		}
+
+		location, _ := rr.Result().Location()
+		if location.String() != e.expectedLocation {
+			t.Errorf("for %s expected location %s, but got %s", e.name, e.expectedLocation, location.String())
+		}
+
+		if e.expectedLocation == "/make-reservation" {
+			res, ok := session.Get(ctx, "reservation").(models.Reservation)
+			if !ok || res.RoomID != 1 || res.Email != "john@smith.com" {
+				t.Errorf("for %s expected the claimed reservation in session, but got %v", e.name, res)
+			}
+			if session.GetInt(ctx, "hold_id") != 1 {
+				t.Errorf("for %s expected the guest to take over the offer's hold", e.name)
+			}
+			if session.GetInt(ctx, waitlistEntryKey) != 1 {
+				t.Errorf("for %s expected the waitlist entry to be claimed once the guest books", e.name)
+			}
+		}
+	}
+}
+
+func TestRepository_MatchWaitlist(t *testing.T) {
+	err := Repo.MatchWaitlist(context.Background())
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+var postFlexibleAvailabilityTests = []struct {
+	name         string
+	windowStart  string
+	windowEnd    string
+	nights       string
+	expectedText string
+}{
+	{"happy-path", "2050-07-01", "2050-07-14", "3", "/book-room?id=1&s=2050-07-01&e=2050-07-04"},
+	{"invalid-start", "invalid", "2050-07-14", "3", "Invalid date"},
+	{"invalid-nights", "2050-07-01", "2050-07-14", "0", "Stay must be between"},
+	{"window-too-short", "2050-07-01", "2050-07-02", "3", "The window is shorter than the stay"},
+	{"window-too-long", "2050-07-01", "2050-12-31", "3", "Please search a window of at most"},
+}
+
+func TestRepository_PostFlexibleAvailability(t *testing.T) {
+	for _, e := range postFlexibleAvailabilityTests {
+		postData := url.Values{}
+		postData.Add("window_start", e.windowStart)
+		postData.Add("window_end", e.windowEnd)
+		postData.Add("nights", e.nights)
+
+		req, _ := http.NewRequest("POST", "/search-availability-flexible", strings.NewReader(postData.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(Repo.PostFlexibleAvailability).ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusOK {
+			t.Errorf("%s: expected %d, but got %d", e.name, http.StatusOK, rr.Code)
+		}
+		if !strings.Contains(rr.Body.String(), e.expectedText) {
+			t.Errorf("%s: expected page to contain %q", e.name, e.expectedText)
+		}
+	}
+}
+
+var adminOutboxTests = []struct {
+	name               string
+	url                string
+	expectedStatusCode int
+	expectedText       string
+}{
+	{"all", "/admin/outbox", http.StatusOK, "Failed"},
+	{"failed-only", "/admin/outbox?status=failed", http.StatusOK, "connection refused"},
+	{"unknown-status", "/admin/outbox?status=bogus", http.StatusBadRequest, ""},
+}
+
+func TestRepository_AdminOutbox(t *testing.T) {
+	for _, e := range adminOutboxTests {
+		req, _ := http.NewRequest("GET", e.url, nil)
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(Repo.AdminOutbox).ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: expected %d, but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+		if !strings.Contains(rr.Body.String(), e.expectedText) {
+			t.Errorf("%s: expected page to contain %q", e.name, e.expectedText)
+		}
+	}
+}
+
+var adminResendOutboxMessageTests = []struct {
+	name               string
+	id                 string
+	expectedStatusCode int
+}{
+	{"valid", "3", http.StatusSeeOther},
+	{"invalid-id", "invalid", http.StatusBadRequest},
+	{"database-error", "1000", http.StatusInternalServerError},
+}
+
+func TestRepository_AdminResendOutboxMessage(t *testing.T) {
+	for _, e := range adminResendOutboxMessageTests {
+		req, _ := http.NewRequest("POST", "/admin/outbox/"+e.id+"/resend", nil)
+		ctx := getCtx(req)
+
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("id", e.id)
+		ctx = context.WithValue(ctx, chi.RouteCtxKey, rctx)
+		req = req.WithContext(ctx)
+
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(Repo.AdminResendOutboxMessage).ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: expected %d, but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+	}
+}
+
+func TestRepository_ResendConfirmation(t *testing.T) {
+	deliverQueuedMail(t)
+	mailRecorder.Reset()
+
+	err := Repo.ResendConfirmation(context.Background(), 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	deliverQueuedMail(t)
+
+	messages := mailRecorder.Messages()
+	if len(messages) != 1 {
+		t.Fatalf("expected one message, got %d", len(messages))
+	}
+	if messages[0].To != "john@smith.com" || messages[0].Subject != "Reservation Confirmation" {
+		t.Errorf("unexpected message to %s with subject %q", messages[0].To, messages[0].Subject)
+	}
+	if len(messages[0].Attachments) != 1 || !strings.Contains(string(messages[0].Attachments[0].Data), "UID:reservation-7@localhost") {
+		t.Error("expected the invite for the reservation to be attached")
+	}
+}
+
+var adminPostIntegrityRepairTests = []struct {
+	name          string
+	key           string
+	expectedFlash string
+}{
+	{"all", "all", "Repaired 0 issues"},
+	{"gone", "missing_restriction-5-0-0", "The issue has already been sorted out"},
+}
+
+func TestRepository_AdminPostIntegrityRepair(t *testing.T) {
+	for _, e := range adminPostIntegrityRepairTests {
+		postData := url.Values{}
+		postData.Add("key", e.key)
+
+		req, _ := http.NewRequest("POST", "/admin/integrity/repair", strings.NewReader(postData.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(Repo.AdminPostIntegrityRepair).ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusSeeOther {
+			t.Errorf("%s: expected %d, but got %d", e.name, http.StatusSeeOther, rr.Code)
+		}
+		if flash := session.GetString(ctx, "flash"); flash != e.expectedFlash {
+			t.Errorf("%s: expected flash %q, got %q", e.name, e.expectedFlash, flash)
+		}
+	}
+}
+
+func TestRepository_PostShowLogin(t *testing.T) {
+	postData := url.Values{"email": {"admin@admin.com"}, "password": {"password"}}
+	req, _ := http.NewRequest("POST", "/user/login", strings.NewReader(postData.Encode()))
+	ctx := getCtx(req)
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("User-Agent", "Mozilla/5.0 (X11; Linux x86_64) Firefox/89.0")
+
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(Repo.PostShowLogin).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusSeeOther {
+		t.Errorf("expected %d, but got %d", http.StatusSeeOther, rr.Code)
+	}
+	if session.GetInt(ctx, "user_id") != 1 {
+		t.Error("expected the user to be signed in")
+	}
+	if len(session.GetString(ctx, "session_key")) != 64 {
+		t.Error("expected the session to be tied to a session record")
+	}
+}
+
+var trackSessionTests = []struct {
+	name               string
+	userID             int
+	key                string
+	expectedSignedIn   bool
+	expectedStatusCode int
+}{
+	{"signed-in", 1, "current", true, http.StatusOK},
+	{"stale", 1, "stale", true, http.StatusOK},
+	{"signed-out-elsewhere", 1, "signed-out", false, http.StatusOK},
+	{"no-key", 1, "", false, http.StatusOK},
+	{"other-user", 2, "current", false, http.StatusOK},
+	{"database-error", 1, "broken", true, http.StatusInternalServerError},
+	{"anonymous", 0, "", false, http.StatusOK},
+}
+
+func TestRepository_TrackSession(t *testing.T) {
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	for _, e := range trackSessionTests {
+		req, _ := http.NewRequest("GET", "/admin/dashboard", nil)
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		if e.userID != 0 {
+			session.Put(ctx, "user_id", e.userID)
+		}
+		if e.key != "" {
+			session.Put(ctx, "session_key", e.key)
+		}
+
+		rr := httptest.NewRecorder()
+		Repo.TrackSession(next).ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: expected %d, but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+		if signedIn := session.Exists(ctx, "user_id"); signedIn != e.expectedSignedIn {
+			t.Errorf("%s: expected signed in to be %t, got %t", e.name, e.expectedSignedIn, signedIn)
+		}
+	}
+}
+
+var adminPostSignOutSessionTests = []struct {
+	name               string
+	id                 string
+	expectedStatusCode int
+	expectedLocation   string
+	expectedSignedIn   bool
+}{
+	{"other-session", "2", http.StatusSeeOther, "/admin/account", true},
+	{"this-session", "1", http.StatusSeeOther, "/user/login", false},
+	{"already-signed-out", "99", http.StatusSeeOther, "/admin/account", true},
+	{"invalid-id", "invalid", http.StatusBadRequest, "", true},
+	{"database-error", "1000", http.StatusInternalServerError, "", true},
+}
+
+func TestRepository_AdminPostSignOutSession(t *testing.T) {
+	for _, e := range adminPostSignOutSessionTests {
+		req, _ := http.NewRequest("POST", "/admin/account/sessions/"+e.id+"/sign-out", nil)
+		ctx := getCtx(req)
+		session.Put(ctx, "user_id", 1)
+		session.Put(ctx, "session_key", "current")
+
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("id", e.id)
+		ctx = context.WithValue(ctx, chi.RouteCtxKey, rctx)
+		req = req.WithContext(ctx)
+
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(Repo.AdminPostSignOutSession).ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: expected %d, but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+		if location := rr.Header().Get("Location"); location != e.expectedLocation {
+			t.Errorf("%s: expected redirect to %q, got %q", e.name, e.expectedLocation, location)
+		}
+		if signedIn := session.Exists(ctx, "user_id"); signedIn != e.expectedSignedIn {
+			t.Errorf("%s: expected signed in to be %t, got %t", e.name, e.expectedSignedIn, signedIn)
+		}
+	}
+}
+
+var adminPostSignOutEverywhereTests = []struct {
+	name               string
+	userID             int
+	expectedStatusCode int
+}{
+	{"valid", 1, http.StatusSeeOther},
+	{"database-error", 1000, http.StatusInternalServerError},
+}
+
+func TestRepository_AdminPostSignOutEverywhere(t *testing.T) {
+	for _, e := range adminPostSignOutEverywhereTests {
+		req, _ := http.NewRequest("POST", "/admin/account/sessions/sign-out-all", nil)
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		session.Put(ctx, "user_id", e.userID)
+
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(Repo.AdminPostSignOutEverywhere).ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: expected %d, but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+		if e.expectedStatusCode == http.StatusSeeOther && session.Exists(ctx, "user_id") {
+			t.Errorf("%s: expected this session to be signed out too", e.name)
+		}
+	}
+}
+
+func TestDescribeDevice(t *testing.T) {
+	tests := map[string]string{
+		"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 Chrome/91.0 Safari/537.36 Edg/91.0": "Edge on Windows",
+		"Mozilla/5.0 (iPhone; CPU iPhone OS 14_6 like Mac OS X) AppleWebKit/605.1.15 Safari/604.1":        "Safari on iPhone",
+		"Mozilla/5.0 (X11; Linux x86_64; rv:89.0) Gecko/20100101 Firefox/89.0":                            "Firefox on Linux",
+		"": "Unknown browser",
+	}
+	for userAgent, expected := range tests {
+		if got := describeDevice(userAgent); got != expected {
+			t.Errorf("%q: expected %q, got %q", userAgent, expected, got)
+		}
+	}
+}
+
+func TestOutboxBackoff(t *testing.T) {
+	if outboxBackoff(1) != outboxBaseBackoff {
+		t.Errorf("expected first retry after %s, got %s", outboxBaseBackoff, outboxBackoff(1))
+	}
+	if outboxBackoff(3) != 4*outboxBaseBackoff {
+		t.Errorf("expected third retry after %s, got %s", 4*outboxBaseBackoff, outboxBackoff(3))
+	}
+	if outboxBackoff(50) != outboxMaxBackoff {
+		t.Errorf("expected backoff to be capped at %s, got %s", outboxMaxBackoff, outboxBackoff(50))
+	}
+}
+
+func TestRepository_PostReservationSendsMail(t *testing.T) {
+	// deliver anything other tests queued, so only this reservation's mail is recorded
+	deliverQueuedMail(t)
+	mailRecorder.Reset()
+
+	postData := url.Values{}
+	postData.Add("start_date", "2050-01-01")
+	postData.Add("end_date", "2050-01-02")
+	postData.Add("first_name", "John")
+	postData.Add("last_name", "Smith")
+	postData.Add("email", "john@smith.com")
+	postData.Add("phone", "123123456")
+	postData.Add("room_id", "1")
+	postData.Add("room_name", "General's Quarters")
+
+	req, _ := http.NewRequest("POST", "/make-reservation", strings.NewReader(postData.Encode()))
+	ctx := getCtx(req)
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(Repo.PostReservation).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusSeeOther {
+		t.Fatalf("expected %d, but got %d", http.StatusSeeOther, rr.Code)
+	}
+
+	deliverQueuedMail(t)
+
+	messages := mailRecorder.Messages()
+	if len(messages) != 2 {
+		t.Fatalf("expected a guest and an owner message, got %d messages", len(messages))
+	}
+
+	guest := messages[0]
+	if guest.To != "john@smith.com" || guest.Subject != "Reservation Confirmation" {
+		t.Errorf("unexpected guest message to %s with subject %q", guest.To, guest.Subject)
+	}
+	if !strings.Contains(guest.Content, "Dear John") || !strings.Contains(guest.Content, "2050-01-01") {
+		t.Errorf("guest message does not describe the reservation: %s", guest.Content)
+	}
+	if len(guest.Attachments) != 1 || !strings.Contains(string(guest.Attachments[0].Data), "METHOD:REQUEST") {
+		t.Error("expected the guest message to carry a calendar invite")
+	} else if !strings.Contains(string(guest.Attachments[0].Data), "UID:reservation-1@localhost") {
+		t.Errorf("expected the invite to identify the new reservation: %s", guest.Attachments[0].Data)
+	}
+
+	owner := messages[1]
+	if owner.To != "me@here.com" || owner.Subject != "Reservation Notification" {
+		t.Errorf("unexpected owner message to %s with subject %q", owner.To, owner.Subject)
+	}
+	if !strings.Contains(owner.Text, "General's Quarters") {
+		t.Errorf("owner message does not name the room: %s", owner.Text)
+	}
+}
+
+func TestRepository_AdminDeleteReservationSendsCancellation(t *testing.T) {
+	deliverQueuedMail(t)
+	mailRecorder.Reset()
+
+	req, _ := http.NewRequest("GET", "/admin/delete-reservation/new/1/do", nil)
+	ctx := getCtx(req)
+
+	rctx := chi.NewRouteContext()
+	rctx.URLParams.Add("src", "new")
+	rctx.URLParams.Add("id", "1")
+	ctx = context.WithValue(ctx, chi.RouteCtxKey, rctx)
+	req = req.WithContext(ctx)
+
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(Repo.AdminDeleteReservation).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusSeeOther {
+		t.Fatalf("expected %d, but got %d", http.StatusSeeOther, rr.Code)
+	}
+
+	deliverQueuedMail(t)
+
+	var cancellation *models.MailData
+	for _, msg := range mailRecorder.Messages() {
+		if msg.To == "john@smith.com" {
+			msg := msg
+			cancellation = &msg
+		}
+	}
+	if cancellation == nil {
+		t.Fatal("expected a cancellation email to the guest")
+	}
+	if len(cancellation.Attachments) != 1 {
+		t.Fatalf("expected a calendar attachment, got %d attachments", len(cancellation.Attachments))
+	}
+
+	ics := string(cancellation.Attachments[0].Data)
+	if !strings.Contains(ics, "METHOD:CANCEL") || !strings.Contains(ics, "UID:reservation-1@localhost") {
+		t.Errorf("expected a cancellation of the reservation's event: %s", ics)
+	}
+}
+
+// deliverQueuedMail sends everything waiting in the outbox through the test mailer
+func deliverQueuedMail(t *testing.T) {
+	messages, err := Repo.DueMail(context.Background(), 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, msg := range messages {
+		err = Repo.DeliverMail(context.Background(), msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+var adminEmailTemplatesTests = []struct {
+	name               string
+	template           string
+	expectedStatusCode int
+	expectedText       string
+}{
+	{"default", "", http.StatusOK, "Subject:</strong> Reservation Cancelled"},
+	{"confirmation", "confirmation", http.StatusOK, "Subject:</strong> Reservation Confirmation"},
+	{"unknown", "unknown", http.StatusNotFound, ""},
+}
+
+func TestRepository_AdminEmailTemplates(t *testing.T) {
+	for _, e := range adminEmailTemplatesTests {
+		req, _ := http.NewRequest("GET", "/admin/email-templates/"+e.template, nil)
+		ctx := getCtx(req)
+
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("name", e.template)
+		ctx = context.WithValue(ctx, chi.RouteCtxKey, rctx)
+		req = req.WithContext(ctx)
+
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(Repo.AdminEmailTemplates).ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: expected %d, but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+		if !strings.Contains(rr.Body.String(), e.expectedText) {
+			t.Errorf("%s: expected page to contain %q", e.name, e.expectedText)
+		}
+	}
+}
+
+var staffRecipientsTests = []struct {
+	name              string
+	event             string
+	expectedImmediate []string
+	expectedDigest    []string
+}{
+	{"new booking", models.NotifyNewBooking, []string{"me@here.com"}, []string{"bookings@here.com"}},
+	{"cancellation", models.NotifyCancellation, []string{"me@here.com"}, nil},
+	{"no rules", models.NotifyBlockChange, nil, nil},
+}
+
+func TestRepository_staffRecipients(t *testing.T) {
+	for _, e := range staffRecipientsTests {
+		immediate, digest, err := Repo.staffRecipients(context.Background(), e.event)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !reflect.DeepEqual(immediate, e.expectedImmediate) {
+			t.Errorf("%s: expected immediate recipients %v, but got %v", e.name, e.expectedImmediate, immediate)
+		}
+		if !reflect.DeepEqual(digest, e.expectedDigest) {
+			t.Errorf("%s: expected digest recipients %v, but got %v", e.name, e.expectedDigest, digest)
+		}
+	}
+}
+
+func TestRepository_staffRecipientsWithoutDigest(t *testing.T) {
+	app.Features.StaffDigest = false
+	defer func() {
+		app.Features.StaffDigest = true
+	}()
+
+	immediate, digest, err := Repo.staffRecipients(context.Background(), models.NotifyNewBooking)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(immediate, []string{"me@here.com", "bookings@here.com"}) || digest != nil {
+		t.Errorf("expected everyone to be notified straight away, got %v now and %v in the digest", immediate, digest)
+	}
+}
+
+func TestRepository_SendDigests(t *testing.T) {
+	// send anything other tests left behind, so only this digest is recorded
+	err := Repo.SendDigests(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	deliverQueuedMail(t)
+	mailRecorder.Reset()
+
+	Repo.addToDigest(context.Background(), []string{"bookings@here.com"}, models.NotifyNewBooking, "New booking: John Smith")
+	Repo.addToDigest(context.Background(), []string{"bookings@here.com"}, models.NotifyNewBooking, "New booking: Jane Doe")
+
+	err = Repo.SendDigests(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	deliverQueuedMail(t)
+
+	messages := mailRecorder.Messages()
+	if len(messages) != 1 {
+		t.Fatalf("expected one digest, got %d messages", len(messages))
+	}
+	if messages[0].To != "bookings@here.com" {
+		t.Errorf("expected digest to bookings@here.com, but got %s", messages[0].To)
+	}
+	if !strings.Contains(messages[0].Text, "John Smith") || !strings.Contains(messages[0].Text, "Jane Doe") {
+		t.Errorf("digest does not list both events: %s", messages[0].Text)
+	}
+
+	// items already sent are not sent again
+	mailRecorder.Reset()
+	err = Repo.SendDigests(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	deliverQueuedMail(t)
+
+	if len(mailRecorder.Messages()) != 0 {
+		t.Errorf("expected no digest on the second run, got %d messages", len(mailRecorder.Messages()))
+	}
+}
+
+// digestLocation is the property's time zone in the daily digest tests
+var digestLocation = time.FixedZone("EST", -5*60*60)
+
+var dailyDigestTests = []struct {
+	name     string
+	now      time.Time
+	expected int
+}{
+	{"before the hour", time.Date(2030, 1, 10, 6, 55, 0, 0, digestLocation), 0},
+	{"first check after the hour", time.Date(2030, 1, 10, 7, 5, 0, 0, digestLocation), 1},
+	{"later the same day", time.Date(2030, 1, 10, 9, 0, 0, 0, digestLocation), 0},
+	{"after the hour in UTC, but not at the property", time.Date(2030, 1, 11, 7, 5, 0, 0, time.UTC), 0},
+	{"restarted hours after the hour", time.Date(2030, 1, 11, 13, 0, 0, 0, digestLocation), 1},
+}
+
+func TestRepository_SendDailyDigest(t *testing.T) {
+	location, digestHour := app.Property.Location, app.DigestHour
+	app.Property.Location = digestLocation
+	app.DigestHour = 7
+	defer func() {
+		app.Property.Location, app.DigestHour = location, digestHour
+	}()
+
+	// send anything other tests left behind, so only these digests are recorded
+	err := Repo.SendDigests(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	deliverQueuedMail(t)
+
+	for _, e := range dailyDigestTests {
+		mailRecorder.Reset()
+		Repo.addToDigest(context.Background(), []string{"bookings@here.com"}, models.NotifyNewBooking, "New booking: John Smith")
+
+		err := Repo.SendDailyDigest(context.Background(), e.now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		deliverQueuedMail(t)
+
+		if len(mailRecorder.Messages()) != e.expected {
+			t.Errorf("%s: expected %d digests, got %d", e.name, e.expected, len(mailRecorder.Messages()))
+		}
+	}
+}
+
+func TestRepository_AdminNotifications(t *testing.T) {
+	req, _ := http.NewRequest("GET", "/admin/notifications", nil)
+	ctx := getCtx(req)
+	req = req.WithContext(ctx)
+
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(Repo.AdminNotifications).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Errorf("expected %d, but got %d", http.StatusOK, rr.Code)
+	}
+	if !strings.Contains(rr.Body.String(), "bookings@here.com") {
+		t.Error("expected page to list the notification rules")
+	}
+}
+
+var adminPostNotificationRuleTests = []struct {
+	name               string
+	postedData         url.Values
+	expectedStatusCode int
+	expectedFlash      string
+}{
+	{
+		name:               "address",
+		postedData:         url.Values{"event": {"new_booking"}, "address": {"desk@here.com"}, "digest": {"1"}},
+		expectedStatusCode: http.StatusSeeOther,
+		expectedFlash:      "Rule added",
+	},
+	{
+		name:               "role",
+		postedData:         url.Values{"event": {"cancellation"}, "access_level": {"3"}},
+		expectedStatusCode: http.StatusSeeOther,
+		expectedFlash:      "Rule added",
+	},
+	{
+		name:               "no recipient",
+		postedData:         url.Values{"event": {"cancellation"}},
+		expectedStatusCode: http.StatusSeeOther,
+	},
+	{
+		name:               "address and role",
+		postedData:         url.Values{"event": {"cancellation"}, "address": {"desk@here.com"}, "access_level": {"3"}},
+		expectedStatusCode: http.StatusSeeOther,
+	},
+	{
+		name:               "invalid address",
+		postedData:         url.Values{"event": {"cancellation"}, "address": {"desk"}},
+		expectedStatusCode: http.StatusSeeOther,
+	},
+	{
+		name:               "unknown event",
+		postedData:         url.Values{"event": {"party"}, "address": {"desk@here.com"}},
+		expectedStatusCode: http.StatusSeeOther,
+	},
+	{
+		name:               "database error",
+		postedData:         url.Values{"event": {"cancellation"}, "address": {"fail@here.com"}},
+		expectedStatusCode: http.StatusInternalServerError,
+	},
+}
+
+func TestRepository_AdminPostNotificationRule(t *testing.T) {
+	for _, e := range adminPostNotificationRuleTests {
+		req, _ := http.NewRequest("POST", "/admin/notifications/rules", strings.NewReader(e.postedData.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(Repo.AdminPostNotificationRule).ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: expected %d, but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+		if e.expectedStatusCode == http.StatusSeeOther {
+			flash := app.Session.GetString(ctx, "flash")
+			if flash != e.expectedFlash {
+				t.Errorf("%s: expected flash %q, but got %q", e.name, e.expectedFlash, flash)
+			}
+		}
+	}
+}
+
+var adminDeleteNotificationRuleTests = []struct {
+	name               string
+	id                 string
+	expectedStatusCode int
+}{
+	{"valid", "1", http.StatusSeeOther},
+	{"database error", "1000", http.StatusInternalServerError},
+	{"invalid id", "x", http.StatusBadRequest},
+}
+
+func TestRepository_AdminDeleteNotificationRule(t *testing.T) {
+	for _, e := range adminDeleteNotificationRuleTests {
+		req, _ := http.NewRequest("POST", fmt.Sprintf("/admin/notifications/rules/%s/delete", e.id), nil)
+		ctx := getCtx(req)
+
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("id", e.id)
+		ctx = context.WithValue(ctx, chi.RouteCtxKey, rctx)
+		req = req.WithContext(ctx)
+
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(Repo.AdminDeleteNotificationRule).ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: expected %d, but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+	}
+}
+
+func TestRepository_AdminPostNotificationPreferences(t *testing.T) {
+	postedData := url.Values{"new_booking": {"1"}, "digest": {"1"}}
+	req, _ := http.NewRequest("POST", "/admin/notifications/preferences", strings.NewReader(postedData.Encode()))
+	ctx := getCtx(req)
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(Repo.AdminPostNotificationPreferences).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusSeeOther {
+		t.Errorf("expected %d, but got %d", http.StatusSeeOther, rr.Code)
+	}
+}
+
+func TestRepository_SendScheduledEmails(t *testing.T) {
+	deliverQueuedMail(t)
+	mailRecorder.Reset()
+
+	err := Repo.SendScheduledEmails(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	deliverQueuedMail(t)
+
+	messages := mailRecorder.Messages()
+	if len(messages) != 2 {
+		t.Fatalf("expected a pre-arrival and a post-stay email, got %d messages", len(messages))
+	}
+	for _, msg := range messages {
+		if msg.To != "john@smith.com" {
+			t.Errorf("expected scheduled email to the guest, but got %s", msg.To)
+		}
+		if !strings.Contains(msg.Text, "Dear John") {
+			t.Errorf("scheduled email was not rendered for the reservation: %s", msg.Text)
+		}
+	}
+
+	// each email is sent only once for a reservation
+	mailRecorder.Reset()
+	err = Repo.SendScheduledEmails(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	deliverQueuedMail(t)
+
+	if len(mailRecorder.Messages()) != 0 {
+		t.Errorf("expected no email on the second run, got %d messages", len(mailRecorder.Messages()))
+	}
+}
+
+func TestRepository_AdminScheduledEmails(t *testing.T) {
+	req, _ := http.NewRequest("GET", "/admin/scheduled-emails", nil)
+	ctx := getCtx(req)
+	req = req.WithContext(ctx)
+
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(Repo.AdminScheduledEmails).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Errorf("expected %d, but got %d", http.StatusOK, rr.Code)
+	}
+	if !strings.Contains(rr.Body.String(), "3 days before arrival") {
+		t.Error("expected page to say when each email is sent")
+	}
+}
+
+var adminShowScheduledEmailTests = []struct {
+	name               string
+	id                 string
+	expectedStatusCode int
+}{
+	{"valid", "1", http.StatusOK},
+	{"unknown", "1000", http.StatusNotFound},
+	{"invalid id", "x", http.StatusBadRequest},
+}
+
+func TestRepository_AdminShowScheduledEmail(t *testing.T) {
+	for _, e := range adminShowScheduledEmailTests {
+		req, _ := http.NewRequest("GET", "/admin/scheduled-emails/"+e.id, nil)
+		ctx := getCtx(req)
+
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("id", e.id)
+		ctx = context.WithValue(ctx, chi.RouteCtxKey, rctx)
+		req = req.WithContext(ctx)
+
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(Repo.AdminShowScheduledEmail).ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: expected %d, but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+	}
+}
+
+var adminPostScheduledEmailTests = []struct {
+	name               string
+	id                 string
+	postedData         url.Values
+	expectedStatusCode int
+	expectedText       string
+}{
+	{
+		name: "valid",
+		id:   "1",
+		postedData: url.Values{
+			"anchor":      {"start_date"},
+			"offset_days": {"-2"},
+			"subject":     {"See you soon"},
+			"html_body":   {"Dear {{.Reservation.FirstName}}"},
+			"text_body":   {"Dear {{.Reservation.FirstName}}"},
+			"active":      {"1"},
+		},
+		expectedStatusCode: http.StatusSeeOther,
+	},
+	{
+		name: "invalid anchor",
+		id:   "1",
+		postedData: url.Values{
+			"anchor":      {"created_at"},
+			"offset_days": {"-2"},
+			"subject":     {"See you soon"},
+			"html_body":   {"Dear {{.Reservation.FirstName}}"},
+			"text_body":   {"Dear {{.Reservation.FirstName}}"},
+		},
+		expectedStatusCode: http.StatusOK,
+		expectedText:       "Choose arrival or departure",
+	},
+	{
+		name: "offset too large",
+		id:   "1",
+		postedData: url.Values{
+			"anchor":      {"start_date"},
+			"offset_days": {"1000"},
+			"subject":     {"See you soon"},
+			"html_body":   {"Dear {{.Reservation.FirstName}}"},
+			"text_body":   {"Dear {{.Reservation.FirstName}}"},
+		},
+		expectedStatusCode: http.StatusOK,
+		expectedText:       "between -365 and 365",
+	},
+	{
+		name: "missing subject",
+		id:   "1",
+		postedData: url.Values{
+			"anchor":      {"start_date"},
+			"offset_days": {"-2"},
+			"html_body":   {"Dear {{.Reservation.FirstName}}"},
+			"text_body":   {"Dear {{.Reservation.FirstName}}"},
+		},
+		expectedStatusCode: http.StatusOK,
+		expectedText:       "This field is required",
+	},
+	{
+		name: "broken template",
+		id:   "1",
+		postedData: url.Values{
+			"anchor":      {"start_date"},
+			"offset_days": {"-2"},
+			"subject":     {"See you soon"},
+			"html_body":   {"Dear {{.Reservation.Nickname}}"},
+			"text_body":   {"Dear {{.Reservation.FirstName}}"},
+		},
+		expectedStatusCode: http.StatusOK,
+		expectedText:       "Nickname",
+	},
+	{
+		name: "database error",
+		id:   "3",
+		postedData: url.Values{
+			"anchor":      {"start_date"},
+			"offset_days": {"365"},
+			"subject":     {"A year ago"},
+			"html_body":   {"Dear {{.Reservation.FirstName}}"},
+			"text_body":   {"Dear {{.Reservation.FirstName}}"},
+		},
+		expectedStatusCode: http.StatusInternalServerError,
+	},
+	{
+		name:               "unknown",
+		id:                 "1000",
+		postedData:         url.Values{},
+		expectedStatusCode: http.StatusNotFound,
+	},
+}
+
+func TestRepository_AdminPostScheduledEmail(t *testing.T) {
+	for _, e := range adminPostScheduledEmailTests {
+		req, _ := http.NewRequest("POST", "/admin/scheduled-emails/"+e.id, strings.NewReader(e.postedData.Encode()))
+		ctx := getCtx(req)
+
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("id", e.id)
+		ctx = context.WithValue(ctx, chi.RouteCtxKey, rctx)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(Repo.AdminPostScheduledEmail).ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: expected %d, but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+		if !strings.Contains(rr.Body.String(), e.expectedText) {
+			t.Errorf("%s: expected page to contain %q", e.name, e.expectedText)
+		}
+	}
+}
+
+func TestRepository_Readyz(t *testing.T) {
+	req, _ := http.NewRequest("GET", "/readyz", nil)
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(Repo.Readyz).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Errorf("Readyz returned %d, expected %d", rr.Code, http.StatusOK)
+	}
+
+	var resp readiness
+	err := json.Unmarshal(rr.Body.Bytes(), &resp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]string{"database": "ok", "templates": "ok", "mail_worker": "ok"}
+	if resp.Status != "ok" || !reflect.DeepEqual(resp.Checks, expected) {
+		t.Errorf("Readyz returned %+v, expected every check to pass", resp)
+	}
+
+	// a mail worker that has stopped checking the outbox
+	heartbeat := app.MailWorker
+	app.MailWorker = health.NewHeartbeat(time.Minute)
+	defer func() { app.MailWorker = heartbeat }()
+
+	rr = httptest.NewRecorder()
+	http.HandlerFunc(Repo.Readyz).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Errorf("Readyz returned %d for a stopped mail worker, expected %d", rr.Code, http.StatusServiceUnavailable)
+	}
+
+	resp = readiness{}
+	err = json.Unmarshal(rr.Body.Bytes(), &resp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Status != "unavailable" || resp.Checks["mail_worker"] == "ok" || resp.Checks["database"] != "ok" {
+		t.Errorf("Readyz returned %+v, expected only the mail worker check to fail", resp)
+	}
+}
+
+var cancelledRequestTests = []struct {
+	name               string
+	url                string
+	handler            func(*Repository, http.ResponseWriter, *http.Request)
+	expectedStatusCode int
+}{
+	{"all-reservations", "/admin/reservations-all", (*Repository).AdminAllReservations, http.StatusInternalServerError},
+	{"new-reservations", "/admin/reservations-new", (*Repository).AdminNewReservations, http.StatusInternalServerError},
+	{"readyz", "/readyz", (*Repository).Readyz, http.StatusServiceUnavailable},
+}
+
+func TestRepository_CancelledRequest(t *testing.T) {
+	for _, e := range cancelledRequestTests {
+		req, _ := http.NewRequest("GET", e.url, nil)
+		ctx, cancel := context.WithCancel(getCtx(req))
+		// the guest gave up before the handler got to the database
+		cancel()
+		req = req.WithContext(ctx)
+
+		rr := httptest.NewRecorder()
+		e.handler(Repo, rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: returned %d for a cancelled request, expected %d", e.name, rr.Code, e.expectedStatusCode)
+		}
+	}
+}
+
+func TestRepository_MatchWaitlistCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err := Repo.MatchWaitlist(ctx)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("expected MatchWaitlist to stop with context.Canceled, got %v", err)
+	}
+}
+
+// reservationForm returns a valid reservation form for room 1
+func reservationForm() url.Values {
+	postData := url.Values{}
+	postData.Add("start_date", "2050-01-01")
+	postData.Add("end_date", "2050-01-02")
+	postData.Add("first_name", "John")
+	postData.Add("last_name", "Smith")
+	postData.Add("email", "john@smith.com")
+	postData.Add("phone", "123123456")
+	postData.Add("room_id", "1")
+	postData.Add("room_name", "General's Quarters")
+	return postData
+}
+
+var postReservationBotTests = []struct {
+	name               string
+	field              string
+	value              string
+	shownAgo           time.Duration
+	expectedStatusCode int
+}{
+	{"person", "", "", time.Minute, http.StatusSeeOther},
+	{"honeypot-filled", "website", "http://spam.example", time.Minute, http.StatusOK},
+	{"too-quick", "", "", time.Second, http.StatusOK},
+	{"form-never-shown", "", "", 0, http.StatusOK},
+	{"captcha-passed", "fake-captcha-response", captcha.FakePass, time.Minute, http.StatusSeeOther},
+	{"captcha-failed", "fake-captcha-response", "fail", time.Minute, http.StatusOK},
+}
+
+func TestRepository_PostReservationBotChecks(t *testing.T) {
+	app.Spam.MinFillTime = config.Duration(3 * time.Second)
+	defer func() {
+		app.Spam.MinFillTime = 0
+		app.Captcha = nil
+	}()
+
+	for _, e := range postReservationBotTests {
+		app.Captcha = nil
+		if strings.HasPrefix(e.name, "captcha") {
+			app.Captcha = captcha.Fake{}
+		}
+
+		postData := reservationForm()
+		if e.field != "" {
+			postData.Set(e.field, e.value)
+		}
+
+		req, _ := http.NewRequest("POST", "/make-reservation", strings.NewReader(postData.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		session.Put(ctx, "hold_id", 1)
+		if e.shownAgo > 0 {
+			session.Put(ctx, formShownKey, time.Now().Add(-e.shownAgo).UnixNano())
+		}
+
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(Repo.PostReservation).ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("for %s expected %d, but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+		if e.expectedStatusCode == http.StatusOK && !strings.Contains(rr.Body.String(), "alert-danger") {
+			t.Errorf("for %s expected the form to say what went wrong", e.name)
+		}
+	}
+}
+
+func TestRepository_PostReservationPendingVerification(t *testing.T) {
+	app.Spam.VerifyEmail = true
+	app.Spam.VerifyWindow = config.Duration(time.Hour)
+	defer func() {
+		app.Spam.VerifyEmail = false
+	}()
+
+	deliverQueuedMail(t)
+	mailRecorder.Reset()
+
+	req, _ := http.NewRequest("POST", "/make-reservation", strings.NewReader(reservationForm().Encode()))
+	ctx := getCtx(req)
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	session.Put(ctx, "hold_id", 1)
+
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(Repo.PostReservation).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusSeeOther {
+		t.Fatalf("expected %d, but got %d", http.StatusSeeOther, rr.Code)
+	}
+	if loc, _ := rr.Result().Location(); loc == nil || loc.Path != "/" {
+		t.Errorf("expected redirect to /, got %v", loc)
+	}
+	if session.GetInt(ctx, "hold_id") != 0 {
+		t.Error("expected the hold to be released, as a pending reservation doesn't block the room")
+	}
+	if _, ok := session.Get(ctx, "reservation").(models.Reservation); ok {
+		t.Error("expected no reservation summary before the guest confirms")
+	}
+
+	deliverQueuedMail(t)
+
+	messages := mailRecorder.Messages()
+	if len(messages) != 1 {
+		t.Fatalf("expected only the verification email, got %d messages", len(messages))
+	}
+	if messages[0].To != "john@smith.com" || messages[0].Subject != "Please confirm your reservation" {
+		t.Errorf("unexpected message to %s with subject %q", messages[0].To, messages[0].Subject)
+	}
+	if !strings.Contains(messages[0].Text, "http://localhost:8080/confirm-reservation/") {
+		t.Errorf("expected the email to carry the confirmation link: %s", messages[0].Text)
+	}
+}
+
+var confirmReservationTests = []struct {
+	name               string
+	token              string
+	expectedStatusCode int
+	expectedLocation   string
+}{
+	{"valid", "valid", http.StatusOK, ""},
+	{"expired", "expired", http.StatusSeeOther, "/search-availability"},
+	{"unknown-token", "unknown", http.StatusSeeOther, "/search-availability"},
+}
+
+func TestRepository_ConfirmReservation(t *testing.T) {
+	for _, e := range confirmReservationTests {
+		req, _ := http.NewRequest("GET", "/confirm-reservation/"+e.token, nil)
+		ctx := getCtx(req)
+
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("token", e.token)
+		ctx = context.WithValue(ctx, chi.RouteCtxKey, rctx)
+		req = req.WithContext(ctx)
+
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(Repo.ConfirmReservation).ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("for %s expected %d, but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+		if e.expectedLocation != "" {
+			if loc, _ := rr.Result().Location(); loc == nil || loc.Path != e.expectedLocation {
+				t.Errorf("for %s expected redirect to %s, got %v", e.name, e.expectedLocation, loc)
+			}
+		}
+	}
+}
+
+var postConfirmReservationTests = []struct {
+	name               string
+	token              string
+	expectedStatusCode int
+	expectedLocation   string
+}{
+	{"valid", "valid", http.StatusSeeOther, "/reservation-summary"},
+	{"expired", "expired", http.StatusSeeOther, "/search-availability"},
+	{"unknown-token", "unknown", http.StatusSeeOther, "/search-availability"},
+	{"room-taken", "taken", http.StatusSeeOther, "/search-availability"},
+	{"confirmed-twice", "confirmed", http.StatusSeeOther, "/"},
+	{"database-error", "broken", http.StatusInternalServerError, ""},
+}
+
+func TestRepository_PostConfirmReservation(t *testing.T) {
+	for _, e := range postConfirmReservationTests {
+		deliverQueuedMail(t)
+		mailRecorder.Reset()
+
+		req, _ := http.NewRequest("POST", "/confirm-reservation/"+e.token, nil)
+		ctx := getCtx(req)
+
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("token", e.token)
+		ctx = context.WithValue(ctx, chi.RouteCtxKey, rctx)
+		req = req.WithContext(ctx)
+
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(Repo.PostConfirmReservation).ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("for %s expected %d, but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+		if e.expectedLocation != "" {
+			if loc, _ := rr.Result().Location(); loc == nil || loc.Path != e.expectedLocation {
+				t.Errorf("for %s expected redirect to %s, got %v", e.name, e.expectedLocation, loc)
+			}
+		}
+
+		if e.name == "valid" {
+			res, ok := session.Get(ctx, "reservation").(models.Reservation)
+			if !ok || res.ID != 1 || res.Email != "john@smith.com" {
+				t.Errorf("expected the confirmed reservation in session, got %v", res)
+			}
+
+			deliverQueuedMail(t)
+			if messages := mailRecorder.Messages(); len(messages) != 2 || messages[0].Subject != "Reservation Confirmation" {
+				t.Errorf("expected the guest confirmation and owner notification, got %d messages", len(messages))
+			}
+		}
+		if e.name == "room-taken" {
+			if msg := session.GetString(ctx, "error"); msg != "Sorry, the room was booked by someone else before you confirmed" {
+				t.Errorf("expected to hear the room was taken, got %q", msg)
+			}
+		}
+	}
+}
+
+func TestRepository_DeleteExpiredPendingReservations(t *testing.T) {
+	err := Repo.DeleteExpiredPendingReservations(context.Background())
+	if err != nil {
+		t.Error(err)
+	}
+}
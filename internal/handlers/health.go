@@ -0,0 +1,60 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+)
+
+// readiness is the body of the /readyz response
+type readiness struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks"`
+}
+
+// Healthz reports that the process is up and serving requests
+func (m *Repository) Healthz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write([]byte("ok\n"))
+}
+
+// Readyz reports whether the app can do its work: the database answers, the templates are loaded and
+// the mail worker is running. It responds 503 when any check fails
+func (m *Repository) Readyz(w http.ResponseWriter, r *http.Request) {
+	checks := map[string]error{
+		"database":    m.DB.Ping(r.Context()),
+		"templates":   nil,
+		"mail_worker": nil,
+	}
+
+	if len(m.App.TemplateCache) == 0 {
+		checks["templates"] = errors.New("template cache is empty")
+	}
+
+	if m.App.MailWorker == nil || !m.App.MailWorker.Alive() {
+		checks["mail_worker"] = errors.New("mail worker is not running")
+	}
+
+	resp := readiness{
+		Status: "ok",
+		Checks: map[string]string{},
+	}
+	status := http.StatusOK
+
+	for name, err := range checks {
+		if err != nil {
+			m.App.Logger.WarnContext(r.Context(), "readiness check failed", "check", name, "error", err)
+			resp.Checks[name] = err.Error()
+			resp.Status = "unavailable"
+			status = http.StatusServiceUnavailable
+			continue
+		}
+		resp.Checks[name] = "ok"
+	}
+
+	out, _ := json.MarshalIndent(resp, "", "    ")
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	w.Write(out)
+}
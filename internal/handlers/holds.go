@@ -0,0 +1,64 @@
+package handlers
+
+import (
+	"context"
+	"time"
+)
+
+// holdDuration is how long a room is held for a guest while they fill in their details
+const holdDuration = 10 * time.Minute
+
+// placeHold holds a room for the guest while they book, replacing any hold they already have.
+// It returns repository.ErrNotAvailable if the room has been taken
+func (m *Repository) placeHold(ctx context.Context, roomID int, startDate, endDate time.Time) error {
+	m.releaseHold(ctx)
+	// a new hold means the guest isn't booking a waitlist offer they followed earlier
+	m.App.Session.Remove(ctx, waitlistEntryKey)
+
+	id, err := m.DB.InsertHoldForRoom(ctx, roomID, startDate, endDate, time.Now().Add(holdDuration))
+	if err != nil {
+		return err
+	}
+
+	m.App.Session.Put(ctx, "hold_id", id)
+	return nil
+}
+
+// adoptHold makes a hold placed for the guest elsewhere, such as for a waitlist offer, their hold,
+// replacing any hold they already have
+func (m *Repository) adoptHold(ctx context.Context, id int) {
+	if m.App.Session.GetInt(ctx, "hold_id") != id {
+		m.releaseHold(ctx)
+	}
+	m.App.Session.Remove(ctx, waitlistEntryKey)
+
+	m.App.Session.Put(ctx, "hold_id", id)
+}
+
+// releaseHold releases the guest's hold, if they have one
+func (m *Repository) releaseHold(ctx context.Context) {
+	id := m.App.Session.GetInt(ctx, "hold_id")
+	if id == 0 {
+		return
+	}
+
+	m.App.Session.Remove(ctx, "hold_id")
+	err := m.DB.DeleteHold(ctx, id)
+	if err != nil {
+		m.App.Logger.ErrorContext(ctx, "can't release hold", "hold_id", id, "error", err)
+	}
+}
+
+// ReleaseExpiredHolds releases holds that guests did not turn into reservations in time,
+// and offers the freed rooms to the waitlist
+func (m *Repository) ReleaseExpiredHolds(ctx context.Context) error {
+	released, err := m.DB.DeleteExpiredHolds(ctx)
+	if err != nil {
+		return err
+	}
+
+	if released > 0 {
+		m.triggerWaitlistMatch()
+	}
+	return nil
+}
@@ -0,0 +1,98 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"github.com/usmanzaheer1995/bed-and-breakfast/internal/helpers"
+	"github.com/usmanzaheer1995/bed-and-breakfast/internal/integrity"
+	"github.com/usmanzaheer1995/bed-and-breakfast/internal/models"
+	"github.com/usmanzaheer1995/bed-and-breakfast/internal/render"
+	"github.com/usmanzaheer1995/bed-and-breakfast/internal/repository"
+	"net/http"
+)
+
+// AdminIntegrity lists the ways reservations and room restrictions disagree, with links to them and
+// a repair button for the issues that can be fixed safely
+func (m *Repository) AdminIntegrity(w http.ResponseWriter, r *http.Request) {
+	issues, err := integrity.Check(r.Context(), m.DB)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	repairable := 0
+	for _, issue := range issues {
+		if issue.Repairable() {
+			repairable++
+		}
+	}
+
+	data := make(map[string]interface{})
+	data["issues"] = issues
+
+	intMap := make(map[string]int)
+	intMap["repairable"] = repairable
+
+	render.Template(w, r, "admin-integrity.page.tmpl", &models.TemplateData{
+		Data:   data,
+		IntMap: intMap,
+	})
+}
+
+// AdminPostIntegrityRepair repairs the issue named by the form's key, or every repairable issue if the
+// key is "all". Issues are found afresh, so nothing is repaired that has been sorted out meanwhile
+func (m *Repository) AdminPostIntegrityRepair(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ClientError(w, r, http.StatusBadRequest)
+		return
+	}
+	key := r.Form.Get("key")
+
+	issues, err := integrity.Check(r.Context(), m.DB)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	if key == "all" {
+		repaired, errs := integrity.RepairAll(r.Context(), m.DB, issues)
+		for _, err := range errs {
+			m.App.Logger.WarnContext(r.Context(), "can't repair integrity issue", "error", err)
+		}
+
+		if len(errs) > 0 {
+			m.App.Session.Put(r.Context(), "error", fmt.Sprintf("Repaired %d issues, %d could not be repaired", repaired, len(errs)))
+		} else {
+			m.App.Session.Put(r.Context(), "flash", fmt.Sprintf("Repaired %d issues", repaired))
+		}
+		http.Redirect(w, r, "/admin/integrity", http.StatusSeeOther)
+		return
+	}
+
+	for _, issue := range issues {
+		if issue.Key() != key {
+			continue
+		}
+
+		err = integrity.Repair(r.Context(), m.DB, issue)
+		switch {
+		case err == nil:
+			m.App.Logger.InfoContext(r.Context(), "repaired integrity issue", "kind", issue.Kind,
+				"reservation_id", issue.ReservationID, "restriction_id", issue.RestrictionID)
+			m.App.Session.Put(r.Context(), "flash", "Issue repaired")
+		case errors.Is(err, repository.ErrNotAvailable):
+			m.App.Session.Put(r.Context(), "error", "The room is taken on those dates, so the reservation has to be moved by hand")
+		case errors.Is(err, repository.ErrConflict), errors.Is(err, integrity.ErrNotRepairable):
+			m.App.Session.Put(r.Context(), "error", "The issue can't be repaired automatically")
+		default:
+			helpers.ServerError(w, r, err)
+			return
+		}
+		http.Redirect(w, r, "/admin/integrity", http.StatusSeeOther)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "The issue has already been sorted out")
+	http.Redirect(w, r, "/admin/integrity", http.StatusSeeOther)
+}
@@ -0,0 +1,318 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"github.com/go-chi/chi/v5"
+	"github.com/usmanzaheer1995/bed-and-breakfast/internal/forms"
+	"github.com/usmanzaheer1995/bed-and-breakfast/internal/helpers"
+	"github.com/usmanzaheer1995/bed-and-breakfast/internal/mailer"
+	"github.com/usmanzaheer1995/bed-and-breakfast/internal/models"
+	"github.com/usmanzaheer1995/bed-and-breakfast/internal/render"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// notificationEvents lists the events staff can be notified about, with a label for each
+var notificationEvents = []struct {
+	Event string
+	Label string
+}{
+	{models.NotifyNewBooking, "New booking"},
+	{models.NotifyCancellation, "Cancellation"},
+	{models.NotifyModification, "Modification"},
+	{models.NotifyBlockChange, "Block change"},
+}
+
+// staffRecipients works out who hears about an event under the notification rules, and whether they
+// get it straight away or in their daily digest. Role rules reach the users with that access level who
+// opted in to the event. Anyone who should get the event straight away under some rule does, and
+// everyone does when the digest is turned off
+func (m *Repository) staffRecipients(ctx context.Context, event string) (immediate, digest []string, err error) {
+	rules, err := m.DB.AllNotificationRules(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	wantsDigest := make(map[string]bool)
+	var order []string
+
+	add := func(address string, useDigest bool) {
+		current, seen := wantsDigest[address]
+		if !seen {
+			order = append(order, address)
+			wantsDigest[address] = useDigest
+			return
+		}
+		wantsDigest[address] = current && useDigest
+	}
+
+	for _, rule := range rules {
+		if rule.Event != event {
+			continue
+		}
+
+		if rule.Address != "" {
+			add(rule.Address, rule.Digest)
+			continue
+		}
+
+		users, err := m.DB.UsersByAccessLevel(ctx, rule.AccessLevel)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, u := range users {
+			if userWantsEvent(u, event) {
+				add(u.Email, rule.Digest || u.NotifyDigest)
+			}
+		}
+	}
+
+	for _, address := range order {
+		if wantsDigest[address] && m.App.Features.StaffDigest {
+			digest = append(digest, address)
+		} else {
+			immediate = append(immediate, address)
+		}
+	}
+	return immediate, digest, nil
+}
+
+// userWantsEvent reports whether a user opted in to an event
+func userWantsEvent(u models.User, event string) bool {
+	switch event {
+	case models.NotifyNewBooking:
+		return u.NotifyNewBooking
+	case models.NotifyCancellation:
+		return u.NotifyCancellation
+	case models.NotifyModification:
+		return u.NotifyModification
+	case models.NotifyBlockChange:
+		return u.NotifyBlockChange
+	}
+	return false
+}
+
+// notifyStaff tells staff about an event, straight away or in their daily digest as the rules say
+func (m *Repository) notifyStaff(ctx context.Context, event, title, summary string) {
+	immediate, digest, err := m.staffRecipients(ctx, event)
+	if err != nil {
+		m.App.Logger.ErrorContext(ctx, "can't find staff to notify", "event", event, "error", err)
+		return
+	}
+
+	for _, to := range immediate {
+		msg, err := m.App.EmailTemplates.Message(m.App.StaffMailFrom, to, mailer.StaffNotification{
+			Title:   title,
+			Summary: summary,
+		})
+		if err != nil {
+			m.App.Logger.ErrorContext(ctx, "can't build staff notification", "event", event, "error", err)
+			return
+		}
+		m.queueMail(ctx, msg)
+	}
+
+	m.addToDigest(ctx, digest, event, summary)
+}
+
+// addToDigest saves an event for the recipients' next daily digest
+func (m *Repository) addToDigest(ctx context.Context, recipients []string, event, summary string) {
+	for _, to := range recipients {
+		err := m.DB.InsertDigestItem(ctx, models.DigestItem{
+			Recipient: to,
+			Event:     event,
+			Summary:   summary,
+		})
+		if err != nil {
+			m.App.Logger.ErrorContext(ctx, "can't add to digest", "event", event, "error", err)
+		}
+	}
+}
+
+// SendDailyDigest sends the digest once a day, on the first call at or after the digest hour in the
+// property's time zone. A day the app was down at the digest hour still gets its digest when it starts
+func (m *Repository) SendDailyDigest(ctx context.Context, now time.Time) error {
+	location := m.App.Property.Location
+	if location == nil {
+		location = time.Local
+	}
+
+	now = now.In(location)
+	if now.Before(time.Date(now.Year(), now.Month(), now.Day(), m.App.DigestHour, 0, 0, 0, location)) {
+		return nil
+	}
+
+	claimed, err := m.DB.ClaimDigestRun(ctx, time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC))
+	if err != nil || !claimed {
+		return err
+	}
+	return m.SendDigests(ctx)
+}
+
+// SendDigests emails each recipient the events saved for their digest, in one message
+func (m *Repository) SendDigests(ctx context.Context) error {
+	items, err := m.DB.PendingDigestItems(ctx)
+	if err != nil {
+		return err
+	}
+
+	byRecipient := make(map[string][]models.DigestItem)
+	var order []string
+	for _, i := range items {
+		if _, ok := byRecipient[i.Recipient]; !ok {
+			order = append(order, i.Recipient)
+		}
+		byRecipient[i.Recipient] = append(byRecipient[i.Recipient], i)
+	}
+
+	for _, to := range order {
+		recipientItems := byRecipient[to]
+
+		msg, err := m.App.EmailTemplates.Message(m.App.StaffMailFrom, to, mailer.Digest{
+			Date:  time.Now(),
+			Items: recipientItems,
+		})
+		if err != nil {
+			return err
+		}
+
+		err = m.DB.InsertOutboxMessage(ctx, msg)
+		if err != nil {
+			return err
+		}
+
+		err = m.DB.MarkDigestItemsSent(ctx, to, recipientItems[len(recipientItems)-1].ID)
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(order) > 0 {
+		m.triggerMailDelivery()
+	}
+	return nil
+}
+
+// reservationSummary describes a reservation in one line for staff notifications
+func reservationSummary(what string, res models.Reservation) string {
+	return fmt.Sprintf("%s: %s %s in %s from %s to %s", what, res.FirstName, res.LastName, res.Room.RoomName,
+		res.StartDate.Format("2006-01-02"), res.EndDate.Format("2006-01-02"))
+}
+
+// AdminNotifications shows the notification rules and the signed in user's notification preferences
+func (m *Repository) AdminNotifications(w http.ResponseWriter, r *http.Request) {
+	rules, err := m.DB.AllNotificationRules(r.Context())
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	user, err := m.DB.GetUserByID(r.Context(), m.App.Session.GetInt(r.Context(), "user_id"))
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["rules"] = rules
+	data["user"] = user
+	data["events"] = notificationEvents
+
+	render.Template(w, r, "admin-notifications.page.tmpl", &models.TemplateData{
+		Data: data,
+	})
+}
+
+// AdminPostNotificationRule adds a notification rule
+func (m *Repository) AdminPostNotificationRule(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	rule := models.NotificationRule{
+		Event:   r.Form.Get("event"),
+		Address: r.Form.Get("address"),
+		Digest:  r.Form.Get("digest") == "1",
+	}
+	rule.AccessLevel, _ = strconv.Atoi(r.Form.Get("access_level"))
+
+	valid := false
+	for _, e := range notificationEvents {
+		if e.Event == rule.Event {
+			valid = true
+		}
+	}
+
+	form := forms.New(r.PostForm)
+	if rule.Address != "" {
+		form.IsEmail("address")
+	}
+
+	// a rule goes either to one address or to a role
+	if !valid || !form.Valid() || (rule.Address == "") == (rule.AccessLevel == 0) {
+		m.App.Session.Put(r.Context(), "error", "A rule needs an event and either an email address or a role")
+		http.Redirect(w, r, "/admin/notifications", http.StatusSeeOther)
+		return
+	}
+
+	err = m.DB.InsertNotificationRule(r.Context(), rule)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Rule added")
+	http.Redirect(w, r, "/admin/notifications", http.StatusSeeOther)
+}
+
+// AdminDeleteNotificationRule removes a notification rule
+func (m *Repository) AdminDeleteNotificationRule(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ClientError(w, r, http.StatusBadRequest)
+		return
+	}
+
+	err = m.DB.DeleteNotificationRule(r.Context(), id)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Rule removed")
+	http.Redirect(w, r, "/admin/notifications", http.StatusSeeOther)
+}
+
+// AdminPostNotificationPreferences saves which events the signed in user wants to hear about
+func (m *Repository) AdminPostNotificationPreferences(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	user, err := m.DB.GetUserByID(r.Context(), m.App.Session.GetInt(r.Context(), "user_id"))
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	user.NotifyNewBooking = r.Form.Get(models.NotifyNewBooking) == "1"
+	user.NotifyCancellation = r.Form.Get(models.NotifyCancellation) == "1"
+	user.NotifyModification = r.Form.Get(models.NotifyModification) == "1"
+	user.NotifyBlockChange = r.Form.Get(models.NotifyBlockChange) == "1"
+	user.NotifyDigest = r.Form.Get("digest") == "1"
+
+	err = m.DB.UpdateUser(r.Context(), user)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Preferences saved")
+	http.Redirect(w, r, "/admin/notifications", http.StatusSeeOther)
+}
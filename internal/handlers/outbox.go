@@ -0,0 +1,172 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"github.com/go-chi/chi/v5"
+	"github.com/usmanzaheer1995/bed-and-breakfast/internal/helpers"
+	"github.com/usmanzaheer1995/bed-and-breakfast/internal/ical"
+	"github.com/usmanzaheer1995/bed-and-breakfast/internal/mailer"
+	"github.com/usmanzaheer1995/bed-and-breakfast/internal/models"
+	"github.com/usmanzaheer1995/bed-and-breakfast/internal/render"
+	"github.com/usmanzaheer1995/bed-and-breakfast/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// outboxMaxAttempts is how many times a message is tried before it is dead-lettered as failed
+const outboxMaxAttempts = 8
+
+// outboxBaseBackoff is the wait before the first retry; each further retry waits twice as long
+const outboxBaseBackoff = 30 * time.Second
+
+// outboxMaxBackoff caps the wait between retries
+const outboxMaxBackoff = 6 * time.Hour
+
+// outboxLease is how long a message being delivered is hidden from other workers
+const outboxLease = 5 * time.Minute
+
+// queueMail puts an email in the outbox and wakes the mail workers
+func (m *Repository) queueMail(ctx context.Context, msg models.MailData) {
+	err := m.DB.InsertOutboxMessage(ctx, msg)
+	if err != nil {
+		m.App.Logger.ErrorContext(ctx, "can't queue email", "to", msg.To, "subject", msg.Subject, "error", err)
+		return
+	}
+	m.triggerMailDelivery()
+}
+
+// triggerMailDelivery asks the mail workers to check the outbox, without waiting for them
+func (m *Repository) triggerMailDelivery() {
+	select {
+	case m.App.MailQueueChan <- struct{}{}:
+	default:
+		// a check is already pending
+	}
+}
+
+// DueMail claims up to limit outbox messages that are due for delivery
+func (m *Repository) DueMail(ctx context.Context, limit int) ([]models.OutboxMessage, error) {
+	return m.DB.ClaimOutboxMessages(ctx, limit, outboxLease)
+}
+
+// DeliverMail sends an outbox message with the configured mailer and records the outcome
+func (m *Repository) DeliverMail(ctx context.Context, msg models.OutboxMessage) error {
+	_, span := tracing.Start(ctx, "mail.Send",
+		attribute.Int("outbox.id", msg.ID),
+		attribute.Int("outbox.attempt", msg.Attempts),
+		attribute.String("mail.template", msg.Mail.Template))
+	err := m.App.Mailer.Send(msg.Mail)
+	tracing.End(span, err)
+
+	return m.RecordMailDelivery(ctx, msg, err)
+}
+
+// RecordMailDelivery records the outcome of sending an outbox message. A failed message is
+// retried with exponential backoff until it runs out of attempts
+func (m *Repository) RecordMailDelivery(ctx context.Context, msg models.OutboxMessage, sendErr error) error {
+	if sendErr == nil {
+		msg.Status = models.OutboxSent
+		msg.LastError = ""
+		msg.SentAt = time.Now()
+		return m.DB.UpdateOutboxMessage(ctx, msg)
+	}
+
+	msg.LastError = sendErr.Error()
+	if msg.Attempts >= outboxMaxAttempts {
+		msg.Status = models.OutboxFailed
+	} else {
+		msg.NextAttemptAt = time.Now().Add(outboxBackoff(msg.Attempts))
+	}
+	return m.DB.UpdateOutboxMessage(ctx, msg)
+}
+
+// outboxBackoff returns how long to wait before retrying a message that has been tried attempts times
+func outboxBackoff(attempts int) time.Duration {
+	backoff := outboxBaseBackoff
+	for i := 1; i < attempts; i++ {
+		backoff *= 2
+		if backoff >= outboxMaxBackoff {
+			return outboxMaxBackoff
+		}
+	}
+	return backoff
+}
+
+// AdminOutbox shows queued, sent and failed emails
+func (m *Repository) AdminOutbox(w http.ResponseWriter, r *http.Request) {
+	status := r.URL.Query().Get("status")
+	switch status {
+	case "", models.OutboxQueued, models.OutboxSent, models.OutboxFailed:
+	default:
+		helpers.ClientError(w, r, http.StatusBadRequest)
+		return
+	}
+
+	messages, err := m.DB.AllOutboxMessages(r.Context(), status)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["messages"] = messages
+
+	stringMap := make(map[string]string)
+	stringMap["status"] = status
+
+	render.Template(w, r, "admin-outbox.page.tmpl", &models.TemplateData{
+		Data:      data,
+		StringMap: stringMap,
+	})
+}
+
+// AdminResendOutboxMessage puts an email back in the outbox for delivery
+func (m *Repository) AdminResendOutboxMessage(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ClientError(w, r, http.StatusBadRequest)
+		return
+	}
+
+	err = m.DB.ResendOutboxMessage(r.Context(), id)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	m.triggerMailDelivery()
+
+	m.App.Session.Put(r.Context(), "flash", "Email queued for delivery")
+	http.Redirect(w, r, fmt.Sprintf("/admin/outbox?status=%s", r.URL.Query().Get("status")), http.StatusSeeOther)
+}
+
+// ResendConfirmation queues the booking confirmation of a reservation again, with its calendar
+// invite, for a guest who lost or never got the first one
+func (m *Repository) ResendConfirmation(ctx context.Context, id int) error {
+	res, err := m.DB.GetReservationByID(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	msg, err := m.App.EmailTemplates.Message(m.App.MailFrom, res.Email, mailer.Confirmation{Reservation: res})
+	if err != nil {
+		return err
+	}
+
+	invite, err := ical.Attachment(ical.MethodRequest, res, m.App.Property)
+	if err != nil {
+		return err
+	}
+	msg.Attachments = append(msg.Attachments, invite)
+
+	err = m.DB.InsertOutboxMessage(ctx, msg)
+	if err != nil {
+		return err
+	}
+
+	m.triggerMailDelivery()
+	return nil
+}
@@ -0,0 +1,221 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"github.com/go-chi/chi/v5"
+	"github.com/usmanzaheer1995/bed-and-breakfast/internal/forms"
+	"github.com/usmanzaheer1995/bed-and-breakfast/internal/helpers"
+	"github.com/usmanzaheer1995/bed-and-breakfast/internal/mailer"
+	"github.com/usmanzaheer1995/bed-and-breakfast/internal/models"
+	"github.com/usmanzaheer1995/bed-and-breakfast/internal/render"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// scheduledCatchUpDays is how many days late a scheduled email may still go out, so none are missed
+// while the site is down
+const scheduledCatchUpDays = 2
+
+// maxScheduledOffsetDays bounds how far before or after a stay a scheduled email can be sent
+const maxScheduledOffsetDays = 365
+
+// SendScheduledEmails queues every active scheduled email that has fallen due. Each email is sent at most
+// once per reservation, however often this runs
+func (m *Repository) SendScheduledEmails(ctx context.Context) error {
+	emails, err := m.DB.AllScheduledEmails(ctx)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	from := today.AddDate(0, 0, -scheduledCatchUpDays)
+
+	queued := false
+	for _, e := range emails {
+		if !e.Active {
+			continue
+		}
+
+		reservations, err := m.DB.ReservationsDueForScheduledEmail(ctx, e, from, today)
+		if err != nil {
+			return err
+		}
+
+		for _, res := range reservations {
+			// an email meant for before the stay is no use once the guest has arrived
+			if e.OffsetDays < 0 && scheduledAnchorDate(e, res).Before(today) {
+				continue
+			}
+
+			msg, err := m.App.EmailTemplates.ScheduledMessage(m.App.MailFrom, e, res)
+			if err != nil {
+				// a broken template must not hold up the other emails
+				m.App.Logger.Error("can't render scheduled email", "scheduled_email", e.Name, "error", err)
+				break
+			}
+
+			ok, err := m.DB.QueueScheduledEmail(ctx, e.ID, res.ID, msg)
+			if err != nil {
+				return err
+			}
+			queued = queued || ok
+		}
+	}
+
+	if queued {
+		m.triggerMailDelivery()
+	}
+	return nil
+}
+
+// scheduledAnchorDate returns the date of a reservation a scheduled email is timed from
+func scheduledAnchorDate(e models.ScheduledEmail, res models.Reservation) time.Time {
+	if e.Anchor == models.AnchorEndDate {
+		return res.EndDate
+	}
+	return res.StartDate
+}
+
+// AdminScheduledEmails lists the scheduled guest emails
+func (m *Repository) AdminScheduledEmails(w http.ResponseWriter, r *http.Request) {
+	emails, err := m.DB.AllScheduledEmails(r.Context())
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["emails"] = emails
+
+	stringMap := make(map[string]string)
+	for _, e := range emails {
+		stringMap[fmt.Sprintf("when_%d", e.ID)] = scheduleDescription(e)
+	}
+
+	render.Template(w, r, "admin-scheduled-emails.page.tmpl", &models.TemplateData{
+		Data:      data,
+		StringMap: stringMap,
+	})
+}
+
+// scheduleDescription says when a scheduled email is sent, like "3 days before arrival"
+func scheduleDescription(e models.ScheduledEmail) string {
+	anchor := "arrival"
+	if e.Anchor == models.AnchorEndDate {
+		anchor = "departure"
+	}
+
+	days := "days"
+	if e.OffsetDays == 1 || e.OffsetDays == -1 {
+		days = "day"
+	}
+
+	switch {
+	case e.OffsetDays < 0:
+		return fmt.Sprintf("%d %s before %s", -e.OffsetDays, days, anchor)
+	case e.OffsetDays > 0:
+		return fmt.Sprintf("%d %s after %s", e.OffsetDays, days, anchor)
+	}
+	return "On the day of " + anchor
+}
+
+// AdminShowScheduledEmail shows a scheduled email for editing, with a preview
+func (m *Repository) AdminShowScheduledEmail(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ClientError(w, r, http.StatusBadRequest)
+		return
+	}
+
+	e, err := m.DB.GetScheduledEmailByID(r.Context(), id)
+	if err != nil {
+		helpers.ClientError(w, r, http.StatusNotFound)
+		return
+	}
+
+	m.renderScheduledEmail(w, r, e, forms.New(nil))
+}
+
+// AdminPostScheduledEmail saves the timing and templates of a scheduled email
+func (m *Repository) AdminPostScheduledEmail(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ClientError(w, r, http.StatusBadRequest)
+		return
+	}
+
+	e, err := m.DB.GetScheduledEmailByID(r.Context(), id)
+	if err != nil {
+		helpers.ClientError(w, r, http.StatusNotFound)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("subject", "html_body", "text_body")
+
+	e.Anchor = form.Get("anchor")
+	if e.Anchor != models.AnchorStartDate && e.Anchor != models.AnchorEndDate {
+		form.Errors.Add("anchor", "Choose arrival or departure")
+	}
+
+	e.OffsetDays, err = strconv.Atoi(form.Get("offset_days"))
+	if err != nil || e.OffsetDays < -maxScheduledOffsetDays || e.OffsetDays > maxScheduledOffsetDays {
+		form.Errors.Add("offset_days", "Enter a number of days between -365 and 365")
+	}
+
+	e.Subject = form.Get("subject")
+	e.HTMLBody = form.Get("html_body")
+	e.TextBody = form.Get("text_body")
+	e.Active = form.Get("active") == "1"
+
+	// the templates must render, or the email could never be sent
+	if form.Valid() {
+		_, _, _, err = m.App.EmailTemplates.RenderScheduled(e, mailer.SampleReservation())
+		if err != nil {
+			form.Errors.Add("html_body", err.Error())
+		}
+	}
+
+	if !form.Valid() {
+		m.renderScheduledEmail(w, r, e, form)
+		return
+	}
+
+	err = m.DB.UpdateScheduledEmail(r.Context(), e)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Changes saved")
+	http.Redirect(w, r, "/admin/scheduled-emails", http.StatusSeeOther)
+}
+
+// renderScheduledEmail renders the scheduled email form, with a preview if the templates render
+func (m *Repository) renderScheduledEmail(w http.ResponseWriter, r *http.Request, e models.ScheduledEmail, form *forms.Form) {
+	data := make(map[string]interface{})
+	data["email"] = e
+
+	stringMap := make(map[string]string)
+	subject, html, text, err := m.App.EmailTemplates.RenderScheduled(e, mailer.SampleReservation())
+	if err == nil {
+		stringMap["subject"] = subject
+		stringMap["html"] = html
+		stringMap["text"] = text
+	}
+
+	render.Template(w, r, "admin-scheduled-emails-show.page.tmpl", &models.TemplateData{
+		Data:      data,
+		StringMap: stringMap,
+		Form:      form,
+	})
+}
@@ -8,10 +8,16 @@ import (
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/justinas/nosurf"
 	"github.com/usmanzaheer1995/bed-and-breakfast/internal/config"
+	"github.com/usmanzaheer1995/bed-and-breakfast/internal/health"
+	"github.com/usmanzaheer1995/bed-and-breakfast/internal/helpers"
+	"github.com/usmanzaheer1995/bed-and-breakfast/internal/ical"
+	"github.com/usmanzaheer1995/bed-and-breakfast/internal/logging"
+	"github.com/usmanzaheer1995/bed-and-breakfast/internal/mailer"
 	"github.com/usmanzaheer1995/bed-and-breakfast/internal/models"
 	"github.com/usmanzaheer1995/bed-and-breakfast/internal/render"
 	"html/template"
 	"log"
+	"log/slog"
 	"net/http"
 	"os"
 	"path/filepath"
@@ -21,6 +27,7 @@ import (
 
 var app config.AppConfig
 var session *scs.SessionManager
+var mailRecorder = mailer.NewRecorder()
 var pathToTemplates = "./../../templates"
 
 var functions = template.FuncMap{
@@ -35,16 +42,11 @@ func TestMain(m *testing.M) {
 	gob.Register(models.User{})
 	gob.Register(models.Room{})
 	gob.Register(models.Restriction{})
-	gob.Register(map[string]int{})
 
 	// change this to true when in production
 	app.InProduction = false
 
-	infoLog := log.New(os.Stdout, "INFO\t", log.Ldate|log.Ltime)
-	app.InfoLog = infoLog
-
-	errorLog := log.New(os.Stdout, "ERROR\t", log.Ldate|log.Ltime|log.Lshortfile)
-	app.ErrorLog = errorLog
+	app.Logger = logging.New(os.Stdout, logging.FormatText, slog.LevelInfo)
 
 	session = scs.New()
 	session.Lifetime = 24 * time.Hour
@@ -54,11 +56,35 @@ func TestMain(m *testing.M) {
 
 	app.Session = session
 
-	mailChan := make(chan models.MailData)
-	app.MailChan = mailChan
-	defer close(mailChan)
+	app.Mailer = mailRecorder
 
-	listenForMail()
+	emailTemplates, err := mailer.LoadTemplates("./../../email-templates")
+	if err != nil {
+		log.Fatal("cannot load email templates")
+	}
+	app.EmailTemplates = emailTemplates
+	app.MailQueueChan = make(chan struct{}, 1)
+	app.MailWorker = health.NewHeartbeat(time.Hour)
+	app.MailWorker.Beat()
+	app.WaitlistChan = make(chan struct{}, 1)
+	app.BaseURL = "http://localhost:8080"
+	app.MailFrom = "developer@bednbreakfast.com"
+	app.StaffMailFrom = "developer@bednbreakfast.com"
+	app.Features = config.Features{
+		Waitlist:        true,
+		FlexibleSearch:  true,
+		ScheduledEmails: true,
+		StaffDigest:     true,
+	}
+	app.Property = ical.Property{
+		Name:     "Usman's Bed and Breakfast",
+		Address:  "1 Main Street, Springfield",
+		Email:    "developer@bednbreakfast.com",
+		Domain:   "localhost",
+		CheckIn:  "15:00",
+		CheckOut: "11:00",
+		Location: time.UTC,
+	}
 
 	tc, err := CreateTestTemplateCache()
 	if err != nil {
@@ -71,24 +97,21 @@ func TestMain(m *testing.M) {
 	repo := NewTestRepo(&app)
 	NewHandlers(repo)
 	render.NewRenderer(&app)
+	helpers.NewHelpers(&app)
 
 	os.Exit(m.Run())
 }
 
-func listenForMail() {
-	go func() {
-		for {
-			_ = <-app.MailChan
-		}
-	}()
-}
-
 func getRoutes() http.Handler {
 	mux := chi.NewRouter()
 
 	mux.Use(middleware.Recoverer)
 	//mux.Use(NoSurf)
 	mux.Use(SessionLoad)
+	mux.Use(Repo.TrackSession)
+
+	mux.Get("/healthz", Repo.Healthz)
+	mux.Get("/readyz", Repo.Readyz)
 
 	mux.Get("/", Repo.Home)
 	mux.Get("/about", Repo.About)
@@ -98,12 +121,19 @@ func getRoutes() http.Handler {
 	mux.Get("/search-availability", Repo.Availability)
 	mux.Post("/search-availability", Repo.PostAvailability)
 	mux.Post("/search-availability-json", Repo.AvailabilityJSON)
+	mux.Post("/search-availability-flexible", Repo.PostFlexibleAvailability)
+
+	mux.Get("/waitlist", Repo.Waitlist)
+	mux.Post("/waitlist", Repo.PostWaitlist)
+	mux.Get("/waitlist/{token}", Repo.ClaimWaitlist)
 
 	mux.Get("/contact", Repo.Contact)
 
 	mux.Get("/make-reservation", Repo.Reservation)
 	mux.Post("/make-reservation", Repo.PostReservation)
 	mux.Get("/reservation-summary", Repo.ReservationSummary)
+	mux.Get("/confirm-reservation/{token}", Repo.ConfirmReservation)
+	mux.Post("/confirm-reservation/{token}", Repo.PostConfirmReservation)
 
 	mux.Get("/user/login", Repo.ShowLogin)
 	mux.Post("/user/login", Repo.PostShowLogin)
@@ -115,12 +145,32 @@ func getRoutes() http.Handler {
 	mux.Get("/admin/reservations-all", Repo.AdminAllReservations)
 	mux.Get("/admin/reservations-calendar", Repo.AdminReservationsCalendar)
 	mux.Post("/admin/reservations-calendar", Repo.AdminPostReservationsCalendar)
+	mux.Get("/admin/reservations-planner", Repo.AdminReservationsPlanner)
+	mux.Get("/admin/calendar-json", Repo.AdminCalendarJSON)
+	mux.Post("/admin/calendar-json", Repo.AdminPostCalendarJSON)
 	mux.Get("/admin/process-reservation/{src}/{id}/do", Repo.AdminProcessReservation)
 	mux.Get("/admin/delete-reservation/{src}/{id}/do", Repo.AdminDeleteReservation)
 
 	mux.Get("/admin/reservations/{src}/{id}/show", Repo.AdminShowReservation)
 	mux.Post("/admin/reservations/{src}/{id}", Repo.AdminPostShowReservation)
 
+	mux.Get("/admin/outbox", Repo.AdminOutbox)
+	mux.Post("/admin/outbox/{id}/resend", Repo.AdminResendOutboxMessage)
+
+	mux.Get("/admin/email-templates", Repo.AdminEmailTemplates)
+	mux.Get("/admin/email-templates/{name}", Repo.AdminEmailTemplates)
+
+	mux.Get("/admin/notifications", Repo.AdminNotifications)
+	mux.Post("/admin/notifications/rules", Repo.AdminPostNotificationRule)
+	mux.Post("/admin/notifications/rules/{id}/delete", Repo.AdminDeleteNotificationRule)
+	mux.Post("/admin/notifications/preferences", Repo.AdminPostNotificationPreferences)
+
+	mux.Get("/admin/scheduled-emails", Repo.AdminScheduledEmails)
+	mux.Get("/admin/scheduled-emails/{id}", Repo.AdminShowScheduledEmail)
+	mux.Post("/admin/scheduled-emails/{id}", Repo.AdminPostScheduledEmail)
+	mux.Get("/admin/integrity", Repo.AdminIntegrity)
+	mux.Get("/admin/account", Repo.AdminAccount)
+
 	fileServer := http.FileServer(http.Dir("./static/"))
 	mux.Handle("/static/*", http.StripPrefix("/static", fileServer))
 
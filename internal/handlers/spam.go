@@ -0,0 +1,56 @@
+package handlers
+
+import (
+	"errors"
+	"github.com/usmanzaheer1995/bed-and-breakfast/internal/captcha"
+	"github.com/usmanzaheer1995/bed-and-breakfast/internal/forms"
+	"github.com/usmanzaheer1995/bed-and-breakfast/internal/helpers"
+	"net/http"
+	"time"
+)
+
+// honeypotField is the reservation form's hidden field, which only bots fill in
+const honeypotField = "website"
+
+// formShownKey is the session key of when the reservation form was last shown, in Unix nanoseconds
+const formShownKey = "reservation_form_shown"
+
+// checkForBots adds an error to the reservation form if a bot looks to have posted it: the honeypot was
+// filled in, the form came back quicker than a person could fill it in, or the CAPTCHA wasn't passed
+func (m *Repository) checkForBots(r *http.Request, form *forms.Form) {
+	form.Honeypot(honeypotField)
+
+	if min := time.Duration(m.App.Spam.MinFillTime); min > 0 {
+		var shown time.Time
+		if ns, ok := m.App.Session.Get(r.Context(), formShownKey).(int64); ok {
+			shown = time.Unix(0, ns)
+		}
+		form.MinFillTime(shown, time.Now(), min)
+	}
+
+	if form.Errors.Get(forms.WholeForm) != "" {
+		m.App.Logger.InfoContext(r.Context(), "reservation form looked automated", "ip", helpers.ClientIP(r))
+		return
+	}
+
+	if m.App.Captcha == nil {
+		return
+	}
+
+	response := form.Get(m.App.Captcha.Widget().Field)
+	err := m.App.Captcha.Verify(r.Context(), response, helpers.ClientIP(r))
+	if errors.Is(err, captcha.ErrFailed) {
+		form.Errors.Add(forms.WholeForm, "Please complete the CAPTCHA")
+	} else if err != nil {
+		m.App.Logger.ErrorContext(r.Context(), "can't verify captcha", "error", err)
+		form.Errors.Add(forms.WholeForm, "We couldn't check the CAPTCHA, please try again")
+	}
+}
+
+// captchaWidget returns the CAPTCHA to show in the reservation form, or nil if there isn't one
+func (m *Repository) captchaWidget() *captcha.Widget {
+	if m.App.Captcha == nil {
+		return nil
+	}
+	return m.App.Captcha.Widget()
+}
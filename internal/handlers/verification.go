@@ -0,0 +1,196 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/go-chi/chi/v5"
+	"github.com/usmanzaheer1995/bed-and-breakfast/internal/forms"
+	"github.com/usmanzaheer1995/bed-and-breakfast/internal/helpers"
+	"github.com/usmanzaheer1995/bed-and-breakfast/internal/ical"
+	"github.com/usmanzaheer1995/bed-and-breakfast/internal/mailer"
+	"github.com/usmanzaheer1995/bed-and-breakfast/internal/metrics"
+	"github.com/usmanzaheer1995/bed-and-breakfast/internal/models"
+	"github.com/usmanzaheer1995/bed-and-breakfast/internal/render"
+	"github.com/usmanzaheer1995/bed-and-breakfast/internal/repository"
+	"net/http"
+	"time"
+)
+
+// postPendingReservation keeps a reservation aside until the guest follows the link emailed to them,
+// so a bot booking with someone else's address never blocks the room. The guest's hold is released
+func (m *Repository) postPendingReservation(w http.ResponseWriter, r *http.Request, reservation models.Reservation) {
+	token, err := newToken()
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	pending := models.PendingReservation{
+		Reservation: reservation,
+		Token:       token,
+		ExpiresAt:   time.Now().Add(time.Duration(m.App.Spam.VerifyWindow)),
+	}
+
+	msg, err := m.App.EmailTemplates.Message(m.App.MailFrom, reservation.Email, mailer.ReservationVerification{
+		Reservation: reservation,
+		Link:        fmt.Sprintf("%s/confirm-reservation/%s", m.App.BaseURL, token),
+		ExpiresAt:   pending.ExpiresAt,
+	})
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	// the email is queued in the same transaction, so the guest can't be left without their link
+	_, err = m.DB.InsertPendingReservation(r.Context(), pending, msg)
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "can't insert reservation into the database")
+		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
+		return
+	}
+
+	m.releaseHold(r.Context())
+	m.triggerMailDelivery()
+	metrics.Booking(metrics.BookingPending)
+
+	m.App.Session.Put(r.Context(), "flash", fmt.Sprintf(
+		"Nearly done! Follow the link we have emailed to %s to confirm your reservation. Until then the room is not booked",
+		reservation.Email))
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// ConfirmReservation shows the reservation a guest is about to confirm, from the link emailed to them.
+// Confirming takes a click, so mail scanners that follow links don't book rooms by themselves
+func (m *Repository) ConfirmReservation(w http.ResponseWriter, r *http.Request) {
+	pending, ok := m.pendingReservation(w, r)
+	if !ok {
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["reservation"] = pending.Reservation
+	data["expires_at"] = pending.ExpiresAt
+
+	render.Template(w, r, "confirm-reservation.page.tmpl", &models.TemplateData{
+		Form:      forms.New(nil),
+		Data:      data,
+		StringMap: map[string]string{"token": pending.Token},
+	})
+}
+
+// PostConfirmReservation turns a pending reservation into a reservation, if the room is still free
+func (m *Repository) PostConfirmReservation(w http.ResponseWriter, r *http.Request) {
+	pending, ok := m.pendingReservation(w, r)
+	if !ok {
+		return
+	}
+	reservation := pending.Reservation
+
+	messages, digest, err := m.reservationMessages(r.Context(), reservation)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	// the room is checked in the same transaction, so two guests confirming at once can't both book it
+	newReservationID, err := m.DB.ConfirmPendingReservation(r.Context(), pending.ID, messages)
+	if errors.Is(err, repository.ErrNotAvailable) {
+		err = m.DB.DeletePendingReservation(r.Context(), pending.ID)
+		if err != nil {
+			m.App.Logger.ErrorContext(r.Context(), "can't delete pending reservation", "id", pending.ID, "error", err)
+		}
+		m.App.Session.Put(r.Context(), "error", "Sorry, the room was booked by someone else before you confirmed")
+		http.Redirect(w, r, "/search-availability", http.StatusSeeOther)
+		return
+	} else if errors.Is(err, sql.ErrNoRows) {
+		m.App.Session.Put(r.Context(), "error", "This reservation has already been confirmed, or the link has expired")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	} else if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+	reservation.ID = newReservationID
+
+	// confirmed from another browser, the waitlist entry isn't known and simply expires with its hold
+	m.claimWaitlistEntry(r.Context())
+	m.triggerMailDelivery()
+
+	m.addToDigest(r.Context(), digest, models.NotifyNewBooking, reservationSummary("New booking", reservation))
+	metrics.Booking(metrics.BookingCreated)
+
+	m.App.Session.Put(r.Context(), "reservation", reservation)
+
+	http.Redirect(w, r, "/reservation-summary", http.StatusSeeOther)
+}
+
+// pendingReservation looks up the pending reservation in the link, sending the guest back to search if
+// it is gone or has expired
+func (m *Repository) pendingReservation(w http.ResponseWriter, r *http.Request) (models.PendingReservation, bool) {
+	pending, err := m.DB.GetPendingReservationByToken(r.Context(), chi.URLParam(r, "token"))
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && time.Now().After(pending.ExpiresAt)) {
+		m.App.Session.Put(r.Context(), "error", "This confirmation link has expired. Please book again")
+		http.Redirect(w, r, "/search-availability", http.StatusSeeOther)
+		return pending, false
+	} else if err != nil {
+		helpers.ServerError(w, r, err)
+		return pending, false
+	}
+	return pending, true
+}
+
+// DeleteExpiredPendingReservations deletes the pending reservations guests didn't confirm in time
+func (m *Repository) DeleteExpiredPendingReservations(ctx context.Context) error {
+	deleted, err := m.DB.DeleteExpiredPendingReservations(ctx)
+	if err != nil {
+		return err
+	}
+
+	if deleted > 0 {
+		m.App.Logger.InfoContext(ctx, "deleted unconfirmed reservations", "count", deleted)
+	}
+	return nil
+}
+
+// reservationMessages returns the emails about a new reservation, to be queued with it: the guest's
+// confirmation and notifications to the staff who want to hear about new bookings straight away. The
+// guest's calendar invite is attached once the reservation has an id, which identifies the event. It
+// also returns the staff who want to hear in their digest
+func (m *Repository) reservationMessages(ctx context.Context, reservation models.Reservation) (func(id int) ([]models.MailData, error), []string, error) {
+	guestMsg, err := m.App.EmailTemplates.Message(m.App.MailFrom, reservation.Email,
+		mailer.Confirmation{Reservation: reservation})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var staffMessages []models.MailData
+	staff, digest, err := m.staffRecipients(ctx, models.NotifyNewBooking)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, to := range staff {
+		msg, err := m.App.EmailTemplates.Message(m.App.StaffMailFrom, to,
+			mailer.OwnerNotification{Reservation: reservation})
+		if err != nil {
+			return nil, nil, err
+		}
+		staffMessages = append(staffMessages, msg)
+	}
+
+	messages := func(id int) ([]models.MailData, error) {
+		res := reservation
+		res.ID = id
+
+		invite, err := ical.Attachment(ical.MethodRequest, res, m.App.Property)
+		if err != nil {
+			return nil, err
+		}
+		msg := guestMsg
+		msg.Attachments = append([]models.MailAttachment{}, invite)
+
+		return append([]models.MailData{msg}, staffMessages...), nil
+	}
+	return messages, digest, nil
+}
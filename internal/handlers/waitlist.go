@@ -0,0 +1,300 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/go-chi/chi/v5"
+	"github.com/usmanzaheer1995/bed-and-breakfast/internal/forms"
+	"github.com/usmanzaheer1995/bed-and-breakfast/internal/helpers"
+	"github.com/usmanzaheer1995/bed-and-breakfast/internal/mailer"
+	"github.com/usmanzaheer1995/bed-and-breakfast/internal/models"
+	"github.com/usmanzaheer1995/bed-and-breakfast/internal/render"
+	"github.com/usmanzaheer1995/bed-and-breakfast/internal/repository"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// waitlistHoldDuration is how long a waitlisted guest has to book once notified
+const waitlistHoldDuration = 24 * time.Hour
+
+// waitlistEntryKey is the session key of the waitlist entry whose offer the guest is booking
+const waitlistEntryKey = "waitlist_entry_id"
+
+// Waitlist displays the form to join the waitlist for a date range
+func (m *Repository) Waitlist(w http.ResponseWriter, r *http.Request) {
+	rooms, err := m.DB.AllRooms(r.Context())
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	stringMap := make(map[string]string)
+	stringMap["start"] = r.URL.Query().Get("start")
+	stringMap["end"] = r.URL.Query().Get("end")
+
+	data := make(map[string]interface{})
+	data["rooms"] = rooms
+
+	render.Template(w, r, "waitlist.page.tmpl", &models.TemplateData{
+		Form:      forms.New(nil),
+		Data:      data,
+		StringMap: stringMap,
+	})
+}
+
+// PostWaitlist adds a guest to the waitlist
+func (m *Repository) PostWaitlist(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "can't parse form!")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("start", "end", "email")
+	form.IsEmail("email")
+
+	layout := "2006-01-02"
+	startDate, err := time.Parse(layout, form.Get("start"))
+	if err != nil {
+		form.Errors.Add("start", "Invalid arrival date")
+	}
+	endDate, err := time.Parse(layout, form.Get("end"))
+	if err != nil {
+		form.Errors.Add("end", "Invalid departure date")
+	}
+	if form.Valid() && !endDate.After(startDate) {
+		form.Errors.Add("end", "Departure must be after arrival")
+	}
+
+	roomID, _ := strconv.Atoi(form.Get("room_id"))
+
+	if !form.Valid() {
+		rooms, err := m.DB.AllRooms(r.Context())
+		if err != nil {
+			helpers.ServerError(w, r, err)
+			return
+		}
+
+		stringMap := make(map[string]string)
+		stringMap["start"] = form.Get("start")
+		stringMap["end"] = form.Get("end")
+
+		data := make(map[string]interface{})
+		data["rooms"] = rooms
+
+		render.Template(w, r, "waitlist.page.tmpl", &models.TemplateData{
+			Form:      form,
+			Data:      data,
+			StringMap: stringMap,
+		})
+		return
+	}
+
+	_, err = m.DB.InsertWaitlistEntry(r.Context(), models.WaitlistEntry{
+		Email:     form.Get("email"),
+		RoomID:    roomID,
+		StartDate: startDate,
+		EndDate:   endDate,
+	})
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "can't add you to the waitlist")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	// a room may already have come free since the search
+	m.triggerWaitlistMatch()
+
+	m.App.Session.Put(r.Context(), "flash", "You are on the waitlist. We will email you if a room becomes available")
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// ClaimWaitlist shows the room offered to a waitlisted guest, from the link emailed to them. Booking it
+// takes a click, so mail scanners that follow links don't take the offer up by themselves
+func (m *Repository) ClaimWaitlist(w http.ResponseWriter, r *http.Request) {
+	entry, ok := m.waitlistOffer(w, r)
+	if !ok {
+		return
+	}
+
+	room, err := m.DB.GetRoomByID(r.Context(), entry.MatchedRoomID)
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "can't find room")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+	entry.Room = room
+
+	data := make(map[string]interface{})
+	data["entry"] = entry
+
+	render.Template(w, r, "claim-waitlist.page.tmpl", &models.TemplateData{
+		Form:      forms.New(nil),
+		Data:      data,
+		StringMap: map[string]string{"token": entry.Token},
+	})
+}
+
+// PostClaimWaitlist takes up a waitlist offer, starting the booking with the room held for the guest
+func (m *Repository) PostClaimWaitlist(w http.ResponseWriter, r *http.Request) {
+	entry, ok := m.waitlistOffer(w, r)
+	if !ok {
+		return
+	}
+
+	room, err := m.DB.GetRoomByID(r.Context(), entry.MatchedRoomID)
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "can't find room")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	// the room has been held since the offer was sent, and stays held until the offer expires
+	m.adoptHold(r.Context(), entry.HoldID)
+	// the entry stays notified until the guest books, so it expires with the hold if they don't
+	m.App.Session.Put(r.Context(), waitlistEntryKey, entry.ID)
+
+	res := models.Reservation{
+		Email:     entry.Email,
+		RoomID:    entry.MatchedRoomID,
+		StartDate: entry.StartDate,
+		EndDate:   entry.EndDate,
+		Room:      room,
+	}
+
+	m.App.Session.Put(r.Context(), "reservation", res)
+	http.Redirect(w, r, "/make-reservation", http.StatusSeeOther)
+}
+
+// waitlistOffer looks up the waitlist entry in the link, sending the guest back to search if the offer
+// has expired or its hold is gone
+func (m *Repository) waitlistOffer(w http.ResponseWriter, r *http.Request) (models.WaitlistEntry, bool) {
+	entry, err := m.DB.GetWaitlistEntryByToken(r.Context(), chi.URLParam(r, "token"))
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "This waitlist link is not valid")
+		http.Redirect(w, r, "/search-availability", http.StatusSeeOther)
+		return entry, false
+	}
+
+	if entry.Status != models.WaitlistNotified || time.Now().After(entry.HoldExpiresAt) {
+		m.App.Session.Put(r.Context(), "error", "This waitlist offer has expired")
+		http.Redirect(w, r, "/search-availability", http.StatusSeeOther)
+		return entry, false
+	}
+
+	if entry.HoldID == 0 {
+		m.App.Session.Put(r.Context(), "error", "Sorry, the room has been taken in the meantime")
+		http.Redirect(w, r, "/search-availability", http.StatusSeeOther)
+		return entry, false
+	}
+	return entry, true
+}
+
+// claimWaitlistEntry marks the waitlist entry the guest came from claimed, now their reservation is made
+func (m *Repository) claimWaitlistEntry(ctx context.Context) {
+	id := m.App.Session.PopInt(ctx, waitlistEntryKey)
+	if id == 0 {
+		return
+	}
+
+	err := m.DB.ClaimWaitlistEntry(ctx, id)
+	if err != nil {
+		m.App.Logger.ErrorContext(ctx, "can't claim waitlist entry", "waitlist_entry_id", id, "error", err)
+	}
+}
+
+// MatchWaitlist offers rooms that have come free to guests on the waitlist, oldest entry first.
+// An offered room is held for the guest, so nobody else can book it until the hold expires
+func (m *Repository) MatchWaitlist(ctx context.Context) error {
+	err := m.DB.ExpireWaitlistEntries(ctx)
+	if err != nil {
+		return err
+	}
+
+	entries, err := m.DB.AllActiveWaitlistEntries(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, e := range entries {
+		if e.Status != models.WaitlistWaiting {
+			continue
+		}
+
+		var candidates []int
+		if e.RoomID > 0 {
+			candidates = append(candidates, e.RoomID)
+		} else {
+			rooms, err := m.DB.SearchAvailabilityForAllRooms(ctx, e.StartDate, e.EndDate)
+			if err != nil {
+				return err
+			}
+			for _, x := range rooms {
+				candidates = append(candidates, x.ID)
+			}
+		}
+
+		for _, roomID := range candidates {
+			token, err := newToken()
+			if err != nil {
+				return err
+			}
+
+			e.Status = models.WaitlistNotified
+			e.MatchedRoomID = roomID
+			e.Token = token
+			e.HoldExpiresAt = time.Now().Add(waitlistHoldDuration)
+
+			e.HoldID, err = m.DB.OfferWaitlistEntry(ctx, e)
+			if errors.Is(err, repository.ErrNotAvailable) {
+				continue
+			} else if err != nil {
+				return err
+			}
+
+			m.sendWaitlistOffer(ctx, e)
+			break
+		}
+	}
+
+	return nil
+}
+
+// sendWaitlistOffer emails a waitlisted guest the link to book the room that came free
+func (m *Repository) sendWaitlistOffer(ctx context.Context, e models.WaitlistEntry) {
+	msg, err := m.App.EmailTemplates.Message(m.App.MailFrom, e.Email, mailer.WaitlistOffer{
+		Entry: e,
+		Link:  fmt.Sprintf("%s/waitlist/%s", m.App.BaseURL, e.Token),
+	})
+	if err != nil {
+		m.App.Logger.ErrorContext(ctx, "can't build waitlist offer", "waitlist_entry_id", e.ID, "error", err)
+		return
+	}
+
+	m.queueMail(ctx, msg)
+}
+
+// triggerWaitlistMatch asks the background matcher to run, without waiting for it
+func (m *Repository) triggerWaitlistMatch() {
+	select {
+	case m.App.WaitlistChan <- struct{}{}:
+	default:
+		// a run is already pending
+	}
+}
+
+// newToken returns a random token for a link emailed to a guest, such as a waitlist offer
+func newToken() (string, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
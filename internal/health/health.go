@@ -0,0 +1,38 @@
+package health
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Heartbeat records when a background worker last went round its loop, so readiness checks can
+// tell a running worker from one that has stopped or is stuck
+type Heartbeat struct {
+	maxAge time.Duration
+	last   int64
+}
+
+// NewHeartbeat creates a heartbeat that counts as alive for maxAge after each beat
+func NewHeartbeat(maxAge time.Duration) *Heartbeat {
+	return &Heartbeat{maxAge: maxAge}
+}
+
+// Beat records that the worker is running
+func (h *Heartbeat) Beat() {
+	atomic.StoreInt64(&h.last, time.Now().UnixNano())
+}
+
+// Last returns when the worker last beat, or the zero time if it never has
+func (h *Heartbeat) Last() time.Time {
+	last := atomic.LoadInt64(&h.last)
+	if last == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, last)
+}
+
+// Alive reports whether the worker has beat within maxAge
+func (h *Heartbeat) Alive() bool {
+	last := h.Last()
+	return !last.IsZero() && time.Since(last) <= h.maxAge
+}
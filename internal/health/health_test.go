@@ -0,0 +1,23 @@
+package health
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHeartbeat(t *testing.T) {
+	h := NewHeartbeat(50 * time.Millisecond)
+	if h.Alive() {
+		t.Error("expected a heartbeat that never beat not to be alive")
+	}
+
+	h.Beat()
+	if !h.Alive() {
+		t.Error("expected the heartbeat to be alive right after a beat")
+	}
+
+	time.Sleep(80 * time.Millisecond)
+	if h.Alive() {
+		t.Error("expected the heartbeat to lapse after its max age")
+	}
+}
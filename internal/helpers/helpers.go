@@ -3,8 +3,12 @@ package helpers
 import (
 	"fmt"
 	"github.com/usmanzaheer1995/bed-and-breakfast/internal/config"
+	"github.com/usmanzaheer1995/bed-and-breakfast/internal/logging"
+	"net"
 	"net/http"
+	"net/netip"
 	"runtime/debug"
+	"strings"
 )
 
 var app *config.AppConfig
@@ -14,18 +18,66 @@ func NewHelpers(a *config.AppConfig) {
 	app = a
 }
 
-func ClientError(w http.ResponseWriter, status int) {
-	app.InfoLog.Println("Client error with status of", status)
-	http.Error(w, http.StatusText(status), status)
+func ClientError(w http.ResponseWriter, r *http.Request, status int) {
+	app.Logger.InfoContext(r.Context(), "client error", "status", status)
+	writeError(w, r, status)
 }
 
-func ServerError(w http.ResponseWriter, err error) {
-	trace := fmt.Sprintf("%s\n%s", err.Error(), debug.Stack())
-	app.ErrorLog.Println(trace)
-	http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+func ServerError(w http.ResponseWriter, r *http.Request, err error) {
+	app.Logger.ErrorContext(r.Context(), "server error", "error", err, "stack", string(debug.Stack()))
+	writeError(w, r, http.StatusInternalServerError)
+}
+
+// writeError sends a plain error page quoting the request id, so a guest reporting it can be matched to the log
+func writeError(w http.ResponseWriter, r *http.Request, status int) {
+	msg := http.StatusText(status)
+	if id := logging.RequestIDFrom(r.Context()); id != "" {
+		msg = fmt.Sprintf("%s (request id %s)", msg, id)
+	}
+	http.Error(w, msg, status)
 }
 
 func IsAuthenticated(r *http.Request) bool {
 	exists := app.Session.Exists(r.Context(), "user_id")
 	return exists
 }
+
+// ClientIP returns the address a request came from, without the port. When it came through a trusted
+// proxy, it is the last address in X-Forwarded-For that isn't another trusted proxy, since a client can
+// put anything it likes before that
+func ClientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+
+	addr, err := netip.ParseAddr(host)
+	if err != nil || !trustedProxy(addr) {
+		return host
+	}
+
+	forwarded := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		addr, err = netip.ParseAddr(strings.TrimSpace(forwarded[i]))
+		if err != nil {
+			break
+		}
+
+		host = addr.Unmap().String()
+		if !trustedProxy(addr) {
+			break
+		}
+	}
+	return host
+}
+
+// trustedProxy reports whether addr belongs to a trusted proxy
+func trustedProxy(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	for _, prefix := range app.TrustedProxies {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
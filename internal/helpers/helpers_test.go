@@ -0,0 +1,46 @@
+package helpers
+
+import (
+	"github.com/usmanzaheer1995/bed-and-breakfast/internal/config"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+)
+
+var clientIPTests = []struct {
+	name       string
+	remoteAddr string
+	forwarded  []string
+	expected   string
+}{
+	{"direct", "203.0.113.7:5000", nil, "203.0.113.7"},
+	{"untrusted proxy", "203.0.113.7:5000", []string{"198.51.100.1"}, "203.0.113.7"},
+	{"trusted proxy", "127.0.0.1:5000", []string{"198.51.100.1"}, "198.51.100.1"},
+	{"made up addresses before the proxy's", "127.0.0.1:5000", []string{"1.2.3.4, 198.51.100.1"}, "198.51.100.1"},
+	{"chain of trusted proxies", "127.0.0.1:5000", []string{"198.51.100.1, 10.0.0.5", "10.0.0.6"}, "198.51.100.1"},
+	{"garbage", "127.0.0.1:5000", []string{"198.51.100.1, nonsense"}, "127.0.0.1"},
+	{"no header", "127.0.0.1:5000", nil, "127.0.0.1"},
+	{"ipv6 proxy", "[::1]:5000", []string{"2001:db8::1"}, "2001:db8::1"},
+}
+
+func TestClientIP(t *testing.T) {
+	app = &config.AppConfig{
+		TrustedProxies: []netip.Prefix{
+			netip.MustParsePrefix("127.0.0.1/32"),
+			netip.MustParsePrefix("::1/128"),
+			netip.MustParsePrefix("10.0.0.0/8"),
+		},
+	}
+
+	for _, e := range clientIPTests {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = e.remoteAddr
+		for _, f := range e.forwarded {
+			r.Header.Add("X-Forwarded-For", f)
+		}
+
+		if ip := ClientIP(r); ip != e.expected {
+			t.Errorf("%s: expected %s, got %s", e.name, e.expected, ip)
+		}
+	}
+}
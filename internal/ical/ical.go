@@ -0,0 +1,145 @@
+package ical
+
+import (
+	"fmt"
+	"github.com/usmanzaheer1995/bed-and-breakfast/internal/models"
+	"strings"
+	"time"
+)
+
+// Methods of an iTIP calendar message (RFC 5546)
+const (
+	MethodRequest = "REQUEST"
+	MethodCancel  = "CANCEL"
+)
+
+// Property describes the bed and breakfast, for the calendar event of a stay
+type Property struct {
+	Name     string
+	Address  string
+	Email    string
+	Domain   string
+	CheckIn  string
+	CheckOut string
+	Location *time.Location
+}
+
+// Invite returns an RFC 5545 calendar for a reservation. A REQUEST adds the stay to the guest's calendar,
+// and a CANCEL with the same reservation removes it again
+func Invite(method string, res models.Reservation, p Property) ([]byte, error) {
+	start, err := atTime(res.StartDate, p.CheckIn, p.Location)
+	if err != nil {
+		return nil, fmt.Errorf("invalid check-in time: %w", err)
+	}
+	end, err := atTime(res.EndDate, p.CheckOut, p.Location)
+	if err != nil {
+		return nil, fmt.Errorf("invalid check-out time: %w", err)
+	}
+
+	// a cancellation must have a higher sequence than the invite it cancels
+	status, sequence := "CONFIRMED", 0
+	if method == MethodCancel {
+		status, sequence = "CANCELLED", 1
+	}
+
+	summary := fmt.Sprintf("Stay at %s", p.Name)
+	if res.Room.RoomName != "" {
+		summary = fmt.Sprintf("%s - %s", summary, res.Room.RoomName)
+	}
+
+	b := &builder{}
+	b.line("BEGIN:VCALENDAR")
+	b.line("VERSION:2.0")
+	b.line(fmt.Sprintf("PRODID:-//%s//Reservations//EN", escape(p.Name)))
+	b.line("CALSCALE:GREGORIAN")
+	b.line("METHOD:" + method)
+	b.line("BEGIN:VEVENT")
+	b.line(fmt.Sprintf("UID:reservation-%d@%s", res.ID, p.Domain))
+	b.line("DTSTAMP:" + formatUTC(time.Now()))
+	b.line(fmt.Sprintf("SEQUENCE:%d", sequence))
+	b.line("STATUS:" + status)
+	b.line("DTSTART:" + formatUTC(start))
+	b.line("DTEND:" + formatUTC(end))
+	b.line("SUMMARY:" + escape(summary))
+	b.line("LOCATION:" + escape(p.Address))
+	b.line("DESCRIPTION:" + escape(fmt.Sprintf("Check-in from %s on %s. Check-out by %s on %s.",
+		p.CheckIn, res.StartDate.Format("January 2"), p.CheckOut, res.EndDate.Format("January 2"))))
+	b.line(fmt.Sprintf("ORGANIZER;CN=%s:mailto:%s", quote(p.Name), p.Email))
+	b.line(fmt.Sprintf("ATTENDEE;CN=%s;ROLE=REQ-PARTICIPANT;RSVP=FALSE:mailto:%s",
+		quote(strings.TrimSpace(res.FirstName+" "+res.LastName)), res.Email))
+	b.line("TRANSP:OPAQUE")
+	b.line("END:VEVENT")
+	b.line("END:VCALENDAR")
+
+	return []byte(b.String()), nil
+}
+
+// Attachment returns a reservation's calendar as an email attachment
+func Attachment(method string, res models.Reservation, p Property) (models.MailAttachment, error) {
+	data, err := Invite(method, res, p)
+	if err != nil {
+		return models.MailAttachment{}, err
+	}
+
+	filename := "reservation.ics"
+	if method == MethodCancel {
+		filename = "cancellation.ics"
+	}
+
+	return models.MailAttachment{
+		Filename:    filename,
+		ContentType: fmt.Sprintf("text/calendar; charset=utf-8; method=%s", method),
+		Data:        data,
+	}, nil
+}
+
+// atTime returns day at the clock time hh:mm in loc
+func atTime(day time.Time, clock string, loc *time.Location) (time.Time, error) {
+	t, err := time.Parse("15:04", clock)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if loc == nil {
+		loc = time.UTC
+	}
+	return time.Date(day.Year(), day.Month(), day.Day(), t.Hour(), t.Minute(), 0, 0, loc), nil
+}
+
+// formatUTC formats a time as an RFC 5545 UTC date-time
+func formatUTC(t time.Time) string {
+	return t.UTC().Format("20060102T150405Z")
+}
+
+// escape escapes a TEXT value
+func escape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`).Replace(s)
+}
+
+// quote quotes a parameter value, which may not contain double quotes
+func quote(s string) string {
+	return `"` + strings.ReplaceAll(s, `"`, "'") + `"`
+}
+
+// builder writes content lines, folded at 75 octets and ended with CRLF as RFC 5545 requires
+type builder struct {
+	strings.Builder
+}
+
+func (b *builder) line(s string) {
+	limit := 75
+	for len(s) > limit {
+		// fold without splitting a UTF-8 sequence
+		cut := limit
+		for cut > 0 && s[cut]&0xC0 == 0x80 {
+			cut--
+		}
+		b.WriteString(s[:cut])
+		b.WriteString("\r\n ")
+		s = s[cut:]
+
+		// the space starting a continuation line counts towards its length
+		limit = 74
+	}
+	b.WriteString(s)
+	b.WriteString("\r\n")
+}
@@ -0,0 +1,134 @@
+package ical
+
+import (
+	"github.com/usmanzaheer1995/bed-and-breakfast/internal/models"
+	"strings"
+	"testing"
+	"time"
+)
+
+var property = Property{
+	Name:     "Usman's Bed and Breakfast",
+	Address:  "1 Main Street, Springfield",
+	Email:    "developer@bednbreakfast.com",
+	Domain:   "bednbreakfast.com",
+	CheckIn:  "15:00",
+	CheckOut: "11:00",
+	Location: time.UTC,
+}
+
+var reservation = models.Reservation{
+	ID:        7,
+	FirstName: "John",
+	LastName:  "Smith",
+	Email:     "john@smith.com",
+	StartDate: time.Date(2050, 1, 1, 0, 0, 0, 0, time.UTC),
+	EndDate:   time.Date(2050, 1, 3, 0, 0, 0, 0, time.UTC),
+	Room:      models.Room{RoomName: "General's Quarters"},
+}
+
+// unfold joins folded content lines back together
+func unfold(s string) string {
+	return strings.ReplaceAll(s, "\r\n ", "")
+}
+
+func TestInvite_Request(t *testing.T) {
+	data, err := Invite(MethodRequest, reservation, property)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ics := unfold(string(data))
+
+	for _, expected := range []string{
+		"BEGIN:VCALENDAR\r\n",
+		"METHOD:REQUEST\r\n",
+		"UID:reservation-7@bednbreakfast.com\r\n",
+		"STATUS:CONFIRMED\r\n",
+		"SEQUENCE:0\r\n",
+		"DTSTART:20500101T150000Z\r\n",
+		"DTEND:20500103T110000Z\r\n",
+		"LOCATION:1 Main Street\\, Springfield\r\n",
+		"SUMMARY:Stay at Usman's Bed and Breakfast - General's Quarters\r\n",
+		"mailto:john@smith.com\r\n",
+		"END:VCALENDAR\r\n",
+	} {
+		if !strings.Contains(ics, expected) {
+			t.Errorf("expected calendar to contain %q, got:\n%s", expected, ics)
+		}
+	}
+}
+
+func TestInvite_CancelMatchesRequest(t *testing.T) {
+	data, err := Invite(MethodCancel, reservation, property)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ics := unfold(string(data))
+
+	for _, expected := range []string{
+		"METHOD:CANCEL\r\n",
+		"UID:reservation-7@bednbreakfast.com\r\n",
+		"STATUS:CANCELLED\r\n",
+		"SEQUENCE:1\r\n",
+	} {
+		if !strings.Contains(ics, expected) {
+			t.Errorf("expected calendar to contain %q, got:\n%s", expected, ics)
+		}
+	}
+}
+
+func TestInvite_LocalCheckInTime(t *testing.T) {
+	p := property
+	p.Location = time.FixedZone("UTC+2", 2*60*60)
+
+	data, err := Invite(MethodRequest, reservation, p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), "DTSTART:20500101T130000Z\r\n") {
+		t.Errorf("expected check-in at 15:00 local time to be 13:00 UTC, got:\n%s", data)
+	}
+}
+
+func TestInvite_FoldsLongLines(t *testing.T) {
+	p := property
+	p.Address = strings.Repeat("Long Road ", 30)
+
+	data, err := Invite(MethodRequest, reservation, p)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, line := range strings.Split(string(data), "\r\n") {
+		if len(line) > 75 {
+			t.Errorf("line longer than 75 octets: %q", line)
+		}
+	}
+	if !strings.Contains(unfold(string(data)), "LOCATION:"+strings.Repeat("Long Road ", 30)) {
+		t.Error("expected the folded line to unfold to the full address")
+	}
+}
+
+func TestInvite_InvalidCheckInTime(t *testing.T) {
+	p := property
+	p.CheckIn = "3pm"
+
+	_, err := Invite(MethodRequest, reservation, p)
+	if err == nil {
+		t.Error("expected an error for an invalid check-in time")
+	}
+}
+
+func TestAttachment(t *testing.T) {
+	a, err := Attachment(MethodCancel, reservation, property)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if a.Filename != "cancellation.ics" {
+		t.Errorf("unexpected filename %q", a.Filename)
+	}
+	if a.ContentType != "text/calendar; charset=utf-8; method=CANCEL" {
+		t.Errorf("unexpected content type %q", a.ContentType)
+	}
+}
@@ -0,0 +1,205 @@
+package integrity
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/usmanzaheer1995/bed-and-breakfast/internal/models"
+	"github.com/usmanzaheer1995/bed-and-breakfast/internal/repository"
+	"sort"
+	"time"
+)
+
+// Kinds of issue
+const (
+	// MissingRestriction is a reservation without a room restriction, so its room shows as free
+	MissingRestriction = "missing_restriction"
+	// MismatchedRestriction is a reservation whose restriction has another room or other dates
+	MismatchedRestriction = "mismatched_restriction"
+	// DuplicateRestriction is a reservation with more than one restriction
+	DuplicateRestriction = "duplicate_restriction"
+	// OrphanedRestriction is a restriction whose reservation no longer exists
+	OrphanedRestriction = "orphaned_restriction"
+	// Overlap is two restrictions that have a room on the same night
+	Overlap = "overlap"
+)
+
+// dateLayout is how dates appear in descriptions
+const dateLayout = "2006-01-02"
+
+// ErrNotRepairable is returned when asked to repair an issue that needs a person to decide what to do
+var ErrNotRepairable = errors.New("this issue can't be repaired automatically")
+
+// Issue is a way the reservations and room restrictions disagree
+type Issue struct {
+	Kind        string
+	Description string
+	// Repair says what Repair will do, and is empty when the issue needs a person to sort it out
+	Repair        string
+	ReservationID int
+	RestrictionID int
+	// OtherRestrictionID is the second restriction of an overlap
+	OtherRestrictionID int
+	RoomID             int
+	StartDate          time.Time
+}
+
+// Key identifies the issue between one check and the next, so a repair is only made if the issue
+// is still there
+func (i Issue) Key() string {
+	return fmt.Sprintf("%s-%d-%d-%d", i.Kind, i.ReservationID, i.RestrictionID, i.OtherRestrictionID)
+}
+
+// Repairable reports whether Repair can fix the issue safely
+func (i Issue) Repairable() bool {
+	return i.Repair != ""
+}
+
+// Check loads every reservation and room restriction and returns the issues found
+func Check(ctx context.Context, db repository.DatabaseRepo) ([]Issue, error) {
+	reservations, err := db.AllReservations(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	restrictions, err := db.GetRestrictionsByDateRange(ctx, time.Time{}, time.Date(9999, 1, 1, 0, 0, 0, 0, time.UTC))
+	if err != nil {
+		return nil, err
+	}
+
+	return Find(reservations, restrictions), nil
+}
+
+// Find returns the issues among reservations and restrictions: reservations that don't block their
+// room, or block it more than once or for other dates, restrictions whose reservation is gone and
+// restrictions that overlap. The reservation is taken to be right, as it is what the guest booked
+func Find(reservations []models.Reservation, restrictions []models.RoomRestriction) []Issue {
+	var issues []Issue
+
+	byReservation := map[int][]models.RoomRestriction{}
+	for _, r := range restrictions {
+		if r.ReservationID != 0 {
+			byReservation[r.ReservationID] = append(byReservation[r.ReservationID], r)
+		}
+	}
+
+	known := map[int]bool{}
+	for _, res := range reservations {
+		known[res.ID] = true
+		issue := Issue{
+			ReservationID: res.ID,
+			RoomID:        res.RoomID,
+			StartDate:     res.StartDate,
+		}
+
+		own := byReservation[res.ID]
+		switch {
+		case len(own) == 0:
+			issue.Kind = MissingRestriction
+			issue.Description = fmt.Sprintf("Reservation %d (%s) has no room restriction, so its room shows as free",
+				res.ID, describe(res.RoomID, res.StartDate, res.EndDate))
+			issue.Repair = "Add a restriction for the reservation's room and dates"
+		case len(own) > 1:
+			issue.Kind = DuplicateRestriction
+			issue.RestrictionID = own[0].ID
+			issue.Description = fmt.Sprintf("Reservation %d (%s) has %d room restrictions",
+				res.ID, describe(res.RoomID, res.StartDate, res.EndDate), len(own))
+			issue.Repair = "Replace them with one restriction for the reservation's room and dates"
+		case own[0].RoomID != res.RoomID || !own[0].StartDate.Equal(res.StartDate) || !own[0].EndDate.Equal(res.EndDate):
+			r := own[0]
+			issue.Kind = MismatchedRestriction
+			issue.RestrictionID = r.ID
+			issue.Description = fmt.Sprintf("Reservation %d is %s, but its room restriction %d is %s",
+				res.ID, describe(res.RoomID, res.StartDate, res.EndDate), r.ID, describe(r.RoomID, r.StartDate, r.EndDate))
+			issue.Repair = "Move the restriction to the reservation's room and dates"
+		default:
+			continue
+		}
+
+		issues = append(issues, issue)
+	}
+
+	for _, r := range restrictions {
+		if r.ReservationID != 0 && !known[r.ReservationID] {
+			issues = append(issues, Issue{
+				Kind: OrphanedRestriction,
+				Description: fmt.Sprintf("Room restriction %d (%s) belongs to reservation %d, which doesn't exist",
+					r.ID, describe(r.RoomID, r.StartDate, r.EndDate), r.ReservationID),
+				Repair:        "Delete the restriction",
+				RestrictionID: r.ID,
+				RoomID:        r.RoomID,
+				StartDate:     r.StartDate,
+			})
+		}
+	}
+
+	sorted := append([]models.RoomRestriction(nil), restrictions...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if sorted[i].RoomID != sorted[j].RoomID {
+			return sorted[i].RoomID < sorted[j].RoomID
+		}
+		return sorted[i].StartDate.Before(sorted[j].StartDate)
+	})
+
+	// each restriction is compared with the one reaching furthest among those before it in its room.
+	// A reservation's own restrictions overlapping each other are reported as duplicates already
+	var latest models.RoomRestriction
+	for i, r := range sorted {
+		if i > 0 && r.RoomID == latest.RoomID && r.StartDate.Before(latest.EndDate) &&
+			(r.ReservationID == 0 || r.ReservationID != latest.ReservationID) {
+			issues = append(issues, Issue{
+				Kind: Overlap,
+				Description: fmt.Sprintf("Room restrictions %d (%s) and %d (%s) overlap", latest.ID,
+					describe(latest.RoomID, latest.StartDate, latest.EndDate), r.ID, describe(r.RoomID, r.StartDate, r.EndDate)),
+				ReservationID:      r.ReservationID,
+				RestrictionID:      latest.ID,
+				OtherRestrictionID: r.ID,
+				RoomID:             r.RoomID,
+				StartDate:          r.StartDate,
+			})
+		}
+		if i == 0 || r.RoomID != latest.RoomID || r.EndDate.After(latest.EndDate) {
+			latest = r
+		}
+	}
+
+	return issues
+}
+
+// Repair fixes an issue, returning ErrNotRepairable for issues that need a person to decide. The
+// database refuses a repair that would double book a room
+func Repair(ctx context.Context, db repository.DatabaseRepo, issue Issue) error {
+	switch issue.Kind {
+	case MissingRestriction, MismatchedRestriction, DuplicateRestriction:
+		return db.RepairReservationRestriction(ctx, issue.ReservationID)
+	case OrphanedRestriction:
+		return db.DeleteOrphanedRestriction(ctx, issue.RestrictionID)
+	default:
+		return ErrNotRepairable
+	}
+}
+
+// RepairAll repairs every repairable issue, carrying on past failures. It returns how many issues it
+// repaired and why the others failed
+func RepairAll(ctx context.Context, db repository.DatabaseRepo, issues []Issue) (int, []error) {
+	repaired := 0
+	var errs []error
+	for _, issue := range issues {
+		if !issue.Repairable() {
+			continue
+		}
+
+		err := Repair(ctx, db, issue)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", issue.Description, err))
+			continue
+		}
+		repaired++
+	}
+	return repaired, errs
+}
+
+// describe formats a room and dates for an issue's description
+func describe(roomID int, start, end time.Time) string {
+	return fmt.Sprintf("room %d from %s to %s", roomID, start.Format(dateLayout), end.Format(dateLayout))
+}
@@ -0,0 +1,107 @@
+package integrity
+
+import (
+	"context"
+	"errors"
+	"github.com/usmanzaheer1995/bed-and-breakfast/internal/config"
+	"github.com/usmanzaheer1995/bed-and-breakfast/internal/models"
+	"github.com/usmanzaheer1995/bed-and-breakfast/internal/repository"
+	"github.com/usmanzaheer1995/bed-and-breakfast/internal/repository/dbrepo"
+	"strings"
+	"testing"
+	"time"
+)
+
+func day(d int) time.Time {
+	return time.Date(2050, 1, d, 0, 0, 0, 0, time.UTC)
+}
+
+func TestFind(t *testing.T) {
+	reservations := []models.Reservation{
+		{ID: 1, RoomID: 1, StartDate: day(1), EndDate: day(3)},
+		{ID: 2, RoomID: 1, StartDate: day(5), EndDate: day(7)},
+		{ID: 3, RoomID: 2, StartDate: day(1), EndDate: day(4)},
+		{ID: 4, RoomID: 3, StartDate: day(1), EndDate: day(2)},
+	}
+	restrictions := []models.RoomRestriction{
+		{ID: 10, ReservationID: 1, RoomID: 1, StartDate: day(1), EndDate: day(3)},
+		{ID: 11, ReservationID: 3, RoomID: 2, StartDate: day(1), EndDate: day(2)},
+		{ID: 12, ReservationID: 9, RoomID: 2, StartDate: day(10), EndDate: day(11)},
+		{ID: 13, RestrictionID: models.RestrictionOwnerBlock, RoomID: 1, StartDate: day(2), EndDate: day(3)},
+		{ID: 14, ReservationID: 4, RoomID: 3, StartDate: day(1), EndDate: day(2)},
+		{ID: 15, ReservationID: 4, RoomID: 3, StartDate: day(1), EndDate: day(2)},
+	}
+
+	issues := Find(reservations, restrictions)
+
+	expected := []struct {
+		kind        string
+		description string
+		repairable  bool
+	}{
+		{MissingRestriction, "Reservation 2 (room 1 from 2050-01-05 to 2050-01-07) has no room restriction", true},
+		{MismatchedRestriction, "Reservation 3 is room 2 from 2050-01-01 to 2050-01-04, but its room restriction 11 is room 2 from 2050-01-01 to 2050-01-02", true},
+		{DuplicateRestriction, "Reservation 4 (room 3 from 2050-01-01 to 2050-01-02) has 2 room restrictions", true},
+		{OrphanedRestriction, "Room restriction 12 (room 2 from 2050-01-10 to 2050-01-11) belongs to reservation 9, which doesn't exist", true},
+		{Overlap, "Room restrictions 10 (room 1 from 2050-01-01 to 2050-01-03) and 13 (room 1 from 2050-01-02 to 2050-01-03) overlap", false},
+	}
+	if len(issues) != len(expected) {
+		t.Fatalf("expected %d issues, got %d: %+v", len(expected), len(issues), issues)
+	}
+	for i, e := range expected {
+		if issues[i].Kind != e.kind || !strings.HasPrefix(issues[i].Description, e.description) || issues[i].Repairable() != e.repairable {
+			t.Errorf("expected %s %q (repairable %t), got %s %q (repairable %t)", e.kind, e.description, e.repairable,
+				issues[i].Kind, issues[i].Description, issues[i].Repairable())
+		}
+	}
+}
+
+func TestFind_None(t *testing.T) {
+	reservations := []models.Reservation{{ID: 1, RoomID: 1, StartDate: day(1), EndDate: day(3)}}
+	restrictions := []models.RoomRestriction{
+		{ID: 10, ReservationID: 1, RoomID: 1, StartDate: day(1), EndDate: day(3)},
+		{ID: 11, RestrictionID: models.RestrictionOwnerBlock, RoomID: 1, StartDate: day(3), EndDate: day(4)},
+		{ID: 12, RestrictionID: models.RestrictionOwnerBlock, RoomID: 2, StartDate: day(1), EndDate: day(4)},
+	}
+
+	if issues := Find(reservations, restrictions); len(issues) != 0 {
+		t.Errorf("expected no issues, got %+v", issues)
+	}
+}
+
+var repairTests = []struct {
+	name          string
+	issue         Issue
+	expectedError error
+}{
+	{"missing restriction", Issue{Kind: MissingRestriction, Repair: "add", ReservationID: 1}, nil},
+	{"room taken", Issue{Kind: MismatchedRestriction, Repair: "move", ReservationID: 1000}, repository.ErrNotAvailable},
+	{"orphan", Issue{Kind: OrphanedRestriction, Repair: "delete", RestrictionID: 1}, nil},
+	{"orphan no more", Issue{Kind: OrphanedRestriction, Repair: "delete", RestrictionID: 1000}, repository.ErrConflict},
+	{"overlap", Issue{Kind: Overlap, RestrictionID: 1, OtherRestrictionID: 2}, ErrNotRepairable},
+}
+
+func TestRepair(t *testing.T) {
+	db := dbrepo.NewTestingRepo(&config.AppConfig{})
+
+	for _, e := range repairTests {
+		err := Repair(context.Background(), db, e.issue)
+		if !errors.Is(err, e.expectedError) {
+			t.Errorf("%s: expected %v, got %v", e.name, e.expectedError, err)
+		}
+	}
+}
+
+func TestRepairAll(t *testing.T) {
+	db := dbrepo.NewTestingRepo(&config.AppConfig{})
+
+	var issues []Issue
+	for _, e := range repairTests {
+		issues = append(issues, e.issue)
+	}
+
+	repaired, errs := RepairAll(context.Background(), db, issues)
+	if repaired != 2 || len(errs) != 2 {
+		t.Errorf("expected 2 repairs and 2 failures, got %d and %v", repaired, errs)
+	}
+}
@@ -0,0 +1,137 @@
+package logging
+
+import (
+	"context"
+	"fmt"
+	"go.opentelemetry.io/otel/trace"
+	"io"
+	"log/slog"
+	"regexp"
+	"strings"
+)
+
+// Log formats accepted by New
+const (
+	FormatJSON = "json"
+	FormatText = "text"
+)
+
+// piiKeys are the attribute keys whose values identify a guest, and are always masked
+var piiKeys = map[string]bool{
+	"email":     true,
+	"phone":     true,
+	"to":        true,
+	"recipient": true,
+}
+
+// emailPattern finds email addresses inside messages and errors, so they can be masked wherever they appear
+var emailPattern = regexp.MustCompile(`[A-Za-z0-9._%+\-]+@[A-Za-z0-9.\-]+\.[A-Za-z]{2,}`)
+
+// New creates a logger writing records at level and above to w, as JSON or as logfmt text. Each record
+// carries the request id from its context, and guest email addresses and phone numbers are masked
+func New(w io.Writer, format string, level slog.Level) *slog.Logger {
+	opts := &slog.HandlerOptions{
+		Level:       level,
+		ReplaceAttr: redact,
+	}
+
+	var h slog.Handler
+	if format == FormatJSON {
+		h = slog.NewJSONHandler(w, opts)
+	} else {
+		h = slog.NewTextHandler(w, opts)
+	}
+
+	return slog.New(contextHandler{h})
+}
+
+// ParseLevel turns debug, info, warn or error into a level
+func ParseLevel(s string) (slog.Level, error) {
+	var level slog.Level
+	err := level.UnmarshalText([]byte(s))
+	if err != nil {
+		return level, fmt.Errorf("unknown log level %q", s)
+	}
+	return level, nil
+}
+
+// contextHandler adds the request and trace ids of the record's context to every record
+type contextHandler struct {
+	slog.Handler
+}
+
+// Handle adds the request id and trace id, when there are any, and passes the record on
+func (h contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	if id := RequestIDFrom(ctx); id != "" {
+		r.AddAttrs(slog.String("request_id", id))
+	}
+	if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+		r.AddAttrs(slog.String("trace_id", sc.TraceID().String()))
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+// WithAttrs keeps the request id handling on loggers made with With
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+// WithGroup keeps the request id handling on loggers made with WithGroup
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
+
+// redact masks guest details: the values of PII keys, and email addresses inside any text
+func redact(groups []string, a slog.Attr) slog.Attr {
+	if piiKeys[a.Key] {
+		if a.Key == "phone" {
+			return slog.String(a.Key, MaskPhone(a.Value.String()))
+		}
+		masked := MaskEmails(a.Value.String())
+		if masked == a.Value.String() && masked != "" {
+			// not an address we recognise, so hide all of it
+			masked = "***"
+		}
+		return slog.String(a.Key, masked)
+	}
+
+	switch a.Value.Kind() {
+	case slog.KindString:
+		return slog.String(a.Key, MaskEmails(a.Value.String()))
+	case slog.KindAny:
+		if err, ok := a.Value.Any().(error); ok {
+			return slog.String(a.Key, MaskEmails(err.Error()))
+		}
+	}
+	return a
+}
+
+// MaskEmails replaces every email address in s with one showing only the first letter and the domain
+func MaskEmails(s string) string {
+	return emailPattern.ReplaceAllStringFunc(s, func(email string) string {
+		at := strings.LastIndex(email, "@")
+		return email[:1] + "***" + email[at:]
+	})
+}
+
+// MaskPhone hides all but the last two digits of a phone number
+func MaskPhone(s string) string {
+	digits := 0
+	for _, c := range s {
+		if c >= '0' && c <= '9' {
+			digits++
+		}
+	}
+
+	var b strings.Builder
+	for _, c := range s {
+		if c >= '0' && c <= '9' {
+			digits--
+			if digits >= 2 {
+				c = '*'
+			}
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
@@ -0,0 +1,101 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+var maskEmailsTests = []struct {
+	name     string
+	text     string
+	expected string
+}{
+	{"address", "john@smith.com", "j***@smith.com"},
+	{"in a sentence", "550 mailbox jane.doe@mail.example.org unavailable", "550 mailbox j***@mail.example.org unavailable"},
+	{"several", "to a@b.com, cc@d.co.uk", "to a***@b.com, c***@d.co.uk"},
+	{"none", "nothing to hide", "nothing to hide"},
+}
+
+func TestMaskEmails(t *testing.T) {
+	for _, e := range maskEmailsTests {
+		if got := MaskEmails(e.text); got != e.expected {
+			t.Errorf("%s: expected %q, got %q", e.name, e.expected, got)
+		}
+	}
+}
+
+func TestMaskPhone(t *testing.T) {
+	if got := MaskPhone("+44 (0)20 7946 0958"); got != "+** (*)** **** **58" {
+		t.Errorf("expected all but the last two digits masked, got %q", got)
+	}
+}
+
+func TestNew_RedactsPII(t *testing.T) {
+	var buf bytes.Buffer
+	logger := New(&buf, FormatJSON, slog.LevelInfo)
+
+	logger.Info("booking from john@smith.com",
+		"email", "john@smith.com",
+		"phone", "555-123456",
+		"to", "someone",
+		"error", errors.New("rejected jane@doe.com"),
+		"room_id", 1,
+	)
+
+	var record map[string]interface{}
+	err := json.Unmarshal(buf.Bytes(), &record)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]interface{}{
+		"msg":     "booking from j***@smith.com",
+		"email":   "j***@smith.com",
+		"phone":   "***-****56",
+		"to":      "***",
+		"error":   "rejected j***@doe.com",
+		"room_id": float64(1),
+	}
+	for key, value := range expected {
+		if record[key] != value {
+			t.Errorf("expected %s to be %v, got %v", key, value, record[key])
+		}
+	}
+
+	if strings.Contains(buf.String(), "john@smith.com") || strings.Contains(buf.String(), "123456") {
+		t.Errorf("expected no guest details in the log, got %s", buf.String())
+	}
+}
+
+func TestNew_AddsRequestID(t *testing.T) {
+	var buf bytes.Buffer
+	logger := New(&buf, FormatText, slog.LevelInfo).With("component", "test")
+
+	logger.InfoContext(WithRequestID(context.Background(), "abc123"), "hello")
+	if !strings.Contains(buf.String(), "request_id=abc123") || !strings.Contains(buf.String(), "component=test") {
+		t.Errorf("expected the request id in a logfmt line, got %q", buf.String())
+	}
+
+	buf.Reset()
+	logger.Info("no request")
+	if strings.Contains(buf.String(), "request_id") {
+		t.Errorf("expected no request id outside a request, got %q", buf.String())
+	}
+}
+
+func TestParseLevel(t *testing.T) {
+	level, err := ParseLevel("warn")
+	if err != nil || level != slog.LevelWarn {
+		t.Errorf("expected warn, got %v, %v", level, err)
+	}
+
+	_, err = ParseLevel("loud")
+	if err == nil {
+		t.Error("expected an error for an unknown level")
+	}
+}
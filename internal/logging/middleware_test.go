@@ -0,0 +1,87 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/go-chi/chi/v5"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRequestID(t *testing.T) {
+	var seen string
+	handler := RequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = RequestIDFrom(r.Context())
+	}))
+
+	// an id from the proxy is kept
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set(RequestIDHeader, "proxy-id.1")
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	if seen != "proxy-id.1" || rr.Header().Get(RequestIDHeader) != "proxy-id.1" {
+		t.Errorf("expected the proxy's request id to be used, got %q and %q", seen, rr.Header().Get(RequestIDHeader))
+	}
+
+	// anything else is replaced
+	req = httptest.NewRequest("GET", "/", nil)
+	req.Header.Set(RequestIDHeader, "bad id\nlevel=ERROR")
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	if seen == "" || strings.Contains(seen, " ") || rr.Header().Get(RequestIDHeader) != seen {
+		t.Errorf("expected a new request id, got %q", seen)
+	}
+}
+
+func TestAccessLog(t *testing.T) {
+	var buf bytes.Buffer
+	logger := New(&buf, FormatJSON, slog.LevelInfo)
+
+	mux := chi.NewRouter()
+	mux.Use(RequestID)
+	mux.Use(AccessLog(logger))
+	mux.Get("/rooms/{id}", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+		w.Write([]byte("short and stout"))
+	})
+	mux.Get("/healthz", func(w http.ResponseWriter, r *http.Request) {})
+
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, httptest.NewRequest("GET", "/rooms/7", nil))
+
+	var record map[string]interface{}
+	err := json.Unmarshal(buf.Bytes(), &record)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]interface{}{
+		"msg":        "request",
+		"method":     "GET",
+		"path":       "/rooms/7",
+		"route":      "/rooms/{id}",
+		"status":     float64(http.StatusTeapot),
+		"bytes":      float64(len("short and stout")),
+		"request_id": rr.Header().Get(RequestIDHeader),
+	}
+	for key, value := range expected {
+		if record[key] != value {
+			t.Errorf("expected %s to be %v, got %v", key, value, record[key])
+		}
+	}
+	if _, ok := record["duration"]; !ok {
+		t.Error("expected the duration to be logged")
+	}
+
+	// health checks are only logged at debug level
+	buf.Reset()
+	mux.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/healthz", nil))
+	if buf.Len() != 0 {
+		t.Errorf("expected health checks not to be logged at info level, got %s", buf.String())
+	}
+}
@@ -0,0 +1,54 @@
+package mailer
+
+import (
+	"fmt"
+	"github.com/usmanzaheer1995/bed-and-breakfast/internal/models"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+)
+
+// FileMailer writes email to a maildir instead of sending it, for development
+type FileMailer struct {
+	Dir string
+}
+
+// deliveries makes maildir file names unique within this process
+var deliveries int64
+
+// NewFileMailer returns a mailer writing to the maildir at dir, creating it if needed
+func NewFileMailer(dir string) (*FileMailer, error) {
+	for _, sub := range []string{"tmp", "new", "cur"} {
+		err := os.MkdirAll(filepath.Join(dir, sub), 0755)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &FileMailer{Dir: dir}, nil
+}
+
+// Send writes msg to the maildir's new directory. As maildir requires, the message is written to tmp
+// first and then moved, so a mail reader never sees half a message
+func (m *FileMailer) Send(msg models.MailData) error {
+	email, err := newEmail(msg)
+	if err != nil {
+		return err
+	}
+
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "localhost"
+	}
+	name := fmt.Sprintf("%d.%d_%d.%s", time.Now().Unix(), os.Getpid(), atomic.AddInt64(&deliveries, 1), hostname)
+
+	tmp := filepath.Join(m.Dir, "tmp", name)
+	err = ioutil.WriteFile(tmp, []byte(email.GetMessage()), 0644)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, filepath.Join(m.Dir, "new", name))
+}
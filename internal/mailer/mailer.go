@@ -0,0 +1,48 @@
+package mailer
+
+import (
+	"fmt"
+	"github.com/usmanzaheer1995/bed-and-breakfast/internal/models"
+	mail "github.com/xhit/go-simple-mail/v2"
+)
+
+// Mailer sends email
+type Mailer interface {
+	Send(msg models.MailData) error
+}
+
+// TemplateDir is the directory holding the email templates and the layout that messages are wrapped in
+var TemplateDir = "./email-templates"
+
+// newEmail builds the email for a message, wrapping its content in the message's template if it has one
+func newEmail(msg models.MailData) (*mail.Email, error) {
+	email := mail.NewMSG()
+	email.
+		SetFrom(msg.From).
+		AddTo(msg.To).
+		SetSubject(msg.Subject)
+
+	if msg.Text != "" {
+		// multipart/alternative, so clients without HTML still get a readable message
+		email.SetBody(mail.TextPlain, msg.Text)
+		email.AddAlternative(mail.TextHTML, msg.Content)
+	} else if msg.Template == "" {
+		email.SetBody(mail.TextHTML, msg.Content)
+	} else {
+		// messages queued before templates were rendered up front name a layout to wrap them in
+		html, err := wrapInLayout(msg.Template, msg.Content)
+		if err != nil {
+			return nil, err
+		}
+		email.SetBody(mail.TextHTML, html)
+	}
+
+	for _, a := range msg.Attachments {
+		email.AddAttachmentData(a.Data, a.Filename, a.ContentType)
+	}
+
+	if email.Error != nil {
+		return nil, fmt.Errorf("can't build email to %s: %w", msg.To, email.Error)
+	}
+	return email, nil
+}
@@ -0,0 +1,125 @@
+package mailer
+
+import (
+	"github.com/usmanzaheer1995/bed-and-breakfast/internal/models"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFileMailer_Send(t *testing.T) {
+	TemplateDir = "./../../email-templates"
+
+	dir := t.TempDir()
+	m, err := NewFileMailer(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = m.Send(models.MailData{
+		To:       "john@smith.com",
+		From:     "me@here.com",
+		Subject:  "Reservation Confirmation",
+		Content:  "<strong>Hello</strong>",
+		Template: "basic.html",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files, _ := ioutil.ReadDir(filepath.Join(dir, "new"))
+	if len(files) != 1 {
+		t.Fatalf("expected one message in new, got %d", len(files))
+	}
+
+	data, _ := ioutil.ReadFile(filepath.Join(dir, "new", files[0].Name()))
+	if !strings.Contains(string(data), "Subject: Reservation Confirmation") {
+		t.Error("expected message to have the subject header")
+	}
+
+	files, _ = ioutil.ReadDir(filepath.Join(dir, "tmp"))
+	if len(files) != 0 {
+		t.Error("expected tmp to be empty after delivery")
+	}
+}
+
+func TestFileMailer_SendAttachment(t *testing.T) {
+	dir := t.TempDir()
+	m, err := NewFileMailer(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = m.Send(models.MailData{
+		To:      "john@smith.com",
+		From:    "me@here.com",
+		Subject: "Reservation Confirmation",
+		Content: "<strong>Hello</strong>",
+		Text:    "Hello",
+		Attachments: []models.MailAttachment{
+			{Filename: "reservation.ics", ContentType: "text/calendar; charset=utf-8; method=REQUEST", Data: []byte("BEGIN:VCALENDAR")},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files, _ := ioutil.ReadDir(filepath.Join(dir, "new"))
+	if len(files) != 1 {
+		t.Fatalf("expected one message in new, got %d", len(files))
+	}
+
+	data, _ := ioutil.ReadFile(filepath.Join(dir, "new", files[0].Name()))
+	if !strings.Contains(string(data), "text/calendar; charset=utf-8; method=REQUEST") {
+		t.Error("expected message to have the calendar attachment")
+	}
+	if !strings.Contains(string(data), "reservation.ics") {
+		t.Error("expected the attachment to keep its filename")
+	}
+}
+
+func TestFileMailer_SendMissingTemplate(t *testing.T) {
+	TemplateDir = "./../../email-templates"
+
+	m, err := NewFileMailer(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = m.Send(models.MailData{To: "john@smith.com", From: "me@here.com", Template: "missing.html"})
+	if err == nil {
+		t.Error("expected an error for a missing template")
+	}
+}
+
+func TestNewSMTPMailer(t *testing.T) {
+	for _, encryption := range []string{"", "none", "starttls", "tls"} {
+		_, err := NewSMTPMailer("localhost", 1025, "", "", encryption)
+		if err != nil {
+			t.Errorf("expected %q to be accepted, got %s", encryption, err)
+		}
+	}
+
+	_, err := NewSMTPMailer("localhost", 1025, "", "", "ssl3")
+	if err == nil {
+		t.Error("expected unknown encryption to be rejected")
+	}
+}
+
+func TestRecorder(t *testing.T) {
+	r := NewRecorder()
+
+	_ = r.Send(models.MailData{To: "john@smith.com"})
+	_ = r.Send(models.MailData{To: "me@here.com"})
+
+	messages := r.Messages()
+	if len(messages) != 2 || messages[0].To != "john@smith.com" || messages[1].To != "me@here.com" {
+		t.Errorf("expected both messages in order, got %v", messages)
+	}
+
+	r.Reset()
+	if len(r.Messages()) != 0 {
+		t.Error("expected no messages after reset")
+	}
+}
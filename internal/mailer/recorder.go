@@ -0,0 +1,44 @@
+package mailer
+
+import (
+	"github.com/usmanzaheer1995/bed-and-breakfast/internal/models"
+	"sync"
+)
+
+// Recorder keeps email in memory instead of sending it, so tests can check what was sent
+type Recorder struct {
+	mu       sync.Mutex
+	messages []models.MailData
+}
+
+// NewRecorder returns an empty recorder
+func NewRecorder() *Recorder {
+	return &Recorder{}
+}
+
+// Send records msg
+func (r *Recorder) Send(msg models.MailData) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.messages = append(r.messages, msg)
+	return nil
+}
+
+// Messages returns the messages sent so far, oldest first
+func (r *Recorder) Messages() []models.MailData {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	messages := make([]models.MailData, len(r.messages))
+	copy(messages, r.messages)
+	return messages
+}
+
+// Reset forgets the messages sent so far
+func (r *Recorder) Reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.messages = nil
+}
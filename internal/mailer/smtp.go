@@ -0,0 +1,69 @@
+package mailer
+
+import (
+	"fmt"
+	"github.com/usmanzaheer1995/bed-and-breakfast/internal/models"
+	mail "github.com/xhit/go-simple-mail/v2"
+	"time"
+)
+
+// SMTPMailer sends email through an SMTP server
+type SMTPMailer struct {
+	Host       string
+	Port       int
+	Username   string
+	Password   string
+	encryption mail.Encryption
+}
+
+// NewSMTPMailer returns a mailer for the SMTP server at host and port. Encryption is one of
+// "none", "starttls" or "tls"; the username and password are only used if a username is given
+func NewSMTPMailer(host string, port int, username, password, encryption string) (*SMTPMailer, error) {
+	m := &SMTPMailer{
+		Host:     host,
+		Port:     port,
+		Username: username,
+		Password: password,
+	}
+
+	switch encryption {
+	case "", "none":
+		m.encryption = mail.EncryptionNone
+	case "starttls":
+		m.encryption = mail.EncryptionSTARTTLS
+	case "tls":
+		m.encryption = mail.EncryptionSSLTLS
+	default:
+		return nil, fmt.Errorf("unknown smtp encryption %q, want none, starttls or tls", encryption)
+	}
+
+	return m, nil
+}
+
+// Send delivers msg to the SMTP server
+func (m *SMTPMailer) Send(msg models.MailData) error {
+	email, err := newEmail(msg)
+	if err != nil {
+		return err
+	}
+
+	server := mail.NewSMTPClient()
+	server.Host = m.Host
+	server.Port = m.Port
+	server.Encryption = m.encryption
+	if m.Username != "" {
+		server.Username = m.Username
+		server.Password = m.Password
+		server.Authentication = mail.AuthPlain
+	}
+	server.KeepAlive = false
+	server.ConnectTimeout = 10 * time.Second
+	server.SendTimeout = 10 * time.Second
+
+	client, err := server.Connect()
+	if err != nil {
+		return err
+	}
+
+	return email.Send(client)
+}
@@ -0,0 +1,349 @@
+package mailer
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/usmanzaheer1995/bed-and-breakfast/internal/models"
+	htmltemplate "html/template"
+	"path/filepath"
+	"sort"
+	"strings"
+	texttemplate "text/template"
+	"time"
+)
+
+// Email is the data for one kind of email. Its template name picks <name>.html.tmpl and <name>.txt.tmpl
+// from the template directory
+type Email interface {
+	TemplateName() string
+}
+
+// Confirmation confirms a reservation to the guest
+type Confirmation struct {
+	Reservation models.Reservation
+}
+
+// TemplateName returns the name of the confirmation templates
+func (Confirmation) TemplateName() string { return "confirmation" }
+
+// ReservationVerification asks the guest to confirm their email address by following Link before
+// ExpiresAt, which turns their request into a reservation
+type ReservationVerification struct {
+	Reservation models.Reservation
+	Link        string
+	ExpiresAt   time.Time
+}
+
+// TemplateName returns the name of the reservation verification templates
+func (ReservationVerification) TemplateName() string { return "verify-reservation" }
+
+// OwnerNotification tells the property owner about a new reservation
+type OwnerNotification struct {
+	Reservation models.Reservation
+}
+
+// TemplateName returns the name of the owner notification templates
+func (OwnerNotification) TemplateName() string { return "owner-notification" }
+
+// Cancellation tells the guest their reservation was cancelled
+type Cancellation struct {
+	Reservation models.Reservation
+}
+
+// TemplateName returns the name of the cancellation templates
+func (Cancellation) TemplateName() string { return "cancellation" }
+
+// Reminder reminds the guest of their upcoming stay
+type Reminder struct {
+	Reservation models.Reservation
+}
+
+// TemplateName returns the name of the reminder templates
+func (Reminder) TemplateName() string { return "reminder" }
+
+// WaitlistOffer offers a room that came free to a waitlisted guest
+type WaitlistOffer struct {
+	Entry models.WaitlistEntry
+	Link  string
+}
+
+// TemplateName returns the name of the waitlist offer templates
+func (WaitlistOffer) TemplateName() string { return "waitlist-offer" }
+
+// StaffNotification tells staff about a change to the bookings
+type StaffNotification struct {
+	Title   string
+	Summary string
+}
+
+// TemplateName returns the name of the staff notification templates
+func (StaffNotification) TemplateName() string { return "staff-notification" }
+
+// Digest gathers a day's staff notifications into one email
+type Digest struct {
+	Date  time.Time
+	Items []models.DigestItem
+}
+
+// TemplateName returns the name of the digest templates
+func (Digest) TemplateName() string { return "digest" }
+
+// Scheduled is the data for a scheduled email, whose templates are edited by staff and kept in the database
+type Scheduled struct {
+	Reservation models.Reservation
+}
+
+// Templates holds the parsed email templates
+type Templates struct {
+	html   map[string]*htmltemplate.Template
+	text   map[string]*texttemplate.Template
+	layout *htmltemplate.Template
+}
+
+var templateFunctions = map[string]interface{}{
+	"formatDate": func(t time.Time, f string) string {
+		return t.Format(f)
+	},
+}
+
+// LoadTemplates parses every email template in dir. Each HTML template is rendered inside layout.html.tmpl,
+// and each text template defines both the subject and the plain text body
+func LoadTemplates(dir string) (*Templates, error) {
+	t := &Templates{
+		html: map[string]*htmltemplate.Template{},
+		text: map[string]*texttemplate.Template{},
+	}
+
+	pages, err := filepath.Glob(filepath.Join(dir, "*.txt.tmpl"))
+	if err != nil {
+		return nil, err
+	}
+
+	t.layout, err = htmltemplate.New("layout.html.tmpl").Funcs(templateFunctions).
+		ParseFiles(filepath.Join(dir, "layout.html.tmpl"))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, page := range pages {
+		name := strings.TrimSuffix(filepath.Base(page), ".txt.tmpl")
+
+		text, err := texttemplate.New(filepath.Base(page)).Funcs(templateFunctions).ParseFiles(page)
+		if err != nil {
+			return nil, err
+		}
+
+		html, err := htmltemplate.New("layout.html.tmpl").Funcs(templateFunctions).
+			ParseFiles(filepath.Join(dir, "layout.html.tmpl"), filepath.Join(dir, name+".html.tmpl"))
+		if err != nil {
+			return nil, err
+		}
+
+		t.text[name] = text
+		t.html[name] = html
+	}
+
+	return t, nil
+}
+
+// legacyLayouts maps the layouts named by messages queued before templates were rendered up front
+// to the layout that replaced them
+var legacyLayouts = map[string]string{
+	"basic.html": "layout.html.tmpl",
+}
+
+// wrapInLayout wraps the already rendered HTML content of a legacy message in the layout it names
+func wrapInLayout(name, content string) (string, error) {
+	layout, ok := legacyLayouts[name]
+	if !ok {
+		return "", fmt.Errorf("no email layout named %s", name)
+	}
+
+	t, err := htmltemplate.New(layout).Funcs(templateFunctions).ParseFiles(filepath.Join(TemplateDir, layout))
+	if err != nil {
+		return "", err
+	}
+	_, err = t.New("content").Parse(`{{.}}`)
+	if err != nil {
+		return "", err
+	}
+
+	buf := new(bytes.Buffer)
+	err = t.Execute(buf, htmltemplate.HTML(content))
+	if err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// Names returns the names of the loaded templates
+func (t *Templates) Names() []string {
+	var names []string
+	for name := range t.text {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Render renders the subject, HTML body and plain text body of an email
+func (t *Templates) Render(e Email) (subject, html, text string, err error) {
+	name := e.TemplateName()
+
+	textTmpl, ok := t.text[name]
+	if !ok {
+		return "", "", "", fmt.Errorf("no email template named %s", name)
+	}
+
+	buf := new(bytes.Buffer)
+	err = textTmpl.ExecuteTemplate(buf, "subject", e)
+	if err != nil {
+		return "", "", "", err
+	}
+	subject = strings.TrimSpace(buf.String())
+
+	buf.Reset()
+	err = textTmpl.ExecuteTemplate(buf, "body", e)
+	if err != nil {
+		return "", "", "", err
+	}
+	text = buf.String()
+
+	buf.Reset()
+	err = t.html[name].Execute(buf, e)
+	if err != nil {
+		return "", "", "", err
+	}
+	html = buf.String()
+
+	return subject, html, text, nil
+}
+
+// Message renders an email into a message ready to queue
+func (t *Templates) Message(from, to string, e Email) (models.MailData, error) {
+	subject, html, text, err := t.Render(e)
+	if err != nil {
+		return models.MailData{}, err
+	}
+
+	return models.MailData{
+		To:      to,
+		From:    from,
+		Subject: subject,
+		Content: html,
+		Text:    text,
+	}, nil
+}
+
+// RenderScheduled renders the subject, HTML body and plain text body of a scheduled email for a reservation.
+// The HTML body is rendered inside the same layout as the other emails
+func (t *Templates) RenderScheduled(e models.ScheduledEmail, res models.Reservation) (subject, html, text string, err error) {
+	data := Scheduled{Reservation: res}
+	buf := new(bytes.Buffer)
+
+	subjectTmpl, err := texttemplate.New("subject").Funcs(templateFunctions).Parse(e.Subject)
+	if err != nil {
+		return "", "", "", err
+	}
+	err = subjectTmpl.Execute(buf, data)
+	if err != nil {
+		return "", "", "", err
+	}
+	subject = strings.TrimSpace(buf.String())
+
+	textTmpl, err := texttemplate.New("body").Funcs(templateFunctions).Parse(e.TextBody)
+	if err != nil {
+		return "", "", "", err
+	}
+	buf.Reset()
+	err = textTmpl.Execute(buf, data)
+	if err != nil {
+		return "", "", "", err
+	}
+	text = buf.String()
+
+	htmlTmpl, err := t.layout.Clone()
+	if err != nil {
+		return "", "", "", err
+	}
+	_, err = htmlTmpl.New("content").Parse(e.HTMLBody)
+	if err != nil {
+		return "", "", "", err
+	}
+	buf.Reset()
+	err = htmlTmpl.Execute(buf, data)
+	if err != nil {
+		return "", "", "", err
+	}
+	html = buf.String()
+
+	return subject, html, text, nil
+}
+
+// ScheduledMessage renders a scheduled email for a reservation into a message ready to queue
+func (t *Templates) ScheduledMessage(from string, e models.ScheduledEmail, res models.Reservation) (models.MailData, error) {
+	subject, html, text, err := t.RenderScheduled(e, res)
+	if err != nil {
+		return models.MailData{}, err
+	}
+
+	return models.MailData{
+		To:      res.Email,
+		From:    from,
+		Subject: subject,
+		Content: html,
+		Text:    text,
+	}, nil
+}
+
+// SampleReservation returns a reservation to preview email templates with
+func SampleReservation() models.Reservation {
+	return models.Reservation{
+		ID:        1,
+		FirstName: "John",
+		LastName:  "Smith",
+		Email:     "john@smith.com",
+		Phone:     "555-555-5555",
+		StartDate: time.Now().AddDate(0, 0, 14),
+		EndDate:   time.Now().AddDate(0, 0, 17),
+		RoomID:    1,
+		Room:      models.Room{ID: 1, RoomName: "General's Quarters"},
+	}
+}
+
+// Samples returns an example of every kind of email, for previewing templates
+func Samples() []Email {
+	res := SampleReservation()
+
+	return []Email{
+		Confirmation{Reservation: res},
+		ReservationVerification{
+			Reservation: res,
+			Link:        "http://localhost:8080/confirm-reservation/example",
+			ExpiresAt:   time.Now().Add(time.Hour),
+		},
+		OwnerNotification{Reservation: res},
+		Cancellation{Reservation: res},
+		Reminder{Reservation: res},
+		WaitlistOffer{
+			Entry: models.WaitlistEntry{
+				Email:         res.Email,
+				StartDate:     res.StartDate,
+				EndDate:       res.EndDate,
+				HoldExpiresAt: time.Now().Add(24 * time.Hour),
+			},
+			Link: "http://localhost:8080/waitlist/example",
+		},
+		StaffNotification{
+			Title:   "Reservation cancelled",
+			Summary: "The reservation of General's Quarters by John Smith has been cancelled",
+		},
+		Digest{
+			Date: time.Now(),
+			Items: []models.DigestItem{
+				{Summary: "New booking of General's Quarters by John Smith"},
+				{Summary: "Reservation of Major's Suite by Jane Doe was moved"},
+			},
+		},
+	}
+}
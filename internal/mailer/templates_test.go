@@ -0,0 +1,120 @@
+package mailer
+
+import (
+	"github.com/usmanzaheer1995/bed-and-breakfast/internal/models"
+	"strings"
+	"testing"
+)
+
+func TestTemplates_RenderSamples(t *testing.T) {
+	templates, err := LoadTemplates("./../../email-templates")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, sample := range Samples() {
+		subject, html, text, err := templates.Render(sample)
+		if err != nil {
+			t.Errorf("%s: %s", sample.TemplateName(), err)
+			continue
+		}
+		if subject == "" || html == "" || text == "" {
+			t.Errorf("%s: expected a subject, an HTML body and a text body", sample.TemplateName())
+		}
+	}
+}
+
+func TestTemplates_EscapesGuestData(t *testing.T) {
+	templates, err := LoadTemplates("./../../email-templates")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg, err := templates.Message("me@here.com", "john@smith.com", Confirmation{
+		Reservation: models.Reservation{FirstName: "<script>alert(1)</script>"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.Contains(msg.Content, "<script>") {
+		t.Error("expected the guest's name to be escaped in the HTML body")
+	}
+	if !strings.Contains(msg.Text, "<script>") {
+		t.Error("expected the plain text body to keep the name as entered")
+	}
+	if msg.Subject != "Reservation Confirmation" {
+		t.Errorf("unexpected subject %q", msg.Subject)
+	}
+}
+
+func TestTemplates_UnknownTemplate(t *testing.T) {
+	templates, err := LoadTemplates("./../../email-templates")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, _, _, err = templates.Render(unknownEmail{})
+	if err == nil {
+		t.Error("expected an error for a template that does not exist")
+	}
+}
+
+type unknownEmail struct{}
+
+func (unknownEmail) TemplateName() string { return "unknown" }
+
+func TestTemplates_RenderScheduled(t *testing.T) {
+	templates, err := LoadTemplates("./../../email-templates")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e := models.ScheduledEmail{
+		Subject:  "See you soon, {{.Reservation.FirstName}}",
+		HTMLBody: "Dear {{.Reservation.FirstName}}, you arrive on {{formatDate .Reservation.StartDate \"2006-01-02\"}}",
+		TextBody: "Dear {{.Reservation.FirstName}}",
+	}
+	res := SampleReservation()
+	res.FirstName = "<b>John</b>"
+
+	msg, err := templates.ScheduledMessage("me@here.com", e, res)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if msg.To != res.Email || msg.Subject != "See you soon, <b>John</b>" {
+		t.Errorf("unexpected message to %s with subject %q", msg.To, msg.Subject)
+	}
+	if !strings.Contains(msg.Content, "&lt;b&gt;John&lt;/b&gt;") || !strings.Contains(msg.Content, res.StartDate.Format("2006-01-02")) {
+		t.Errorf("HTML body was not rendered into the layout: %s", msg.Content)
+	}
+	if !strings.Contains(msg.Content, "Usman's Bed and Breakfast") {
+		t.Error("expected the HTML body to use the email layout")
+	}
+	if msg.Text != "Dear <b>John</b>" {
+		t.Errorf("unexpected text body %q", msg.Text)
+	}
+
+	_, _, _, err = templates.RenderScheduled(models.ScheduledEmail{Subject: "{{.Missing"}, res)
+	if err == nil {
+		t.Error("expected an error for a template that does not parse")
+	}
+}
+
+func TestWrapInLayout(t *testing.T) {
+	TemplateDir = "./../../email-templates"
+
+	html, err := wrapInLayout("basic.html", "<strong>Hello</strong>")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(html, "<strong>Hello</strong>") || !strings.Contains(html, "Usman's Bed and Breakfast") {
+		t.Errorf("expected the legacy message to be wrapped in the email layout: %s", html)
+	}
+
+	_, err = wrapInLayout("missing.html", "<strong>Hello</strong>")
+	if err == nil {
+		t.Error("expected an error for an unknown layout")
+	}
+}
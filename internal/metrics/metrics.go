@@ -0,0 +1,126 @@
+package metrics
+
+import (
+	"database/sql"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Booking events counted by Booking
+const (
+	BookingCreated   = "created"
+	BookingCancelled = "cancelled"
+	BookingModified  = "modified"
+	// BookingPending is a reservation waiting for the guest to confirm their email address
+	BookingPending = "pending"
+)
+
+// unmatchedRoute labels requests that did not match any route, so stray URLs can't create new series
+const unmatchedRoute = "unmatched"
+
+// Registry holds every metric the app exposes on /metrics
+var Registry = prometheus.NewRegistry()
+
+var httpRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "bnb_http_requests_total",
+	Help: "HTTP requests handled, by route pattern, method and status code.",
+}, []string{"route", "method", "status"})
+
+var httpDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+	Name:    "bnb_http_request_duration_seconds",
+	Help:    "How long HTTP requests took to handle, by route pattern and method.",
+	Buckets: prometheus.DefBuckets,
+}, []string{"route", "method"})
+
+var bookings = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "bnb_bookings_total",
+	Help: "Reservations created, cancelled and modified.",
+}, []string{"event"})
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		httpRequests,
+		httpDuration,
+		bookings,
+	)
+
+	// start every booking series at zero, so rates work from the first scrape
+	for _, event := range []string{BookingCreated, BookingCancelled, BookingModified, BookingPending} {
+		bookings.WithLabelValues(event)
+	}
+}
+
+// Booking counts a booking event
+func Booking(event string) {
+	bookings.WithLabelValues(event).Inc()
+}
+
+// RegisterDB exposes the connection pool statistics of db
+func RegisterDB(db *sql.DB, name string) {
+	Registry.MustRegister(collectors.NewDBStatsCollector(db, name))
+}
+
+// RegisterMailQueue exposes the number of emails waiting to be sent, as counted by depth at scrape time
+func RegisterMailQueue(depth func() (int, error)) {
+	Registry.MustRegister(&queueCollector{
+		desc:  prometheus.NewDesc("bnb_mail_queue_depth", "Emails in the outbox waiting to be sent.", nil, nil),
+		depth: depth,
+	})
+}
+
+// queueCollector reports the outbox depth, asking the database on every scrape
+type queueCollector struct {
+	desc  *prometheus.Desc
+	depth func() (int, error)
+}
+
+// Describe sends the description of the queue depth metric
+func (c *queueCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.desc
+}
+
+// Collect counts the queue, reporting an error to the scraper when the count fails
+func (c *queueCollector) Collect(ch chan<- prometheus.Metric) {
+	n, err := c.depth()
+	if err != nil {
+		ch <- prometheus.NewInvalidMetric(c.desc, err)
+		return
+	}
+	ch <- prometheus.MustNewConstMetric(c.desc, prometheus.GaugeValue, float64(n))
+}
+
+// Instrument counts and times every request by the chi route pattern it matched
+func Instrument(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+
+		next.ServeHTTP(ww, r)
+
+		route := unmatchedRoute
+		if rctx := chi.RouteContext(r.Context()); rctx != nil && rctx.RoutePattern() != "" {
+			route = rctx.RoutePattern()
+		}
+
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+
+		httpRequests.WithLabelValues(route, r.Method, strconv.Itoa(status)).Inc()
+		httpDuration.WithLabelValues(route, r.Method).Observe(time.Since(start).Seconds())
+	})
+}
+
+// Handler serves the metrics in the Prometheus text format
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{})
+}
@@ -0,0 +1,75 @@
+package metrics
+
+import (
+	"errors"
+	"github.com/go-chi/chi/v5"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestInstrument(t *testing.T) {
+	mux := chi.NewRouter()
+	mux.Use(Instrument)
+	mux.Get("/rooms/{id}", func(w http.ResponseWriter, r *http.Request) {})
+	mux.Post("/rooms/{id}", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusSeeOther)
+	})
+
+	for _, req := range []*http.Request{
+		httptest.NewRequest("GET", "/rooms/1", nil),
+		httptest.NewRequest("GET", "/rooms/2", nil),
+		httptest.NewRequest("POST", "/rooms/2", nil),
+		httptest.NewRequest("GET", "/no-such-page", nil),
+	} {
+		mux.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	if n := testutil.ToFloat64(httpRequests.WithLabelValues("/rooms/{id}", "GET", "200")); n != 2 {
+		t.Errorf("expected 2 GET requests counted under the route pattern, got %v", n)
+	}
+	if n := testutil.ToFloat64(httpRequests.WithLabelValues("/rooms/{id}", "POST", "303")); n != 1 {
+		t.Errorf("expected 1 POST request counted with its status, got %v", n)
+	}
+	if n := testutil.ToFloat64(httpRequests.WithLabelValues(unmatchedRoute, "GET", "404")); n != 1 {
+		t.Errorf("expected the unmatched request to be counted as %s, got %v", unmatchedRoute, n)
+	}
+}
+
+func TestBooking(t *testing.T) {
+	before := testutil.ToFloat64(bookings.WithLabelValues(BookingCreated))
+	Booking(BookingCreated)
+
+	if n := testutil.ToFloat64(bookings.WithLabelValues(BookingCreated)); n != before+1 {
+		t.Errorf("expected the created counter to go up by one, got %v from %v", n, before)
+	}
+}
+
+func TestHandler(t *testing.T) {
+	depth, depthErr := 4, error(nil)
+	RegisterMailQueue(func() (int, error) {
+		return depth, depthErr
+	})
+
+	rr := httptest.NewRecorder()
+	Handler().ServeHTTP(rr, httptest.NewRequest("GET", "/metrics", nil))
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", rr.Code)
+	}
+	for _, expected := range []string{"bnb_mail_queue_depth 4", `bnb_bookings_total{event="cancelled"}`, "go_goroutines"} {
+		if !strings.Contains(rr.Body.String(), expected) {
+			t.Errorf("expected %q in the metrics output", expected)
+		}
+	}
+
+	depthErr = errors.New("connection refused")
+	rr = httptest.NewRecorder()
+	Handler().ServeHTTP(rr, httptest.NewRequest("GET", "/metrics", nil))
+
+	if rr.Code != http.StatusInternalServerError {
+		t.Errorf("expected status 500 when the queue can't be counted, got %d", rr.Code)
+	}
+}
@@ -0,0 +1,306 @@
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// versionLayout is the timestamp a migration's version is made of, the same as soda used
+const versionLayout = "20060102150405"
+
+// lockID is the Postgres advisory lock held while migrating, so two servers starting together don't
+// both apply the same migration
+const lockID = 7270105
+
+// createTable creates soda's version table if it isn't there already, so databases soda migrated
+// carry on from where they were
+const createTable = `
+	create table if not exists schema_migration (version varchar(14) not null);
+	create unique index if not exists schema_migration_version_idx on schema_migration (version);`
+
+// fileName matches migration files, such as 20210417095956_create_reservation_table.up.sql
+var fileName = regexp.MustCompile(`^(\d{14})_(\w+)\.(up|down)\.sql$`)
+
+// Migration is one schema change, with the SQL that makes it and the SQL that undoes it
+type Migration struct {
+	Version string
+	Name    string
+	Up      string
+	Down    string
+}
+
+// State is a migration and whether it has been applied to the database
+type State struct {
+	Migration
+	Applied bool
+}
+
+// Load reads the migrations in fsys, oldest first. Every migration needs both an up and a down file
+func Load(fsys fs.FS) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, err
+	}
+
+	// a migration's files, as they are found
+	type pair struct {
+		Migration
+		up, down bool
+	}
+
+	byVersion := map[string]*pair{}
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".sql" {
+			continue
+		}
+
+		parts := fileName.FindStringSubmatch(entry.Name())
+		if parts == nil {
+			return nil, fmt.Errorf("migration %s is not named like 20060102150405_name.up.sql", entry.Name())
+		}
+
+		content, err := fs.ReadFile(fsys, entry.Name())
+		if err != nil {
+			return nil, err
+		}
+
+		version, name, direction := parts[1], parts[2], parts[3]
+		p, ok := byVersion[version]
+		if !ok {
+			p = &pair{Migration: Migration{Version: version, Name: name}}
+			byVersion[version] = p
+		}
+		if p.Name != name {
+			return nil, fmt.Errorf("migrations %s_%s and %s_%s share a version", version, p.Name, version, name)
+		}
+
+		if direction == "up" {
+			p.Up, p.up = string(content), true
+		} else {
+			p.Down, p.down = string(content), true
+		}
+	}
+
+	var migrations []Migration
+	for _, p := range byVersion {
+		if !p.up || !p.down {
+			return nil, fmt.Errorf("migration %s_%s needs both an up and a down file", p.Version, p.Name)
+		}
+		migrations = append(migrations, p.Migration)
+	}
+
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+
+	return migrations, nil
+}
+
+// Create writes empty up and down files for a new migration called name to dir, returning their paths
+func Create(dir, name string, now time.Time) ([]string, error) {
+	name = strings.ToLower(strings.Join(strings.FieldsFunc(name, func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9')
+	}), "_"))
+	if name == "" {
+		return nil, errors.New("a migration needs a name made of letters and digits")
+	}
+
+	version := now.UTC().Format(versionLayout)
+	var paths []string
+	for _, direction := range []string{"up", "down"} {
+		path := filepath.Join(dir, fmt.Sprintf("%s_%s.%s.sql", version, name, direction))
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if err != nil {
+			return paths, err
+		}
+		f.Close()
+		paths = append(paths, path)
+	}
+
+	return paths, nil
+}
+
+// Migrator applies migrations to a database
+type Migrator struct {
+	db         *sql.DB
+	migrations []Migration
+	logger     *slog.Logger
+}
+
+// New creates a migrator for the migrations in fsys
+func New(db *sql.DB, fsys fs.FS, logger *slog.Logger) (*Migrator, error) {
+	migrations, err := Load(fsys)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Migrator{
+		db:         db,
+		migrations: migrations,
+		logger:     logger,
+	}, nil
+}
+
+// Up applies every migration not yet applied, oldest first, returning how many it applied. Each
+// migration runs in its own transaction, so a failed one leaves the database as the one before left it
+func (m *Migrator) Up(ctx context.Context) (int, error) {
+	applied := 0
+	err := m.locked(ctx, func(conn *sql.Conn, done map[string]bool) error {
+		for _, migration := range m.migrations {
+			if done[migration.Version] {
+				continue
+			}
+
+			err := m.apply(ctx, conn, migration, migration.Up,
+				"insert into schema_migration (version) values ($1)")
+			if err != nil {
+				return err
+			}
+			m.logger.InfoContext(ctx, "applied migration", "version", migration.Version, "name", migration.Name)
+			applied++
+		}
+		return nil
+	})
+
+	return applied, err
+}
+
+// Down rolls back the last steps applied migrations, newest first, returning how many it rolled back
+func (m *Migrator) Down(ctx context.Context, steps int) (int, error) {
+	known := map[string]Migration{}
+	for _, migration := range m.migrations {
+		known[migration.Version] = migration
+	}
+
+	rolledBack := 0
+	err := m.locked(ctx, func(conn *sql.Conn, done map[string]bool) error {
+		var versions []string
+		for version := range done {
+			versions = append(versions, version)
+		}
+		sort.Sort(sort.Reverse(sort.StringSlice(versions)))
+
+		for _, version := range versions {
+			if rolledBack == steps {
+				break
+			}
+
+			migration, ok := known[version]
+			if !ok {
+				return fmt.Errorf("migration %s is applied but isn't in this build, so it can't be rolled back", version)
+			}
+
+			err := m.apply(ctx, conn, migration, migration.Down,
+				"delete from schema_migration where version = $1")
+			if err != nil {
+				return err
+			}
+			m.logger.InfoContext(ctx, "rolled back migration", "version", migration.Version, "name", migration.Name)
+			rolledBack++
+		}
+		return nil
+	})
+
+	return rolledBack, err
+}
+
+// Status returns every migration and whether it has been applied, oldest first
+func (m *Migrator) Status(ctx context.Context) ([]State, error) {
+	var states []State
+	err := m.locked(ctx, func(conn *sql.Conn, done map[string]bool) error {
+		for _, migration := range m.migrations {
+			states = append(states, State{Migration: migration, Applied: done[migration.Version]})
+		}
+		return nil
+	})
+
+	return states, err
+}
+
+// locked runs fn on a connection holding the advisory lock, passing it the versions already applied.
+// Another migrator waits for the lock, then finds the work done
+func (m *Migrator) locked(ctx context.Context, fn func(conn *sql.Conn, done map[string]bool) error) error {
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	_, err = conn.ExecContext(ctx, "select pg_advisory_lock($1)", lockID)
+	if err != nil {
+		return fmt.Errorf("can't take the migration lock: %w", err)
+	}
+	// the lock belongs to the session, so it is released on this connection even if ctx is done
+	defer conn.ExecContext(context.Background(), "select pg_advisory_unlock($1)", lockID)
+
+	_, err = conn.ExecContext(ctx, createTable)
+	if err != nil {
+		return fmt.Errorf("can't create the schema_migration table: %w", err)
+	}
+
+	rows, err := conn.QueryContext(ctx, "select version from schema_migration")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	done := map[string]bool{}
+	for rows.Next() {
+		var version string
+		if err := rows.Scan(&version); err != nil {
+			return err
+		}
+		done[version] = true
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	rows.Close()
+
+	return fn(conn, done)
+}
+
+// apply runs the SQL of one direction of migration and records it with record, in one transaction
+func (m *Migrator) apply(ctx context.Context, conn *sql.Conn, migration Migration, statements, record string) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if !blank(statements) {
+		_, err = tx.ExecContext(ctx, statements)
+		if err != nil {
+			return fmt.Errorf("migration %s_%s: %w", migration.Version, migration.Name, err)
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, record, migration.Version)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// blank reports whether statements holds nothing but whitespace and comments, which Postgres
+// refuses to run
+func blank(statements string) bool {
+	for _, line := range strings.Split(statements, "\n") {
+		line = strings.TrimSpace(line)
+		if line != "" && !strings.HasPrefix(line, "--") {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,130 @@
+package migrate
+
+import (
+	"github.com/usmanzaheer1995/bed-and-breakfast/migrations"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func TestLoad(t *testing.T) {
+	fsys := fstest.MapFS{
+		"20210417100249_create_rooms_table.up.sql":   {Data: []byte("create table rooms ();")},
+		"20210417100249_create_rooms_table.down.sql": {Data: []byte("drop table rooms;")},
+		"20210417070511_create_users.up.sql":         {Data: []byte("create table users ();")},
+		"20210417070511_create_users.down.sql":       {Data: []byte("")},
+		"migrations.go":                              {Data: []byte("package migrations")},
+	}
+
+	loaded, err := Load(fsys)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(loaded) != 2 || loaded[0].Name != "create_users" || loaded[1].Name != "create_rooms_table" {
+		t.Fatalf("expected both migrations, oldest first, got %+v", loaded)
+	}
+	if loaded[1].Up != "create table rooms ();" || loaded[1].Down != "drop table rooms;" {
+		t.Errorf("expected the up and down SQL, got %+v", loaded[1])
+	}
+}
+
+var loadErrorTests = []struct {
+	name          string
+	fsys          fstest.MapFS
+	expectedError string
+}{
+	{
+		name: "no down file",
+		fsys: fstest.MapFS{
+			"20210417100249_create_rooms_table.up.sql": {Data: []byte("create table rooms ();")},
+		},
+		expectedError: "needs both an up and a down file",
+	},
+	{
+		name: "bad name",
+		fsys: fstest.MapFS{
+			"create_rooms_table.up.sql": {Data: []byte("create table rooms ();")},
+		},
+		expectedError: "is not named like",
+	},
+	{
+		name: "shared version",
+		fsys: fstest.MapFS{
+			"20210417100249_create_rooms_table.up.sql": {Data: []byte("")},
+			"20210417100249_create_users.down.sql":     {Data: []byte("")},
+		},
+		expectedError: "share a version",
+	},
+}
+
+func TestLoad_Errors(t *testing.T) {
+	for _, e := range loadErrorTests {
+		_, err := Load(e.fsys)
+		if err == nil || !strings.Contains(err.Error(), e.expectedError) {
+			t.Errorf("%s: expected an error containing %q, got %v", e.name, e.expectedError, err)
+		}
+	}
+}
+
+func TestLoad_Embedded(t *testing.T) {
+	loaded, err := Load(migrations.FS)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the versions soda recorded must carry on matching
+	if len(loaded) == 0 || loaded[0].Version != "20210417070511" {
+		t.Fatalf("expected the users table to be the first migration, got %+v", loaded)
+	}
+	for _, m := range loaded {
+		if blank(m.Up) {
+			t.Errorf("migration %s_%s does nothing", m.Version, m.Name)
+		}
+	}
+}
+
+func TestCreate(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Date(2021, 6, 1, 9, 30, 15, 0, time.UTC)
+
+	paths, err := Create(dir, "Add room capacity", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{
+		filepath.Join(dir, "20210601093015_add_room_capacity.up.sql"),
+		filepath.Join(dir, "20210601093015_add_room_capacity.down.sql"),
+	}
+	for i, path := range expected {
+		if i >= len(paths) || paths[i] != path {
+			t.Errorf("expected %s to be created, got %v", path, paths)
+		}
+		if _, err := os.Stat(path); err != nil {
+			t.Error(err)
+		}
+	}
+
+	_, err = Create(dir, "add room capacity", now)
+	if err == nil {
+		t.Error("expected an error rather than overwriting a migration")
+	}
+
+	_, err = Create(dir, "  !! ", now)
+	if err == nil {
+		t.Error("expected an error for a migration without a name")
+	}
+}
+
+func TestBlank(t *testing.T) {
+	if !blank("\n-- nothing to undo\n  \n") {
+		t.Error("expected comments and whitespace to be blank")
+	}
+	if blank("-- drop it\ndrop table rooms;") {
+		t.Error("expected a statement not to be blank")
+	}
+}
@@ -6,14 +6,34 @@ import (
 
 // User is the users model
 type User struct {
-	ID          int
-	FirstName   string
-	LastName    string
-	Email       string
-	Password    string
-	AccessLevel int
-	CreatedAt   time.Time
-	UpdatedAt   time.Time
+	ID                 int
+	FirstName          string
+	LastName           string
+	Email              string
+	Password           string
+	AccessLevel        int
+	NotifyNewBooking   bool
+	NotifyCancellation bool
+	NotifyModification bool
+	NotifyBlockChange  bool
+	NotifyDigest       bool
+	// Active is false for a user who can no longer sign in
+	Active    bool
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// UserSession is a place a user is signed in, recorded so they can see it and sign it out from elsewhere.
+// Key is kept in the session itself, and a session whose key has no row is signed out
+type UserSession struct {
+	ID         int
+	UserID     int
+	Key        string
+	UserAgent  string
+	IPAddress  string
+	LastSeenAt time.Time
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
 }
 
 // Room is the room model
@@ -24,6 +44,13 @@ type Room struct {
 	UpdatedAt time.Time
 }
 
+// RoomAvailability is a room that is free for a stay starting on StartDate
+type RoomAvailability struct {
+	Room      Room
+	StartDate time.Time
+	EndDate   time.Time
+}
+
 // Restriction is the restrictions model
 type Restriction struct {
 	ID              int
@@ -32,6 +59,13 @@ type Restriction struct {
 	UpdatedAt       time.Time
 }
 
+// Restriction ids seeded in the restrictions table
+const (
+	RestrictionReservation = 1
+	RestrictionOwnerBlock  = 2
+	RestrictionHold        = 3
+)
+
 // Reservation is the reservation model
 type Reservation struct {
 	ID        int
@@ -48,6 +82,17 @@ type Reservation struct {
 	Room      Room
 }
 
+// PendingReservation is a reservation waiting for the guest to confirm their email address by following
+// the link with Token before ExpiresAt. It doesn't block the room until then
+type PendingReservation struct {
+	ID          int
+	Reservation Reservation
+	Token       string
+	ExpiresAt   time.Time
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+}
+
 // RoomRestriction is the room restriction model
 type RoomRestriction struct {
 	ID            int
@@ -63,11 +108,117 @@ type RoomRestriction struct {
 	Restriction   Restriction
 }
 
-// MailData holds an email message
+// WaitlistEntry is the waitlist entries model. A RoomID of 0 means any room will do
+type WaitlistEntry struct {
+	ID            int
+	Email         string
+	RoomID        int
+	MatchedRoomID int
+	StartDate     time.Time
+	EndDate       time.Time
+	Status        string
+	Token         string
+	HoldExpiresAt time.Time
+	HoldID        int
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+	Room          Room
+}
+
+// Waitlist entry statuses
+const (
+	WaitlistWaiting  = "waiting"
+	WaitlistNotified = "notified"
+	WaitlistClaimed  = "claimed"
+	WaitlistExpired  = "expired"
+)
+
+// MailData holds an email message. Content is the HTML body and Text, if set, its plain text alternative
 type MailData struct {
-	To       string
-	From     string
-	Subject  string
-	Content  string
-	Template string
+	To          string
+	From        string
+	Subject     string
+	Content     string
+	Text        string
+	Template    string
+	Attachments []MailAttachment
+}
+
+// MailAttachment is a file attached to an email
+type MailAttachment struct {
+	Filename    string
+	ContentType string
+	Data        []byte
+}
+
+// OutboxMessage is an email waiting in, or delivered from, the outbox
+type OutboxMessage struct {
+	ID            int
+	Mail          MailData
+	Status        string
+	Attempts      int
+	LastError     string
+	NextAttemptAt time.Time
+	SentAt        time.Time
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+}
+
+// Outbox message statuses
+const (
+	OutboxQueued = "queued"
+	OutboxSent   = "sent"
+	OutboxFailed = "failed"
+)
+
+// Events staff can be notified about
+const (
+	NotifyNewBooking   = "new_booking"
+	NotifyCancellation = "cancellation"
+	NotifyModification = "modification"
+	NotifyBlockChange  = "block_change"
+)
+
+// NotificationRule sends an event to an address, or to the users with an access level who opted in
+type NotificationRule struct {
+	ID          int
+	Event       string
+	Address     string
+	AccessLevel int
+	Digest      bool
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+}
+
+// DigestItem is an event waiting to go out in a recipient's daily digest
+type DigestItem struct {
+	ID        int
+	Recipient string
+	Event     string
+	Summary   string
+	SentAt    time.Time
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// Dates a scheduled email can be sent relative to
+const (
+	AnchorStartDate = "start_date"
+	AnchorEndDate   = "end_date"
+)
+
+// ScheduledEmail is a guest email sent a number of days before or after the start or end of a stay.
+// Its subject and bodies are templates, editable by staff
+type ScheduledEmail struct {
+	ID          int
+	Name        string
+	Description string
+	Anchor      string
+	OffsetDays  int
+	Subject     string
+	HTMLBody    string
+	TextBody    string
+	Active      bool
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
 }
@@ -0,0 +1,60 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// memorySweepInterval is how often buckets that have filled up again are forgotten
+const memorySweepInterval = time.Minute
+
+// MemoryStore keeps buckets in memory, so each instance of the app limits clients on its own
+type MemoryStore struct {
+	mu        sync.Mutex
+	buckets   map[string]*memoryBucket
+	lastSweep time.Time
+}
+
+// memoryBucket is a bucket and when it will be full, which is when it can be forgotten
+type memoryBucket struct {
+	bucket
+	full time.Time
+}
+
+// NewMemoryStore creates an empty store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		buckets: map[string]*memoryBucket{},
+	}
+}
+
+// Take takes a token from the bucket named key
+func (s *MemoryStore) Take(ctx context.Context, key string, limit Limit, now time.Time) (time.Duration, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if now.Sub(s.lastSweep) > memorySweepInterval {
+		s.sweep(now)
+	}
+
+	b, ok := s.buckets[key]
+	if !ok {
+		b = &memoryBucket{bucket: newBucket(limit, now)}
+		s.buckets[key] = b
+	}
+
+	wait := b.take(limit, now)
+	b.full = b.fullAt(limit)
+	return wait, nil
+}
+
+// sweep forgets the buckets that are full, which are no different from new ones
+func (s *MemoryStore) sweep(now time.Time) {
+	for key, b := range s.buckets {
+		if !now.Before(b.full) {
+			delete(s.buckets, key)
+		}
+	}
+	s.lastSweep = now
+}
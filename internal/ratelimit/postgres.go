@@ -0,0 +1,71 @@
+package ratelimit
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// PostgresStore keeps buckets in the rate_limits table, so every instance of the app shares them
+type PostgresStore struct {
+	db      *sql.DB
+	timeout time.Duration
+}
+
+// NewPostgresStore creates a store on db whose queries give up after timeout
+func NewPostgresStore(db *sql.DB, timeout time.Duration) *PostgresStore {
+	return &PostgresStore{
+		db:      db,
+		timeout: timeout,
+	}
+}
+
+// Take takes a token from the bucket named key. The bucket's row is locked while it is updated, so
+// requests to different instances at the same moment can't both take the last token
+func (p *PostgresStore) Take(ctx context.Context, key string, limit Limit, now time.Time) (time.Duration, error) {
+	ctx, cancel := context.WithTimeout(ctx, p.timeout)
+	defer cancel()
+
+	tx, err := p.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	full := newBucket(limit, now)
+	_, err = tx.ExecContext(ctx, `insert into rate_limits (key, tokens, updated_at) values ($1, $2, $3)
+			on conflict (key) do nothing`, key, full.tokens, full.updated)
+	if err != nil {
+		return 0, err
+	}
+
+	var b bucket
+	err = tx.QueryRowContext(ctx, `select tokens, updated_at from rate_limits where key = $1 for update`, key).
+		Scan(&b.tokens, &b.updated)
+	if err != nil {
+		return 0, err
+	}
+
+	wait := b.take(limit, now)
+
+	_, err = tx.ExecContext(ctx, `update rate_limits set tokens = $1, updated_at = $2 where key = $3`,
+		b.tokens, b.updated, key)
+	if err != nil {
+		return 0, err
+	}
+
+	return wait, tx.Commit()
+}
+
+// DeleteIdle removes the buckets not used since before, returning how many there were. Buckets idle
+// for longer than the longest limit's period are full, so they are no different from new ones
+func (p *PostgresStore) DeleteIdle(ctx context.Context, before time.Time) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, p.timeout)
+	defer cancel()
+
+	result, err := p.db.ExecContext(ctx, `delete from rate_limits where updated_at < $1`, before)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
@@ -0,0 +1,110 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Limit allows Requests requests every Per, in bursts of up to Requests. A Limit of zero requests
+// allows everything
+type Limit struct {
+	Requests int
+	Per      time.Duration
+}
+
+// ParseLimit parses a limit written like "10/1m", or "off" for no limit
+func ParseLimit(s string) (Limit, error) {
+	if s == "off" {
+		return Limit{}, nil
+	}
+
+	requests, per, found := strings.Cut(s, "/")
+	if !found {
+		return Limit{}, fmt.Errorf("rate limit %q must be like 10/1m, or off", s)
+	}
+
+	n, err := strconv.Atoi(requests)
+	if err != nil || n < 1 {
+		return Limit{}, fmt.Errorf("rate limit %q must allow at least one request", s)
+	}
+
+	d, err := time.ParseDuration(per)
+	if err != nil || d <= 0 {
+		return Limit{}, fmt.Errorf("rate limit %q must have a positive period, like 1m", s)
+	}
+
+	return Limit{Requests: n, Per: d}, nil
+}
+
+// Off reports whether the limit allows everything
+func (l Limit) Off() bool {
+	return l.Requests == 0
+}
+
+// UnmarshalText parses a limit like "10/1m"
+func (l *Limit) UnmarshalText(text []byte) error {
+	v, err := ParseLimit(string(text))
+	if err != nil {
+		return err
+	}
+	*l = v
+	return nil
+}
+
+// String formats the limit like "10/1m0s"
+func (l Limit) String() string {
+	if l.Off() {
+		return "off"
+	}
+	return fmt.Sprintf("%d/%s", l.Requests, l.Per)
+}
+
+// Set parses a limit from a flag or environment variable
+func (l *Limit) Set(value string) error {
+	return l.UnmarshalText([]byte(value))
+}
+
+// Store keeps a token bucket for every client and route
+type Store interface {
+	// Take takes a token from the bucket named key. It returns zero if there was one, or how long
+	// until there will be
+	Take(ctx context.Context, key string, limit Limit, now time.Time) (time.Duration, error)
+}
+
+// bucket is a token bucket, which refills continuously up to the limit's number of requests
+type bucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+// newBucket returns a full bucket
+func newBucket(limit Limit, now time.Time) bucket {
+	return bucket{tokens: float64(limit.Requests), updated: now}
+}
+
+// take refills the bucket for the time since it was last used and takes a token. It returns zero if
+// there was a token, or how long until there will be
+func (b *bucket) take(limit Limit, now time.Time) time.Duration {
+	perToken := limit.Per / time.Duration(limit.Requests)
+
+	if elapsed := now.Sub(b.updated); elapsed > 0 {
+		b.tokens = math.Min(float64(limit.Requests), b.tokens+float64(elapsed)/float64(perToken))
+		b.updated = now
+	}
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return 0
+	}
+	return time.Duration(math.Ceil((1 - b.tokens) * float64(perToken)))
+}
+
+// fullAt returns when the bucket will be full again, after which it can be forgotten
+func (b *bucket) fullAt(limit Limit) time.Time {
+	perToken := limit.Per / time.Duration(limit.Requests)
+	return b.updated.Add(time.Duration((float64(limit.Requests) - b.tokens) * float64(perToken)))
+}
@@ -0,0 +1,84 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+var parseLimitTests = []struct {
+	value    string
+	expected Limit
+	err      bool
+}{
+	{"10/1m", Limit{Requests: 10, Per: time.Minute}, false},
+	{"1/24h", Limit{Requests: 1, Per: 24 * time.Hour}, false},
+	{"off", Limit{}, false},
+	{"10", Limit{}, true},
+	{"0/1m", Limit{}, true},
+	{"ten/1m", Limit{}, true},
+	{"10/minute", Limit{}, true},
+	{"10/-1m", Limit{}, true},
+}
+
+func TestParseLimit(t *testing.T) {
+	for _, e := range parseLimitTests {
+		l, err := ParseLimit(e.value)
+		if (err != nil) != e.err {
+			t.Errorf("%q: expected error %t, got %v", e.value, e.err, err)
+		}
+		if l != e.expected {
+			t.Errorf("%q: expected %+v, got %+v", e.value, e.expected, l)
+		}
+	}
+}
+
+func TestLimit_String(t *testing.T) {
+	if s := (Limit{Requests: 10, Per: time.Minute}).String(); s != "10/1m0s" {
+		t.Errorf("expected 10/1m0s, got %s", s)
+	}
+	if s := (Limit{}).String(); s != "off" {
+		t.Errorf("expected off, got %s", s)
+	}
+}
+
+func TestMemoryStore(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	limit := Limit{Requests: 3, Per: 3 * time.Minute}
+	now := time.Date(2021, 6, 5, 12, 0, 0, 0, time.UTC)
+
+	// a burst of up to three is allowed, then the client waits for a token
+	for i := 0; i < 3; i++ {
+		wait, _ := store.Take(ctx, "a", limit, now)
+		if wait != 0 {
+			t.Fatalf("request %d: expected to be allowed, got a wait of %s", i+1, wait)
+		}
+	}
+	wait, _ := store.Take(ctx, "a", limit, now)
+	if wait != time.Minute {
+		t.Errorf("expected a wait of a minute, got %s", wait)
+	}
+
+	// other clients have buckets of their own
+	wait, _ = store.Take(ctx, "b", limit, now)
+	if wait != 0 {
+		t.Errorf("expected another client to be allowed, got a wait of %s", wait)
+	}
+
+	// a token comes back every minute
+	wait, _ = store.Take(ctx, "a", limit, now.Add(30*time.Second))
+	if wait != 30*time.Second {
+		t.Errorf("expected a wait of 30s, got %s", wait)
+	}
+	wait, _ = store.Take(ctx, "a", limit, now.Add(time.Minute))
+	if wait != 0 {
+		t.Errorf("expected to be allowed after a minute, got a wait of %s", wait)
+	}
+
+	// buckets that have filled up again are forgotten
+	store.Take(ctx, "c", limit, now.Add(time.Hour))
+	if len(store.buckets) != 1 {
+		t.Errorf("expected only the new bucket to be kept, got %d", len(store.buckets))
+	}
+}
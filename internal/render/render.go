@@ -6,7 +6,10 @@ import (
 	"fmt"
 	"github.com/justinas/nosurf"
 	"github.com/usmanzaheer1995/bed-and-breakfast/internal/config"
+	"github.com/usmanzaheer1995/bed-and-breakfast/internal/csp"
 	"github.com/usmanzaheer1995/bed-and-breakfast/internal/models"
+	"github.com/usmanzaheer1995/bed-and-breakfast/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
 	"html/template"
 	"net/http"
 	"path/filepath"
@@ -57,6 +60,7 @@ func AddDefaultData(td *models.TemplateData, r *http.Request) *models.TemplateDa
 	td.Error = app.Session.PopString(r.Context(), "error")
 	td.Warning = app.Session.PopString(r.Context(), "warning")
 	td.CSRFToken = nosurf.Token(r)
+	td.CSPNonce = csp.NonceFrom(r.Context())
 	if app.Session.Exists(r.Context(), "user_id") {
 		td.IsAuthenticated = 1
 	}
@@ -65,7 +69,10 @@ func AddDefaultData(td *models.TemplateData, r *http.Request) *models.TemplateDa
 }
 
 // Template renders templates using html/template
-func Template(w http.ResponseWriter, r *http.Request, tmpl string, td *models.TemplateData) error {
+func Template(w http.ResponseWriter, r *http.Request, tmpl string, td *models.TemplateData) (err error) {
+	_, span := tracing.Start(r.Context(), "render.Template", attribute.String("template", tmpl))
+	defer func() { tracing.End(span, err) }()
+
 	var tc map[string]*template.Template
 
 	if app.UseCache {
@@ -82,9 +89,9 @@ func Template(w http.ResponseWriter, r *http.Request, tmpl string, td *models.Te
 
 	td = AddDefaultData(td, r)
 	_ = t.Execute(buf, td)
-	_, err := buf.WriteTo(w)
+	_, err = buf.WriteTo(w)
 	if err != nil {
-		fmt.Println("error writing template to browser", err)
+		app.Logger.ErrorContext(r.Context(), "can't write template to browser", "template", tmpl, "error", err)
 		return err
 	}
 	return nil
@@ -1,8 +1,17 @@
 package render
 
 import (
+	"github.com/usmanzaheer1995/bed-and-breakfast/internal/csp"
 	"github.com/usmanzaheer1995/bed-and-breakfast/internal/models"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace/noop"
+	"io/ioutil"
 	"net/http"
+	"path/filepath"
+	"regexp"
 	"testing"
 )
 
@@ -14,10 +23,14 @@ func TestAddDefaultData(t *testing.T) {
 	}
 
 	session.Put(r.Context(), "flash", "123")
+	r = r.WithContext(csp.WithNonce(r.Context(), "abc"))
 	result := AddDefaultData(&td, r)
 	if result.Flash != "123" {
 		t.Error("flash value of 123 not found in session")
 	}
+	if result.CSPNonce != "abc" {
+		t.Error("nonce from the request context not found")
+	}
 }
 
 func TestRenderTemplate(t *testing.T) {
@@ -46,6 +59,39 @@ func TestRenderTemplate(t *testing.T) {
 	}
 }
 
+func TestRenderTemplate_Span(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	defer otel.SetTracerProvider(noop.NewTracerProvider())
+
+	pathToTemplates = "./../../templates"
+	tc, err := CreateTemplateCache()
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.TemplateCache = tc
+
+	r, err := getSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ww myWriter
+
+	_ = Template(&ww, r, "home.page.tmpl", &models.TemplateData{})
+	_ = Template(&ww, r, "non-existent.page.tmpl", &models.TemplateData{})
+
+	spans := recorder.Ended()
+	if len(spans) != 2 {
+		t.Fatalf("expected a span per render, got %d", len(spans))
+	}
+	if spans[0].Name() != "render.Template" || spans[0].Status().Code == codes.Error {
+		t.Errorf("expected a successful render.Template span, got %s with status %v", spans[0].Name(), spans[0].Status())
+	}
+	if spans[1].Status().Code != codes.Error {
+		t.Error("expected the span of a missing template to be marked failed")
+	}
+}
+
 func getSession() (*http.Request, error) {
 	r, err := http.NewRequest("GET", "/some-url", nil)
 	if err != nil {
@@ -68,3 +114,24 @@ func TestCreateTemplateCache(t *testing.T) {
 		t.Error(err)
 	}
 }
+
+// protocolRelative matches script and stylesheet URLs without a scheme, which load over http: in
+// development, where the CSP only allows the CDNs over https:
+var protocolRelative = regexp.MustCompile(`(src|href)="//`)
+
+func TestTemplates_NoProtocolRelativeURLs(t *testing.T) {
+	files, err := filepath.Glob("./../../templates/*.tmpl")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, file := range files {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if loc := protocolRelative.Find(data); loc != nil {
+			t.Errorf("%s: load %q over https:// explicitly", filepath.Base(file), loc)
+		}
+	}
+}
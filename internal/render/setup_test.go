@@ -4,8 +4,9 @@ import (
 	"encoding/gob"
 	"github.com/alexedwards/scs/v2"
 	"github.com/usmanzaheer1995/bed-and-breakfast/internal/config"
+	"github.com/usmanzaheer1995/bed-and-breakfast/internal/logging"
 	"github.com/usmanzaheer1995/bed-and-breakfast/internal/models"
-	"log"
+	"log/slog"
 	"net/http"
 	"os"
 	"testing"
@@ -22,11 +23,7 @@ func TestMain(m *testing.M) {
 	// TODO: change this to true when in production
 	testApp.InProduction = false
 
-	infoLog := log.New(os.Stdout, "INFO\t", log.Ldate|log.Ltime)
-	testApp.InfoLog = infoLog
-
-	errorLog := log.New(os.Stdout, "ERROR\t", log.Ldate|log.Ltime|log.Lshortfile)
-	testApp.ErrorLog = errorLog
+	testApp.Logger = logging.New(os.Stdout, logging.FormatText, slog.LevelInfo)
 
 	session = scs.New()
 	session.Lifetime = 24 * time.Hour
@@ -3,7 +3,9 @@ package dbrepo
 import (
 	"database/sql"
 	"github.com/usmanzaheer1995/bed-and-breakfast/internal/config"
+	"github.com/usmanzaheer1995/bed-and-breakfast/internal/models"
 	"github.com/usmanzaheer1995/bed-and-breakfast/internal/repository"
+	"sync"
 )
 
 type postgresDBRepo struct {
@@ -14,6 +16,17 @@ type postgresDBRepo struct {
 type testDBRepo struct {
 	App *config.AppConfig
 	DB  *sql.DB
+
+	// outbox and digest hold queued email in memory, so tests can deliver it
+	mu     sync.Mutex
+	outbox []models.OutboxMessage
+	digest []models.DigestItem
+
+	// scheduledSends records which scheduled emails were sent for which reservations
+	scheduledSends map[[2]int]bool
+
+	// digestRuns records the days the digest went out
+	digestRuns map[string]bool
 }
 
 func NewPostgresRepo(app *config.AppConfig, conn *sql.DB) repository.DatabaseRepo {
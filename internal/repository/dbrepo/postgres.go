@@ -2,21 +2,57 @@ package dbrepo
 
 import (
 	"context"
+	"database/sql"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"github.com/usmanzaheer1995/bed-and-breakfast/internal/models"
+	"github.com/usmanzaheer1995/bed-and-breakfast/internal/repository"
+	"github.com/usmanzaheer1995/bed-and-breakfast/internal/tracing"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
 	"golang.org/x/crypto/bcrypt"
-	"log"
 	"time"
 )
 
-func (m *postgresDBRepo) AllUsers() bool {
+// passwordCost is the bcrypt cost passwords are hashed with
+const passwordCost = 12
+
+// defaultQueryTimeout bounds how long a repository method may take when no timeout is configured
+const defaultQueryTimeout = 3 * time.Second
+
+// begin starts the span and the timeout of a repository method, returning a func that ends both. The
+// method is cancelled early when parent is, such as when the guest gives up on the request. The span
+// is named after the method alone, so query parameters, and with them guest details, never reach a trace
+func (m *postgresDBRepo) begin(parent context.Context, method string) (context.Context, func()) {
+	timeout := m.App.QueryTimeout
+	if timeout <= 0 {
+		timeout = defaultQueryTimeout
+	}
+
+	ctx, span := tracing.Start(parent, "postgres."+method, semconv.DBSystemPostgreSQL, semconv.DBOperation(method))
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	return ctx, func() {
+		cancel()
+		span.End()
+	}
+}
+
+func (m *postgresDBRepo) AllUsers(ctx context.Context) bool {
 	return true
 }
 
+// Ping checks the database can be reached
+func (m *postgresDBRepo) Ping(ctx context.Context) error {
+	ctx, done := m.begin(ctx, "Ping")
+	defer done()
+
+	return m.DB.PingContext(ctx)
+}
+
 // InsertReservation inserts a reservation into the database
-func (m *postgresDBRepo) InsertReservation(res models.Reservation) (int, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
+func (m *postgresDBRepo) InsertReservation(ctx context.Context, res models.Reservation) (int, error) {
+	ctx, done := m.begin(ctx, "InsertReservation")
+	defer done()
 
 	stmt := `insert into reservations (first_name, last_name, email, phone, start_date,
 			end_date, room_id, created_at, updated_at)
@@ -42,10 +78,105 @@ func (m *postgresDBRepo) InsertReservation(res models.Reservation) (int, error)
 	return newID, nil
 }
 
+// InsertReservationWithOutbox inserts a reservation, the room restriction that books the room
+// for it and the emails announcing it in one transaction, so the emails are sent if and only
+// if the reservation was saved. The emails are built once the new reservation's id is known.
+// The guest's hold holdID gives way to the reservation; it returns repository.ErrNotAvailable
+// if anything else has the room on those dates
+func (m *postgresDBRepo) InsertReservationWithOutbox(ctx context.Context, res models.Reservation, holdID int, messages func(id int) ([]models.MailData, error)) (int, error) {
+	ctx, done := m.begin(ctx, "InsertReservationWithOutbox")
+	defer done()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	err = lockRoomIfAvailable(ctx, tx, res.RoomID, res.StartDate, res.EndDate, 0, holdID)
+	if err != nil {
+		return 0, err
+	}
+
+	if holdID > 0 {
+		_, err = tx.ExecContext(ctx, `delete from room_restrictions where id = $1 and restriction_id = $2`,
+			holdID, models.RestrictionHold)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	newID, err := insertReservation(ctx, tx, res, messages)
+	if err != nil {
+		return 0, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return 0, err
+	}
+	return newID, nil
+}
+
+// insertReservation inserts a reservation, the restriction blocking its room and the messages about it
+// in tx, returning the reservation's id
+func insertReservation(ctx context.Context, tx *sql.Tx, res models.Reservation, messages func(id int) ([]models.MailData, error)) (int, error) {
+	stmt := `insert into reservations (first_name, last_name, email, phone, start_date,
+			end_date, room_id, created_at, updated_at)
+			values ($1, $2, $3, $4, $5, $6, $7, $8, $9) returning id`
+
+	var newID int
+
+	err := tx.QueryRowContext(ctx, stmt,
+		res.FirstName,
+		res.LastName,
+		res.Email,
+		res.Phone,
+		res.StartDate,
+		res.EndDate,
+		res.RoomID,
+		time.Now(),
+		time.Now(),
+	).Scan(&newID)
+	if err != nil {
+		return 0, err
+	}
+
+	stmt = `insert into room_restrictions (start_date, end_date, room_id, reservation_id,
+			created_at, updated_at, restriction_id) values ($1, $2, $3, $4, $5, $6, $7)`
+
+	_, err = tx.ExecContext(ctx, stmt,
+		res.StartDate,
+		res.EndDate,
+		res.RoomID,
+		newID,
+		time.Now(),
+		time.Now(),
+		models.RestrictionReservation,
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	msgs, err := messages(newID)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, msg := range msgs {
+		err = insertOutboxMessage(ctx, tx, msg)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return newID, nil
+}
+
 // InsertRoomRestriction inserts a room restriction into the database
-func (m *postgresDBRepo) InsertRoomRestriction(r models.RoomRestriction) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
+func (m *postgresDBRepo) InsertRoomRestriction(ctx context.Context, r models.RoomRestriction) error {
+	ctx, done := m.begin(ctx, "InsertRoomRestriction")
+	defer done()
 
 	stmt := `insert into room_restrictions (start_date, end_date, room_id, reservation_id,
 			created_at, updated_at, restriction_id) values ($1, $2, $3, $4, $5, $6, $7)`
@@ -67,21 +198,22 @@ func (m *postgresDBRepo) InsertRoomRestriction(r models.RoomRestriction) error {
 }
 
 // SearchAvailabilityByDatesByRoomID returns true if availability exists for roomId and false if it doesn't
-func (m *postgresDBRepo) SearchAvailabilityByDatesByRoomID(start, end time.Time, roomId int) (bool, error) {
+func (m *postgresDBRepo) SearchAvailabilityByDatesByRoomID(ctx context.Context, start, end time.Time, roomId int) (bool, error) {
 	query := `select
 				count(id)
 			from
 				room_restrictions
 			where
 			    room_id = $1
-				and $2 < end_date and $3 > start_date;`
+				and $2 < end_date and $3 > start_date
+				and (expires_at is null or expires_at > $4);`
 
 	var numRows int
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
+	ctx, done := m.begin(ctx, "SearchAvailabilityByDatesByRoomID")
+	defer done()
 
-	row := m.DB.QueryRowContext(ctx, query, roomId, start, end)
+	row := m.DB.QueryRowContext(ctx, query, roomId, start, end, time.Now())
 	err := row.Scan(&numRows)
 	if err != nil {
 		return false, nil
@@ -94,9 +226,9 @@ func (m *postgresDBRepo) SearchAvailabilityByDatesByRoomID(start, end time.Time,
 }
 
 // SearchAvailabilityForAllRooms returns a slice of available rooms if any for given date range
-func (m *postgresDBRepo) SearchAvailabilityForAllRooms(start, end time.Time) ([]models.Room, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
+func (m *postgresDBRepo) SearchAvailabilityForAllRooms(ctx context.Context, start, end time.Time) ([]models.Room, error) {
+	ctx, done := m.begin(ctx, "SearchAvailabilityForAllRooms")
+	defer done()
 
 	var rooms []models.Room
 
@@ -106,9 +238,10 @@ func (m *postgresDBRepo) SearchAvailabilityForAllRooms(start, end time.Time) ([]
 			from
 				rooms r
 			where r.id not in
-			(select rr.room_id from room_restrictions rr where $1 < rr.end_date and $2 > rr.start_date);`
+			(select rr.room_id from room_restrictions rr where $1 < rr.end_date and $2 > rr.start_date
+				and (rr.expires_at is null or rr.expires_at > $3));`
 
-	rows, err := m.DB.QueryContext(ctx, query, start, end)
+	rows, err := m.DB.QueryContext(ctx, query, start, end, time.Now())
 	if err != nil {
 		return rooms, err
 	}
@@ -129,10 +262,57 @@ func (m *postgresDBRepo) SearchAvailabilityForAllRooms(start, end time.Time) ([]
 	return rooms, nil
 }
 
+// SearchFlexibleAvailability returns every room and arrival date for which a stay of the given number
+// of nights fits inside the window without overlapping a restriction, ordered by arrival date
+func (m *postgresDBRepo) SearchFlexibleAvailability(ctx context.Context, windowStart, windowEnd time.Time, nights int) ([]models.RoomAvailability, error) {
+	ctx, done := m.begin(ctx, "SearchFlexibleAvailability")
+	defer done()
+
+	var available []models.RoomAvailability
+
+	query := `
+			select
+				r.id, r.room_name, days.d
+			from
+				rooms r
+				cross join (
+					select generate_series($1::date, $2::date - $3::int, interval '1 day')::date as d
+				) days
+			where not exists (
+				select 1 from room_restrictions rr
+				where rr.room_id = r.id
+				and days.d < rr.end_date and days.d + $3::int > rr.start_date
+				and (rr.expires_at is null or rr.expires_at > $4)
+			)
+			order by days.d, r.id`
+
+	rows, err := m.DB.QueryContext(ctx, query, windowStart, windowEnd, nights, time.Now())
+	if err != nil {
+		return available, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var a models.RoomAvailability
+		err := rows.Scan(&a.Room.ID, &a.Room.RoomName, &a.StartDate)
+		if err != nil {
+			return available, err
+		}
+		a.EndDate = a.StartDate.AddDate(0, 0, nights)
+		available = append(available, a)
+	}
+
+	if err = rows.Err(); err != nil {
+		return available, err
+	}
+
+	return available, nil
+}
+
 // GetRoomByID gets a room by ID
-func (m *postgresDBRepo) GetRoomByID(id int) (models.Room, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
+func (m *postgresDBRepo) GetRoomByID(ctx context.Context, id int) (models.Room, error) {
+	ctx, done := m.begin(ctx, "GetRoomByID")
+	defer done()
 
 	var r models.Room
 	query := `
@@ -153,55 +333,173 @@ func (m *postgresDBRepo) GetRoomByID(id int) (models.Room, error) {
 }
 
 // GetUserByID returns a user by ID
-func (m *postgresDBRepo) GetUserByID(id int) (models.User, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
+func (m *postgresDBRepo) GetUserByID(ctx context.Context, id int) (models.User, error) {
+	ctx, done := m.begin(ctx, "GetUserByID")
+	defer done()
 
-	query := `select id, first_name, last_name, email, password, access_level, created_at, updated_at
+	query := `select id, first_name, last_name, email, password, access_level, notify_new_booking,
+		notify_cancellation, notify_modification, notify_block_change, notify_digest, active, created_at, updated_at
 		from users where id=$1;
 	`
 
 	row := m.DB.QueryRowContext(ctx, query, id)
 
-	var u models.User
-	err := row.Scan(
-		&u.ID,
-		&u.FirstName,
-		&u.LastName,
-		&u.AccessLevel,
-		&u.Password,
-		&u.CreatedAt,
-		&u.UpdatedAt)
+	return scanUser(row)
+}
+
+// GetUserByEmail returns the user with an email address, ignoring case
+func (m *postgresDBRepo) GetUserByEmail(ctx context.Context, email string) (models.User, error) {
+	ctx, done := m.begin(ctx, "GetUserByEmail")
+	defer done()
+
+	query := `select id, first_name, last_name, email, password, access_level, notify_new_booking,
+		notify_cancellation, notify_modification, notify_block_change, notify_digest, active, created_at, updated_at
+		from users where lower(email) = lower($1);
+	`
+
+	row := m.DB.QueryRowContext(ctx, query, email)
+
+	return scanUser(row)
+}
+
+// InsertUser adds a user with a password, which is stored hashed, and returns the new user's id.
+// It returns repository.ErrEmailTaken if another user has the email address
+func (m *postgresDBRepo) InsertUser(ctx context.Context, u models.User, password string) (int, error) {
+	ctx, done := m.begin(ctx, "InsertUser")
+	defer done()
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), passwordCost)
+	if err != nil {
+		return 0, err
+	}
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var taken int
+	err = tx.QueryRowContext(ctx, `select count(id) from users where lower(email) = lower($1)`, u.Email).Scan(&taken)
+	if err != nil {
+		return 0, err
+	}
+	if taken > 0 {
+		return 0, repository.ErrEmailTaken
+	}
+
+	stmt := `insert into users (first_name, last_name, email, password, access_level, created_at, updated_at)
+			values ($1, $2, $3, $4, $5, $6, $7) returning id`
+
+	var newID int
+	err = tx.QueryRowContext(ctx, stmt,
+		u.FirstName,
+		u.LastName,
+		u.Email,
+		string(hash),
+		u.AccessLevel,
+		time.Now(),
+		time.Now(),
+	).Scan(&newID)
 	if err != nil {
-		return u, err
+		return 0, err
 	}
-	return u, nil
+
+	return newID, tx.Commit()
 }
 
-// UpdateUser a user in the database
-func (m *postgresDBRepo) UpdateUser(u models.User) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
+// UpdatePassword replaces a user's password, which is stored hashed, and signs the user out everywhere
+func (m *postgresDBRepo) UpdatePassword(ctx context.Context, id int, password string) error {
+	ctx, done := m.begin(ctx, "UpdatePassword")
+	defer done()
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), passwordCost)
+	if err != nil {
+		return err
+	}
 
-	query := `update users set first_name=$1, last_name=$2, email=$3, access_level=$4, updated_at=$5;`
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
 
-	_, err := m.DB.ExecContext(ctx, query, u.FirstName, u.LastName, u.Email, u.AccessLevel, time.Now())
+	result, err := tx.ExecContext(ctx, `update users set password=$1, updated_at=$2 where id=$3`,
+		string(hash), time.Now(), id)
 	if err != nil {
 		return err
 	}
-	return nil
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+
+	_, err = tx.ExecContext(ctx, `delete from user_sessions where user_id = $1`, id)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// UpdateUser a user in the database. Deactivating a user signs them out everywhere
+func (m *postgresDBRepo) UpdateUser(ctx context.Context, u models.User) error {
+	ctx, done := m.begin(ctx, "UpdateUser")
+	defer done()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `update users set first_name=$1, last_name=$2, email=$3, access_level=$4, notify_new_booking=$5,
+		notify_cancellation=$6, notify_modification=$7, notify_block_change=$8, notify_digest=$9, active=$10,
+		updated_at=$11 where id=$12;`
+
+	_, err = tx.ExecContext(ctx, query,
+		u.FirstName,
+		u.LastName,
+		u.Email,
+		u.AccessLevel,
+		u.NotifyNewBooking,
+		u.NotifyCancellation,
+		u.NotifyModification,
+		u.NotifyBlockChange,
+		u.NotifyDigest,
+		u.Active,
+		time.Now(),
+		u.ID,
+	)
+	if err != nil {
+		return err
+	}
+
+	if !u.Active {
+		_, err = tx.ExecContext(ctx, `delete from user_sessions where user_id = $1`, u.ID)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
 }
 
-// Authenticate authenticates a user
-func (m *postgresDBRepo) Authenticate(email, testPassword string) (int, string, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
+// Authenticate authenticates an active user
+func (m *postgresDBRepo) Authenticate(ctx context.Context, email, testPassword string) (int, string, error) {
+	ctx, done := m.begin(ctx, "Authenticate")
+	defer done()
 
 	var id int
 	var hashedPassword string
+	var active bool
 
-	row := m.DB.QueryRowContext(ctx, `select id, password from users where email=$1`, email)
-	err := row.Scan(&id, &hashedPassword)
+	row := m.DB.QueryRowContext(ctx, `select id, password, active from users where lower(email) = lower($1)`, email)
+	err := row.Scan(&id, &hashedPassword, &active)
 	if err != nil {
 		return id, "", err
 	}
@@ -213,13 +511,17 @@ func (m *postgresDBRepo) Authenticate(email, testPassword string) (int, string,
 		return 0, "", err
 	}
 
+	if !active {
+		return 0, "", errors.New("user is deactivated")
+	}
+
 	return id, hashedPassword, nil
 }
 
 // AllReservations returns a slice of all reservations
-func (m *postgresDBRepo) AllReservations() ([]models.Reservation, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
+func (m *postgresDBRepo) AllReservations(ctx context.Context) ([]models.Reservation, error) {
+	ctx, done := m.begin(ctx, "AllReservations")
+	defer done()
 
 	var reservations []models.Reservation
 
@@ -267,9 +569,9 @@ func (m *postgresDBRepo) AllReservations() ([]models.Reservation, error) {
 	return reservations, nil
 }
 
-func (m *postgresDBRepo) AllNewReservations() ([]models.Reservation, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
+func (m *postgresDBRepo) AllNewReservations(ctx context.Context) ([]models.Reservation, error) {
+	ctx, done := m.begin(ctx, "AllNewReservations")
+	defer done()
 
 	var reservations []models.Reservation
 
@@ -319,9 +621,9 @@ func (m *postgresDBRepo) AllNewReservations() ([]models.Reservation, error) {
 }
 
 // GetReservationByID returns one reservation by ID
-func (m *postgresDBRepo) GetReservationByID(id int) (models.Reservation, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
+func (m *postgresDBRepo) GetReservationByID(ctx context.Context, id int) (models.Reservation, error) {
+	ctx, done := m.begin(ctx, "GetReservationByID")
+	defer done()
 
 	var res models.Reservation
 
@@ -357,9 +659,9 @@ func (m *postgresDBRepo) GetReservationByID(id int) (models.Reservation, error)
 }
 
 // UpdateReservation updates one reservation by ID
-func (m *postgresDBRepo) UpdateReservation(u models.Reservation) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
+func (m *postgresDBRepo) UpdateReservation(ctx context.Context, u models.Reservation) error {
+	ctx, done := m.begin(ctx, "UpdateReservation")
+	defer done()
 
 	query := `update reservations set first_name=$1, last_name=$2, email=$3, phone=$4, updated_at=$5
 		where id=$6
@@ -373,9 +675,9 @@ func (m *postgresDBRepo) UpdateReservation(u models.Reservation) error {
 }
 
 // DeleteReservation deletes one reservation by ID
-func (m *postgresDBRepo) DeleteReservation(id int) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
+func (m *postgresDBRepo) DeleteReservation(ctx context.Context, id int) error {
+	ctx, done := m.begin(ctx, "DeleteReservation")
+	defer done()
 
 	query := `delete from reservations where id=$1`
 
@@ -387,9 +689,9 @@ func (m *postgresDBRepo) DeleteReservation(id int) error {
 }
 
 // UpdateProcessedReservation updates a processed for a reservation by ID
-func (m *postgresDBRepo) UpdateProcessedReservation(id, processed int) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
+func (m *postgresDBRepo) UpdateProcessedReservation(ctx context.Context, id, processed int) error {
+	ctx, done := m.begin(ctx, "UpdateProcessedReservation")
+	defer done()
 
 	query := `update reservations set processed=$1 where id=$2;`
 
@@ -401,9 +703,9 @@ func (m *postgresDBRepo) UpdateProcessedReservation(id, processed int) error {
 }
 
 // AllRooms returns all rooms
-func (m *postgresDBRepo) AllRooms() ([]models.Room, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
+func (m *postgresDBRepo) AllRooms(ctx context.Context) ([]models.Room, error) {
+	ctx, done := m.begin(ctx, "AllRooms")
+	defer done()
 
 	var rooms []models.Room
 
@@ -437,14 +739,14 @@ func (m *postgresDBRepo) AllRooms() ([]models.Room, error) {
 }
 
 // GetRestrictionsForRoomByDay returns restrictions for a room by date range
-func (m *postgresDBRepo) GetRestrictionsForRoomByDay(roomID int, startDate, endDate time.Time) ([]models.RoomRestriction, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
+func (m *postgresDBRepo) GetRestrictionsForRoomByDay(ctx context.Context, roomID int, startDate, endDate time.Time) ([]models.RoomRestriction, error) {
+	ctx, done := m.begin(ctx, "GetRestrictionsForRoomByDay")
+	defer done()
 
 	var restrictions []models.RoomRestriction
 
-	query := `select id, coalesce(reservation_id, 0), restriction_id, room_id, start_date, end_date from room_restrictions where
-			$1 < end_date and $2 >= start_date and room_id = $3`
+	query := `select id, coalesce(reservation_id, 0), restriction_id, room_id, start_date, end_date, updated_at
+			from room_restrictions where $1 < end_date and $2 >= start_date and room_id = $3`
 
 	rows, err := m.DB.QueryContext(ctx, query, startDate, endDate, roomID)
 	if err != nil {
@@ -461,10 +763,62 @@ func (m *postgresDBRepo) GetRestrictionsForRoomByDay(roomID int, startDate, endD
 			&r.RoomID,
 			&r.StartDate,
 			&r.EndDate,
+			&r.UpdatedAt,
+		)
+		if err != nil {
+			return restrictions, err
+		}
+
+		restrictions = append(restrictions, r)
+	}
+
+	if err = rows.Err(); err != nil {
+		return restrictions, err
+	}
+	return restrictions, nil
+}
+
+// GetRestrictionsByDateRange returns restrictions for all rooms by date range, along with the
+// guest name and version of the reservation they belong to
+func (m *postgresDBRepo) GetRestrictionsByDateRange(ctx context.Context, startDate, endDate time.Time) ([]models.RoomRestriction, error) {
+	ctx, done := m.begin(ctx, "GetRestrictionsByDateRange")
+	defer done()
+
+	var restrictions []models.RoomRestriction
+
+	query := `select rr.id, coalesce(rr.reservation_id, 0), rr.restriction_id, rr.room_id, rr.start_date,
+			rr.end_date, rr.updated_at, coalesce(r.first_name, ''), coalesce(r.last_name, ''),
+			coalesce(r.updated_at, rr.updated_at)
+			from room_restrictions rr
+			left join reservations r on rr.reservation_id = r.id
+			where $1 < rr.end_date and $2 >= rr.start_date
+			and (rr.expires_at is null or rr.expires_at > $3)
+			order by rr.room_id, rr.start_date`
+
+	rows, err := m.DB.QueryContext(ctx, query, startDate, endDate, time.Now())
+	if err != nil {
+		return restrictions, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var r models.RoomRestriction
+		err := rows.Scan(
+			&r.ID,
+			&r.ReservationID,
+			&r.RestrictionID,
+			&r.RoomID,
+			&r.StartDate,
+			&r.EndDate,
+			&r.UpdatedAt,
+			&r.Reservation.FirstName,
+			&r.Reservation.LastName,
+			&r.Reservation.UpdatedAt,
 		)
 		if err != nil {
 			return restrictions, err
 		}
+		r.Reservation.ID = r.ReservationID
 
 		restrictions = append(restrictions, r)
 	}
@@ -475,34 +829,1340 @@ func (m *postgresDBRepo) GetRestrictionsForRoomByDay(roomID int, startDate, endD
 	return restrictions, nil
 }
 
-// InsertBlockForRoom inserts a room restriction
-func (m *postgresDBRepo) InsertBlockForRoom(id int, startDate time.Time) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
+// UpdateReservationDates moves a reservation, and its room restriction, to a new room and/or dates.
+// It returns repository.ErrConflict if the reservation changed since version, and
+// repository.ErrNotAvailable if the target room is taken for the new dates
+func (m *postgresDBRepo) UpdateReservationDates(ctx context.Context, res models.Reservation, version time.Time) error {
+	ctx, done := m.begin(ctx, "UpdateReservationDates")
+	defer done()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var current time.Time
+	err = tx.QueryRowContext(ctx, `select updated_at from reservations where id = $1 for update`, res.ID).Scan(&current)
+	if err == sql.ErrNoRows {
+		return repository.ErrConflict
+	} else if err != nil {
+		return err
+	}
+
+	if !current.Equal(version) {
+		return repository.ErrConflict
+	}
+
+	err = lockRoomIfAvailable(ctx, tx, res.RoomID, res.StartDate, res.EndDate, res.ID, 0)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+
+	query := `update reservations set room_id=$1, start_date=$2, end_date=$3, updated_at=$4 where id=$5`
+	_, err = tx.ExecContext(ctx, query, res.RoomID, res.StartDate, res.EndDate, now, res.ID)
+	if err != nil {
+		return err
+	}
+
+	query = `update room_restrictions set room_id=$1, start_date=$2, end_date=$3, updated_at=$4
+			where reservation_id=$5`
+	_, err = tx.ExecContext(ctx, query, res.RoomID, res.StartDate, res.EndDate, now, res.ID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// InsertBlockForRoom inserts an owner block for a single day. It returns repository.ErrConflict
+// if the day has been booked or blocked since the calendar was loaded
+func (m *postgresDBRepo) InsertBlockForRoom(ctx context.Context, id int, startDate time.Time) error {
+	err := m.InsertBlocksForRoom(ctx, id, startDate, startDate.AddDate(0, 0, 1))
+	if err == repository.ErrNotAvailable {
+		return repository.ErrConflict
+	}
+	return err
+}
+
+// InsertBlocksForRoom inserts one owner block per day from startDate up to, but not including,
+// endDate. Nothing is inserted, and repository.ErrNotAvailable returned, if any day is taken
+func (m *postgresDBRepo) InsertBlocksForRoom(ctx context.Context, id int, startDate, endDate time.Time) error {
+	ctx, done := m.begin(ctx, "InsertBlocksForRoom")
+	defer done()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = lockRoomIfAvailable(ctx, tx, id, startDate, endDate, 0, 0)
+	if err != nil {
+		return err
+	}
 
 	query := `insert into room_restrictions (start_date, end_date, room_id, restriction_id,
 				created_at, updated_at) values ($1, $2, $3, $4, $5, $6);
 			`
 
-	_, err := m.DB.ExecContext(ctx, query, startDate, startDate.AddDate(0, 0, 1), id, 2, time.Now(), time.Now())
+	for d := startDate; d.Before(endDate); d = d.AddDate(0, 0, 1) {
+		_, err = tx.ExecContext(ctx, query, d, d.AddDate(0, 0, 1), id, 2, time.Now(), time.Now())
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// lockRoomIfAvailable locks a room for the rest of the transaction, so nobody else can book it
+// while we do, and returns repository.ErrNotAvailable if any restriction overlaps the dates.
+// Restrictions belonging to reservation excludeReservationID, and the hold excludeHoldID, are ignored. Hold expiry is written from
+// the app's clock into a column without a time zone, so it is always compared with time.Now(), never
+// the database's now()
+func lockRoomIfAvailable(ctx context.Context, tx *sql.Tx, roomID int, start, end time.Time, excludeReservationID, excludeHoldID int) error {
+	var id int
+	err := tx.QueryRowContext(ctx, `select id from rooms where id = $1 for update`, roomID).Scan(&id)
+	if err != nil {
+		return err
+	}
+
+	var numRows int
+	query := `select count(id) from room_restrictions
+			where room_id = $1 and $2 < end_date and $3 > start_date
+			and (reservation_id is null or reservation_id <> $4)
+			and id <> $6
+			and (expires_at is null or expires_at > $5)`
+	err = tx.QueryRowContext(ctx, query, roomID, start, end, excludeReservationID, time.Now(), excludeHoldID).Scan(&numRows)
 	if err != nil {
-		log.Println(err)
 		return err
 	}
+	if numRows > 0 {
+		return repository.ErrNotAvailable
+	}
 	return nil
 }
 
-// DeleteBlockForRoom deletes a room restriction
-func (m *postgresDBRepo) DeleteBlockForRoom(id int) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
+// RepairReservationRestriction replaces the room restrictions of a reservation with one for its room
+// and dates. It returns repository.ErrNotAvailable if something else has the room on those dates, and
+// sql.ErrNoRows if the reservation doesn't exist
+func (m *postgresDBRepo) RepairReservationRestriction(ctx context.Context, id int) error {
+	ctx, done := m.begin(ctx, "RepairReservationRestriction")
+	defer done()
 
-	query := `delete from room_restrictions where id=$1`
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
 
-	_, err := m.DB.ExecContext(ctx, query, id)
+	var res models.Reservation
+	query := `select id, room_id, start_date, end_date from reservations where id = $1 for update`
+	err = tx.QueryRowContext(ctx, query, id).Scan(&res.ID, &res.RoomID, &res.StartDate, &res.EndDate)
 	if err != nil {
-		log.Println(err)
 		return err
 	}
-	return nil
+
+	err = lockRoomIfAvailable(ctx, tx, res.RoomID, res.StartDate, res.EndDate, res.ID, 0)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `delete from room_restrictions where reservation_id = $1`, res.ID)
+	if err != nil {
+		return err
+	}
+
+	stmt := `insert into room_restrictions (start_date, end_date, room_id, reservation_id,
+			restriction_id, created_at, updated_at) values ($1, $2, $3, $4, $5, $6, $7)`
+	_, err = tx.ExecContext(ctx, stmt, res.StartDate, res.EndDate, res.RoomID, res.ID,
+		models.RestrictionReservation, time.Now(), time.Now())
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// DeleteOrphanedRestriction deletes a room restriction whose reservation no longer exists. It returns
+// repository.ErrConflict if the restriction is gone or its reservation exists after all
+func (m *postgresDBRepo) DeleteOrphanedRestriction(ctx context.Context, id int) error {
+	ctx, done := m.begin(ctx, "DeleteOrphanedRestriction")
+	defer done()
+
+	query := `delete from room_restrictions rr
+			where rr.id = $1 and rr.reservation_id is not null
+			and not exists (select 1 from reservations r where r.id = rr.reservation_id)`
+
+	result, err := m.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return repository.ErrConflict
+	}
+	return nil
+}
+
+// InsertUserSession records that a user has signed in, returning the new record's id
+func (m *postgresDBRepo) InsertUserSession(ctx context.Context, s models.UserSession) (int, error) {
+	ctx, done := m.begin(ctx, "InsertUserSession")
+	defer done()
+
+	stmt := `insert into user_sessions (user_id, key, user_agent, ip_address, last_seen_at, created_at, updated_at)
+			values ($1, $2, $3, $4, $5, $6, $7) returning id`
+
+	var newID int
+	err := m.DB.QueryRowContext(ctx, stmt,
+		s.UserID,
+		s.Key,
+		s.UserAgent,
+		s.IPAddress,
+		time.Now(),
+		time.Now(),
+		time.Now(),
+	).Scan(&newID)
+	if err != nil {
+		return 0, err
+	}
+
+	return newID, nil
+}
+
+// GetUserSessionByKey returns the session with a key, or sql.ErrNoRows if it has been signed out
+func (m *postgresDBRepo) GetUserSessionByKey(ctx context.Context, key string) (models.UserSession, error) {
+	ctx, done := m.begin(ctx, "GetUserSessionByKey")
+	defer done()
+
+	query := `select id, user_id, key, user_agent, ip_address, last_seen_at, created_at, updated_at
+			from user_sessions where key = $1`
+
+	var s models.UserSession
+	err := m.DB.QueryRowContext(ctx, query, key).Scan(
+		&s.ID,
+		&s.UserID,
+		&s.Key,
+		&s.UserAgent,
+		&s.IPAddress,
+		&s.LastSeenAt,
+		&s.CreatedAt,
+		&s.UpdatedAt,
+	)
+	return s, err
+}
+
+// TouchUserSession records when and from where a session was last used
+func (m *postgresDBRepo) TouchUserSession(ctx context.Context, id int, ipAddress string, seen time.Time) error {
+	ctx, done := m.begin(ctx, "TouchUserSession")
+	defer done()
+
+	_, err := m.DB.ExecContext(ctx, `update user_sessions set ip_address = $1, last_seen_at = $2 where id = $3`,
+		ipAddress, seen, id)
+	return err
+}
+
+// UserSessions returns the sessions a user is signed in with, most recently used first
+func (m *postgresDBRepo) UserSessions(ctx context.Context, userID int) ([]models.UserSession, error) {
+	ctx, done := m.begin(ctx, "UserSessions")
+	defer done()
+
+	var sessions []models.UserSession
+
+	query := `select id, user_id, key, user_agent, ip_address, last_seen_at, created_at, updated_at
+			from user_sessions where user_id = $1 order by last_seen_at desc`
+
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return sessions, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var s models.UserSession
+		err := rows.Scan(
+			&s.ID,
+			&s.UserID,
+			&s.Key,
+			&s.UserAgent,
+			&s.IPAddress,
+			&s.LastSeenAt,
+			&s.CreatedAt,
+			&s.UpdatedAt,
+		)
+		if err != nil {
+			return sessions, err
+		}
+		sessions = append(sessions, s)
+	}
+
+	if err = rows.Err(); err != nil {
+		return sessions, err
+	}
+	return sessions, nil
+}
+
+// DeleteUserSession signs out one of a user's sessions. It returns sql.ErrNoRows if the user has no
+// session with the id
+func (m *postgresDBRepo) DeleteUserSession(ctx context.Context, userID, id int) error {
+	ctx, done := m.begin(ctx, "DeleteUserSession")
+	defer done()
+
+	result, err := m.DB.ExecContext(ctx, `delete from user_sessions where id = $1 and user_id = $2`, id, userID)
+	if err != nil {
+		return err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// DeleteUserSessions signs a user out everywhere
+func (m *postgresDBRepo) DeleteUserSessions(ctx context.Context, userID int) error {
+	ctx, done := m.begin(ctx, "DeleteUserSessions")
+	defer done()
+
+	_, err := m.DB.ExecContext(ctx, `delete from user_sessions where user_id = $1`, userID)
+	return err
+}
+
+// DeleteBlockForRoom deletes an owner block, provided it still belongs to the room and has not been
+// modified since updatedAt. It returns repository.ErrConflict otherwise
+func (m *postgresDBRepo) DeleteBlockForRoom(ctx context.Context, roomID, id int, updatedAt time.Time) error {
+	ctx, done := m.begin(ctx, "DeleteBlockForRoom")
+	defer done()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var current time.Time
+	query := `select updated_at from room_restrictions
+			where id = $1 and room_id = $2 and reservation_id is null and restriction_id = $3
+			for update`
+	err = tx.QueryRowContext(ctx, query, id, roomID, models.RestrictionOwnerBlock).Scan(&current)
+	if err == sql.ErrNoRows {
+		return repository.ErrConflict
+	} else if err != nil {
+		return err
+	}
+
+	if !current.Equal(updatedAt) {
+		return repository.ErrConflict
+	}
+
+	_, err = tx.ExecContext(ctx, `delete from room_restrictions where id=$1`, id)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// InsertWaitlistEntry adds a guest to the waitlist
+func (m *postgresDBRepo) InsertWaitlistEntry(ctx context.Context, e models.WaitlistEntry) (int, error) {
+	ctx, done := m.begin(ctx, "InsertWaitlistEntry")
+	defer done()
+
+	stmt := `insert into waitlist_entries (email, room_id, start_date, end_date, status, token,
+			created_at, updated_at)
+			values ($1, nullif($2::integer, 0), $3, $4, $5, '', $6, $7) returning id`
+
+	var newID int
+
+	err := m.DB.QueryRowContext(ctx, stmt,
+		e.Email,
+		e.RoomID,
+		e.StartDate,
+		e.EndDate,
+		models.WaitlistWaiting,
+		time.Now(),
+		time.Now(),
+	).Scan(&newID)
+
+	if err != nil {
+		return 0, err
+	}
+	return newID, nil
+}
+
+// AllActiveWaitlistEntries returns waiting and notified waitlist entries, oldest first
+func (m *postgresDBRepo) AllActiveWaitlistEntries(ctx context.Context) ([]models.WaitlistEntry, error) {
+	ctx, done := m.begin(ctx, "AllActiveWaitlistEntries")
+	defer done()
+
+	var entries []models.WaitlistEntry
+
+	query := `
+		select id, email, coalesce(room_id, 0), coalesce(matched_room_id, 0), start_date, end_date,
+		status, token, hold_expires_at, coalesce(hold_id, 0), created_at, updated_at
+		from waitlist_entries
+		where status in ($1, $2)
+		order by created_at asc
+	`
+
+	rows, err := m.DB.QueryContext(ctx, query, models.WaitlistWaiting, models.WaitlistNotified)
+	if err != nil {
+		return entries, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		e, err := scanWaitlistEntry(rows)
+		if err != nil {
+			return entries, err
+		}
+		entries = append(entries, e)
+	}
+
+	if err = rows.Err(); err != nil {
+		return entries, err
+	}
+	return entries, nil
+}
+
+// OfferWaitlistEntry holds e.MatchedRoomID for the guest until e.HoldExpiresAt and records the offer's
+// status, matched room, token and hold on the entry, in one transaction. It returns the hold's id, or
+// repository.ErrNotAvailable if the room has been taken
+func (m *postgresDBRepo) OfferWaitlistEntry(ctx context.Context, e models.WaitlistEntry) (int, error) {
+	ctx, done := m.begin(ctx, "OfferWaitlistEntry")
+	defer done()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	err = lockRoomIfAvailable(ctx, tx, e.MatchedRoomID, e.StartDate, e.EndDate, 0, 0)
+	if err != nil {
+		return 0, err
+	}
+
+	query := `insert into room_restrictions (start_date, end_date, room_id, restriction_id, expires_at,
+			created_at, updated_at) values ($1, $2, $3, $4, $5, $6, $7) returning id`
+
+	var holdID int
+	err = tx.QueryRowContext(ctx, query, e.StartDate, e.EndDate, e.MatchedRoomID, models.RestrictionHold,
+		e.HoldExpiresAt, time.Now(), time.Now()).Scan(&holdID)
+	if err != nil {
+		return 0, err
+	}
+
+	query = `update waitlist_entries set status=$1, matched_room_id=$2, token=$3, hold_expires_at=$4,
+			hold_id=$5, updated_at=$6
+			where id=$7`
+
+	_, err = tx.ExecContext(ctx, query, e.Status, e.MatchedRoomID, e.Token, e.HoldExpiresAt, holdID, time.Now(), e.ID)
+	if err != nil {
+		return 0, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return 0, err
+	}
+	return holdID, nil
+}
+
+// ClaimWaitlistEntry marks a notified waitlist entry claimed, once its guest has booked the room offered.
+// An entry whose hold has already lapsed is left for ExpireWaitlistEntries
+func (m *postgresDBRepo) ClaimWaitlistEntry(ctx context.Context, id int) error {
+	ctx, done := m.begin(ctx, "ClaimWaitlistEntry")
+	defer done()
+
+	query := `update waitlist_entries set status=$1, updated_at=$2
+			where id=$3 and status=$4 and hold_expires_at > $2`
+
+	_, err := m.DB.ExecContext(ctx, query, models.WaitlistClaimed, time.Now(), id, models.WaitlistNotified)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// ExpireWaitlistEntries expires notifications whose hold has lapsed, and entries whose dates have passed,
+// releasing the rooms held for them
+func (m *postgresDBRepo) ExpireWaitlistEntries(ctx context.Context) error {
+	ctx, done := m.begin(ctx, "ExpireWaitlistEntries")
+	defer done()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	expiring := `(status = $1 and hold_expires_at < $2) or (status in ($1, $3) and start_date < current_date)`
+
+	query := `delete from room_restrictions where restriction_id = $4 and id in
+			(select hold_id from waitlist_entries where ` + expiring + `)`
+
+	_, err = tx.ExecContext(ctx, query, models.WaitlistNotified, now, models.WaitlistWaiting, models.RestrictionHold)
+	if err != nil {
+		return err
+	}
+
+	query = `update waitlist_entries set status=$4, updated_at=$2 where ` + expiring
+
+	_, err = tx.ExecContext(ctx, query, models.WaitlistNotified, now, models.WaitlistWaiting, models.WaitlistExpired)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// GetWaitlistEntryByToken returns the waitlist entry a hold link was sent for
+func (m *postgresDBRepo) GetWaitlistEntryByToken(ctx context.Context, token string) (models.WaitlistEntry, error) {
+	ctx, done := m.begin(ctx, "GetWaitlistEntryByToken")
+	defer done()
+
+	query := `
+		select w.id, w.email, coalesce(w.room_id, 0), coalesce(w.matched_room_id, 0), w.start_date, w.end_date,
+		w.status, w.token, w.hold_expires_at, coalesce(w.hold_id, 0), w.created_at, w.updated_at
+		from waitlist_entries w
+		where w.token = $1 and w.token <> ''
+	`
+
+	row := m.DB.QueryRowContext(ctx, query, token)
+	return scanWaitlistEntry(row)
+}
+
+// scanner is implemented by both *sql.Row and *sql.Rows
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanWaitlistEntry scans a waitlist entry selected with the columns used in this file
+func scanWaitlistEntry(row scanner) (models.WaitlistEntry, error) {
+	var e models.WaitlistEntry
+	var holdExpiresAt sql.NullTime
+
+	err := row.Scan(
+		&e.ID,
+		&e.Email,
+		&e.RoomID,
+		&e.MatchedRoomID,
+		&e.StartDate,
+		&e.EndDate,
+		&e.Status,
+		&e.Token,
+		&holdExpiresAt,
+		&e.HoldID,
+		&e.CreatedAt,
+		&e.UpdatedAt,
+	)
+	if err != nil {
+		return e, err
+	}
+
+	if holdExpiresAt.Valid {
+		e.HoldExpiresAt = holdExpiresAt.Time
+	}
+	return e, nil
+}
+
+// InsertHoldForRoom holds a room for a guest who is still booking, until expiresAt. It returns
+// repository.ErrNotAvailable if the room has been taken in the meantime
+func (m *postgresDBRepo) InsertHoldForRoom(ctx context.Context, roomID int, startDate, endDate, expiresAt time.Time) (int, error) {
+	ctx, done := m.begin(ctx, "InsertHoldForRoom")
+	defer done()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	err = lockRoomIfAvailable(ctx, tx, roomID, startDate, endDate, 0, 0)
+	if err != nil {
+		return 0, err
+	}
+
+	query := `insert into room_restrictions (start_date, end_date, room_id, restriction_id, expires_at,
+			created_at, updated_at) values ($1, $2, $3, $4, $5, $6, $7) returning id`
+
+	var newID int
+	err = tx.QueryRowContext(ctx, query, startDate, endDate, roomID, models.RestrictionHold, expiresAt,
+		time.Now(), time.Now()).Scan(&newID)
+	if err != nil {
+		return 0, err
+	}
+
+	return newID, tx.Commit()
+}
+
+// DeleteHold releases a hold
+func (m *postgresDBRepo) DeleteHold(ctx context.Context, id int) error {
+	ctx, done := m.begin(ctx, "DeleteHold")
+	defer done()
+
+	query := `delete from room_restrictions where id = $1 and restriction_id = $2`
+
+	_, err := m.DB.ExecContext(ctx, query, id, models.RestrictionHold)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// DeleteExpiredHolds releases all holds that have expired, and returns how many there were
+func (m *postgresDBRepo) DeleteExpiredHolds(ctx context.Context) (int64, error) {
+	ctx, done := m.begin(ctx, "DeleteExpiredHolds")
+	defer done()
+
+	query := `delete from room_restrictions where restriction_id = $1 and expires_at < $2`
+
+	result, err := m.DB.ExecContext(ctx, query, models.RestrictionHold, time.Now())
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// InsertPendingReservation inserts a reservation waiting for the guest to confirm their email address,
+// and queues the email asking them to, in one transaction
+func (m *postgresDBRepo) InsertPendingReservation(ctx context.Context, p models.PendingReservation, verification models.MailData) (int, error) {
+	ctx, done := m.begin(ctx, "InsertPendingReservation")
+	defer done()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	stmt := `insert into pending_reservations (first_name, last_name, email, phone, start_date, end_date,
+			room_id, token, expires_at, created_at, updated_at)
+			values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $10) returning id`
+
+	res := p.Reservation
+	var newID int
+	err = tx.QueryRowContext(ctx, stmt,
+		res.FirstName,
+		res.LastName,
+		res.Email,
+		res.Phone,
+		res.StartDate,
+		res.EndDate,
+		res.RoomID,
+		p.Token,
+		p.ExpiresAt,
+		time.Now(),
+	).Scan(&newID)
+	if err != nil {
+		return 0, err
+	}
+
+	err = insertOutboxMessage(ctx, tx, verification)
+	if err != nil {
+		return 0, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return 0, err
+	}
+	return newID, nil
+}
+
+// GetPendingReservationByToken returns the pending reservation with the token, with its room
+func (m *postgresDBRepo) GetPendingReservationByToken(ctx context.Context, token string) (models.PendingReservation, error) {
+	ctx, done := m.begin(ctx, "GetPendingReservationByToken")
+	defer done()
+
+	query := `
+		select p.id, p.first_name, p.last_name, p.email, p.phone, p.start_date, p.end_date, p.room_id,
+		p.token, p.expires_at, p.created_at, p.updated_at, r.id, r.room_name
+		from pending_reservations p
+		left join rooms r on (p.room_id = r.id)
+		where p.token = $1
+	`
+
+	var p models.PendingReservation
+	err := m.DB.QueryRowContext(ctx, query, token).Scan(
+		&p.ID,
+		&p.Reservation.FirstName,
+		&p.Reservation.LastName,
+		&p.Reservation.Email,
+		&p.Reservation.Phone,
+		&p.Reservation.StartDate,
+		&p.Reservation.EndDate,
+		&p.Reservation.RoomID,
+		&p.Token,
+		&p.ExpiresAt,
+		&p.CreatedAt,
+		&p.UpdatedAt,
+		&p.Reservation.Room.ID,
+		&p.Reservation.Room.RoomName,
+	)
+	return p, err
+}
+
+// ConfirmPendingReservation turns a pending reservation that hasn't expired into a reservation blocking
+// its room, queueing the messages about it, in one transaction. It returns sql.ErrNoRows if the pending
+// reservation is gone or has expired, so a link followed twice books the room once, and
+// repository.ErrNotAvailable if the room was booked in the meantime
+func (m *postgresDBRepo) ConfirmPendingReservation(ctx context.Context, id int, messages func(id int) ([]models.MailData, error)) (int, error) {
+	ctx, done := m.begin(ctx, "ConfirmPendingReservation")
+	defer done()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	query := `delete from pending_reservations where id = $1 and expires_at > $2
+			returning first_name, last_name, email, phone, start_date, end_date, room_id`
+
+	var res models.Reservation
+	err = tx.QueryRowContext(ctx, query, id, time.Now()).Scan(
+		&res.FirstName,
+		&res.LastName,
+		&res.Email,
+		&res.Phone,
+		&res.StartDate,
+		&res.EndDate,
+		&res.RoomID,
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	err = lockRoomIfAvailable(ctx, tx, res.RoomID, res.StartDate, res.EndDate, 0, 0)
+	if err != nil {
+		return 0, err
+	}
+
+	newID, err := insertReservation(ctx, tx, res, messages)
+	if err != nil {
+		return 0, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return 0, err
+	}
+	return newID, nil
+}
+
+// DeletePendingReservation deletes a pending reservation
+func (m *postgresDBRepo) DeletePendingReservation(ctx context.Context, id int) error {
+	ctx, done := m.begin(ctx, "DeletePendingReservation")
+	defer done()
+
+	_, err := m.DB.ExecContext(ctx, `delete from pending_reservations where id = $1`, id)
+	return err
+}
+
+// DeleteExpiredPendingReservations deletes the pending reservations whose guests didn't confirm them
+// in time, returning how many there were
+func (m *postgresDBRepo) DeleteExpiredPendingReservations(ctx context.Context) (int64, error) {
+	ctx, done := m.begin(ctx, "DeleteExpiredPendingReservations")
+	defer done()
+
+	result, err := m.DB.ExecContext(ctx, `delete from pending_reservations where expires_at < $1`, time.Now())
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// execer is implemented by both *sql.DB and *sql.Tx
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+// insertOutboxMessage queues an email for delivery as soon as possible
+func insertOutboxMessage(ctx context.Context, db execer, msg models.MailData) error {
+	// attachments are kept with the message as JSON, so a claimed message is complete in one row
+	var attachments string
+	if len(msg.Attachments) > 0 {
+		data, err := json.Marshal(msg.Attachments)
+		if err != nil {
+			return err
+		}
+		attachments = string(data)
+	}
+
+	stmt := `insert into outbox_messages (to_address, from_address, subject, content, text_content, template,
+			attachments, status, attempts, last_error, next_attempt_at, created_at, updated_at)
+			values ($1, $2, $3, $4, $5, $6, $7, $8, 0, '', $9, $9, $9)`
+
+	_, err := db.ExecContext(ctx, stmt,
+		msg.To,
+		msg.From,
+		msg.Subject,
+		msg.Content,
+		msg.Text,
+		msg.Template,
+		attachments,
+		models.OutboxQueued,
+		time.Now(),
+	)
+	return err
+}
+
+// InsertOutboxMessage queues an email for delivery
+func (m *postgresDBRepo) InsertOutboxMessage(ctx context.Context, msg models.MailData) error {
+	ctx, done := m.begin(ctx, "InsertOutboxMessage")
+	defer done()
+
+	return insertOutboxMessage(ctx, m.DB, msg)
+}
+
+// ClaimOutboxMessages returns up to limit queued messages that are due for delivery, counting the
+// attempt and hiding them from other workers for the lease. A message whose worker dies is retried
+// once the lease runs out. next_attempt_at is written from the app's clock, so it is compared with
+// time.Now() rather than the database's now()
+func (m *postgresDBRepo) ClaimOutboxMessages(ctx context.Context, limit int, lease time.Duration) ([]models.OutboxMessage, error) {
+	ctx, done := m.begin(ctx, "ClaimOutboxMessages")
+	defer done()
+
+	var messages []models.OutboxMessage
+
+	now := time.Now()
+	query := `update outbox_messages set attempts = attempts + 1, next_attempt_at = $1, updated_at = $4
+			where id in (
+				select id from outbox_messages
+				where status = $2 and next_attempt_at <= $4
+				order by next_attempt_at
+				limit $3
+				for update skip locked
+			)
+			returning id, to_address, from_address, subject, content, text_content, template, attachments, status, attempts,
+				last_error, next_attempt_at, sent_at, created_at, updated_at`
+
+	rows, err := m.DB.QueryContext(ctx, query, now.Add(lease), models.OutboxQueued, limit, now)
+	if err != nil {
+		return messages, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		msg, err := scanOutboxMessage(rows)
+		if err != nil {
+			return messages, err
+		}
+		messages = append(messages, msg)
+	}
+
+	if err = rows.Err(); err != nil {
+		return messages, err
+	}
+	return messages, nil
+}
+
+// UpdateOutboxMessage records the outcome of a delivery attempt
+func (m *postgresDBRepo) UpdateOutboxMessage(ctx context.Context, msg models.OutboxMessage) error {
+	ctx, done := m.begin(ctx, "UpdateOutboxMessage")
+	defer done()
+
+	var sentAt sql.NullTime
+	if !msg.SentAt.IsZero() {
+		sentAt = sql.NullTime{Time: msg.SentAt, Valid: true}
+	}
+
+	stmt := `update outbox_messages set status = $1, attempts = $2, last_error = $3, next_attempt_at = $4,
+			sent_at = $5, updated_at = $6
+			where id = $7`
+
+	_, err := m.DB.ExecContext(ctx, stmt,
+		msg.Status,
+		msg.Attempts,
+		msg.LastError,
+		msg.NextAttemptAt,
+		sentAt,
+		time.Now(),
+		msg.ID,
+	)
+	return err
+}
+
+// AllOutboxMessages returns the most recent outbox messages, optionally only those with the given status
+func (m *postgresDBRepo) AllOutboxMessages(ctx context.Context, status string) ([]models.OutboxMessage, error) {
+	ctx, done := m.begin(ctx, "AllOutboxMessages")
+	defer done()
+
+	var messages []models.OutboxMessage
+
+	query := `select id, to_address, from_address, subject, content, text_content, template, attachments, status, attempts,
+			last_error, next_attempt_at, sent_at, created_at, updated_at
+			from outbox_messages
+			where $1 = '' or status = $1
+			order by created_at desc
+			limit 200`
+
+	rows, err := m.DB.QueryContext(ctx, query, status)
+	if err != nil {
+		return messages, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		msg, err := scanOutboxMessage(rows)
+		if err != nil {
+			return messages, err
+		}
+		messages = append(messages, msg)
+	}
+
+	if err = rows.Err(); err != nil {
+		return messages, err
+	}
+	return messages, nil
+}
+
+// CountOutboxMessages returns how many emails in the outbox have the given status
+func (m *postgresDBRepo) CountOutboxMessages(ctx context.Context, status string) (int, error) {
+	ctx, done := m.begin(ctx, "CountOutboxMessages")
+	defer done()
+
+	var count int
+
+	query := `select count(*) from outbox_messages where status = $1`
+
+	err := m.DB.QueryRowContext(ctx, query, status).Scan(&count)
+	if err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+// ResendOutboxMessage puts a message back in the queue for immediate delivery with a fresh set of attempts
+func (m *postgresDBRepo) ResendOutboxMessage(ctx context.Context, id int) error {
+	ctx, done := m.begin(ctx, "ResendOutboxMessage")
+	defer done()
+
+	stmt := `update outbox_messages set status = $1, attempts = 0, last_error = '', next_attempt_at = $2,
+			sent_at = null, updated_at = $2
+			where id = $3`
+
+	_, err := m.DB.ExecContext(ctx, stmt, models.OutboxQueued, time.Now(), id)
+	return err
+}
+
+// scanOutboxMessage scans an outbox message selected with the columns used in this file
+func scanOutboxMessage(row scanner) (models.OutboxMessage, error) {
+	var msg models.OutboxMessage
+	var sentAt sql.NullTime
+	var attachments string
+
+	err := row.Scan(
+		&msg.ID,
+		&msg.Mail.To,
+		&msg.Mail.From,
+		&msg.Mail.Subject,
+		&msg.Mail.Content,
+		&msg.Mail.Text,
+		&msg.Mail.Template,
+		&attachments,
+		&msg.Status,
+		&msg.Attempts,
+		&msg.LastError,
+		&msg.NextAttemptAt,
+		&sentAt,
+		&msg.CreatedAt,
+		&msg.UpdatedAt,
+	)
+	if err != nil {
+		return msg, err
+	}
+
+	if attachments != "" {
+		err = json.Unmarshal([]byte(attachments), &msg.Mail.Attachments)
+		if err != nil {
+			return msg, err
+		}
+	}
+
+	msg.SentAt = sentAt.Time
+	return msg, nil
+}
+
+// scanUser scans a user selected with the columns used in this file
+func scanUser(row scanner) (models.User, error) {
+	var u models.User
+	err := row.Scan(
+		&u.ID,
+		&u.FirstName,
+		&u.LastName,
+		&u.Email,
+		&u.Password,
+		&u.AccessLevel,
+		&u.NotifyNewBooking,
+		&u.NotifyCancellation,
+		&u.NotifyModification,
+		&u.NotifyBlockChange,
+		&u.NotifyDigest,
+		&u.Active,
+		&u.CreatedAt,
+		&u.UpdatedAt,
+	)
+	return u, err
+}
+
+// AllNotificationRules returns every notification rule
+func (m *postgresDBRepo) AllNotificationRules(ctx context.Context) ([]models.NotificationRule, error) {
+	ctx, done := m.begin(ctx, "AllNotificationRules")
+	defer done()
+
+	var rules []models.NotificationRule
+
+	query := `select id, event, address, access_level, digest, created_at, updated_at
+			from notification_rules order by event, id`
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return rules, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var r models.NotificationRule
+		err := rows.Scan(&r.ID, &r.Event, &r.Address, &r.AccessLevel, &r.Digest, &r.CreatedAt, &r.UpdatedAt)
+		if err != nil {
+			return rules, err
+		}
+		rules = append(rules, r)
+	}
+
+	if err = rows.Err(); err != nil {
+		return rules, err
+	}
+	return rules, nil
+}
+
+// InsertNotificationRule adds a notification rule
+func (m *postgresDBRepo) InsertNotificationRule(ctx context.Context, rule models.NotificationRule) error {
+	ctx, done := m.begin(ctx, "InsertNotificationRule")
+	defer done()
+
+	stmt := `insert into notification_rules (event, address, access_level, digest, created_at, updated_at)
+			values ($1, $2, $3, $4, $5, $5)`
+
+	_, err := m.DB.ExecContext(ctx, stmt, rule.Event, rule.Address, rule.AccessLevel, rule.Digest, time.Now())
+	return err
+}
+
+// DeleteNotificationRule removes a notification rule
+func (m *postgresDBRepo) DeleteNotificationRule(ctx context.Context, id int) error {
+	ctx, done := m.begin(ctx, "DeleteNotificationRule")
+	defer done()
+
+	_, err := m.DB.ExecContext(ctx, `delete from notification_rules where id = $1`, id)
+	return err
+}
+
+// UsersByAccessLevel returns the active users with an access level
+func (m *postgresDBRepo) UsersByAccessLevel(ctx context.Context, accessLevel int) ([]models.User, error) {
+	ctx, done := m.begin(ctx, "UsersByAccessLevel")
+	defer done()
+
+	var users []models.User
+
+	query := `select id, first_name, last_name, email, password, access_level, notify_new_booking,
+		notify_cancellation, notify_modification, notify_block_change, notify_digest, active, created_at, updated_at
+		from users where access_level = $1 and active order by id`
+
+	rows, err := m.DB.QueryContext(ctx, query, accessLevel)
+	if err != nil {
+		return users, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		u, err := scanUser(rows)
+		if err != nil {
+			return users, err
+		}
+		users = append(users, u)
+	}
+
+	if err = rows.Err(); err != nil {
+		return users, err
+	}
+	return users, nil
+}
+
+// InsertDigestItem saves an event for a recipient's next daily digest
+func (m *postgresDBRepo) InsertDigestItem(ctx context.Context, item models.DigestItem) error {
+	ctx, done := m.begin(ctx, "InsertDigestItem")
+	defer done()
+
+	stmt := `insert into digest_items (recipient, event, summary, created_at, updated_at)
+			values ($1, $2, $3, $4, $4)`
+
+	_, err := m.DB.ExecContext(ctx, stmt, item.Recipient, item.Event, item.Summary, time.Now())
+	return err
+}
+
+// PendingDigestItems returns the digest items not yet sent, by recipient and then oldest first
+func (m *postgresDBRepo) PendingDigestItems(ctx context.Context) ([]models.DigestItem, error) {
+	ctx, done := m.begin(ctx, "PendingDigestItems")
+	defer done()
+
+	var items []models.DigestItem
+
+	query := `select id, recipient, event, summary, created_at, updated_at
+			from digest_items where sent_at is null order by recipient, id`
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return items, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var i models.DigestItem
+		err := rows.Scan(&i.ID, &i.Recipient, &i.Event, &i.Summary, &i.CreatedAt, &i.UpdatedAt)
+		if err != nil {
+			return items, err
+		}
+		items = append(items, i)
+	}
+
+	if err = rows.Err(); err != nil {
+		return items, err
+	}
+	return items, nil
+}
+
+// MarkDigestItemsSent marks a recipient's digest items up to and including upToID as sent
+func (m *postgresDBRepo) MarkDigestItemsSent(ctx context.Context, recipient string, upToID int) error {
+	ctx, done := m.begin(ctx, "MarkDigestItemsSent")
+	defer done()
+
+	stmt := `update digest_items set sent_at = $1, updated_at = $1
+			where recipient = $2 and id <= $3 and sent_at is null`
+
+	_, err := m.DB.ExecContext(ctx, stmt, time.Now(), recipient, upToID)
+	return err
+}
+
+// ClaimDigestRun records that the digest for date is going out, returning false if it already has, so
+// the digest is sent once a day however often the app checks or restarts
+func (m *postgresDBRepo) ClaimDigestRun(ctx context.Context, date time.Time) (bool, error) {
+	ctx, done := m.begin(ctx, "ClaimDigestRun")
+	defer done()
+
+	stmt := `insert into digest_runs (digest_date, created_at) values ($1, $2) on conflict do nothing`
+
+	result, err := m.DB.ExecContext(ctx, stmt, date, time.Now())
+	if err != nil {
+		return false, err
+	}
+	claimed, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return claimed > 0, nil
+}
+
+// AllScheduledEmails returns every scheduled email
+func (m *postgresDBRepo) AllScheduledEmails(ctx context.Context) ([]models.ScheduledEmail, error) {
+	ctx, done := m.begin(ctx, "AllScheduledEmails")
+	defer done()
+
+	var emails []models.ScheduledEmail
+
+	query := `select id, name, description, anchor, offset_days, subject, html_body, text_body, active,
+			created_at, updated_at
+			from scheduled_emails order by anchor desc, offset_days, id`
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return emails, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		e, err := scanScheduledEmail(rows)
+		if err != nil {
+			return emails, err
+		}
+		emails = append(emails, e)
+	}
+
+	if err = rows.Err(); err != nil {
+		return emails, err
+	}
+	return emails, nil
+}
+
+// GetScheduledEmailByID returns a scheduled email by id
+func (m *postgresDBRepo) GetScheduledEmailByID(ctx context.Context, id int) (models.ScheduledEmail, error) {
+	ctx, done := m.begin(ctx, "GetScheduledEmailByID")
+	defer done()
+
+	query := `select id, name, description, anchor, offset_days, subject, html_body, text_body, active,
+			created_at, updated_at
+			from scheduled_emails where id = $1`
+
+	return scanScheduledEmail(m.DB.QueryRowContext(ctx, query, id))
+}
+
+// UpdateScheduledEmail saves the timing, templates and state of a scheduled email
+func (m *postgresDBRepo) UpdateScheduledEmail(ctx context.Context, e models.ScheduledEmail) error {
+	ctx, done := m.begin(ctx, "UpdateScheduledEmail")
+	defer done()
+
+	stmt := `update scheduled_emails set anchor = $1, offset_days = $2, subject = $3, html_body = $4,
+			text_body = $5, active = $6, updated_at = $7
+			where id = $8`
+
+	_, err := m.DB.ExecContext(ctx, stmt,
+		e.Anchor,
+		e.OffsetDays,
+		e.Subject,
+		e.HTMLBody,
+		e.TextBody,
+		e.Active,
+		time.Now(),
+		e.ID,
+	)
+	return err
+}
+
+// ReservationsDueForScheduledEmail returns the reservations a scheduled email falls due for between two
+// days inclusive, leaving out those it was already sent for
+func (m *postgresDBRepo) ReservationsDueForScheduledEmail(ctx context.Context, e models.ScheduledEmail, from, to time.Time) ([]models.Reservation, error) {
+	ctx, done := m.begin(ctx, "ReservationsDueForScheduledEmail")
+	defer done()
+
+	var reservations []models.Reservation
+
+	// the anchor picks a column, so it is checked rather than interpolated from the caller
+	var anchor string
+	switch e.Anchor {
+	case models.AnchorStartDate:
+		anchor = "r.start_date"
+	case models.AnchorEndDate:
+		anchor = "r.end_date"
+	default:
+		return reservations, fmt.Errorf("unknown scheduled email anchor %q", e.Anchor)
+	}
+
+	query := fmt.Sprintf(`
+		select r.id, r.first_name, r.last_name, r.email, r.phone, r.start_date, r.end_date,
+		r.room_id, r.created_at, r.updated_at, r.processed,
+		rm.id, rm.room_name
+		from reservations r
+		left join rooms rm on r.room_id = rm.id
+		where %[1]s + $1::integer between $2::date and $3::date
+		and not exists (
+			select 1 from scheduled_email_sends s
+			where s.scheduled_email_id = $4 and s.reservation_id = r.id
+		)
+		order by %[1]s, r.id`, anchor)
+
+	rows, err := m.DB.QueryContext(ctx, query, e.OffsetDays, from, to, e.ID)
+	if err != nil {
+		return reservations, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var i models.Reservation
+		err := rows.Scan(
+			&i.ID,
+			&i.FirstName,
+			&i.LastName,
+			&i.Email,
+			&i.Phone,
+			&i.StartDate,
+			&i.EndDate,
+			&i.RoomID,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.Processed,
+			&i.Room.ID,
+			&i.Room.RoomName,
+		)
+		if err != nil {
+			return reservations, err
+		}
+		reservations = append(reservations, i)
+	}
+
+	if err = rows.Err(); err != nil {
+		return reservations, err
+	}
+	return reservations, nil
+}
+
+// QueueScheduledEmail records that a scheduled email was sent for a reservation and queues the message,
+// in one transaction. It queues nothing and returns false if the email was already sent for the reservation
+func (m *postgresDBRepo) QueueScheduledEmail(ctx context.Context, emailID, reservationID int, msg models.MailData) (bool, error) {
+	ctx, done := m.begin(ctx, "QueueScheduledEmail")
+	defer done()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	stmt := `insert into scheduled_email_sends (scheduled_email_id, reservation_id, created_at, updated_at)
+			values ($1, $2, $3, $3)
+			on conflict (scheduled_email_id, reservation_id) do nothing`
+
+	result, err := tx.ExecContext(ctx, stmt, emailID, reservationID, time.Now())
+	if err != nil {
+		return false, err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	if rows == 0 {
+		return false, nil
+	}
+
+	err = insertOutboxMessage(ctx, tx, msg)
+	if err != nil {
+		return false, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// scanScheduledEmail scans a scheduled email from a row
+func scanScheduledEmail(row scanner) (models.ScheduledEmail, error) {
+	var e models.ScheduledEmail
+	err := row.Scan(
+		&e.ID,
+		&e.Name,
+		&e.Description,
+		&e.Anchor,
+		&e.OffsetDays,
+		&e.Subject,
+		&e.HTMLBody,
+		&e.TextBody,
+		&e.Active,
+		&e.CreatedAt,
+		&e.UpdatedAt,
+	)
+	return e, err
 }
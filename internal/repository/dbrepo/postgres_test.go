@@ -0,0 +1,77 @@
+package dbrepo
+
+import (
+	"context"
+	"fmt"
+	"github.com/usmanzaheer1995/bed-and-breakfast/internal/config"
+	"github.com/usmanzaheer1995/bed-and-breakfast/internal/driver"
+	"github.com/usmanzaheer1995/bed-and-breakfast/internal/models"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestPostgresDBRepo_ClaimOutboxMessagesSkewedClock(t *testing.T) {
+	dsn := os.Getenv(config.EnvPrefix + "DSN")
+	if dsn == "" {
+		t.Skip("no database configured, set BNB_DSN to run")
+	}
+
+	db, err := driver.NewDatabase(dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	// one connection, so the database's clock runs hours away from the app's in every query
+	db.SetMaxOpenConns(1)
+	zone := "Pacific/Kiritimati"
+	if _, offset := time.Now().Zone(); offset > 0 {
+		zone = "Pacific/Pago_Pago"
+	}
+	_, err = db.Exec(fmt.Sprintf("set time zone '%s'", zone))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	repo := NewPostgresRepo(&config.AppConfig{}, db)
+	ctx := context.Background()
+
+	subject := fmt.Sprintf("skewed clock test %d", time.Now().UnixNano())
+	err = repo.InsertOutboxMessage(ctx, models.MailData{To: "john@smith.com", From: "me@here.com", Subject: subject})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var id int
+	err = db.QueryRow(`select id from outbox_messages where subject = $1`, subject).Scan(&id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Exec(`delete from outbox_messages where id = $1`, id)
+
+	claimed := func(due time.Time) bool {
+		_, err := db.Exec(`update outbox_messages set next_attempt_at = $1 where id = $2`, due, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		messages, err := repo.ClaimOutboxMessages(ctx, 1000, time.Minute)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, msg := range messages {
+			if msg.ID == id {
+				return true
+			}
+		}
+		return false
+	}
+
+	if claimed(time.Now().Add(time.Hour)) {
+		t.Error("expected a message due in an hour not to be claimed")
+	}
+	if !claimed(time.Now().Add(-time.Minute)) {
+		t.Error("expected a message due a minute ago to be claimed")
+	}
+}
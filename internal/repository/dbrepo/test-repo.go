@@ -1,16 +1,27 @@
 package dbrepo
 
 import (
+	"context"
+	"database/sql"
 	"errors"
 	"github.com/usmanzaheer1995/bed-and-breakfast/internal/models"
+	"github.com/usmanzaheer1995/bed-and-breakfast/internal/repository"
 	"time"
 )
 
-func (t *testDBRepo) AllUsers() bool {
+func (t *testDBRepo) AllUsers(ctx context.Context) bool {
 	return true
 }
 
-func (t *testDBRepo) InsertReservation(res models.Reservation) (int, error) {
+func (t *testDBRepo) Ping(ctx context.Context) error {
+	return ctx.Err()
+}
+
+func (t *testDBRepo) InsertReservation(ctx context.Context, res models.Reservation) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
 	// if the room id is 2 then fail
 	if res.RoomID == 2 {
 		return 0, errors.New("some error")
@@ -18,27 +29,90 @@ func (t *testDBRepo) InsertReservation(res models.Reservation) (int, error) {
 	return 1, nil
 }
 
-func (t *testDBRepo) InsertRoomRestriction(r models.RoomRestriction) error {
+func (t *testDBRepo) InsertRoomRestriction(ctx context.Context, r models.RoomRestriction) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
 	if r.RoomID == 1000 {
 		return errors.New("some error")
 	}
 	return nil
 }
 
-func (t *testDBRepo) SearchAvailabilityByDatesByRoomID(start, end time.Time, roomId int) (bool, error) {
+func (t *testDBRepo) InsertReservationWithOutbox(ctx context.Context, res models.Reservation, holdID int, messages func(id int) ([]models.MailData, error)) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	// room 2 has been taken, unless hold 1 keeps it for the guest, and then inserting the reservation
+	// fails. If the room id is 1000 fail inserting the restriction
+	if res.RoomID == 2 && holdID != 1 {
+		return 0, repository.ErrNotAvailable
+	}
+	if res.RoomID == 2 || res.RoomID == 1000 {
+		return 0, errors.New("some error")
+	}
+
+	msgs, err := messages(1)
+	if err != nil {
+		return 0, err
+	}
+	for _, msg := range msgs {
+		_ = t.InsertOutboxMessage(ctx, msg)
+	}
+	return 1, nil
+}
+
+func (t *testDBRepo) SearchAvailabilityByDatesByRoomID(ctx context.Context, start, end time.Time, roomId int) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+
 	if roomId == 1000 {
 		return false, errors.New("my error")
 	}
+	// only room 1 is ever free
+	if roomId == 1 {
+		return true, nil
+	}
 	return false, nil
 }
 
-func (t *testDBRepo) SearchAvailabilityForAllRooms(start, end time.Time) ([]models.Room, error) {
+func (t *testDBRepo) SearchAvailabilityForAllRooms(ctx context.Context, start, end time.Time) ([]models.Room, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
 	var room []models.Room
 
 	return room, nil
 }
 
-func (t *testDBRepo) GetRoomByID(id int) (models.Room, error) {
+func (t *testDBRepo) SearchFlexibleAvailability(ctx context.Context, windowStart, windowEnd time.Time, nights int) ([]models.RoomAvailability, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	var available []models.RoomAvailability
+
+	// room 1 is free on every other arrival date, room 2 is always booked
+	for d := windowStart; !d.AddDate(0, 0, nights).After(windowEnd); d = d.AddDate(0, 0, 2) {
+		available = append(available, models.RoomAvailability{
+			Room:      models.Room{ID: 1, RoomName: "General's Quarters"},
+			StartDate: d,
+			EndDate:   d.AddDate(0, 0, nights),
+		})
+	}
+
+	return available, nil
+}
+
+func (t *testDBRepo) GetRoomByID(ctx context.Context, id int) (models.Room, error) {
+	if err := ctx.Err(); err != nil {
+		return models.Room{}, err
+	}
+
 	var room models.Room
 
 	if id > 2 {
@@ -48,66 +122,866 @@ func (t *testDBRepo) GetRoomByID(id int) (models.Room, error) {
 	return room, nil
 }
 
-func (t *testDBRepo) GetUserByID(id int) (models.User, error) {
+func (t *testDBRepo) GetUserByID(ctx context.Context, id int) (models.User, error) {
+	if err := ctx.Err(); err != nil {
+		return models.User{}, err
+	}
+
 	var u models.User
 
 	return u, nil
 }
 
-func (t *testDBRepo) UpdateUser(u models.User) error {
+func (t *testDBRepo) GetUserByEmail(ctx context.Context, email string) (models.User, error) {
+	if err := ctx.Err(); err != nil {
+		return models.User{}, err
+	}
+
+	// only the admin exists
+	if email != "admin@admin.com" {
+		return models.User{}, sql.ErrNoRows
+	}
+	return models.User{ID: 1, FirstName: "Admin", LastName: "User", Email: email, AccessLevel: 3, Active: true}, nil
+}
+
+func (t *testDBRepo) InsertUser(ctx context.Context, u models.User, password string) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	if u.Email == "admin@admin.com" {
+		return 0, repository.ErrEmailTaken
+	}
+	return 2, nil
+}
+
+func (t *testDBRepo) UpdatePassword(ctx context.Context, id int, password string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if id != 1 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+func (t *testDBRepo) InsertUserSession(ctx context.Context, s models.UserSession) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	if s.UserID == 1000 {
+		return 0, errors.New("some error")
+	}
+	return 1, nil
+}
+
+func (t *testDBRepo) GetUserSessionByKey(ctx context.Context, key string) (models.UserSession, error) {
+	if err := ctx.Err(); err != nil {
+		return models.UserSession{}, err
+	}
+
+	switch key {
+	case "signed-out":
+		return models.UserSession{}, sql.ErrNoRows
+	case "broken":
+		return models.UserSession{}, errors.New("some error")
+	case "stale":
+		return models.UserSession{ID: 2, UserID: 1, Key: key, LastSeenAt: time.Now().Add(-time.Hour)}, nil
+	}
+	return models.UserSession{ID: 1, UserID: 1, Key: key, LastSeenAt: time.Now()}, nil
+}
+
+func (t *testDBRepo) TouchUserSession(ctx context.Context, id int, ipAddress string, seen time.Time) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
 	return nil
 }
 
-func (t *testDBRepo) Authenticate(email, testPassword string) (int, string, error) {
+func (t *testDBRepo) UserSessions(ctx context.Context, userID int) ([]models.UserSession, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	if userID == 1000 {
+		return nil, errors.New("some error")
+	}
+
+	// signed in here and on a phone
+	return []models.UserSession{
+		{ID: 1, UserID: userID, Key: "current", UserAgent: "Mozilla/5.0 (Windows NT 10.0; Win64; x64) Firefox/89.0",
+			IPAddress: "127.0.0.1", LastSeenAt: time.Now()},
+		{ID: 2, UserID: userID, Key: "phone", UserAgent: "Mozilla/5.0 (iPhone; CPU iPhone OS 14_6 like Mac OS X) Safari/604.1",
+			IPAddress: "10.0.0.2", LastSeenAt: time.Now().Add(-time.Hour)},
+	}, nil
+}
+
+func (t *testDBRepo) DeleteUserSession(ctx context.Context, userID, id int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	switch id {
+	case 1000:
+		return errors.New("some error")
+	case 99:
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+func (t *testDBRepo) DeleteUserSessions(ctx context.Context, userID int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if userID == 1000 {
+		return errors.New("some error")
+	}
+	return nil
+}
+
+func (t *testDBRepo) UpdateUser(ctx context.Context, u models.User) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (t *testDBRepo) Authenticate(ctx context.Context, email, testPassword string) (int, string, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, "", err
+	}
+
 	return 1, "", nil
 }
 
-func (t *testDBRepo) AllReservations() ([]models.Reservation, error) {
+func (t *testDBRepo) AllReservations(ctx context.Context) ([]models.Reservation, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
 	var reservations []models.Reservation
 
 	return reservations, nil
 }
 
-func (t *testDBRepo) AllNewReservations() ([]models.Reservation, error) {
+func (t *testDBRepo) AllNewReservations(ctx context.Context) ([]models.Reservation, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
 	var reservations []models.Reservation
 
 	return reservations, nil
 }
 
-func (t *testDBRepo) GetReservationByID(id int) (models.Reservation, error) {
-	var res models.Reservation
+func (t *testDBRepo) GetReservationByID(ctx context.Context, id int) (models.Reservation, error) {
+	if err := ctx.Err(); err != nil {
+		return models.Reservation{}, err
+	}
+
+	res := models.Reservation{
+		ID:        id,
+		FirstName: "John",
+		LastName:  "Smith",
+		Email:     "john@smith.com",
+		StartDate: time.Date(2050, 1, 1, 0, 0, 0, 0, time.UTC),
+		EndDate:   time.Date(2050, 1, 2, 0, 0, 0, 0, time.UTC),
+		RoomID:    1,
+		Room:      models.Room{ID: 1, RoomName: "General's Quarters"},
+	}
 
 	return res, nil
 }
 
-func (t *testDBRepo) UpdateReservation(u models.Reservation) error {
+func (t *testDBRepo) UpdateReservation(ctx context.Context, u models.Reservation) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
 	return nil
 }
 
-func (t *testDBRepo) DeleteReservation(id int) error {
+func (t *testDBRepo) DeleteReservation(ctx context.Context, id int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
 	return nil
 }
 
-func (t *testDBRepo) UpdateProcessedReservation(id, processed int) error {
+func (t *testDBRepo) UpdateProcessedReservation(ctx context.Context, id, processed int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
 	return nil
 }
 
-func (t *testDBRepo) AllRooms() ([]models.Room, error) {
+func (t *testDBRepo) AllRooms(ctx context.Context) ([]models.Room, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
 	var rooms []models.Room
 
 	return rooms, nil
 }
 
-func (t *testDBRepo) GetRestrictionsForRoomByDay(roomID int, startDate, endDate time.Time) ([]models.RoomRestriction, error) {
+func (t *testDBRepo) GetRestrictionsForRoomByDay(ctx context.Context, roomID int, startDate, endDate time.Time) ([]models.RoomRestriction, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
 	var restrictions []models.RoomRestriction
 
 	return restrictions, nil
 }
 
-func (t *testDBRepo) InsertBlockForRoom(id int, startDate time.Time) error {
+func (t *testDBRepo) GetRestrictionsByDateRange(ctx context.Context, startDate, endDate time.Time) ([]models.RoomRestriction, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	var restrictions []models.RoomRestriction
+
+	return restrictions, nil
+}
+
+func (t *testDBRepo) UpdateReservationDates(ctx context.Context, res models.Reservation, version time.Time) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	// if the reservation id is 1000, pretend another admin changed it
+	if res.ID == 1000 {
+		return repository.ErrConflict
+	}
+	// if the room id is 1000, pretend the room is taken
+	if res.RoomID == 1000 {
+		return repository.ErrNotAvailable
+	}
+	return nil
+}
+
+func (t *testDBRepo) InsertBlockForRoom(ctx context.Context, id int, startDate time.Time) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	// if the room id is 1000, pretend another admin already used the day
+	if id == 1000 {
+		return repository.ErrConflict
+	}
+	return nil
+}
+
+func (t *testDBRepo) InsertBlocksForRoom(ctx context.Context, id int, startDate, endDate time.Time) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	// if the room id is 1000, pretend the room is taken
+	if id == 1000 {
+		return repository.ErrNotAvailable
+	}
+	return nil
+}
+
+func (t *testDBRepo) DeleteBlockForRoom(ctx context.Context, roomID, id int, updatedAt time.Time) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	// if the block id is 1000, pretend another admin changed it
+	if id == 1000 {
+		return repository.ErrConflict
+	}
+	return nil
+}
+
+func (t *testDBRepo) RepairReservationRestriction(ctx context.Context, id int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	// if the reservation id is 1000, pretend the room is taken
+	if id == 1000 {
+		return repository.ErrNotAvailable
+	}
+	return nil
+}
+
+func (t *testDBRepo) DeleteOrphanedRestriction(ctx context.Context, id int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	// if the restriction id is 1000, pretend its reservation exists after all
+	if id == 1000 {
+		return repository.ErrConflict
+	}
+	return nil
+}
+
+func (t *testDBRepo) InsertWaitlistEntry(ctx context.Context, e models.WaitlistEntry) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	// if the room id is 1000 then fail
+	if e.RoomID == 1000 {
+		return 0, errors.New("some error")
+	}
+	return 1, nil
+}
+
+func (t *testDBRepo) AllActiveWaitlistEntries(ctx context.Context) ([]models.WaitlistEntry, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	entries := []models.WaitlistEntry{
+		{
+			ID:        1,
+			Email:     "john@smith.com",
+			RoomID:    1,
+			StartDate: time.Now().AddDate(0, 0, 10),
+			EndDate:   time.Now().AddDate(0, 0, 12),
+			Status:    models.WaitlistWaiting,
+		},
+	}
+
+	return entries, nil
+}
+
+func (t *testDBRepo) OfferWaitlistEntry(ctx context.Context, e models.WaitlistEntry) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	// if the room id is 1000, pretend the room is taken
+	if e.MatchedRoomID == 1000 {
+		return 0, repository.ErrNotAvailable
+	}
+	return 1, nil
+}
+
+func (t *testDBRepo) ExpireWaitlistEntries(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (t *testDBRepo) GetWaitlistEntryByToken(ctx context.Context, token string) (models.WaitlistEntry, error) {
+	if err := ctx.Err(); err != nil {
+		return models.WaitlistEntry{}, err
+	}
+
+	e := models.WaitlistEntry{
+		ID:            1,
+		Email:         "john@smith.com",
+		MatchedRoomID: 1,
+		StartDate:     time.Now().AddDate(0, 0, 10),
+		EndDate:       time.Now().AddDate(0, 0, 12),
+		Status:        models.WaitlistNotified,
+		Token:         token,
+		HoldExpiresAt: time.Now().Add(time.Hour),
+		HoldID:        1,
+	}
+
+	switch token {
+	case "valid":
+		return e, nil
+	case "expired":
+		e.HoldExpiresAt = time.Now().Add(-time.Hour)
+		return e, nil
+	case "unavailable":
+		// the hold was released, and someone else booked the room
+		e.MatchedRoomID = 1000
+		e.HoldID = 0
+		return e, nil
+	}
+	return e, errors.New("no rows in result set")
+}
+
+func (t *testDBRepo) ClaimWaitlistEntry(ctx context.Context, id int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	// if the entry id is 1000 then fail
+	if id == 1000 {
+		return errors.New("some error")
+	}
+	return nil
+}
+
+func (t *testDBRepo) InsertHoldForRoom(ctx context.Context, roomID int, startDate, endDate, expiresAt time.Time) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	// if the room id is 1000, pretend the room is taken
+	if roomID == 1000 {
+		return 0, repository.ErrNotAvailable
+	}
+	return 1, nil
+}
+
+func (t *testDBRepo) DeleteHold(ctx context.Context, id int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (t *testDBRepo) DeleteExpiredHolds(ctx context.Context) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	return 1, nil
+}
+
+func (t *testDBRepo) InsertPendingReservation(ctx context.Context, p models.PendingReservation, verification models.MailData) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	// if the room id is 1000, fail inserting the pending reservation
+	if p.Reservation.RoomID == 1000 {
+		return 0, errors.New("some error")
+	}
+
+	_ = t.InsertOutboxMessage(ctx, verification)
+	return 1, nil
+}
+
+func (t *testDBRepo) GetPendingReservationByToken(ctx context.Context, token string) (models.PendingReservation, error) {
+	if err := ctx.Err(); err != nil {
+		return models.PendingReservation{}, err
+	}
+
+	p := models.PendingReservation{
+		ID: 1,
+		Reservation: models.Reservation{
+			FirstName: "John",
+			LastName:  "Smith",
+			Email:     "john@smith.com",
+			StartDate: time.Now().AddDate(0, 0, 10),
+			EndDate:   time.Now().AddDate(0, 0, 12),
+			RoomID:    1,
+			Room:      models.Room{ID: 1, RoomName: "General's Quarters"},
+		},
+		Token:     token,
+		ExpiresAt: time.Now().Add(time.Hour),
+	}
+
+	switch token {
+	case "valid":
+		return p, nil
+	case "expired":
+		p.ExpiresAt = time.Now().Add(-time.Hour)
+		return p, nil
+	case "taken":
+		// only room 1 is ever free
+		p.ID = 2
+		p.Reservation.RoomID = 2
+		p.Reservation.Room = models.Room{ID: 2, RoomName: "Major's Suite"}
+		return p, nil
+	case "confirmed":
+		// confirmed in another tab since it was looked up
+		p.ID = 99
+		return p, nil
+	case "broken":
+		p.ID = 1000
+		return p, nil
+	}
+	return models.PendingReservation{}, sql.ErrNoRows
+}
+
+func (t *testDBRepo) ConfirmPendingReservation(ctx context.Context, id int, messages func(id int) ([]models.MailData, error)) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	// id 2's room has been booked, id 99 has already been confirmed, and id 1000 fails
+	switch id {
+	case 2:
+		return 0, repository.ErrNotAvailable
+	case 99:
+		return 0, sql.ErrNoRows
+	case 1000:
+		return 0, errors.New("some error")
+	}
+
+	msgs, err := messages(1)
+	if err != nil {
+		return 0, err
+	}
+	for _, msg := range msgs {
+		_ = t.InsertOutboxMessage(ctx, msg)
+	}
+	return 1, nil
+}
+
+func (t *testDBRepo) DeletePendingReservation(ctx context.Context, id int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (t *testDBRepo) DeleteExpiredPendingReservations(ctx context.Context) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	return 1, nil
+}
+
+func (t *testDBRepo) InsertOutboxMessage(ctx context.Context, msg models.MailData) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.outbox = append(t.outbox, models.OutboxMessage{
+		ID:            len(t.outbox) + 1,
+		Mail:          msg,
+		Status:        models.OutboxQueued,
+		NextAttemptAt: time.Now(),
+	})
+	return nil
+}
+
+func (t *testDBRepo) ClaimOutboxMessages(ctx context.Context, limit int, lease time.Duration) ([]models.OutboxMessage, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var messages []models.OutboxMessage
+	for i := range t.outbox {
+		msg := &t.outbox[i]
+		if len(messages) == limit {
+			break
+		}
+		if msg.Status != models.OutboxQueued || msg.NextAttemptAt.After(time.Now()) {
+			continue
+		}
+		msg.Attempts++
+		msg.NextAttemptAt = time.Now().Add(lease)
+		messages = append(messages, *msg)
+	}
+	return messages, nil
+}
+
+func (t *testDBRepo) UpdateOutboxMessage(ctx context.Context, msg models.OutboxMessage) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for i := range t.outbox {
+		if t.outbox[i].ID == msg.ID {
+			t.outbox[i] = msg
+		}
+	}
+	return nil
+}
+
+func (t *testDBRepo) AllOutboxMessages(ctx context.Context, status string) ([]models.OutboxMessage, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	messages := []models.OutboxMessage{
+		{ID: 1, Mail: models.MailData{To: "john@smith.com", Subject: "Queued"}, Status: models.OutboxQueued, Attempts: 2, LastError: "connection refused"},
+		{ID: 2, Mail: models.MailData{To: "john@smith.com", Subject: "Sent"}, Status: models.OutboxSent, Attempts: 1, SentAt: time.Now()},
+		{ID: 3, Mail: models.MailData{To: "john@smith.com", Subject: "Failed"}, Status: models.OutboxFailed, Attempts: 8, LastError: "connection refused"},
+	}
+
+	if status == "" {
+		return messages, nil
+	}
+
+	var filtered []models.OutboxMessage
+	for _, msg := range messages {
+		if msg.Status == status {
+			filtered = append(filtered, msg)
+		}
+	}
+	return filtered, nil
+}
+
+func (t *testDBRepo) CountOutboxMessages(ctx context.Context, status string) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	messages, err := t.AllOutboxMessages(ctx, status)
+	return len(messages), err
+}
+
+func (t *testDBRepo) ResendOutboxMessage(ctx context.Context, id int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if id == 1000 {
+		return errors.New("some error")
+	}
+	return nil
+}
+
+func (t *testDBRepo) AllNotificationRules(ctx context.Context) ([]models.NotificationRule, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	rules := []models.NotificationRule{
+		{ID: 1, Event: models.NotifyNewBooking, AccessLevel: 3},
+		{ID: 2, Event: models.NotifyNewBooking, Address: "bookings@here.com", Digest: true},
+		{ID: 3, Event: models.NotifyCancellation, Address: "me@here.com"},
+	}
+	return rules, nil
+}
+
+func (t *testDBRepo) InsertNotificationRule(ctx context.Context, rule models.NotificationRule) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if rule.Address == "fail@here.com" {
+		return errors.New("some error")
+	}
+	return nil
+}
+
+func (t *testDBRepo) DeleteNotificationRule(ctx context.Context, id int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if id == 1000 {
+		return errors.New("some error")
+	}
+	return nil
+}
+
+func (t *testDBRepo) UsersByAccessLevel(ctx context.Context, accessLevel int) ([]models.User, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	var users []models.User
+
+	// one admin opted in to new bookings, one did not
+	if accessLevel == 3 {
+		users = append(users,
+			models.User{ID: 1, Email: "me@here.com", AccessLevel: 3, NotifyNewBooking: true},
+			models.User{ID: 2, Email: "quiet@here.com", AccessLevel: 3},
+		)
+	}
+	return users, nil
+}
+
+func (t *testDBRepo) InsertDigestItem(ctx context.Context, item models.DigestItem) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	item.ID = len(t.digest) + 1
+	t.digest = append(t.digest, item)
+	return nil
+}
+
+func (t *testDBRepo) PendingDigestItems(ctx context.Context) ([]models.DigestItem, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var items []models.DigestItem
+	for _, i := range t.digest {
+		if i.SentAt.IsZero() {
+			items = append(items, i)
+		}
+	}
+	return items, nil
+}
+
+func (t *testDBRepo) MarkDigestItemsSent(ctx context.Context, recipient string, upToID int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for i := range t.digest {
+		if t.digest[i].Recipient == recipient && t.digest[i].ID <= upToID {
+			t.digest[i].SentAt = time.Now()
+		}
+	}
 	return nil
 }
 
-func (t *testDBRepo) DeleteBlockForRoom(id int) error {
+func (t *testDBRepo) ClaimDigestRun(ctx context.Context, date time.Time) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.digestRuns == nil {
+		t.digestRuns = make(map[string]bool)
+	}
+	day := date.Format("2006-01-02")
+	if t.digestRuns[day] {
+		return false, nil
+	}
+	t.digestRuns[day] = true
+	return true, nil
+}
+
+func (t *testDBRepo) AllScheduledEmails(ctx context.Context) ([]models.ScheduledEmail, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	emails := []models.ScheduledEmail{
+		{
+			ID:         1,
+			Name:       "pre_arrival",
+			Anchor:     models.AnchorStartDate,
+			OffsetDays: -3,
+			Subject:    "Your stay is almost here",
+			HTMLBody:   "Dear {{.Reservation.FirstName}}, check-in is from 3pm",
+			TextBody:   "Dear {{.Reservation.FirstName}}, check-in is from 3pm",
+			Active:     true,
+		},
+		{
+			ID:         2,
+			Name:       "post_stay",
+			Anchor:     models.AnchorEndDate,
+			OffsetDays: 1,
+			Subject:    "Thank you for staying with us",
+			HTMLBody:   "Dear {{.Reservation.FirstName}}, please leave us a review",
+			TextBody:   "Dear {{.Reservation.FirstName}}, please leave us a review",
+			Active:     true,
+		},
+		{
+			ID:         3,
+			Name:       "anniversary",
+			Anchor:     models.AnchorStartDate,
+			OffsetDays: 365,
+			Subject:    "A year ago",
+			HTMLBody:   "Dear {{.Reservation.FirstName}}",
+			TextBody:   "Dear {{.Reservation.FirstName}}",
+		},
+	}
+	return emails, nil
+}
+
+func (t *testDBRepo) GetScheduledEmailByID(ctx context.Context, id int) (models.ScheduledEmail, error) {
+	if err := ctx.Err(); err != nil {
+		return models.ScheduledEmail{}, err
+	}
+
+	emails, _ := t.AllScheduledEmails(ctx)
+	for _, e := range emails {
+		if e.ID == id {
+			return e, nil
+		}
+	}
+	return models.ScheduledEmail{}, errors.New("some error")
+}
+
+func (t *testDBRepo) UpdateScheduledEmail(ctx context.Context, e models.ScheduledEmail) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	// the inactive email can't be saved
+	if e.ID == 3 {
+		return errors.New("some error")
+	}
 	return nil
 }
+
+func (t *testDBRepo) ReservationsDueForScheduledEmail(ctx context.Context, e models.ScheduledEmail, from, to time.Time) ([]models.Reservation, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var reservations []models.Reservation
+
+	// one reservation falls due for every scheduled email, until it has been sent
+	if t.scheduledSends[[2]int{e.ID, 1}] {
+		return reservations, nil
+	}
+
+	res := models.Reservation{
+		ID:        1,
+		FirstName: "John",
+		LastName:  "Smith",
+		Email:     "john@smith.com",
+		StartDate: to.AddDate(0, 0, -e.OffsetDays),
+		EndDate:   to.AddDate(0, 0, 2-e.OffsetDays),
+		RoomID:    1,
+		Room:      models.Room{ID: 1, RoomName: "General's Quarters"},
+	}
+	if e.Anchor == models.AnchorEndDate {
+		res.StartDate = to.AddDate(0, 0, -2-e.OffsetDays)
+		res.EndDate = to.AddDate(0, 0, -e.OffsetDays)
+	}
+	reservations = append(reservations, res)
+	return reservations, nil
+}
+
+func (t *testDBRepo) QueueScheduledEmail(ctx context.Context, emailID, reservationID int, msg models.MailData) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+
+	t.mu.Lock()
+	if t.scheduledSends == nil {
+		t.scheduledSends = make(map[[2]int]bool)
+	}
+	key := [2]int{emailID, reservationID}
+	if t.scheduledSends[key] {
+		t.mu.Unlock()
+		return false, nil
+	}
+	t.scheduledSends[key] = true
+	t.mu.Unlock()
+
+	return true, t.InsertOutboxMessage(ctx, msg)
+}
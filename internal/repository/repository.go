@@ -1,29 +1,97 @@
 package repository
 
 import (
+	"context"
+	"errors"
 	"github.com/usmanzaheer1995/bed-and-breakfast/internal/models"
 	"time"
 )
 
+// ErrConflict is returned when a record was changed by someone else since it was read
+var ErrConflict = errors.New("record was changed by another user")
+
+// ErrNotAvailable is returned when a room is already taken for the requested dates
+var ErrNotAvailable = errors.New("room is not available for the requested dates")
+
+// ErrEmailTaken is returned when a user is added with the email address of an existing user
+var ErrEmailTaken = errors.New("a user with that email address already exists")
+
+// DatabaseRepo is the storage the app runs on. Every method takes the caller's context and gives up
+// when it is cancelled or its deadline passes
 type DatabaseRepo interface {
-	AllUsers() bool
-
-	InsertReservation(res models.Reservation) (int, error)
-	InsertRoomRestriction(r models.RoomRestriction) error
-	SearchAvailabilityByDatesByRoomID(start, end time.Time, roomId int) (bool, error)
-	SearchAvailabilityForAllRooms(start, end time.Time) ([]models.Room, error)
-	GetRoomByID(id int) (models.Room, error)
-	GetUserByID(id int) (models.User, error)
-	UpdateUser(u models.User) error
-	Authenticate(email, testPassword string) (int, string, error)
-	AllReservations() ([]models.Reservation, error)
-	AllNewReservations() ([]models.Reservation, error)
-	GetReservationByID(id int) (models.Reservation, error)
-	UpdateReservation(u models.Reservation) error
-	DeleteReservation(id int) error
-	UpdateProcessedReservation(id, processed int) error
-	AllRooms() ([]models.Room, error)
-	GetRestrictionsForRoomByDay(roomID int, startDate, endDate time.Time) ([]models.RoomRestriction, error)
-	InsertBlockForRoom(id int, startDate time.Time) error
-	DeleteBlockForRoom(id int) error
+	AllUsers(ctx context.Context) bool
+	Ping(ctx context.Context) error
+
+	InsertReservation(ctx context.Context, res models.Reservation) (int, error)
+	InsertRoomRestriction(ctx context.Context, r models.RoomRestriction) error
+	InsertReservationWithOutbox(ctx context.Context, res models.Reservation, holdID int, messages func(id int) ([]models.MailData, error)) (int, error)
+	SearchAvailabilityByDatesByRoomID(ctx context.Context, start, end time.Time, roomId int) (bool, error)
+	SearchAvailabilityForAllRooms(ctx context.Context, start, end time.Time) ([]models.Room, error)
+	SearchFlexibleAvailability(ctx context.Context, windowStart, windowEnd time.Time, nights int) ([]models.RoomAvailability, error)
+	GetRoomByID(ctx context.Context, id int) (models.Room, error)
+	GetUserByID(ctx context.Context, id int) (models.User, error)
+	GetUserByEmail(ctx context.Context, email string) (models.User, error)
+	InsertUser(ctx context.Context, u models.User, password string) (int, error)
+	UpdateUser(ctx context.Context, u models.User) error
+	UpdatePassword(ctx context.Context, id int, password string) error
+	Authenticate(ctx context.Context, email, testPassword string) (int, string, error)
+	AllReservations(ctx context.Context) ([]models.Reservation, error)
+	AllNewReservations(ctx context.Context) ([]models.Reservation, error)
+	GetReservationByID(ctx context.Context, id int) (models.Reservation, error)
+	UpdateReservation(ctx context.Context, u models.Reservation) error
+	DeleteReservation(ctx context.Context, id int) error
+	UpdateProcessedReservation(ctx context.Context, id, processed int) error
+	AllRooms(ctx context.Context) ([]models.Room, error)
+	GetRestrictionsForRoomByDay(ctx context.Context, roomID int, startDate, endDate time.Time) ([]models.RoomRestriction, error)
+	GetRestrictionsByDateRange(ctx context.Context, startDate, endDate time.Time) ([]models.RoomRestriction, error)
+	UpdateReservationDates(ctx context.Context, res models.Reservation, version time.Time) error
+	InsertBlockForRoom(ctx context.Context, id int, startDate time.Time) error
+	InsertBlocksForRoom(ctx context.Context, id int, startDate, endDate time.Time) error
+	DeleteBlockForRoom(ctx context.Context, roomID, id int, updatedAt time.Time) error
+	InsertHoldForRoom(ctx context.Context, roomID int, startDate, endDate, expiresAt time.Time) (int, error)
+	DeleteHold(ctx context.Context, id int) error
+	DeleteExpiredHolds(ctx context.Context) (int64, error)
+	InsertPendingReservation(ctx context.Context, p models.PendingReservation, verification models.MailData) (int, error)
+	GetPendingReservationByToken(ctx context.Context, token string) (models.PendingReservation, error)
+	ConfirmPendingReservation(ctx context.Context, id int, messages func(id int) ([]models.MailData, error)) (int, error)
+	DeletePendingReservation(ctx context.Context, id int) error
+	DeleteExpiredPendingReservations(ctx context.Context) (int64, error)
+	RepairReservationRestriction(ctx context.Context, id int) error
+	DeleteOrphanedRestriction(ctx context.Context, id int) error
+
+	InsertUserSession(ctx context.Context, s models.UserSession) (int, error)
+	GetUserSessionByKey(ctx context.Context, key string) (models.UserSession, error)
+	TouchUserSession(ctx context.Context, id int, ipAddress string, seen time.Time) error
+	UserSessions(ctx context.Context, userID int) ([]models.UserSession, error)
+	DeleteUserSession(ctx context.Context, userID, id int) error
+	DeleteUserSessions(ctx context.Context, userID int) error
+
+	InsertWaitlistEntry(ctx context.Context, e models.WaitlistEntry) (int, error)
+	AllActiveWaitlistEntries(ctx context.Context) ([]models.WaitlistEntry, error)
+	OfferWaitlistEntry(ctx context.Context, e models.WaitlistEntry) (int, error)
+	ExpireWaitlistEntries(ctx context.Context) error
+	GetWaitlistEntryByToken(ctx context.Context, token string) (models.WaitlistEntry, error)
+	ClaimWaitlistEntry(ctx context.Context, id int) error
+
+	InsertOutboxMessage(ctx context.Context, msg models.MailData) error
+	ClaimOutboxMessages(ctx context.Context, limit int, lease time.Duration) ([]models.OutboxMessage, error)
+	UpdateOutboxMessage(ctx context.Context, msg models.OutboxMessage) error
+	AllOutboxMessages(ctx context.Context, status string) ([]models.OutboxMessage, error)
+	CountOutboxMessages(ctx context.Context, status string) (int, error)
+	ResendOutboxMessage(ctx context.Context, id int) error
+
+	AllNotificationRules(ctx context.Context) ([]models.NotificationRule, error)
+	InsertNotificationRule(ctx context.Context, rule models.NotificationRule) error
+	DeleteNotificationRule(ctx context.Context, id int) error
+	UsersByAccessLevel(ctx context.Context, accessLevel int) ([]models.User, error)
+	InsertDigestItem(ctx context.Context, item models.DigestItem) error
+	PendingDigestItems(ctx context.Context) ([]models.DigestItem, error)
+	MarkDigestItemsSent(ctx context.Context, recipient string, upToID int) error
+	ClaimDigestRun(ctx context.Context, date time.Time) (bool, error)
+
+	AllScheduledEmails(ctx context.Context) ([]models.ScheduledEmail, error)
+	GetScheduledEmailByID(ctx context.Context, id int) (models.ScheduledEmail, error)
+	UpdateScheduledEmail(ctx context.Context, e models.ScheduledEmail) error
+	ReservationsDueForScheduledEmail(ctx context.Context, e models.ScheduledEmail, from, to time.Time) ([]models.Reservation, error)
+	QueueScheduledEmail(ctx context.Context, emailID, reservationID int, msg models.MailData) (bool, error)
 }
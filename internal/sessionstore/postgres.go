@@ -0,0 +1,70 @@
+package sessionstore
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// PostgresStore keeps sessions in the sessions table, so they survive restarts and are shared by every
+// instance of the app. Expired sessions are ignored, and removed by DeleteExpired
+type PostgresStore struct {
+	db      *sql.DB
+	timeout time.Duration
+}
+
+// NewPostgresStore creates a store on db whose queries give up after timeout
+func NewPostgresStore(db *sql.DB, timeout time.Duration) *PostgresStore {
+	return &PostgresStore{
+		db:      db,
+		timeout: timeout,
+	}
+}
+
+// Find returns the data of an unexpired session
+func (p *PostgresStore) Find(token string) ([]byte, bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), p.timeout)
+	defer cancel()
+
+	var b []byte
+	query := `select data from sessions where token = $1 and current_timestamp < expiry`
+	err := p.db.QueryRowContext(ctx, query, token).Scan(&b)
+	if err == sql.ErrNoRows {
+		return nil, false, nil
+	} else if err != nil {
+		return nil, false, err
+	}
+	return b, true, nil
+}
+
+// Commit saves the data of a session, replacing what was there
+func (p *PostgresStore) Commit(token string, b []byte, expiry time.Time) error {
+	ctx, cancel := context.WithTimeout(context.Background(), p.timeout)
+	defer cancel()
+
+	stmt := `insert into sessions (token, data, expiry) values ($1, $2, $3)
+			on conflict (token) do update set data = excluded.data, expiry = excluded.expiry`
+	_, err := p.db.ExecContext(ctx, stmt, token, b, expiry)
+	return err
+}
+
+// Delete removes a session
+func (p *PostgresStore) Delete(token string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), p.timeout)
+	defer cancel()
+
+	_, err := p.db.ExecContext(ctx, `delete from sessions where token = $1`, token)
+	return err
+}
+
+// DeleteExpired removes the sessions that have expired, returning how many there were
+func (p *PostgresStore) DeleteExpired(ctx context.Context) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, p.timeout)
+	defer cancel()
+
+	result, err := p.db.ExecContext(ctx, `delete from sessions where expiry < current_timestamp`)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
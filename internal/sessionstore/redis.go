@@ -0,0 +1,211 @@
+package sessionstore
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// redisKeyPrefix namespaces session keys, so the server can be shared with other data
+const redisKeyPrefix = "bnb:session:"
+
+// redisMaxIdle is how many idle connections are kept for reuse
+const redisMaxIdle = 10
+
+// errRedisNil is a nil reply, which GET gives for a missing key
+var errRedisNil = errors.New("redis: nil")
+
+// RedisStore keeps sessions in Redis, or a server speaking its protocol such as Valkey or KeyDB, which
+// removes them itself when they expire
+type RedisStore struct {
+	addr     string
+	password string
+	db       int
+	timeout  time.Duration
+	idle     chan *redisConn
+}
+
+// NewRedisStore creates a store on the server at addr (host:port), authenticating with password if it
+// is not empty and using database db. Commands give up after timeout
+func NewRedisStore(addr, password string, db int, timeout time.Duration) *RedisStore {
+	return &RedisStore{
+		addr:     addr,
+		password: password,
+		db:       db,
+		timeout:  timeout,
+		idle:     make(chan *redisConn, redisMaxIdle),
+	}
+}
+
+// Ping checks the server can be reached
+func (s *RedisStore) Ping() error {
+	_, err := s.do("PING")
+	return err
+}
+
+// Find returns the data of a session
+func (s *RedisStore) Find(token string) ([]byte, bool, error) {
+	b, err := s.do("GET", redisKeyPrefix+token)
+	if err == errRedisNil {
+		return nil, false, nil
+	} else if err != nil {
+		return nil, false, err
+	}
+	return b, true, nil
+}
+
+// Commit saves the data of a session until expiry, replacing what was there
+func (s *RedisStore) Commit(token string, b []byte, expiry time.Time) error {
+	ttl := time.Until(expiry).Milliseconds()
+	if ttl <= 0 {
+		return s.Delete(token)
+	}
+
+	_, err := s.do("SET", redisKeyPrefix+token, string(b), "PX", strconv.FormatInt(ttl, 10))
+	return err
+}
+
+// Delete removes a session
+func (s *RedisStore) Delete(token string) error {
+	_, err := s.do("DEL", redisKeyPrefix+token)
+	return err
+}
+
+// Close closes the idle connections
+func (s *RedisStore) Close() {
+	for {
+		select {
+		case c := <-s.idle:
+			c.conn.Close()
+		default:
+			return
+		}
+	}
+}
+
+// do runs a command on an idle or new connection, returning the reply. A connection is only reused
+// after a clean reply, as one that failed may have a reply left unread
+func (s *RedisStore) do(args ...string) ([]byte, error) {
+	c, err := s.get()
+	if err != nil {
+		return nil, err
+	}
+
+	reply, err := c.do(s.timeout, args...)
+	var replyErr redisError
+	if err != nil && err != errRedisNil && !errors.As(err, &replyErr) {
+		c.conn.Close()
+		return nil, err
+	}
+
+	select {
+	case s.idle <- c:
+	default:
+		c.conn.Close()
+	}
+	return reply, err
+}
+
+// get returns an idle connection, or dials, authenticates and selects the database on a new one
+func (s *RedisStore) get() (*redisConn, error) {
+	select {
+	case c := <-s.idle:
+		return c, nil
+	default:
+	}
+
+	conn, err := net.DialTimeout("tcp", s.addr, s.timeout)
+	if err != nil {
+		return nil, err
+	}
+	c := &redisConn{conn: conn, r: bufio.NewReader(conn)}
+
+	if s.password != "" {
+		if _, err := c.do(s.timeout, "AUTH", s.password); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	if s.db != 0 {
+		if _, err := c.do(s.timeout, "SELECT", strconv.Itoa(s.db)); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+
+	return c, nil
+}
+
+// redisError is an error reply from the server
+type redisError string
+
+func (e redisError) Error() string {
+	return "redis: " + string(e)
+}
+
+// redisConn is a connection speaking RESP, the Redis protocol
+type redisConn struct {
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+// do sends a command and reads its reply, which must arrive within timeout
+func (c *redisConn) do(timeout time.Duration, args ...string) ([]byte, error) {
+	err := c.conn.SetDeadline(time.Now().Add(timeout))
+	if err != nil {
+		return nil, err
+	}
+
+	var cmd strings.Builder
+	fmt.Fprintf(&cmd, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(&cmd, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	_, err = io.WriteString(c.conn, cmd.String())
+	if err != nil {
+		return nil, err
+	}
+
+	return c.readReply()
+}
+
+// readReply reads a simple string, error, integer or bulk string reply
+func (c *redisConn) readReply() ([]byte, error) {
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if line == "" {
+		return nil, errors.New("redis: empty reply")
+	}
+
+	switch line[0] {
+	case '+', ':':
+		return []byte(line[1:]), nil
+	case '-':
+		return nil, redisError(line[1:])
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, fmt.Errorf("redis: bad bulk length %q", line)
+		}
+		if n < 0 {
+			return nil, errRedisNil
+		}
+
+		b := make([]byte, n+2)
+		_, err = io.ReadFull(c.r, b)
+		if err != nil {
+			return nil, err
+		}
+		return b[:n], nil
+	default:
+		return nil, fmt.Errorf("redis: unexpected reply %q", line)
+	}
+}
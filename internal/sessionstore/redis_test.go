@@ -0,0 +1,224 @@
+package sessionstore
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeRedis is a server understanding just enough of the protocol for the store: AUTH, SELECT,
+// PING, GET, SET with PX, and DEL
+type fakeRedis struct {
+	ln       net.Listener
+	password string
+
+	mu       sync.Mutex
+	data     map[string]string
+	ttls     map[string]int64
+	commands []string
+}
+
+func newFakeRedis(t *testing.T, password string) *fakeRedis {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f := &fakeRedis{
+		ln:       ln,
+		password: password,
+		data:     map[string]string{},
+		ttls:     map[string]int64{},
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go f.serve(conn)
+		}
+	}()
+	return f
+}
+
+func (f *fakeRedis) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	authed := f.password == ""
+
+	for {
+		args, err := readCommand(r)
+		if err != nil {
+			return
+		}
+
+		f.mu.Lock()
+		f.commands = append(f.commands, args[0])
+		var reply string
+		switch {
+		case args[0] == "AUTH":
+			if args[1] == f.password {
+				authed = true
+				reply = "+OK\r\n"
+			} else {
+				reply = "-WRONGPASS invalid password\r\n"
+			}
+		case !authed:
+			reply = "-NOAUTH Authentication required.\r\n"
+		case args[0] == "SELECT", args[0] == "PING":
+			reply = "+OK\r\n"
+		case args[0] == "GET":
+			v, ok := f.data[args[1]]
+			if ok {
+				reply = fmt.Sprintf("$%d\r\n%s\r\n", len(v), v)
+			} else {
+				reply = "$-1\r\n"
+			}
+		case args[0] == "SET":
+			f.data[args[1]] = args[2]
+			f.ttls[args[1]], _ = strconv.ParseInt(args[4], 10, 64)
+			reply = "+OK\r\n"
+		case args[0] == "DEL":
+			delete(f.data, args[1])
+			reply = ":1\r\n"
+		default:
+			reply = "-ERR unknown command\r\n"
+		}
+		f.mu.Unlock()
+
+		_, err = io.WriteString(conn, reply)
+		if err != nil {
+			return
+		}
+	}
+}
+
+// readCommand reads a command sent as an array of bulk strings
+func readCommand(r *bufio.Reader) ([]string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(line[1:]))
+	if err != nil {
+		return nil, err
+	}
+
+	args := make([]string, n)
+	for i := range args {
+		line, err = r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		size, err := strconv.Atoi(strings.TrimSpace(line[1:]))
+		if err != nil {
+			return nil, err
+		}
+		b := make([]byte, size+2)
+		_, err = io.ReadFull(r, b)
+		if err != nil {
+			return nil, err
+		}
+		args[i] = string(b[:size])
+	}
+	return args, nil
+}
+
+func TestRedisStore(t *testing.T) {
+	f := newFakeRedis(t, "secret")
+	store := NewRedisStore(f.ln.Addr().String(), "secret", 2, time.Second)
+	defer store.Close()
+
+	_, found, err := store.Find("missing")
+	if err != nil || found {
+		t.Fatalf("Find of a missing session: found %t, error %v", found, err)
+	}
+
+	data := []byte("some\r\nsession data")
+	err = store.Commit("abc", data, time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatalf("Commit: %v", err)
+	}
+
+	b, found, err := store.Find("abc")
+	if err != nil || !found || string(b) != string(data) {
+		t.Fatalf("Find: got %q, found %t, error %v", b, found, err)
+	}
+
+	f.mu.Lock()
+	ttl := f.ttls[redisKeyPrefix+"abc"]
+	f.mu.Unlock()
+	if ttl <= 0 || ttl > time.Hour.Milliseconds() {
+		t.Errorf("expiry sent as %dms, wanted up to an hour", ttl)
+	}
+
+	err = store.Delete("abc")
+	if err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	_, found, _ = store.Find("abc")
+	if found {
+		t.Error("session found after Delete")
+	}
+
+	// a session committed already expired is removed rather than saved
+	store.Commit("old", data, time.Now().Add(time.Hour))
+	store.Commit("old", data, time.Now().Add(-time.Minute))
+	_, found, _ = store.Find("old")
+	if found {
+		t.Error("session found after committing it expired")
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.commands[0] != "AUTH" || f.commands[1] != "SELECT" {
+		t.Errorf("new connection sent %v, wanted AUTH then SELECT first", f.commands[:2])
+	}
+	auths := 0
+	for _, c := range f.commands {
+		if c == "AUTH" {
+			auths++
+		}
+	}
+	if auths != 1 {
+		t.Errorf("authenticated %d times, wanted the connection reused", auths)
+	}
+}
+
+func TestRedisStore_Errors(t *testing.T) {
+	f := newFakeRedis(t, "secret")
+
+	store := NewRedisStore(f.ln.Addr().String(), "wrong", 0, time.Second)
+	err := store.Ping()
+	if err == nil || !strings.Contains(err.Error(), "WRONGPASS") {
+		t.Errorf("wrong password: got %v", err)
+	}
+
+	store = NewRedisStore(f.ln.Addr().String(), "", 0, time.Second)
+	_, _, err = store.Find("abc")
+	if err == nil || !strings.Contains(err.Error(), "NOAUTH") {
+		t.Errorf("no password: got %v", err)
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	store = NewRedisStore(addr, "", 0, time.Second)
+	err = store.Ping()
+	if err == nil {
+		t.Error("no server: got no error")
+	}
+}
@@ -0,0 +1,102 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"github.com/go-chi/chi/v5"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+var recorder = tracetest.NewSpanRecorder()
+
+func TestMain(m *testing.M) {
+	// spans are kept in memory for the tests to look at
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	os.Exit(m.Run())
+}
+
+// endedSpan returns the ended span called name, failing the test if there isn't one
+func endedSpan(t *testing.T, name string) sdktrace.ReadOnlySpan {
+	t.Helper()
+	for _, span := range recorder.Ended() {
+		if span.Name() == name {
+			return span
+		}
+	}
+	t.Fatalf("no span called %q", name)
+	return nil
+}
+
+// attr returns the value of the attribute key of span
+func attr(span sdktrace.ReadOnlySpan, key string) attribute.Value {
+	for _, kv := range span.Attributes() {
+		if string(kv.Key) == key {
+			return kv.Value
+		}
+	}
+	return attribute.Value{}
+}
+
+func TestMiddleware(t *testing.T) {
+	mux := chi.NewRouter()
+	mux.Use(Middleware)
+	mux.Get("/rooms/{id}", func(w http.ResponseWriter, r *http.Request) {
+		_, span := Start(r.Context(), "child")
+		span.End()
+	})
+	mux.Get("/broken", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+
+	req := httptest.NewRequest("GET", "/rooms/7", nil)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	mux.ServeHTTP(httptest.NewRecorder(), req)
+
+	server := endedSpan(t, "GET /rooms/{id}")
+	if got := attr(server, "http.route").AsString(); got != "/rooms/{id}" {
+		t.Errorf("expected the route pattern as http.route, got %q", got)
+	}
+	if got := attr(server, "http.response.status_code").AsInt64(); got != http.StatusOK {
+		t.Errorf("expected status 200, got %d", got)
+	}
+	if got := server.SpanContext().TraceID().String(); got != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Errorf("expected the caller's trace to be continued, got trace %s", got)
+	}
+
+	child := endedSpan(t, "child")
+	if child.Parent().SpanID() != server.SpanContext().SpanID() {
+		t.Error("expected spans started in the handler to be children of the request span")
+	}
+
+	mux.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/broken", nil))
+	if got := endedSpan(t, "GET /broken").Status().Code; got != codes.Error {
+		t.Errorf("expected a 500 to mark the span as failed, got %v", got)
+	}
+}
+
+func TestEnd(t *testing.T) {
+	_, span := Start(context.Background(), "send", attribute.Int("outbox.id", 3))
+	End(span, errors.New("550 no such user john@smith.com"))
+
+	ended := endedSpan(t, "send")
+	if ended.Status().Code != codes.Error {
+		t.Errorf("expected the span to be marked failed, got %v", ended.Status().Code)
+	}
+	if ended.Status().Description != "550 no such user j***@smith.com" {
+		t.Errorf("expected the email address in the error to be masked, got %q", ended.Status().Description)
+	}
+	if len(ended.Events()) != 1 || ended.Events()[0].Name != "exception" {
+		t.Errorf("expected the error to be recorded, got %v", ended.Events())
+	}
+}
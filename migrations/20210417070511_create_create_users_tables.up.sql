@@ -0,0 +1,13 @@
+CREATE TABLE "users" (
+  "id" SERIAL NOT NULL,
+  "first_name" VARCHAR (255) NOT NULL DEFAULT '',
+  "last_name" VARCHAR (255) NOT NULL DEFAULT '',
+  "email" VARCHAR (255) NOT NULL,
+  "password" VARCHAR (60) NOT NULL,
+  "access_level" integer NOT NULL,
+  "created_at" timestamp NOT NULL,
+  "updated_at" timestamp NOT NULL,
+  PRIMARY KEY ("id")
+);
+
+CREATE UNIQUE INDEX "users_email_idx" ON "users" ("email");
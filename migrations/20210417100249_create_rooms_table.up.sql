@@ -0,0 +1,7 @@
+CREATE TABLE "rooms" (
+  "id" SERIAL NOT NULL,
+  "room_name" VARCHAR (255) NOT NULL DEFAULT '',
+  "created_at" timestamp NOT NULL,
+  "updated_at" timestamp NOT NULL,
+  PRIMARY KEY ("id")
+);
@@ -0,0 +1 @@
+ALTER TABLE "reservations" DROP CONSTRAINT IF EXISTS "reservation_room_id_fk";
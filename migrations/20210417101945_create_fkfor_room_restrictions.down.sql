@@ -0,0 +1,2 @@
+ALTER TABLE "room_restrictions" DROP CONSTRAINT IF EXISTS "room_restrictions_room_id_fk";
+ALTER TABLE "room_restrictions" DROP CONSTRAINT IF EXISTS "room_restrictions_restriction_id_fk";
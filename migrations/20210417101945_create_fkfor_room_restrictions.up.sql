@@ -0,0 +1,2 @@
+ALTER TABLE "room_restrictions" ADD CONSTRAINT "room_restrictions_room_id_fk" FOREIGN KEY ("room_id") REFERENCES "rooms" ("id") ON DELETE CASCADE ON UPDATE CASCADE;
+ALTER TABLE "room_restrictions" ADD CONSTRAINT "room_restrictions_restriction_id_fk" FOREIGN KEY ("restriction_id") REFERENCES "restrictions" ("id") ON DELETE CASCADE ON UPDATE CASCADE;
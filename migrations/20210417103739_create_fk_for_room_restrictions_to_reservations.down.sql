@@ -0,0 +1 @@
+ALTER TABLE "room_restrictions" DROP CONSTRAINT IF EXISTS "room_restrictions_reservation_id_fk";
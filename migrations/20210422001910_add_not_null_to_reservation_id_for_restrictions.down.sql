@@ -0,0 +1 @@
+-- rows without a reservation may exist by now, so the column stays nullable
@@ -0,0 +1 @@
+-- the admin user is kept, as it may have been changed since
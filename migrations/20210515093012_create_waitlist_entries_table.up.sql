@@ -0,0 +1,20 @@
+CREATE TABLE "waitlist_entries" (
+  "id" SERIAL NOT NULL,
+  "email" VARCHAR (255) NOT NULL,
+  "room_id" integer,
+  "matched_room_id" integer,
+  "start_date" date NOT NULL,
+  "end_date" date NOT NULL,
+  "status" VARCHAR (255) NOT NULL DEFAULT 'waiting',
+  "token" VARCHAR (255) NOT NULL DEFAULT '',
+  "hold_expires_at" timestamp,
+  "created_at" timestamp NOT NULL,
+  "updated_at" timestamp NOT NULL,
+  PRIMARY KEY ("id")
+);
+
+CREATE INDEX "waitlist_entries_status_idx" ON "waitlist_entries" ("status");
+CREATE INDEX "waitlist_entries_token_idx" ON "waitlist_entries" ("token");
+
+ALTER TABLE "waitlist_entries" ADD CONSTRAINT "waitlist_entries_room_id_fk" FOREIGN KEY ("room_id") REFERENCES "rooms" ("id") ON DELETE CASCADE ON UPDATE CASCADE;
+ALTER TABLE "waitlist_entries" ADD CONSTRAINT "waitlist_entries_matched_room_id_fk" FOREIGN KEY ("matched_room_id") REFERENCES "rooms" ("id") ON DELETE SET NULL ON UPDATE CASCADE;
@@ -0,0 +1 @@
+ALTER TABLE "room_restrictions" ADD COLUMN "expires_at" timestamp;
@@ -0,0 +1,2 @@
+INSERT INTO public.restrictions (restriction_name,created_at,updated_at) VALUES
+('Hold','2021-05-16 00:00:00.000','2021-05-16 00:00:00.000');
\ No newline at end of file
@@ -0,0 +1,18 @@
+CREATE TABLE "outbox_messages" (
+  "id" SERIAL NOT NULL,
+  "to_address" VARCHAR (255) NOT NULL,
+  "from_address" VARCHAR (255) NOT NULL,
+  "subject" VARCHAR (255) NOT NULL DEFAULT '',
+  "content" text NOT NULL DEFAULT '',
+  "template" VARCHAR (255) NOT NULL DEFAULT '',
+  "status" VARCHAR (255) NOT NULL DEFAULT 'queued',
+  "attempts" integer NOT NULL DEFAULT 0,
+  "last_error" text NOT NULL DEFAULT '',
+  "next_attempt_at" timestamp NOT NULL,
+  "sent_at" timestamp,
+  "created_at" timestamp NOT NULL,
+  "updated_at" timestamp NOT NULL,
+  PRIMARY KEY ("id")
+);
+
+CREATE INDEX "outbox_messages_status_next_attempt_at_idx" ON "outbox_messages" ("status", "next_attempt_at");
@@ -0,0 +1 @@
+ALTER TABLE "outbox_messages" ADD COLUMN "text_content" text NOT NULL DEFAULT '';
@@ -0,0 +1,12 @@
+CREATE TABLE "notification_rules" (
+  "id" SERIAL NOT NULL,
+  "event" VARCHAR (255) NOT NULL,
+  "address" VARCHAR (255) NOT NULL DEFAULT '',
+  "access_level" integer NOT NULL DEFAULT 0,
+  "digest" bool NOT NULL DEFAULT false,
+  "created_at" timestamp NOT NULL,
+  "updated_at" timestamp NOT NULL,
+  PRIMARY KEY ("id")
+);
+
+CREATE INDEX "notification_rules_event_idx" ON "notification_rules" ("event");
@@ -0,0 +1,5 @@
+ALTER TABLE "users" DROP COLUMN "notify_new_booking";
+ALTER TABLE "users" DROP COLUMN "notify_cancellation";
+ALTER TABLE "users" DROP COLUMN "notify_modification";
+ALTER TABLE "users" DROP COLUMN "notify_block_change";
+ALTER TABLE "users" DROP COLUMN "notify_digest";
@@ -0,0 +1,5 @@
+ALTER TABLE "users" ADD COLUMN "notify_new_booking" bool NOT NULL DEFAULT false;
+ALTER TABLE "users" ADD COLUMN "notify_cancellation" bool NOT NULL DEFAULT false;
+ALTER TABLE "users" ADD COLUMN "notify_modification" bool NOT NULL DEFAULT false;
+ALTER TABLE "users" ADD COLUMN "notify_block_change" bool NOT NULL DEFAULT false;
+ALTER TABLE "users" ADD COLUMN "notify_digest" bool NOT NULL DEFAULT false;
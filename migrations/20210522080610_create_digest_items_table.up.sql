@@ -0,0 +1,12 @@
+CREATE TABLE "digest_items" (
+  "id" SERIAL NOT NULL,
+  "recipient" VARCHAR (255) NOT NULL,
+  "event" VARCHAR (255) NOT NULL,
+  "summary" text NOT NULL,
+  "sent_at" timestamp,
+  "created_at" timestamp NOT NULL,
+  "updated_at" timestamp NOT NULL,
+  PRIMARY KEY ("id")
+);
+
+CREATE INDEX "digest_items_recipient_sent_at_idx" ON "digest_items" ("recipient", "sent_at");
@@ -0,0 +1 @@
+DELETE FROM public.notification_rules WHERE address = '' AND access_level = 3 AND event IN ('new_booking', 'cancellation');
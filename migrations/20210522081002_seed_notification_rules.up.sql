@@ -0,0 +1,5 @@
+INSERT INTO public.notification_rules (event,address,access_level,digest,created_at,updated_at) VALUES
+('new_booking','',3,false,'2021-05-22 00:00:00.000','2021-05-22 00:00:00.000'),
+('cancellation','',3,false,'2021-05-22 00:00:00.000','2021-05-22 00:00:00.000');
+
+UPDATE public.users SET notify_new_booking = true, notify_cancellation = true WHERE access_level = 3;
@@ -0,0 +1,16 @@
+CREATE TABLE "scheduled_emails" (
+  "id" SERIAL NOT NULL,
+  "name" VARCHAR (255) NOT NULL,
+  "description" VARCHAR (255) NOT NULL DEFAULT '',
+  "anchor" VARCHAR (255) NOT NULL,
+  "offset_days" integer NOT NULL DEFAULT 0,
+  "subject" VARCHAR (255) NOT NULL,
+  "html_body" text NOT NULL,
+  "text_body" text NOT NULL,
+  "active" bool NOT NULL DEFAULT true,
+  "created_at" timestamp NOT NULL,
+  "updated_at" timestamp NOT NULL,
+  PRIMARY KEY ("id")
+);
+
+CREATE UNIQUE INDEX "scheduled_emails_name_idx" ON "scheduled_emails" ("name");
@@ -0,0 +1,13 @@
+CREATE TABLE "scheduled_email_sends" (
+  "id" SERIAL NOT NULL,
+  "scheduled_email_id" integer NOT NULL,
+  "reservation_id" integer NOT NULL,
+  "created_at" timestamp NOT NULL,
+  "updated_at" timestamp NOT NULL,
+  PRIMARY KEY ("id")
+);
+
+CREATE UNIQUE INDEX "scheduled_email_sends_scheduled_email_id_reservation_id_idx" ON "scheduled_email_sends" ("scheduled_email_id", "reservation_id");
+
+ALTER TABLE "scheduled_email_sends" ADD CONSTRAINT "scheduled_email_sends_scheduled_email_id_fk" FOREIGN KEY ("scheduled_email_id") REFERENCES "scheduled_emails" ("id") ON DELETE CASCADE ON UPDATE CASCADE;
+ALTER TABLE "scheduled_email_sends" ADD CONSTRAINT "scheduled_email_sends_reservation_id_fk" FOREIGN KEY ("reservation_id") REFERENCES "reservations" ("id") ON DELETE CASCADE ON UPDATE CASCADE;
@@ -0,0 +1 @@
+DELETE FROM public.scheduled_emails WHERE name IN ('pre_arrival', 'post_stay');
@@ -0,0 +1,33 @@
+INSERT INTO public.scheduled_emails (name,description,anchor,offset_days,subject,html_body,text_body,active,created_at,updated_at) VALUES
+('pre_arrival','Directions and check-in information, 3 days before arrival','start_date',-3,
+'Your stay is almost here',
+'<strong>Your stay is almost here</strong><br>
+Dear {{.Reservation.FirstName}},<br>
+We look forward to welcoming you to {{.Reservation.Room.RoomName}} on {{formatDate .Reservation.StartDate "Monday, January 2"}}.<br>
+<br>
+<strong>Check-in</strong> is from 3pm. If you expect to arrive after 9pm, please reply to this email so we can leave your key at the front desk.<br>
+<br>
+<strong>Directions:</strong> follow the signs for Usman''s Bed and Breakfast from the highway. Free parking is available behind the main house.',
+'Dear {{.Reservation.FirstName}},
+
+We look forward to welcoming you to {{.Reservation.Room.RoomName}} on {{formatDate .Reservation.StartDate "Monday, January 2"}}.
+
+Check-in is from 3pm. If you expect to arrive after 9pm, please reply to this email so we can leave your key at the front desk.
+
+Directions: follow the signs for Usman''s Bed and Breakfast from the highway. Free parking is available behind the main house.
+',
+true,'2021-05-24 00:00:00.000','2021-05-24 00:00:00.000'),
+('post_stay','Thank you and review request, the day after checkout','end_date',1,
+'Thank you for staying with us',
+'<strong>Thank you for staying with us</strong><br>
+Dear {{.Reservation.FirstName}},<br>
+We hope you enjoyed your stay in {{.Reservation.Room.RoomName}}.<br>
+<br>
+If you have a moment, we would be grateful if you left us a review. It helps other guests find us.',
+'Dear {{.Reservation.FirstName}},
+
+We hope you enjoyed your stay in {{.Reservation.Room.RoomName}}.
+
+If you have a moment, we would be grateful if you left us a review. It helps other guests find us.
+',
+true,'2021-05-24 00:00:00.000','2021-05-24 00:00:00.000');
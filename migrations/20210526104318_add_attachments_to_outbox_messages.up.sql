@@ -0,0 +1 @@
+ALTER TABLE "outbox_messages" ADD COLUMN "attachments" text NOT NULL DEFAULT '';
@@ -0,0 +1,8 @@
+CREATE TABLE "sessions" (
+  "token" text NOT NULL,
+  "data" bytea NOT NULL,
+  "expiry" timestamptz NOT NULL,
+  PRIMARY KEY ("token")
+);
+
+CREATE INDEX "sessions_expiry_idx" ON "sessions" ("expiry");
@@ -0,0 +1 @@
+ALTER TABLE "users" ADD COLUMN "active" bool NOT NULL DEFAULT true;
@@ -0,0 +1,16 @@
+CREATE TABLE "user_sessions" (
+  "id" SERIAL NOT NULL,
+  "user_id" integer NOT NULL,
+  "key" VARCHAR (64) NOT NULL,
+  "user_agent" text NOT NULL DEFAULT '',
+  "ip_address" VARCHAR (64) NOT NULL DEFAULT '',
+  "last_seen_at" timestamp NOT NULL,
+  "created_at" timestamp NOT NULL,
+  "updated_at" timestamp NOT NULL,
+  PRIMARY KEY ("id")
+);
+
+CREATE UNIQUE INDEX "user_sessions_key_idx" ON "user_sessions" ("key");
+CREATE INDEX "user_sessions_user_id_idx" ON "user_sessions" ("user_id");
+
+ALTER TABLE "user_sessions" ADD CONSTRAINT "user_sessions_user_id_fk" FOREIGN KEY ("user_id") REFERENCES "users" ("id") ON DELETE CASCADE ON UPDATE CASCADE;
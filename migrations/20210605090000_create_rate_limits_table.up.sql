@@ -0,0 +1,8 @@
+CREATE TABLE "rate_limits" (
+  "key" VARCHAR (255) NOT NULL,
+  "tokens" double precision NOT NULL,
+  "updated_at" timestamptz NOT NULL,
+  PRIMARY KEY ("key")
+);
+
+CREATE INDEX "rate_limits_updated_at_idx" ON "rate_limits" ("updated_at");
@@ -0,0 +1,20 @@
+CREATE TABLE "pending_reservations" (
+  "id" SERIAL NOT NULL,
+  "first_name" VARCHAR (255) NOT NULL,
+  "last_name" VARCHAR (255) NOT NULL,
+  "email" VARCHAR (255) NOT NULL,
+  "phone" VARCHAR (255) NOT NULL,
+  "start_date" date NOT NULL,
+  "end_date" date NOT NULL,
+  "room_id" integer NOT NULL,
+  "token" VARCHAR (64) NOT NULL,
+  "expires_at" timestamp NOT NULL,
+  "created_at" timestamp NOT NULL,
+  "updated_at" timestamp NOT NULL,
+  PRIMARY KEY ("id")
+);
+
+CREATE UNIQUE INDEX "pending_reservations_token_idx" ON "pending_reservations" ("token");
+CREATE INDEX "pending_reservations_expires_at_idx" ON "pending_reservations" ("expires_at");
+
+ALTER TABLE "pending_reservations" ADD CONSTRAINT "pending_reservations_room_id_fk" FOREIGN KEY ("room_id") REFERENCES "rooms" ("id") ON DELETE CASCADE ON UPDATE CASCADE;
@@ -0,0 +1 @@
+ALTER TABLE "waitlist_entries" DROP COLUMN "hold_id";
@@ -0,0 +1,3 @@
+ALTER TABLE "waitlist_entries" ADD COLUMN "hold_id" integer;
+
+ALTER TABLE "waitlist_entries" ADD CONSTRAINT "waitlist_entries_hold_id_fk" FOREIGN KEY ("hold_id") REFERENCES "room_restrictions" ("id") ON DELETE SET NULL ON UPDATE CASCADE;
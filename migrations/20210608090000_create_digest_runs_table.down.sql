@@ -0,0 +1 @@
+DROP TABLE "digest_runs";
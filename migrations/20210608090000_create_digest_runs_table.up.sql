@@ -0,0 +1,5 @@
+CREATE TABLE "digest_runs" (
+  "digest_date" date NOT NULL,
+  "created_at" timestamp NOT NULL,
+  PRIMARY KEY ("digest_date")
+);
@@ -0,0 +1,9 @@
+package migrations
+
+import "embed"
+
+// FS holds the schema migrations, built into the binary so a server can migrate its database without
+// the source tree
+//
+//go:embed *.sql
+var FS embed.FS